@@ -0,0 +1,189 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// loadedDirs holds the directory each import path was loaded from, for
+// all the loads of this run.
+var loadedDirs = struct {
+	sync.Mutex
+	dirs map[string]string
+}{dirs: map[string]string{}}
+
+// checkAmbiguous returns why the import path of p does not identify its
+// sources unambiguously, or "" if it does: p was loaded from another
+// directory than the first GOPATH entry holding its import path, or the
+// same import path was loaded from another directory by this run. The
+// outputs of such packages are cached under whichever sources happened
+// to be picked, so they are not cached at all.
+func (p *Package) checkAmbiguous() string {
+	if p.Goroot || p.local || p.Dir == "" {
+		return ""
+	}
+	loadedDirs.Lock()
+	other, ok := loadedDirs.dirs[p.baseImportPath]
+	if !ok {
+		loadedDirs.dirs[p.baseImportPath] = p.Dir
+	}
+	loadedDirs.Unlock()
+	if ok && other != p.Dir {
+		return fmt.Sprintf("loaded from %s and %s", other, p.Dir)
+	}
+
+	if moduleMode() || p.Root == "" || isVendored(p.Dir) {
+		return ""
+	}
+	if first := gopathResolve(p.baseImportPath); first != "" && first != p.Dir {
+		return fmt.Sprintf("loaded from %s but %s comes first in GOPATH", p.Dir, first)
+	}
+	return ""
+}
+
+// isVendored reports whether dir is in a vendor directory.
+func isVendored(dir string) bool {
+	for _, elem := range strings.Split(filepath.ToSlash(dir), "/") {
+		if elem == "vendor" {
+			return true
+		}
+	}
+	return false
+}
+
+// gopathResolve returns the directory of importPath in the first GOPATH
+// entry holding Go files for it, or "".
+func gopathResolve(importPath string) string {
+	for _, entry := range gopathEntries() {
+		dir := filepath.Join(entry, "src", filepath.FromSlash(importPath))
+		if hasGoFiles(dir) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// hasGoFiles reports whether dir holds Go source files.
+func hasGoFiles(dir string) bool {
+	return len(goFiles(dir)) > 0
+}
+
+// goFiles returns the names of the Go source files in dir, sorted.
+func goFiles(dir string) []string {
+	infos, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".go") {
+			names = append(names, info.Name())
+		}
+	}
+	return names
+}
+
+// goFilesDigest returns a digest of the names and contents of the Go
+// source files in dir.
+func goFilesDigest(dir string) (string, error) {
+	h := sha1.New()
+	for _, name := range goFiles(dir) {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\n", name)
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// A shadowedPackage is an import path found in several GOPATH entries
+// with different sources.
+type shadowedPackage struct {
+	ImportPath string
+	Dirs       []string // in GOPATH order, the first being the one used
+}
+
+// shadowedPackages returns the import paths found in several GOPATH
+// entries with different sources, outside vendor and testdata
+// directories.
+func shadowedPackages() ([]shadowedPackage, error) {
+	dirs := map[string][]string{}
+	for _, entry := range gopathEntries() {
+		src := filepath.Join(entry, "src")
+		err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) || os.IsPermission(err) {
+					return nil
+				}
+				return err
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			if name := fi.Name(); path != src && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			if path != src && hasGoFiles(path) {
+				rel, _ := filepath.Rel(src, path)
+				importPath := filepath.ToSlash(rel)
+				dirs[importPath] = append(dirs[importPath], path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var shadowed []shadowedPackage
+	for importPath, ds := range dirs {
+		if len(ds) < 2 {
+			continue
+		}
+		digests := map[string]bool{}
+		for _, d := range ds {
+			digest, err := goFilesDigest(d)
+			if err != nil {
+				log.Printf("warning: %s", err)
+			}
+			digests[digest] = true
+		}
+		if len(digests) > 1 {
+			shadowed = append(shadowed, shadowedPackage{ImportPath: importPath, Dirs: ds})
+		}
+	}
+	sort.Slice(shadowed, func(i, j int) bool {
+		return shadowed[i].ImportPath < shadowed[j].ImportPath
+	})
+	return shadowed, nil
+}
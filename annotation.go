@@ -0,0 +1,156 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// packageAnnotationFile is the name of the file in which the authors of
+// a package declare how it is cached.
+const packageAnnotationFile = ".buildcache-package.json"
+
+// A packageAnnotation is the contents of the packageAnnotationFile of a
+// package, e.g.
+//
+//	{"cacheable": false, "reason": "embeds the build time"}
+//	{"extraInputs": ["schema.sql"]}
+type packageAnnotation struct {
+	// Cacheable is false for packages which must not be cached, such as
+	// those whose outputs are not reproducible.
+	Cacheable *bool  `json:"cacheable"`
+	Reason    string `json:"reason"`
+	// ExtraInputs are files read by the build of the package but not
+	// among its sources, relative to its directory.
+	ExtraInputs []string `json:"extraInputs"`
+
+	digest string // of the file itself
+}
+
+// readPackageAnnotation returns the annotation of the package in dir, or
+// nil if it has none.
+func readPackageAnnotation(dir string) (*packageAnnotation, error) {
+	path := filepath.Join(dir, packageAnnotationFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	a := &packageAnnotation{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, in := range a.ExtraInputs {
+		if in == "" || filepath.IsAbs(in) || filepath.VolumeName(in) != "" {
+			return nil, fmt.Errorf("%s: extra input \"%s\" is not relative to the package", path, in)
+		}
+	}
+	a.digest = hashFileContents(path)
+	if a.digest == "" {
+		return nil, fmt.Errorf("unable to read %s", path)
+	}
+	return a, nil
+}
+
+// uncacheable returns why the annotated package must not be cached, or
+// "" if it may be.
+func (a *packageAnnotation) uncacheable() string {
+	if a == nil || a.Cacheable == nil || *a.Cacheable {
+		return ""
+	}
+	if a.Reason == "" {
+		return "annotated uncacheable"
+	}
+	return "annotated uncacheable: " + a.Reason
+}
+
+// String describes the annotation for the summary.
+func (a *packageAnnotation) String() string {
+	if reason := a.uncacheable(); reason != "" {
+		return reason
+	}
+	switch n := len(a.ExtraInputs); n {
+	case 0:
+		return "annotated"
+	case 1:
+		return "1 extra input"
+	default:
+		return fmt.Sprintf("%d extra inputs", n)
+	}
+}
+
+// annotationInputs returns the fingerprinted digests of the annotation
+// of p and of its extra inputs, or an error if one of those cannot be
+// read. The annotation itself is an input so that changing it
+// invalidates the entries of the package.
+func (p *Package) annotationInputs() ([]string, error) {
+	a := p.annotation
+	if a == nil {
+		return nil, nil
+	}
+	inputs := []string{"annotation=" + a.digest}
+	for _, in := range a.ExtraInputs {
+		path := filepath.Join(p.Dir, filepath.FromSlash(in))
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("extra input %s: %s", in, err)
+		}
+		digest := hashFileContents(path)
+		if digest == "" {
+			return nil, fmt.Errorf("extra input %s: unable to read it", in)
+		}
+		inputs = append(inputs, "extra="+filepath.ToSlash(in)+":"+digest)
+	}
+	return inputs, nil
+}
+
+// recordAnnotations notes the annotated packages among pkgs in s.
+func (s *summary) recordAnnotations(pkgs []*Package) {
+	for _, pkg := range pkgs {
+		if pkg.annotation == nil {
+			continue
+		}
+		if s.Annotated == nil {
+			s.Annotated = map[string]string{}
+		}
+		s.Annotated[pkg.ImportPath] = pkg.annotation.String()
+	}
+}
+
+// formatAnnotated returns the annotated packages, e.g. "annotated:
+// example.com/a (1 extra input), example.com/b (annotated uncacheable:
+// embeds the build time)", or "" if there are none.
+func formatAnnotated(annotated map[string]string) string {
+	if len(annotated) == 0 {
+		return ""
+	}
+	var importPaths []string
+	for importPath := range annotated {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+	var parts []string
+	for _, importPath := range importPaths {
+		parts = append(parts, fmt.Sprintf("%s (%s)", importPath, annotated[importPath]))
+	}
+	return "annotated: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// archiveMagic begins package archives.
+const archiveMagic = "!<arch>\n"
+
+// artifactGoVersion returns the version of the Go toolchain that
+// produced the package archive or binary at path: from the "go object"
+// header of an archive or the build information embedded in a binary.
+// It returns "" if the version cannot be determined.
+func artifactGoVersion(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf := make([]byte, 64<<10)
+	n, _ := f.ReadAt(buf, 0)
+	buf = buf[:n]
+	if bytes.HasPrefix(buf, []byte(archiveMagic)) {
+		i := bytes.Index(buf, []byte("go object "))
+		if i == -1 {
+			return ""
+		}
+		line := buf[i:]
+		if j := bytes.IndexByte(line, '\n'); j != -1 {
+			line = line[:j]
+		}
+		// go object <goos> <goarch> <version> ...
+		fields := strings.Fields(string(line))
+		if len(fields) < 5 {
+			return ""
+		}
+		return fields[4]
+	}
+
+	info, err := buildinfo.Read(f)
+	if err != nil {
+		return ""
+	}
+	return goVersionBase(info.GoVersion)
+}
+
+// goVersionBase strips the experiments and other annotations from a Go
+// version string.
+func goVersionBase(v string) string {
+	if strings.HasPrefix(v, "devel") {
+		return v
+	}
+	if fields := strings.Fields(v); len(fields) > 0 {
+		return fields[0]
+	}
+	return v
+}
+
+// toolchainMismatch returns a description of the problem if the package
+// archive or binary at path was produced by a toolchain other than
+// version, e.g. a stale archive left behind by a Go upgrade, or "" if it
+// was not or the version cannot be determined.
+func toolchainMismatch(path, version string) string {
+	got := artifactGoVersion(path)
+	if got == "" || version == "" || got == goVersionBase(version) {
+		return ""
+	}
+	return fmt.Sprintf("toolchain mismatch: built by %s, not %s", got, goVersionBase(version))
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"sort"
+	"strings"
+)
+
+var audit = flag.Bool("audit", false,
+	"after restoring, report why packages would still be rebuilt")
+
+// An auditCause groups the packages that would be rebuilt for the same
+// kind of reason.
+type auditCause struct {
+	kind    string
+	example string // a package and its reason
+	count   int
+}
+
+// auditRestore reloads the packages named by args after a restore and
+// reports the causes of the packages that go install would still
+// rebuild, most frequent first, with an example package for each.
+// Restored entries of such packages are checked as by verify.
+func auditRestore(args []string, dir string) {
+	packageCache = map[string]*Package{}
+	pkgs := loadAll(args)
+
+	entries, err := listEntries(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	byPath := map[string]*cacheEntry{}
+	for _, e := range entries {
+		byPath[e.Path] = e
+	}
+
+	causes := map[string]*auditCause{}
+	var stale int
+	for _, pkg := range pkgs {
+		if !pkg.Stale || !pkg.cached() {
+			continue
+		}
+		stale++
+		reason := pkg.StaleReason
+		if e := byPath[lookupEntry(dir, pkg.Fingerprint())]; e != nil {
+			if problem := checkEntry(e); problem != "" {
+				reason = "bad cache entry: " + problem
+			}
+		}
+		kind := reason
+		if i := strings.Index(kind, ":"); i != -1 {
+			kind = kind[:i]
+		}
+		c := causes[kind]
+		if c == nil {
+			c = &auditCause{kind: kind, example: pkg.ImportPath + " (" + reason + ")"}
+			causes[kind] = c
+		}
+		c.count++
+	}
+
+	log.Printf("audit: %d packages would be rebuilt", stale)
+	var sorted []*auditCause
+	for _, c := range causes {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].kind < sorted[j].kind
+	})
+	for _, c := range sorted {
+		log.Printf("%6d  %s, e.g. %s", c.count, c.kind, c.example)
+	}
+}
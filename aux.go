@@ -0,0 +1,163 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auxFormatVersion is the version of the format of the auxiliary files
+// of the cache (the stats, the indexes and the journals), as opposed to
+// its entries. An auxiliary file holding JSON starts with a header line
+//
+//	build-cache <kind> v<version> sha256:<hex digest of the rest>
+//
+// and the logs appended to hold one record per line, preceded by the
+// hex CRC-32 of the record. These files only hold state which can be
+// rebuilt: one which cannot be decoded is moved aside and regenerated
+// rather than failing the run.
+const auxFormatVersion = 1
+
+const auxMagic = "build-cache"
+
+// auxHeader returns the header line of an auxiliary file of the kind
+// holding data.
+func auxHeader(kind string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s %s v%d sha256:%s\n", auxMagic, kind, auxFormatVersion, hex.EncodeToString(sum[:]))
+}
+
+// writeAux writes v as JSON to the auxiliary file of the kind at path.
+func writeAux(path, kind string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return writeFileAtomic(path, append([]byte(auxHeader(kind, data)), data...))
+}
+
+// readAux reads the auxiliary file of the kind at path into v and
+// reports whether it did. A file which cannot be read or decoded is
+// reported with a warning, and moved aside unless it could not be
+// read, so that it is regenerated. Files written before auxiliary files
+// had headers are decoded as plain JSON.
+func readAux(path, kind string, v interface{}) bool {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		log.Printf("warning: %s", err)
+		return false
+	}
+	if err := decodeAux(data, kind, v); err != nil {
+		moveAsideAux(path, err)
+		return false
+	}
+	return true
+}
+
+// decodeAux decodes the contents of an auxiliary file of the kind into
+// v.
+func decodeAux(data []byte, kind string, v interface{}) error {
+	if bytes.HasPrefix(data, []byte(auxMagic+" ")) {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return fmt.Errorf("truncated header")
+		}
+		header, body := string(data[:i]), data[i+1:]
+		fields := strings.Fields(header)
+		if len(fields) != 4 || fields[1] != kind {
+			return fmt.Errorf("not a %s file", kind)
+		}
+		if version, err := strconv.Atoi(strings.TrimPrefix(fields[2], "v")); err != nil || version != auxFormatVersion {
+			return fmt.Errorf("unsupported format %s", fields[2])
+		}
+		if auxHeader(kind, body) != header+"\n" {
+			return fmt.Errorf("checksum mismatch")
+		}
+		data = body
+	}
+	return json.Unmarshal(data, v)
+}
+
+var movedAside struct {
+	sync.Mutex
+	paths map[string]bool
+}
+
+// moveAsideAux renames the auxiliary file at path, which cannot be
+// decoded because of err, to path.corrupt-<time> for inspection, logging
+// it once per run.
+func moveAsideAux(path string, err error) {
+	movedAside.Lock()
+	defer movedAside.Unlock()
+	if movedAside.paths[path] {
+		return
+	}
+	if movedAside.paths == nil {
+		movedAside.paths = map[string]bool{}
+	}
+	movedAside.paths[path] = true
+	aside := asideName(path)
+	if rerr := os.Rename(path, aside); rerr != nil {
+		log.Printf("warning: %s: %s, ignoring it", path, err)
+		return
+	}
+	log.Printf("warning: %s: %s, moved aside to %s and starting afresh", path, err, aside)
+}
+
+// asideName returns the name the file at path is moved aside to.
+func asideName(path string) string {
+	return path + ".corrupt-" + time.Now().UTC().Format("20060102T150405")
+}
+
+// isCorruptAuxName reports whether name is that of an auxiliary file
+// moved aside.
+func isCorruptAuxName(name string) bool {
+	return strings.Contains(name, ".corrupt-")
+}
+
+// frameLine returns the line of an appended log holding record.
+func frameLine(record []byte) []byte {
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(record), record))
+}
+
+// unframeLine returns the record held by a line of an appended log,
+// without its newline, or false if it is corrupt, e.g. a partial line
+// left by an interrupted run. Lines written before logs were framed are
+// returned as they are if legacy accepts them.
+func unframeLine(line []byte, legacy func([]byte) bool) ([]byte, bool) {
+	if len(line) >= 9 && line[8] == ' ' {
+		if sum, err := strconv.ParseUint(string(line[:8]), 16, 32); err == nil {
+			record := line[9:]
+			return record, uint32(sum) == crc32.ChecksumIEEE(record)
+		}
+	}
+	return line, legacy != nil && legacy(line)
+}
@@ -0,0 +1,115 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// auxRecord stands for the contents of the auxiliary files.
+type auxRecord struct {
+	Name    string            `json:"name"`
+	Size    int64             `json:"size"`
+	Entries map[string]string `json:"entries,omitempty"`
+}
+
+func FuzzReadAux(f *testing.F) {
+	// Corrupt files are reported by every run.
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := f.TempDir()
+	path := filepath.Join(dir, "aux")
+	if err := writeAux(path, "test", &auxRecord{
+		Name: "example.com/app/lib", Size: 1234, Entries: map[string]string{"a": "b"},
+	}); err != nil {
+		f.Fatal(err)
+	}
+	valid, err := os.ReadFile(path)
+	if err != nil {
+		f.Fatal(err)
+	}
+	header := bytes.IndexByte(valid, '\n') + 1
+	f.Add(valid)
+	// Truncated headers, and bodies of every other length.
+	for _, n := range []int{0, 1, len(auxMagic), len(auxMagic) + 1, header / 2, header - 1, header, header + 1, len(valid) - 1} {
+		f.Add(valid[:n])
+	}
+	for _, data := range []string{
+		auxMagic + " test v1\n{}\n",
+		auxMagic + " test v2 sha256:00\n{}\n",
+		auxMagic + " other v1 sha256:00\n{}\n",
+		`{"name": "legacy", "size": 1}`,
+		"{\n",
+		"\x00\x01\x02",
+	} {
+		f.Add([]byte(data))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(t.TempDir(), "aux")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		var got auxRecord
+		ok := readAux(path, "test", &got)
+		if ok != (decodeAux(data, "test", new(auxRecord)) == nil) {
+			t.Fatalf("readAux: %v, but decodeAux disagrees", ok)
+		}
+		if !ok {
+			// The file is moved aside and regenerated.
+			if exists(path) {
+				t.Fatalf("corrupt %s not moved aside", path)
+			}
+			got = auxRecord{Name: "regenerated"}
+		}
+		if err := writeAux(path, "test", &got); err != nil {
+			t.Fatal(err)
+		}
+		var again auxRecord
+		if !readAux(path, "test", &again) || !reflect.DeepEqual(again, got) {
+			t.Fatalf("read back %+v, want %+v", again, got)
+		}
+	})
+}
+
+func FuzzUnframeLine(f *testing.F) {
+	line := bytes.TrimSuffix(frameLine([]byte(`{"fp":"1234","event":"hit"}`)), []byte("\n"))
+	f.Add(line)
+	for _, n := range []int{0, 7, 8, 9, 10, len(line) - 1} {
+		f.Add(line[:n])
+	}
+	f.Add([]byte("zzzzzzzz record"))
+	f.Add([]byte("legacy record"))
+
+	f.Fuzz(func(t *testing.T, line []byte) {
+		legacy := func([]byte) bool { return false }
+		record, ok := unframeLine(line, legacy)
+		// The checksum may be in either case.
+		if ok && !bytes.EqualFold(frameLine(record), append(append([]byte{}, line...), '\n')) {
+			t.Fatalf("unframeLine(%q) = %q, which frames differently", line, record)
+		}
+		got, ok := unframeLine(bytes.TrimSuffix(frameLine(line), []byte("\n")), legacy)
+		if !ok || !bytes.Equal(got, line) {
+			t.Fatalf("unframeLine(frameLine(%q)) = %q, %v", line, got, ok)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A benchPhase is the measurement of one phase of the bench command.
+type benchPhase struct {
+	Name     string  `json:"name"`
+	Seconds  float64 `json:"seconds"`
+	Packages int     `json:"packages"`
+	Hits     int     `json:"hits"`
+	Misses   int     `json:"misses"`
+	Stale    int     `json:"stale"`
+	HitRate  float64 `json:"hitRate"`
+
+	counted bool // hits and misses apply
+}
+
+// benchPhaseOf returns the measurement of a save or restore phase from
+// its summary.
+func benchPhaseOf(name string, s *summary) *benchPhase {
+	return &benchPhase{
+		Name:     name,
+		Seconds:  s.Seconds,
+		Packages: s.Packages,
+		Hits:     s.Hits,
+		Misses:   s.Misses,
+		Stale:    s.Stale,
+		HitRate:  s.HitRate,
+		counted:  true,
+	}
+}
+
+// benchRun runs fn, save or restore, on args, exiting if it fails.
+func benchRun(fn func([]string) (*summary, error), args []string) *summary {
+	s, err := fn(args)
+	if err != nil {
+		fatal(err)
+	}
+	return s
+}
+
+// bench measures how build-cache performs on the packages named by args
+// with a scripted sequence: fingerprinting them, saving them to an
+// empty cache, restoring them, and restoring them again after touching
+// a source file of a leaf package and then of the package imported the
+// most. Touching only changes the modification times of the files,
+// which are put back afterwards. The cache and the restored outputs are
+// kept in a temporary directory, and the outputs are saved by copying
+// them, so that neither the cache in use nor the installed outputs are
+// changed.
+func bench(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	tmp, err := os.MkdirTemp("", "build-cache-bench-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Setenv("CACHE", filepath.Join(tmp, "cache")); err != nil {
+		log.Fatal(err)
+	}
+	*targetRoot = filepath.Join(tmp, "root")
+
+	var phases []*benchPhase
+	start := time.Now()
+	pkgs := loadAll(args)
+	fingerprinted := 0
+	for _, pkg := range pkgs {
+		if pkg.Goroot && pkg.race {
+			log.Fatalf("bench: %s would be restored into the installed toolchain; run bench without -race", pkg.ImportPath)
+		}
+		if pkg.cached() && pkg.Fingerprint() != "" {
+			fingerprinted++
+		}
+	}
+	phases = append(phases, &benchPhase{
+		Name:     "fingerprint",
+		Seconds:  time.Since(start).Seconds(),
+		Packages: fingerprinted,
+	})
+	leaf, core := benchFiles(pkgs)
+
+	// Entries hardlinked to the installed outputs would share their
+	// modification times with the outputs restored from them.
+	mode := *linkMode
+	*linkMode = "copy"
+	packageCache = map[string]*Package{}
+	phases = append(phases, benchPhaseOf("save (cold)", benchRun(save, args)))
+	*linkMode = mode
+	packageCache = map[string]*Package{}
+	phases = append(phases, benchPhaseOf("restore", benchRun(restore, args)))
+	for _, touched := range []struct{ name, file string }{{"leaf", leaf}, {"core", core}} {
+		if touched.file == "" {
+			log.Printf("bench: no %s package to touch", touched.name)
+			continue
+		}
+		undo := touchFile(touched.file)
+		packageCache = map[string]*Package{}
+		s := benchRun(restore, args)
+		undo()
+		phases = append(phases, benchPhaseOf("restore (touched "+touched.name+")", s))
+	}
+
+	if *jsonOutput {
+		fmt.Println(prettyJSON(phases))
+		return
+	}
+	fmt.Printf("%-26s %9s %8s %6s %6s %6s %8s\n", "phase", "seconds", "packages", "hits", "misses", "stale", "hit rate")
+	for _, p := range phases {
+		if !p.counted {
+			fmt.Printf("%-26s %9.3f %8d %6s %6s %6s %8s\n", p.Name, p.Seconds, p.Packages, "-", "-", "-", "-")
+			continue
+		}
+		fmt.Printf("%-26s %9.3f %8d %6d %6d %6d %7.1f%%\n",
+			p.Name, p.Seconds, p.Packages, p.Hits, p.Misses, p.Stale, 100*p.HitRate)
+	}
+	if leaf != "" || core != "" {
+		fmt.Printf("\ntouched leaf %s, core %s\n", benchName(leaf), benchName(core))
+	}
+}
+
+// benchFiles returns a source file of a cached package imported by no
+// other, and one of the cached package imported by the most others, or
+// "" if there are none.
+func benchFiles(pkgs []*Package) (leaf, core string) {
+	importers := map[*Package]int{}
+	for _, pkg := range pkgs {
+		for _, imp := range pkg.imports {
+			importers[imp]++
+		}
+	}
+	var corePkg *Package
+	for _, pkg := range pkgs {
+		if !pkg.cached() || pkg.Goroot || len(pkg.GoFiles) == 0 {
+			continue
+		}
+		if leaf == "" && importers[pkg] == 0 {
+			leaf = filepath.Join(pkg.Dir, pkg.GoFiles[0])
+		}
+		if importers[pkg] > 0 && (corePkg == nil || importers[pkg] > importers[corePkg]) {
+			corePkg = pkg
+		}
+	}
+	if corePkg != nil {
+		core = filepath.Join(corePkg.Dir, corePkg.GoFiles[0])
+	}
+	return leaf, core
+}
+
+// touchFile sets the modification time of file to now and returns a
+// function putting back the previous one.
+func touchFile(file string) func() {
+	fi, err := os.Stat(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(file, now, now); err != nil {
+		log.Fatal(err)
+	}
+	return func() {
+		if err := os.Chtimes(file, fi.ModTime(), fi.ModTime()); err != nil {
+			log.Printf("warning: unable to restore the modification time of %s: %s", file, err)
+		}
+	}
+}
+
+func benchName(file string) string {
+	if file == "" {
+		return "(none)"
+	}
+	return file
+}
@@ -0,0 +1,113 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// blobDir returns the directory of the blob store of the cache dir,
+// holding the contents of entries once, named by their SHA-256. An
+// entry is a hardlink to its blob, so entries of different fingerprints
+// with the same contents share their storage and are restored exactly
+// as other entries.
+func blobDir(dir string) string {
+	return filepath.Join(dir, "blobs")
+}
+
+func blobPath(dir, sum string) string {
+	return filepath.Join(blobDir(dir), sum)
+}
+
+// storeEntry saves the file src as the entry dst of the cache dir,
+// adding its contents to the blob store if they are not there yet and
+// linking dst to the blob. An entry is copied from its blob if the blob
+// cannot be linked, e.g. as it has too many links.
+func storeEntry(dir, src, dst string) error {
+	sum, _, err := fileChecksum(src)
+	if err != nil {
+		return err
+	}
+	blob := blobPath(dir, sum)
+	if !exists(blob) {
+		if err := os.MkdirAll(blobDir(dir), 0755); err != nil {
+			return err
+		}
+		// Another worker may be storing the same contents: place the
+		// blob under a temporary name and rename it.
+		f, err := createTemp(blobDir(dir))
+		if err != nil {
+			return err
+		}
+		tmp := f.Name()
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		if err := linkOrCopy(src, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, blob); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Link(blob, dst); err == nil || os.IsExist(err) {
+		return nil
+	}
+	return copyFile(blob, dst)
+}
+
+// gcBlobs removes the blobs of the cache dir which no entry references
+// any more, returning their number and size. Entries are hardlinks of
+// their blobs, so removing the blob of an entry whose metadata was lost
+// only loses the sharing of its storage.
+func gcBlobs(dir string) (int, int64, error) {
+	infos, err := os.ReadDir(blobDir(dir))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := listEntries(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	referenced := map[string]bool{}
+	for _, e := range entries {
+		if e.Meta != nil {
+			referenced[e.Meta.Checksum] = true
+			for _, x := range e.Meta.Extras {
+				referenced[x.Checksum] = true
+			}
+		}
+	}
+	var count int
+	var bytes int64
+	for _, info := range infos {
+		if info.IsDir() || referenced[info.Name()] {
+			continue
+		}
+		path := filepath.Join(blobDir(dir), info.Name())
+		size := fileSize(path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return count, bytes, err
+		}
+		count++
+		bytes += size
+	}
+	return count, bytes, nil
+}
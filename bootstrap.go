@@ -0,0 +1,87 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// bootstrapAge is how much older than the restore the directories
+// created by bootstrap are stamped: more than the timestamp granularity
+// of the coarsest filesystems (2s on FAT).
+const bootstrapAge = 4 * time.Second
+
+// A bootstrap holds the directories created for the Targets of a
+// restore into a fresh GOPATH, where neither pkg nor its GOOS_GOARCH
+// (and installsuffix) subdirectories exist yet.
+type bootstrap struct {
+	dirs  []string
+	stamp time.Time
+}
+
+// newBootstrap creates the directories of the Targets of the packages,
+// whether or not they are in the cache, and stamps those it created
+// before start. Directories which cannot be created are left to the
+// pre-flight checks to report.
+func newBootstrap(pkgs []*Package, start time.Time) *bootstrap {
+	b := &bootstrap{stamp: start.Add(-bootstrapAge)}
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		if !pkg.cached() || pkg.Target == "" {
+			continue
+		}
+		d := filepath.Dir(pkg.Target)
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		var missing []string
+		for p := d; !exists(p); p = filepath.Dir(p) {
+			missing = append(missing, p)
+			if filepath.Dir(p) == p {
+				break
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		if err := os.MkdirAll(d, 0755); err != nil {
+			vlogf("bootstrap: %s", err)
+			continue
+		}
+		b.dirs = append(b.dirs, missing...)
+	}
+	sort.Strings(b.dirs)
+	b.restamp()
+	if len(b.dirs) > 0 {
+		vlogf("bootstrap: created %d directories", len(b.dirs))
+	}
+	return b
+}
+
+// restamp stamps the directories created by the bootstrap before the
+// restore again, as restoring Targets into them updated their
+// modification times.
+func (b *bootstrap) restamp() {
+	for _, d := range b.dirs {
+		if err := os.Chtimes(d, b.stamp, b.stamp); err != nil {
+			vlogf("bootstrap: %s", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBootstrap checks that restoring into a GOPATH holding only sources
+// creates the directories of the Targets, older than the outputs
+// restored into them, leaving the packages up to date.
+func TestBootstrap(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		flags []string // $ROOT is the fixture root
+		root  string   // the directories are created under, relative to the fixture root
+	}{
+		{name: "GOPATH", root: "gopath"},
+		{name: "install suffix", flags: []string{"-installsuffix", "shared"}, root: "gopath"},
+		{name: "target root", flags: []string{"-target-root", "$ROOT/fresh"}, root: "fresh"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			var flags []string
+			for _, flag := range test.flags {
+				flags = append(flags, strings.Replace(flag, "$ROOT", f.root, 1))
+			}
+			for i := 0; i < len(flags); i += 2 {
+				if flags[i] == "-installsuffix" {
+					f.setFlag("installsuffix", flags[i+1])
+				}
+			}
+			f.install("./...")
+			f.mustRun(append(flags, "save", "./...")...)
+			for _, dir := range []string{"pkg", "bin"} {
+				if err := os.RemoveAll(filepath.Join(f.gopath, dir)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			out := f.mustRun(append(flags, "restore", "./...")...)
+			root := filepath.Join(f.root, test.root)
+			var cached int
+			for _, pkg := range f.load("./...") {
+				if !pkg.cached() {
+					continue
+				}
+				cached++
+				target := pkg.Target
+				if test.root != "gopath" {
+					rel, err := filepath.Rel(f.gopath, target)
+					if err != nil {
+						t.Fatal(err)
+					}
+					target = filepath.Join(root, rel)
+				}
+				fi, err := os.Stat(target)
+				if err != nil {
+					t.Errorf("%s: not restored:\n%s", pkg.ImportPath, out)
+					continue
+				}
+				if pkg.Stale && test.root == "gopath" {
+					t.Errorf("%s: stale after restoring: %s", pkg.ImportPath, pkg.StaleReason)
+				}
+				// The created directories are older than the output.
+				for dir := filepath.Dir(target); underDir(dir, root) && dir != root; dir = filepath.Dir(dir) {
+					di, err := os.Stat(dir)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if di.ModTime().After(fi.ModTime()) {
+						t.Errorf("%s: %s newer than the restored %s", pkg.ImportPath, dir, target)
+					}
+				}
+			}
+			if cached == 0 {
+				t.Fatal("no packages cached")
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// buildDurations holds the approximate time taken to build each
+// package installed by warm, by import path, recorded in the metadata
+// of the entries it saves.
+var buildDurations map[string]time.Duration
+
+// targetTimes returns the modification times of the targets of pkgs,
+// omitting those which do not exist.
+func targetTimes(pkgs []*Package) map[*Package]time.Time {
+	times := map[*Package]time.Time{}
+	for _, pkg := range pkgs {
+		if pkg.Target == "" {
+			continue
+		}
+		if fi, err := os.Stat(pkg.Target); err == nil {
+			times[pkg] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+// attributeBuildTimes estimates the time taken to build each of pkgs
+// whose target was written by a build started at start, from the
+// modification times of the targets before and after it. A package is
+// compiled once the last of its rebuilt imports is written, so its
+// build is taken to span from then (or start) until its own target is
+// written. This is approximate: it also includes the time the package
+// waited for a free worker.
+func attributeBuildTimes(pkgs []*Package, before map[*Package]time.Time, start time.Time) map[string]time.Duration {
+	after := targetTimes(pkgs)
+	rebuilt := map[*Package]time.Time{}
+	for pkg, t := range after {
+		if prev, ok := before[pkg]; !ok || !t.Equal(prev) {
+			rebuilt[pkg] = t
+		}
+	}
+	durations := map[string]time.Duration{}
+	for pkg, t := range rebuilt {
+		from := start
+		for _, imp := range pkg.imports {
+			if it, ok := rebuilt[imp]; ok && it.After(from) {
+				from = it
+			}
+		}
+		if d := t.Sub(from); d > 0 {
+			durations[pkg.ImportPath] = d
+		}
+	}
+	return durations
+}
+
+// entryBuildTime returns the build duration recorded in the metadata of
+// the entry at path, or 0 if there is none.
+func entryBuildTime(path string) time.Duration {
+	m, err := readMeta(path)
+	if err != nil || m == nil {
+		return 0
+	}
+	return time.Duration(m.BuildSeconds * float64(time.Second))
+}
+
+// formatSaved describes the build time saved by cache hits, or returns
+// "" if none is known.
+func formatSaved(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("time saved by cache hits: %s", time.Duration(seconds*float64(time.Second)).Round(100*time.Millisecond))
+}
@@ -0,0 +1,126 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cacheDirTagName is the name of the file marking a cache directory, as
+// specified at https://bford.info/cachedir/ and recognized by backup
+// tools such as Borg, Bacula and GNU tar (--exclude-caches).
+const cacheDirTagName = "CACHEDIR.TAG"
+
+// cacheDirTag is the content of the CACHEDIR.TAG file, which must start
+// with the signature.
+const cacheDirTag = "Signature: 8a477f597d28d172789f06886806bc55\n" +
+	"# This file is a cache directory tag created by build-cache.\n" +
+	"# For information about cache directory tags, see:\n" +
+	"#	https://bford.info/cachedir/\n"
+
+func cacheDirTagPath(dir string) string {
+	return filepath.Join(dir, cacheDirTagName)
+}
+
+// createCacheDir creates the cache dir, if needed, and tags it.
+func createCacheDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("%w: %v", errCacheUnavailable, err)
+	}
+	if exists(cacheDirTagPath(dir)) {
+		return nil
+	}
+	return writeFileAtomic(cacheDirTagPath(dir), []byte(cacheDirTag))
+}
+
+// isEntryName reports whether name, in the cache directory or one of
+// its subdirectories holding entries, names an entry rather than
+// metadata, a temporary or a bookkeeping file.
+func isEntryName(name string) bool {
+	fp := entryFingerprint(name)
+	return !strings.HasSuffix(name, ".meta") &&
+		len(fp) == fingerprintWidth && strings.Trim(fp, "0123456789abcdef") == ""
+}
+
+// isCacheName reports whether name may appear at the top level of a
+// cache directory.
+func isCacheName(dir, name string) bool {
+	if strings.HasPrefix(name, ".") {
+		switch name {
+		case ".lock", ".use", ".tmp", filepath.Base(formatPath(dir)):
+			return true
+		}
+		// Temporary files of build-cache.
+		for _, prefix := range []string{".tmp-", ".doctor-", ".skew-", ".build-cache-preflight-"} {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	path := filepath.Join(dir, name)
+	switch path {
+	case cacheDirTagPath(dir), testDir(dir), resultsDir(dir), quarantineDir(dir),
+		statsDir(dir), journalDir(dir), snapshotDir(dir), gocacheDir(dir), denylistPath(dir), blobDir(dir),
+		namespacesDir(dir), vendorDigestsPath(dir), pinsPath(dir), policySkipsPath(dir), packageIndexPath(dir), pushDir(dir):
+		return true
+	}
+	if isCorruptAuxName(name) {
+		return true
+	}
+	fp := entryFingerprint(strings.TrimSuffix(strings.TrimSuffix(name, ".meta"), ".extra"))
+	return len(fp) == fingerprintWidth && strings.Trim(fp, "0123456789abcdef") == ""
+}
+
+// checkCacheLayout refuses to use dir as the cache directory if it is
+// not tagged with a CACHEDIR.TAG and holds anything build-cache would
+// not have put there, which protects e.g. $HOME from "clear" when
+// $CACHE is set to it by mistake. An untagged directory holding only
+// cache content, such as a cache created by an older build-cache, is
+// tagged.
+func checkCacheLayout(dir string) error {
+	if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory; check $CACHE", errCacheUnavailable, dir)
+	}
+	if !exists(dir) || exists(cacheDirTagPath(dir)) {
+		return nil
+	}
+	infos, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errCacheUnavailable, err)
+	}
+	var unexpected []string
+	for _, info := range infos {
+		if !isCacheName(dir, info.Name()) {
+			unexpected = append(unexpected, info.Name())
+		}
+	}
+	if len(unexpected) > 0 {
+		if len(unexpected) > 3 {
+			unexpected = append(unexpected[:3], "...")
+		}
+		return fmt.Errorf("%w: %s does not look like a build-cache directory: it has no %s and contains %s; check $CACHE",
+			errCacheUnavailable, dir, cacheDirTagName, strings.Join(unexpected, ", "))
+	}
+	if err := createCacheDir(dir); err != nil {
+		log.Printf("warning: unable to tag the cache directory: %s", err)
+	}
+	return nil
+}
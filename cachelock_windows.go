@@ -0,0 +1,37 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+//go:build windows
+// +build windows
+
+package main
+
+import "time"
+
+// lockCache is a no-op on Windows, where concurrent use of a cache is
+// not supported.
+func lockCache(dir string) (func(), error) {
+	return func() {}, nil
+}
+
+// lockCacheUse is a no-op on Windows.
+func lockCacheUse(dir string, exclusive bool, timeout time.Duration) (func(), error) {
+	return func() {}, nil
+}
+
+// flockFile is a no-op on Windows.
+func flockFile(path string, wait bool) (func(), error) {
+	return func() {}, nil
+}
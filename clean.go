@@ -0,0 +1,152 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var allSuffixes = flag.Bool("all-suffixes", false,
+	"with clean, also remove the outputs of the other install suffixes (e.g. race and netgo)")
+
+// cleanRoots returns the directories the outputs of p may be removed
+// from by clean: the pkg and bin directories of the root its Target is
+// under, GOBIN and the -pkgdir in effect.
+func (p *Package) cleanRoots() []string {
+	var roots []string
+	if root := p.targetRoot(); root != "" {
+		roots = append(roots, filepath.Join(root, "pkg"), filepath.Join(root, "bin"))
+	}
+	if gobin != "" {
+		roots = append(roots, gobin)
+	}
+	if _, pkgdir := buildSettings(); pkgdir != "" {
+		roots = append(roots, pkgdir)
+	}
+	return roots
+}
+
+// cleanTargets returns the outputs of p to be removed by clean: its
+// Target and, with --all-suffixes, the archives of the same package
+// installed with other install suffixes.
+func (p *Package) cleanTargets() []string {
+	targets := []string{p.Target}
+	if !*allSuffixes || p.Name == "main" {
+		return targets
+	}
+	rel := filepath.FromSlash(p.baseImportPath) + ".a"
+	if !strings.HasSuffix(p.Target, string(filepath.Separator)+rel) {
+		return targets
+	}
+	// <root>/pkg/<goos>_<goarch>[_<suffix>]
+	pkgDir := strings.TrimSuffix(p.Target, string(filepath.Separator)+rel)
+	platform := p.buildContext.GOOS + "_" + p.buildContext.GOARCH
+	variants, _ := filepath.Glob(filepath.Join(filepath.Dir(pkgDir), platform+"_*", rel))
+	return append(targets, variants...)
+}
+
+// checkCleanTarget returns why target may not be removed by clean, or
+// nil if it may: it must be a regular file or a symlink under one of
+// roots once its parent directories are resolved.
+func checkCleanTarget(target string, roots []string) error {
+	if _, err := checkTarget(target, ""); err != nil {
+		return err
+	}
+	dir, err := resolveExisting(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if resolved, err := resolveExisting(root); err == nil && underDir(dir, resolved) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is outside %s", target, strings.Join(roots, ", "))
+}
+
+// clean removes the outputs installed for the packages named by args,
+// e.g. when switching between configurations leaves a mix of them
+// behind, without touching the cache. With --dry-run the outputs are
+// only listed.
+func clean(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	if err := checkTestFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setAltRoot(*targetRoot); err != nil {
+		log.Fatal(err)
+	}
+	pkgs := loadAll(args)
+
+	seen := map[string]bool{}
+	var targets []string
+	roots := map[string][]string{}
+	for _, pkg := range pkgs {
+		if !pkg.cached() || pkg.Target == "" {
+			continue
+		}
+		for _, target := range pkg.cleanTargets() {
+			if !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+				roots[target] = pkg.cleanRoots()
+			}
+		}
+	}
+	sort.Strings(targets)
+
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	var count, refused int
+	var bytes int64
+	for _, target := range targets {
+		fi, err := os.Lstat(target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = checkCleanTarget(target, roots[target])
+		}
+		if err != nil {
+			log.Printf("WARNING: refusing to remove %s: %s", target, err)
+			refused++
+			continue
+		}
+		log.Print(target)
+		if !*dryRun {
+			if err := os.Remove(target); err != nil {
+				log.Fatal(err)
+			}
+		}
+		count++
+		bytes += fi.Size()
+	}
+	log.Printf("%s %d package outputs (%s)", verb, count, humanSize(bytes))
+	if refused > 0 {
+		log.Printf("refused to remove %d package outputs", refused)
+		os.Exit(1)
+	}
+}
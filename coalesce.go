@@ -0,0 +1,63 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import "sync"
+
+// A flightGroup coalesces the concurrent operations of the workers on
+// the same key. Packages share a fingerprint when -rewrite-import maps
+// their import paths to the same one (e.g. identical vendored copies),
+// and saving both at once would store the same entry twice without
+// comparing them. The first operation on a key runs while the others
+// wait for it to end. A waiting operation then runs in turn and finds
+// the result of the first in the cache, so it shares the work done
+// without depending on its outcome: if the first operation stored
+// nothing, the next one does the work itself.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]chan struct{}
+}
+
+// flights coalesces the saves of the entries of the same fingerprint.
+var flights flightGroup
+
+// start waits for the operation in flight on key, if any, and starts
+// one, returning the function ending it and whether it had to wait.
+func (g *flightGroup) start(key string) (end func(), coalesced bool) {
+	g.mu.Lock()
+	for {
+		done, ok := g.flights[key]
+		if !ok {
+			break
+		}
+		coalesced = true
+		g.mu.Unlock()
+		<-done
+		g.mu.Lock()
+	}
+	if g.flights == nil {
+		g.flights = map[string]chan struct{}{}
+	}
+	done := make(chan struct{})
+	g.flights[key] = done
+	g.mu.Unlock()
+	return func() {
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(done)
+	}, coalesced
+}
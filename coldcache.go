@@ -0,0 +1,154 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A coldCause checks for one reason why a restore found none of the
+// packages in the cache dir, returning a description of it or "" if it
+// does not apply. missed holds the packages missed, which may be empty.
+type coldCause func(dir string, missed *missHint) string
+
+// coldCauses are the checks run when a restore finds no entries, in
+// order of likelihood.
+var coldCauses = []coldCause{
+	coldEmpty,
+	coldNamespaces,
+	coldInstrumentation,
+	coldToolchain,
+}
+
+// reportColdCache explains a restore which found none of the packages
+// it needed, with the likely causes, instead of leaving only a run of
+// misses.
+func reportColdCache(dir string, missed *missHint, misses int) {
+	log.Print(colorize(ansiBold, fmt.Sprintf("cold cache: none of the %d packages needed were found in %s", misses, dir)))
+	var causes []string
+	for _, cause := range coldCauses {
+		if c := cause(dir, missed); c != "" {
+			causes = append(causes, c)
+		}
+	}
+	if len(causes) == 0 {
+		log.Print("  no likely cause found: the inputs of every package differ from those saved " +
+			"(see check -manifest to compare them)")
+		return
+	}
+	log.Print("likely causes:")
+	for i, c := range causes {
+		log.Printf("  %d. %s", i+1, c)
+	}
+}
+
+// coldEmpty reports a cache dir holding no entries at all.
+func coldEmpty(dir string, _ *missHint) string {
+	if len(entryIndex(dir)) > 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s holds no entries: nothing has been saved to it yet; run save after building", dir)
+}
+
+// namespaceEntries returns the number of entries in each namespace of
+// the cache root, "" being the default namespace, omitting the empty
+// ones.
+func namespaceEntries(root string) map[string]int {
+	counts := map[string]int{}
+	if n := len(entryIndex(root)); n > 0 {
+		counts[""] = n
+	}
+	infos, _ := os.ReadDir(namespacesDir(root))
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		if n := len(entryIndex(namespaceDir(root, info.Name()))); n > 0 {
+			counts[info.Name()] = n
+		}
+	}
+	return counts
+}
+
+// coldNamespaces reports the entries held by namespaces other than the
+// one in use.
+func coldNamespaces(dir string, _ *missHint) string {
+	counts := namespaceEntries(cacheRoot())
+	delete(counts, namespace())
+	for _, ns := range fallbacks() {
+		delete(counts, ns)
+	}
+	if len(counts) == 0 {
+		return ""
+	}
+	var names []string
+	for ns := range counts {
+		names = append(names, ns)
+	}
+	sort.Slice(names, func(i, j int) bool { return counts[names[i]] > counts[names[j]] })
+	var list []string
+	for _, ns := range names {
+		name := ns
+		if ns == "" {
+			name = "the default namespace"
+		}
+		list = append(list, fmt.Sprintf("%s (%d)", name, counts[ns]))
+	}
+	return fmt.Sprintf("entries exist in other namespaces: %s; check -namespace or add -fallback-namespace",
+		strings.Join(list, ", "))
+}
+
+// coldInstrumentation reports entries for the missed packages saved
+// with a different instrumentation, such as -race.
+func coldInstrumentation(dir string, missed *missHint) string {
+	return strings.Join(missed.hints(dir), "; ")
+}
+
+// coldToolchain reports entries for the missed packages saved by a
+// build-cache fingerprinting with a different Go toolchain.
+func coldToolchain(dir string, missed *missHint) string {
+	if len(missed.missed) == 0 {
+		return ""
+	}
+	entries, err := listEntries(dir)
+	if err != nil {
+		return ""
+	}
+	versions := map[string]int{}
+	for _, e := range entries {
+		m := e.Meta
+		if m == nil || m.GoVersion == "" || m.GoVersion == goVersion {
+			continue
+		}
+		if _, ok := missed.missed[packageBaseImportPath(m.ImportPath)]; ok {
+			versions[m.GoVersion]++
+		}
+	}
+	if len(versions) == 0 {
+		return ""
+	}
+	var list []string
+	for v, n := range versions {
+		list = append(list, fmt.Sprintf("%s (%d)", v, n))
+	}
+	sort.Strings(list)
+	return fmt.Sprintf("entries for these packages were saved with %s, but fingerprints now use %s; "+
+		"the toolchain is part of every fingerprint", strings.Join(list, ", "), goVersion)
+}
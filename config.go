@@ -0,0 +1,73 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+)
+
+const defaultConfigFile = ".buildcache"
+
+var configFile = flag.String("config", os.Getenv("BUILDCACHE_CONFIG"),
+	"file supplying flag defaults (defaults to "+defaultConfigFile+" if present)")
+
+// loadConfig applies the settings in the config file to the flags that
+// were not set on the command line. Each line of the config file has
+// the form "name = value" where name is a flag name. Blank lines and
+// lines beginning with "#" are ignored. A flag that may be repeated on
+// the command line may also be repeated in the config file.
+func loadConfig() {
+	path := *configFile
+	if path == "" {
+		if !exists(defaultConfigFile) {
+			return
+		}
+		path = defaultConfigFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := line, "true"
+		if j := strings.IndexByte(line, '='); j != -1 {
+			name, value = strings.TrimSpace(line[:j]), strings.TrimSpace(line[j+1:])
+		}
+		name = strings.TrimLeft(name, "-")
+		if set[name] {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			log.Fatalf("%s:%d: unknown setting \"%s\"", path, i+1, name)
+		}
+		if err := flag.Set(name, value); err != nil {
+			log.Fatalf("%s:%d: %s", path, i+1, err)
+		}
+	}
+}
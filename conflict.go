@@ -0,0 +1,86 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var replaceOnConflict = flag.Bool("replace-on-conflict", false,
+	"when saving an output differing from the entry with the same fingerprint, replace the entry instead of keeping it")
+
+// entryConflict compares the output at target with the entry src saved
+// for the same fingerprint, returning a description of the difference
+// or "" if they have the same contents or the entry records no
+// checksum. A difference means the build is not reproducible or the
+// fingerprint misses one of its inputs.
+func entryConflict(src, target string) (string, error) {
+	m, err := readMeta(src)
+	if err != nil || m == nil || m.Checksum == "" {
+		return "", err
+	}
+	sum, _, err := fileChecksum(target)
+	if err != nil {
+		return "", err
+	}
+	if sum == m.Checksum {
+		return "", nil
+	}
+	return fmt.Sprintf("entry has sha256 %s (saved %s by %s), output has sha256 %s (built by %s)",
+		m.Checksum, m.Created.Format("2006-01-02T15:04:05Z"), describeProvenance(m.Provenance),
+		sum, describeProvenance(currentProvenance())), nil
+}
+
+// describeProvenance returns a short description of the builder p.
+func describeProvenance(p *provenance) string {
+	if p == nil {
+		return "an unknown builder"
+	}
+	s := fmt.Sprintf("%s@%s (build-cache %s)", p.User, p.Hostname, p.Version)
+	for _, name := range []string{"BUILD_URL", "CI_JOB_URL", "BUILDKITE_BUILD_URL"} {
+		if url := p.Env[name]; url != "" {
+			s += " " + url
+			break
+		}
+	}
+	return s
+}
+
+// saveConflicting handles the output of pkg at target differing from
+// the entry src of the same fingerprint fp in the cache dir: the
+// conflict is reported and the first saved entry is kept, unless
+// --replace-on-conflict was specified, in which case it is replaced by
+// dst. It reports whether the entry was replaced.
+func saveConflicting(pkg *Package, dir, target, src, dst, detail string, r *pkgReport) (bool, error) {
+	log.Printf("WARNING: %s: fingerprint conflict for %s: %s", pkg.ImportPath, src, detail)
+	r.conflict()
+	if !*replaceOnConflict {
+		return false, nil
+	}
+	if err := os.Remove(metaPath(src)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := os.Remove(src); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := storeEntry(dir, target, dst); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var remoteCredentialsHelper = flag.String("remote-credentials-helper", "",
+	"command run using the shell to print the bearer token for an HTTP --remote as "+
+		`{"token": "...", "expiry": "<RFC 3339 time>"}, run again once the token expires`)
+
+// tokenExpiryMargin is how long before its expiry a token from the
+// credentials helper is renewed, so that it does not expire in flight.
+const tokenExpiryMargin = 30 * time.Second
+
+// remoteCredentials authorize the requests of the HTTP backend, with the
+// bearer token printed by --remote-credentials-helper or else with the
+// login and password in the URL or for its host in the .netrc file, if
+// any. They are
+// never logged nor recorded: the failures of the helper do not include
+// its output.
+type remoteCredentials struct {
+	helper          string
+	login, password string // from the URL or .netrc
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time // zero if the token does not expire
+	err    error     // of the helper, which is not run again
+}
+
+// newRemoteCredentials returns the credentials for the HTTP backend at
+// u, or nil if there are none.
+func newRemoteCredentials(u *url.URL) *remoteCredentials {
+	if *remoteCredentialsHelper != "" {
+		return &remoteCredentials{helper: *remoteCredentialsHelper}
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		return &remoteCredentials{login: u.User.Username(), password: password}
+	}
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	login, password, err := netrcLogin(path, u.Hostname())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("warning: %s", err)
+		}
+		return nil
+	}
+	if login == "" && password == "" {
+		return nil
+	}
+	return &remoteCredentials{login: login, password: password}
+}
+
+// authorize adds the credentials to req, running the credentials helper
+// if there is no current token.
+func (c *remoteCredentials) authorize(req *http.Request) error {
+	if c == nil {
+		return nil
+	}
+	if c.helper == "" {
+		req.SetBasicAuth(c.login, c.password)
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if c.token == "" || !c.expiry.IsZero() && time.Now().Add(tokenExpiryMargin).After(c.expiry) {
+		if c.token != "" {
+			vlogf("remote token expired at %s, renewing it", c.expiry.Format(time.RFC3339))
+		}
+		if c.token, c.expiry, c.err = runCredentialsHelper(c.helper); c.err != nil {
+			return c.err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	return nil
+}
+
+// runCredentialsHelper runs the credentials helper cmd and returns the
+// token it prints and its expiry.
+func runCredentialsHelper(cmd string) (string, time.Time, error) {
+	c := exec.Command("/bin/sh", "-c", cmd)
+	c.Stderr = os.Stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("--remote-credentials-helper: %w", err)
+	}
+	var creds struct {
+		Token  string `json:"token"`
+		Expiry string `json:"expiry"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil || creds.Token == "" {
+		return "", time.Time{}, errors.New("--remote-credentials-helper: did not print a token")
+	}
+	var expiry time.Time
+	if creds.Expiry != "" {
+		if expiry, err = time.Parse(time.RFC3339, creds.Expiry); err != nil {
+			return "", time.Time{}, fmt.Errorf("--remote-credentials-helper: invalid expiry \"%s\"", creds.Expiry)
+		}
+	}
+	return creds.Token, expiry, nil
+}
+
+// netrcLogin returns the login and password for host in the .netrc file
+// at path, or those of its default entry if it has none for host.
+func netrcLogin(path, host string) (login, password string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	// The entries for host and the default one.
+	var found, dflt [2]string
+	var entry *[2]string // being read, nil if for another host
+	matched, inMacro := false, false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if inMacro {
+			// A macro definition ends with an empty line.
+			inMacro = strings.TrimSpace(s.Text()) != ""
+			continue
+		}
+		fields := strings.Fields(s.Text())
+		for i := 0; i < len(fields); i++ {
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				entry = nil
+				if value == host && !matched {
+					entry, matched = &found, true
+				}
+				i++
+			case "default":
+				entry = &dflt
+			case "login":
+				if entry != nil {
+					entry[0] = value
+				}
+				i++
+			case "password":
+				if entry != nil {
+					entry[1] = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", "", err
+	}
+	if matched {
+		return found[0], found[1], nil
+	}
+	return dflt[0], dflt[1], nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetrcLogin(t *testing.T) {
+	const netrc = `machine other.example.com login other password other-secret
+default login anonymous password guest
+
+machine cache.example.com
+	login ci
+	account builds
+	password s3cret
+macdef init
+machine cache.example.com login macro password macro
+
+machine cache.example.com login second password second-secret
+`
+	path := filepath.Join(t.TempDir(), ".netrc")
+	if err := os.WriteFile(path, []byte(netrc), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		host, login, password string
+	}{
+		{"cache.example.com", "ci", "s3cret"},
+		{"other.example.com", "other", "other-secret"},
+		{"unknown.example.com", "anonymous", "guest"},
+	} {
+		login, password, err := netrcLogin(path, test.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login != test.login || password != test.password {
+			t.Errorf("%s: got %s:%s, want %s:%s", test.host, login, password, test.login, test.password)
+		}
+	}
+	if _, _, err := netrcLogin(filepath.Join(t.TempDir(), "missing"), "cache.example.com"); !os.IsNotExist(err) {
+		t.Errorf("got %v for a missing file, want it not to exist", err)
+	}
+}
+
+// TestRemoteCredentials checks that the HTTP backend authorizes its
+// requests with the token of the credentials helper, renewing it as it
+// expires, or with the .netrc file, that the credentials appear neither
+// in the output nor in the stats and report, and that a failing helper
+// leaves save to the cache.
+func TestRemoteCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the credentials helper is run with /bin/sh")
+	}
+	const secret = "s3cret-token"
+	f := newFixture(t)
+	s := newRemoteServer(t)
+	url := s.URL + "/cache/"
+	f.install("./...")
+
+	runs := filepath.Join(f.root, "runs")
+	report := filepath.Join(f.root, "report.json")
+	// The token expires as it is issued, so that every request renews it.
+	expiry := time.Now().UTC().Format(time.RFC3339)
+	helper := `echo >> '` + runs + `'; echo '{"token": "` + secret + `", "expiry": "` + expiry + `"}'`
+	s.setAuth("Bearer " + secret)
+	out := f.mustRun("-v", "-remote", url, "-remote-credentials-helper", helper, "-report", report, "save", "./...")
+	if !strings.Contains(out, "remote: 4 pushed") {
+		t.Errorf("save did not push the 4 entries with the token:\n%s", out)
+	}
+	if data, err := os.ReadFile(runs); err != nil {
+		t.Fatal(err)
+	} else if n := strings.Count(string(data), "\n"); n < 2 {
+		t.Errorf("the helper ran %d times, want it run again as the token expired", n)
+	}
+	for name, data := range map[string]string{"output": out, "stats": readFile(t, statsEventsPath(f.cache)), "report": readFile(t, report)} {
+		if strings.Contains(data, secret) {
+			t.Errorf("the token appears in the %s", name)
+		}
+	}
+
+	out = f.mustRun("-remote", url, "-remote-credentials-helper", "echo "+secret+"; exit 1", "save", "./...")
+	if !strings.Contains(out, "unavailable, continuing with the local cache") || strings.Contains(out, secret) {
+		t.Errorf("save did not warn about the failing helper without its output:\n%s", out)
+	}
+
+	netrc := filepath.Join(f.root, ".netrc")
+	data := "machine 127.0.0.1 login ci password " + secret + "\n"
+	if err := os.WriteFile(netrc, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", netrc)
+	s.setAuth("Basic " + base64.StdEncoding.EncodeToString([]byte("ci:"+secret)))
+	if err := os.RemoveAll(f.cache); err != nil {
+		t.Fatal(err)
+	}
+	f.removeOutputs()
+	if out := f.mustRun("-remote", url, "restore", "./..."); !strings.Contains(out, "remote: 4 fetched") {
+		t.Errorf("restore did not fetch the 4 entries with the .netrc login:\n%s", out)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+)
+
+// depRecord describes a package of the dependency closure printed by
+// deps.
+type depRecord struct {
+	ImportPath  string   `json:"importPath"`
+	Dir         string   `json:"dir"`
+	Fingerprint string   `json:"fingerprint"`
+	Imports     []string `json:"imports,omitempty"` // direct imports
+	InRoot      bool     `json:"inRoot"`            // in the repository of a root package
+	Cached      bool     `json:"cached"`
+}
+
+// dependencyRecords returns the records of pkgs, in the order of pkgs,
+// with their cache status in dir. Standard packages are omitted as they
+// are by save and restore.
+func dependencyRecords(pkgs []*Package, dir string) []*depRecord {
+	trees := rootTrees(pkgs)
+	records := []*depRecord{}
+	for _, pkg := range pkgs {
+		if !pkg.cached() {
+			continue
+		}
+		fp := pkg.Fingerprint()
+		r := &depRecord{
+			ImportPath:  pkg.ImportPath,
+			Dir:         pkg.Dir,
+			Fingerprint: fp,
+			InRoot:      pkg.inTree(trees),
+			Cached:      lookupEntry(dir, fp) != "",
+		}
+		for _, dep := range pkg.imports {
+			r.Imports = append(r.Imports, dep.ImportPath)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// deps prints the dependency closure of the specified packages with
+// their fingerprints and whether they are cached, for use by other
+// tools. It does not modify the cache.
+func deps(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	records := dependencyRecords(loadAll(args), cacheDir())
+	if *jsonOutput {
+		fmt.Println(prettyJSON(records))
+		return
+	}
+	for _, r := range records {
+		status := resultMiss
+		if r.Cached {
+			status = resultHit
+		}
+		fmt.Printf("%s  %-4s  %s\n", r.Fingerprint, status, r.ImportPath)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+// diskSpace is only implemented on Linux and macOS.
+func diskSpace(path string) (uint64, uint64, error) {
+	return 0, 0, errDiskSpaceUnsupported
+}
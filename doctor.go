@@ -0,0 +1,135 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// doctor checks the environment build-cache runs in for problems that
+// otherwise show up as unexplained misses or rebuilds.
+func doctor(args []string) {
+	problems := 0
+	report := func(check string, err error) {
+		if err != nil {
+			problems++
+			fmt.Printf("FAIL  %s: %s\n", check, err)
+		} else {
+			fmt.Printf("ok    %s\n", check)
+		}
+	}
+
+	check := "go command"
+	path, version, err := findGo()
+	if path != "" {
+		check += " " + path
+	}
+	if version != "" {
+		check += fmt.Sprintf(" (%s, at least %s needed)", version, minGoVersion)
+	}
+	report(check, err)
+
+	dir := cacheDir()
+	report("cache directory "+dir+" is writable", func() error {
+		if err := createCacheDir(dir); err != nil {
+			return err
+		}
+		f, err := os.CreateTemp(dir, ".doctor-")
+		if err != nil {
+			return err
+		}
+		_ = f.Close()
+		return os.Remove(f.Name())
+	}())
+
+	dirs := []struct{ what, dir string }{
+		{"cache", dir},
+		{"sources", cwd},
+	}
+	for _, entry := range gopathEntries() {
+		dirs = append(dirs, struct{ what, dir string }{"package outputs", filepath.Join(entry, "pkg")})
+	}
+	for _, d := range dirs {
+		skew, err := fsSkew(d.dir)
+		if err == nil && absDuration(skew) > skewThreshold {
+			err = fmt.Errorf("off by %s", skew)
+		}
+		report(fmt.Sprintf("clock of the filesystem holding the %s (%s)", d.what, d.dir), err)
+	}
+
+	check = "hardlinks per cache entry"
+	limit, err := probeLinkLimit(dir, maxLinkProbe)
+	if err == nil && limit < maxLinkProbe {
+		check += fmt.Sprintf(" (limited to %d; entries at the limit are copied)", limit)
+	} else if err == nil {
+		check += fmt.Sprintf(" (at least %d)", limit)
+	}
+	report(check, err)
+
+	check = "import paths unique across GOPATH entries"
+	shadowed, err := shadowedPackages()
+	if err == nil && len(shadowed) > 0 {
+		err = fmt.Errorf("%d have different sources in several entries, the first being used", len(shadowed))
+	}
+	report(check, err)
+	for _, s := range shadowed {
+		fmt.Printf("      %s: %s\n", s.ImportPath, strings.Join(s.Dirs, ", "))
+	}
+
+	check = "open file limit"
+	nofile, err := openFileLimit()
+	if err == nil {
+		check += fmt.Sprintf(" %d (up to %d files opened at once for hashing and copying)",
+			nofile, fdBudgetFor(nofile))
+	}
+	report(check, err)
+
+	if problems > 0 {
+		fmt.Printf("%d problems found\n", problems)
+		os.Exit(1)
+	}
+}
+
+// maxLinkProbe bounds the number of hardlinks created by probeLinkLimit.
+const maxLinkProbe = 100000
+
+// probeLinkLimit returns the number of hardlinks a file in dir can have,
+// found by creating links to a probe file until the filesystem refuses,
+// or max if it allows that many.
+func probeLinkLimit(dir string, max int) (int, error) {
+	tmp, err := os.MkdirTemp(dir, ".doctor-links-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	probe := filepath.Join(tmp, "probe")
+	if err := os.WriteFile(probe, nil, 0644); err != nil {
+		return 0, err
+	}
+	for n := 1; n < max; n++ {
+		if err := os.Link(probe, filepath.Join(tmp, strconv.Itoa(n))); err != nil {
+			if isLinkLimit(err) {
+				return n, nil
+			}
+			return 0, err
+		}
+	}
+	return max, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	duBy  = flag.String("by", "package", "du grouping: package, module or age-bucket")
+	duTop = flag.Int("top", 20, "number of du groups to list (0 for all)")
+)
+
+const unknownGroup = "(unknown)"
+
+// A duGroup is the disk usage of a group of cache entries.
+type duGroup struct {
+	Key        string  `json:"key"`
+	Count      int     `json:"count"`
+	Size       int64   `json:"size"`
+	Percent    float64 `json:"percent"`
+	Cumulative float64 `json:"cumulative"`
+}
+
+// ageBuckets are the age-bucket groups, by upper bound.
+var ageBuckets = []struct {
+	max  time.Duration
+	name string
+}{
+	{24 * time.Hour, "<1d"},
+	{7 * 24 * time.Hour, "1d-7d"},
+	{30 * 24 * time.Hour, "7d-30d"},
+	{90 * 24 * time.Hour, "30d-90d"},
+}
+
+// duKey returns the group of the entry for the grouping by.
+func duKey(e *cacheEntry, by string, now time.Time) string {
+	switch by {
+	case "age-bucket":
+		age := now.Sub(e.Created())
+		for _, b := range ageBuckets {
+			if age < b.max {
+				return b.name
+			}
+		}
+		return ">90d"
+	case "module":
+		if e.Meta != nil && e.Meta.Module != "" {
+			return e.Meta.Module
+		}
+	}
+	if importPath := e.ImportPath(); importPath != "" {
+		return packageBaseImportPath(importPath)
+	}
+	return unknownGroup
+}
+
+// duGroups aggregates the sizes of the entries by the grouping by,
+// sorted by decreasing size.
+func duGroups(entries []*cacheEntry, by string, now time.Time) []*duGroup {
+	m := map[string]*duGroup{}
+	var total int64
+	for _, e := range entries {
+		key := duKey(e, by, now)
+		g := m[key]
+		if g == nil {
+			g = &duGroup{Key: key}
+			m[key] = g
+		}
+		g.Count++
+		g.Size += e.Size
+		total += e.Size
+	}
+
+	groups := make([]*duGroup, 0, len(m))
+	for _, g := range m {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Size != groups[j].Size {
+			return groups[i].Size > groups[j].Size
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	var cum int64
+	for _, g := range groups {
+		cum += g.Size
+		if total > 0 {
+			g.Percent = 100 * float64(g.Size) / float64(total)
+			g.Cumulative = 100 * float64(cum) / float64(total)
+		}
+	}
+	return groups
+}
+
+// du reports the disk usage of the cache grouped by package, module or
+// age.
+func du(args []string) {
+	switch *duBy {
+	case "package", "module", "age-bucket":
+	default:
+		log.Printf("unknown du grouping \"%s\"", *duBy)
+		os.Exit(1)
+	}
+	entries, err := listEntries(cacheDir())
+	if err != nil {
+		log.Fatal(err)
+	}
+	groups := duGroups(entries, *duBy, time.Now())
+	if *duTop > 0 && len(groups) > *duTop {
+		groups = groups[:*duTop]
+	}
+
+	if *jsonOutput {
+		fmt.Println(prettyJSON(groups))
+		return
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	fmt.Printf("%8s %6s %6s %6s  %s\n", "SIZE", "COUNT", "%", "CUM%", strings.ToUpper(*duBy))
+	for _, g := range groups {
+		fmt.Printf("%8s %6d %5.1f%% %5.1f%%  %s\n", humanSize(g.Size), g.Count, g.Percent, g.Cumulative, g.Key)
+	}
+	fmt.Printf("%8s %6d entries in total\n", humanSize(total), len(entries))
+}
@@ -0,0 +1,265 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSlugLen bounds the length of the slug in entry file names.
+const maxSlugLen = 40
+
+// entrySlug returns a short slug identifying the package with the given
+// import path for humans: its last two path elements joined by "-",
+// with characters other than letters, digits, "." and "_" replaced.
+func entrySlug(importPath string) string {
+	importPath = packageBaseImportPath(importPath)
+	elems := strings.Split(path.Clean(importPath), "/")
+	if len(elems) > 2 {
+		elems = elems[len(elems)-2:]
+	}
+	slug := []byte(strings.Join(elems, "-"))
+	for i, c := range slug {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '.', c == '_', c == '-':
+		default:
+			slug[i] = '_'
+		}
+	}
+	if len(slug) > maxSlugLen {
+		slug = slug[len(slug)-maxSlugLen:]
+	}
+	return string(slug)
+}
+
+// entryFileName returns the file name of the entry with fingerprint fp
+// holding the output for the package with the given import path:
+// "<fingerprint>-<slug>". The slug is for humans only; entries are
+// looked up by fingerprint.
+func entryFileName(fp, importPath string) string {
+	if slug := entrySlug(importPath); slug != "" {
+		return fp + "-" + slug
+	}
+	return fp
+}
+
+// entryFingerprint returns the fingerprint of the entry with the given
+// file name. Entries saved by older versions are named by their bare
+// fingerprint.
+func entryFingerprint(name string) string {
+	if i := strings.IndexByte(name, '-'); i != -1 {
+		return name[:i]
+	}
+	return name
+}
+
+var entryIndexMu sync.Mutex
+var entryIndexes = map[string]map[string]string{}
+
+// entryIndex returns the entries of dir indexed by fingerprint, reading
+// the directory on first use. The caller must hold entryIndexMu.
+func entryIndex(dir string) map[string]string {
+	index := entryIndexes[dir]
+	if index == nil {
+		index = map[string]string{}
+		infos, _ := os.ReadDir(dir)
+		removeOrphans(dir, infos)
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() || !isEntryName(name) {
+				continue
+			}
+			index[entryFingerprint(name)] = filepath.Join(dir, name)
+		}
+		entryIndexes[dir] = index
+	}
+	return index
+}
+
+// lookupEntry returns the path of the entry with fingerprint fp in dir,
+// whether or not its file name has a slug, or "" if there is none.
+func lookupEntry(dir, fp string) string {
+	if fp == "" {
+		return ""
+	}
+	entryIndexMu.Lock()
+	path := entryIndex(dir)[fp]
+	entryIndexMu.Unlock()
+	if path == "" || !checkEntryMeta(dir, path) {
+		return ""
+	}
+	return path
+}
+
+// addEntry records the entry at path, stored in dir, in the index used
+// by lookupEntry.
+func addEntry(dir, path string) {
+	entryIndexMu.Lock()
+	defer entryIndexMu.Unlock()
+	entryIndex(dir)[entryFingerprint(filepath.Base(path))] = path
+}
+
+// A cacheEntry is an entry in the cache directory.
+type cacheEntry struct {
+	Path    string     `json:"path"`
+	Name    string     `json:"name"` // the file name: fingerprint and slug, or test result key
+	Size    int64      `json:"size"`
+	ModTime time.Time  `json:"modTime"`
+	Meta    *entryMeta `json:"meta,omitempty"` // nil for entries without metadata
+	MetaErr error      `json:"-"`              // the error reading the metadata, if any
+}
+
+// Fingerprint returns the fingerprint of the entry.
+func (e *cacheEntry) Fingerprint() string {
+	return entryFingerprint(e.Name)
+}
+
+// Created returns the creation time of the entry from its metadata,
+// falling back to its modification time.
+func (e *cacheEntry) Created() time.Time {
+	if e.Meta != nil {
+		return e.Meta.Created
+	}
+	return e.ModTime
+}
+
+// ImportPath returns the import path of the package the entry holds the
+// output of, if known.
+func (e *cacheEntry) ImportPath() string {
+	if e.Meta != nil {
+		return e.Meta.ImportPath
+	}
+	return ""
+}
+
+// remove removes the entry, its metadata and its extra artifacts.
+func (e *cacheEntry) remove() error {
+	if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(metaPath(e.Path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(extraDir(e.Path))
+}
+
+// changed reports whether the entry has been removed, replaced or
+// modified since it was listed.
+func (e *cacheEntry) changed() bool {
+	fi, err := os.Stat(e.Path)
+	return err != nil || fi.Size() != e.Size || !fi.ModTime().Equal(e.ModTime)
+}
+
+// cacheSubdirs lists the subdirectories of the cache holding entries.
+func cacheSubdirs(dir string) []string {
+	return []string{dir, testDir(dir), resultsDir(dir)}
+}
+
+// listEntries returns the entries in the cache directory sorted by
+// name. Metadata and temporary files are not entries. Entries whose
+// metadata cannot be read are returned with MetaErr set.
+func listEntries(dir string) ([]*cacheEntry, error) {
+	var entries []*cacheEntry
+	for _, d := range cacheSubdirs(dir) {
+		infos, err := os.ReadDir(d)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() || !isEntryName(name) {
+				continue
+			}
+			fi, err := info.Info()
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			e := &cacheEntry{
+				Path:    filepath.Join(d, name),
+				Name:    name,
+				Size:    fi.Size(),
+				ModTime: fi.ModTime(),
+			}
+			e.Meta, e.MetaErr = readMeta(e.Path)
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// A byteSize is a flag.Value holding a number of bytes, specified with
+// an optional K, M, G or T suffix (e.g. "64KB", "10G").
+type byteSize int64
+
+var sizeSuffixes = []struct {
+	suffix string
+	mult   int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+	mult := int64(1)
+	for _, x := range sizeSuffixes {
+		if strings.HasSuffix(v, x.suffix) {
+			v, mult = strings.TrimSuffix(v, x.suffix), x.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size \"%s\"", s)
+	}
+	*b = byteSize(n * float64(mult))
+	return nil
+}
+
+// humanSize formats n bytes for display.
+func humanSize(n int64) string {
+	for _, x := range sizeSuffixes {
+		if n >= x.mult {
+			return fmt.Sprintf("%.1f%sB", float64(n)/float64(x.mult), x.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
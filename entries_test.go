@@ -0,0 +1,195 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEntrySlug(t *testing.T) {
+	for _, test := range []struct {
+		importPath, slug string
+	}{
+		{"fmt", "fmt"},
+		{"example.com/app/lib", "app-lib"},
+		{"example.com/app/cmd/tool", "cmd-tool"},
+		{"example.com/app/lib:race", "app-lib"},
+		{"gopkg.in/yaml.v2", "gopkg.in-yaml.v2"},
+		{"_/home/user/src/my pkg", "src-my_pkg"},
+		{"example.com/a+b/c~d", "a_b-c_d"},
+		{"example.com/" + strings.Repeat("x", 50), strings.Repeat("x", maxSlugLen)},
+	} {
+		if slug := entrySlug(test.importPath); slug != test.slug {
+			t.Errorf("entrySlug(%q) = %q, want %q", test.importPath, slug, test.slug)
+		}
+	}
+}
+
+func TestEntryNames(t *testing.T) {
+	fp := strings.Repeat("0123456789abcdef", 3)[:fingerprintWidth]
+	for _, test := range []struct {
+		name  string
+		entry bool
+		fp    string // of the entry
+	}{
+		{fp, true, fp}, // legacy
+		{fp + "-app-lib", true, fp},
+		{fp + "-example.com-a-b-c", true, fp},
+		{fp + "-", true, fp},
+		{fp + ".meta", false, ""},
+		{fp + "-app-lib.meta", false, ""},
+		{fp[1:], false, ""},
+		{fp[1:] + "-app-lib", false, ""},
+		{fp + "0", false, ""},
+		{fp + "0-app-lib", false, ""},
+		{strings.ToUpper(fp[:20]) + fp[20:], false, ""},
+		{"-" + fp, false, ""},
+	} {
+		if entry := isEntryName(test.name); entry != test.entry {
+			t.Errorf("isEntryName(%q) = %v, want %v", test.name, entry, test.entry)
+		}
+		if test.entry {
+			if got := entryFingerprint(test.name); got != test.fp {
+				t.Errorf("entryFingerprint(%q) = %q, want %q", test.name, got, test.fp)
+			}
+		}
+	}
+
+	// Whatever the import path, the fingerprint is recovered from the
+	// name, which names an entry.
+	for _, importPath := range []string{
+		"", "fmt", "example.com/app/lib", "example.com/app/lib:race",
+		"example.com/app-lib/x-y", "_/C_/src/app", "/", "-",
+	} {
+		name := entryFileName(fp, importPath)
+		if got := entryFingerprint(name); got != fp || !isEntryName(name) {
+			t.Errorf("entryFileName(%q) = %q: fingerprint %q, entry %v", importPath, name, got, isEntryName(name))
+		}
+	}
+}
+
+func TestLookupEntry(t *testing.T) {
+	dir := t.TempDir()
+	fp := func(last string) string {
+		return strings.Repeat("a", fingerprintWidth-1) + last
+	}
+	// Fingerprints sharing all but their last character, and slugs
+	// which collide or look like fingerprints.
+	for _, name := range []string{
+		fp("0"),
+		fp("1") + "-app-lib",
+		fp("2") + "-app-lib",
+		fp("3") + "-" + fp("4"),
+		fp("5") + "0-app-lib", // not an entry
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for fp, name := range map[string]string{
+		fp("0"): fp("0"),
+		fp("1"): fp("1") + "-app-lib",
+		fp("2"): fp("2") + "-app-lib",
+		fp("3"): fp("3") + "-" + fp("4"),
+		fp("4"): "",
+		fp("5"): "",
+		fp(""):  "",
+		"":      "",
+	} {
+		want := ""
+		if name != "" {
+			want = filepath.Join(dir, name)
+		}
+		if path := lookupEntry(dir, fp); path != want {
+			t.Errorf("lookupEntry(%q) = %q, want %q", fp, path, want)
+		}
+	}
+
+	// Added entries are found, under either name.
+	for _, name := range []string{fp("6"), fp("7") + "-cmd-tool"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		addEntry(dir, path)
+		if got := lookupEntry(dir, entryFingerprint(name)); got != path {
+			t.Errorf("lookupEntry(%q) after addEntry = %q, want %q", entryFingerprint(name), got, path)
+		}
+	}
+}
+
+// TestEntryFileNames checks that the entries saved from the fixture are
+// named with slugs, that packages whose slugs collide are told apart,
+// and that entries renamed to the legacy bare fingerprint are restored.
+func TestEntryFileNames(t *testing.T) {
+	f := newFixture(t)
+	f.writeFile("other.org/app/lib/lib.go", "package lib\n\nconst Name = \"other\"\n")
+	args := []string{"./...", "other.org/app/lib"}
+	f.install(args...)
+	f.mustRun(append([]string{"save"}, args...)...)
+
+	pkgs := map[string]*Package{}
+	for _, pkg := range f.load(args...) {
+		if pkg.cached() {
+			pkgs[pkg.Fingerprint()] = pkg
+		}
+	}
+	entries, err := listEntries(f.cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		pkg := pkgs[e.Fingerprint()]
+		if pkg == nil {
+			continue
+		}
+		if want := entryFileName(pkg.Fingerprint(), pkg.ImportPath); e.Name != want {
+			t.Errorf("%s: entry %s, want %s", pkg.ImportPath, e.Name, want)
+		}
+		delete(pkgs, e.Fingerprint())
+		// Rename it as older versions named it.
+		legacy := filepath.Join(filepath.Dir(e.Path), e.Fingerprint())
+		for _, path := range []string{e.Path, metaPath(e.Path), extraDir(e.Path)} {
+			if err := os.Rename(path, legacy+strings.TrimPrefix(path, e.Path)); err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, pkg := range pkgs {
+		t.Errorf("%s: not saved", pkg.ImportPath)
+	}
+
+	loaded := f.load(args...)
+	for _, pkg := range loaded {
+		if pkg.cached() {
+			if err := os.Remove(pkg.Target); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	f.mustRun(append([]string{"restore"}, args...)...)
+	for _, pkg := range loaded {
+		if !pkg.cached() {
+			continue
+		}
+		if data, err := os.ReadFile(pkg.Target); err != nil || string(data) != "output of "+pkg.ImportPath+"\n" {
+			t.Errorf("%s: restored %q, %v", pkg.ImportPath, data, err)
+		}
+	}
+}
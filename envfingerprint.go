@@ -0,0 +1,119 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+var (
+	envFingerprint = flag.String("env-fingerprint", "",
+		"string identifying the build environment, such as the digest of a CI container image, "+
+			"fingerprinted as an input of the packages using cgo, or of all packages with -env-fingerprint-scope=all")
+	envFingerprintScope = flag.String("env-fingerprint-scope", "cgo",
+		"packages whose fingerprint includes -env-fingerprint: cgo or all")
+)
+
+var envFingerprintOnce sync.Once
+
+// envFingerprintInputs returns the fingerprint inputs of -env-fingerprint
+// for p. The environment covers what the toolchain checks cannot, such
+// as the C library and headers linked into the packages using cgo.
+func (p *Package) envFingerprintInputs() []string {
+	if p.envFingerprint() == "" {
+		return nil
+	}
+	return []string{"env=" + *envFingerprint}
+}
+
+// envFingerprint returns the -env-fingerprint folded into the
+// fingerprint of p, or "" if there is none.
+func (p *Package) envFingerprint() string {
+	if *envFingerprint == "" {
+		return ""
+	}
+	envFingerprintOnce.Do(func() {
+		switch *envFingerprintScope {
+		case "cgo", "all":
+		default:
+			log.Fatalf("invalid -env-fingerprint-scope \"%s\": must be cgo or all", *envFingerprintScope)
+		}
+	})
+	if *envFingerprintScope == "cgo" && !p.usesCgo() {
+		return ""
+	}
+	return *envFingerprint
+}
+
+// shortEnvFingerprint abbreviates the environment fingerprint s for
+// listings, keeping the start of an image digest.
+func shortEnvFingerprint(s string) string {
+	const max = 19 // "sha256:" and 12 digits
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
+
+// envStats counts the entries of the cache dir and their sizes by the
+// environment fingerprint they were saved with, "" for none.
+func envStats(dir string) (counts map[string]int, sizes map[string]int64) {
+	entries, err := listEntries(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	counts, sizes = map[string]int{}, map[string]int64{}
+	for _, e := range entries {
+		var env string
+		if e.Meta != nil {
+			env = e.Meta.EnvFingerprint
+		}
+		counts[env]++
+		sizes[env] += e.Size
+	}
+	return counts, sizes
+}
+
+// formatEnvStats describes the entries counted by envStats, or returns
+// "" if none of them has an environment fingerprint.
+func formatEnvStats(counts map[string]int, sizes map[string]int64) string {
+	var envs []string
+	for env := range counts {
+		if env != "" {
+			envs = append(envs, env)
+		}
+	}
+	if len(envs) == 0 {
+		return ""
+	}
+	sort.Strings(envs)
+	s := fmt.Sprintf("env      %d environment fingerprints", len(envs))
+	if counts[""] > 0 {
+		envs = append(envs, "")
+	}
+	for _, env := range envs {
+		name := env
+		if name == "" {
+			name = "none"
+		}
+		s += fmt.Sprintf("\n         %s: %d entries (%s)", name, counts[env], humanSize(sizes[env]))
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// The failures the commands tell apart. They are wrapped with their
+// details (see fmt.Errorf) and tested with errors.Is, so that a caller
+// need not match messages; exitStatus maps them to the exit status of
+// a command failing with them.
+var (
+	errCacheMiss        = errors.New("no entry")
+	errCacheUnavailable = errors.New("cache unavailable")
+	errIntegrity        = errors.New("does not match its checksum")
+	errLockTimeout      = errors.New("timed out")
+)
+
+// An uncacheableError is the failure to fingerprint a package, whose
+// output therefore cannot be cached.
+type uncacheableError struct {
+	ImportPath string
+	Reason     string
+}
+
+func (e *uncacheableError) Error() string {
+	return "uncacheable: " + e.Reason
+}
+
+// The exit statuses of the failures tested by exitStatus. Other
+// failures exit with status 1, and a missing go command with exitNoGo.
+const (
+	exitLockTimeout      = 4
+	exitCacheUnavailable = 5
+	exitIntegrity        = 6
+)
+
+// exitStatus returns the exit status of a command failing with err.
+func exitStatus(err error) int {
+	switch {
+	case errors.Is(err, errLockTimeout):
+		return exitLockTimeout
+	case errors.Is(err, errCacheUnavailable):
+		return exitCacheUnavailable
+	case errors.Is(err, errIntegrity):
+		return exitIntegrity
+	}
+	return 1
+}
+
+var bestEffort = flag.Bool("best-effort", false,
+	"make save and restore do what they can and exit with status 0, warning once about their failures")
+
+// failSoftly is set for the commands run with --best-effort, for which
+// failures are not to get in the way of the build: fatal only warns
+// about them and exits with status 0.
+var failSoftly bool
+
+// fatal logs err and exits with its exit status, as log.Fatal does with
+// status 1. The scratch directory of the run is removed first, as
+// exiting skips the deferred removal.
+func fatal(err error) {
+	removeScratch()
+	if failSoftly {
+		log.Printf("warning: build-cache: %s (--best-effort)", err)
+		os.Exit(0)
+	}
+	log.Print(err)
+	os.Exit(exitStatus(err))
+}
+
+// logFailure logs a failure the command gets past. With --best-effort
+// only -v does, fatal summing the failures up in a single warning.
+func logFailure(format string, args ...interface{}) {
+	if failSoftly {
+		vlogf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// commandErrors are the failures a command got past, such as those of
+// some of its packages, in the order they occurred.
+type commandErrors []error
+
+func (e commandErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// Unwrap lets errors.Is and errors.As test each of the failures.
+func (e commandErrors) Unwrap() []error {
+	return e
+}
+
+// err returns e as an error, or nil if there were no failures.
+func (e commandErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,330 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExitStatus(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want int
+	}{
+		{errors.New("other"), 1},
+		{fmt.Errorf("%w after 1s waiting", errLockTimeout), exitLockTimeout},
+		{fmt.Errorf("%w: permission denied", errCacheUnavailable), exitCacheUnavailable},
+		{fmt.Errorf("artifact bin/tool %w", errIntegrity), exitIntegrity},
+		{fmt.Errorf("example.com/app/lib: %w", fmt.Errorf("artifact bin/tool %w", errIntegrity)), exitIntegrity},
+		{fmt.Errorf("%w \"x\" in /cache", errCacheMiss), 1},
+		{&uncacheableError{ImportPath: "example.com/app/lib", Reason: "cgo"}, 1},
+	} {
+		if got := exitStatus(test.err); got != test.want {
+			t.Errorf("exitStatus(%q) = %d, want %d", test.err, got, test.want)
+		}
+	}
+
+	err := fmt.Errorf("restoring: %w", &uncacheableError{ImportPath: "example.com/app/lib", Reason: "cgo"})
+	var uerr *uncacheableError
+	if !errors.As(err, &uerr) || uerr.ImportPath != "example.com/app/lib" || uerr.Reason != "cgo" {
+		t.Errorf("errors.As(%q) = %+v", err, uerr)
+	}
+}
+
+// TestInjectedFailures checks the errors and exit statuses of failures
+// injected into the cache.
+func TestInjectedFailures(t *testing.T) {
+	t.Run("lock timeout", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("the cache is not locked on windows")
+		}
+		f := newFixture(t)
+		if err := createCacheDir(f.cache); err != nil {
+			t.Fatal(err)
+		}
+		unlock, err := lockCacheUse(f.cache, true, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unlock()
+		_, err = lockCacheUse(f.cache, false, 10*time.Millisecond)
+		if !errors.Is(err, errLockTimeout) {
+			t.Errorf("lockCacheUse with the cache locked: %v, want %v", err, errLockTimeout)
+		}
+		f.expectExit(exitLockTimeout, "-lock-timeout", "100ms", "restore", "./...")
+	})
+
+	t.Run("cache unavailable", func(t *testing.T) {
+		f := newFixture(t)
+		if err := os.WriteFile(f.cache, []byte("not a directory"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := createCacheDir(f.cache); !errors.Is(err, errCacheUnavailable) {
+			t.Errorf("createCacheDir(file): %v, want %v", err, errCacheUnavailable)
+		}
+		f.expectExit(exitCacheUnavailable, "save", "./...")
+
+		// A directory which does not look like a cache.
+		if err := os.Remove(f.cache); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(f.cache, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(f.cache, "notes.txt"), []byte("notes"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f.expectExit(exitCacheUnavailable, "save", "./...")
+	})
+
+	t.Run("corrupt artifact", func(t *testing.T) {
+		f := newFixture(t)
+		f.install("./...")
+		artifact := filepath.Join(f.dir("example.com/app"), "bin", "tool")
+		if err := os.MkdirAll(filepath.Dir(artifact), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(artifact, []byte("artifact"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f.mustRun("save", "-artifact", "bin/tool:example.com/app/cmd/tool", "./...")
+		extras, err := filepath.Glob(filepath.Join(f.cache, "*.extra"))
+		if err != nil || len(extras) != 1 {
+			t.Fatalf("saved artifacts: %q, %v", extras, err)
+		}
+		// The stored artifact may be a hard link to the original.
+		stored := filepath.Join(extras[0], "bin", "tool")
+		for _, path := range []string{stored, artifact} {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(stored, []byte("corrupt"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		entry := strings.TrimSuffix(extras[0], ".extra")
+		if err := restoreExtras(entry, time.Now()); !errors.Is(err, errIntegrity) {
+			t.Errorf("restoreExtras: %v, want %v", err, errIntegrity)
+		}
+		f.expectExit(exitIntegrity, "restore", "./...")
+		if exists(artifact) {
+			t.Errorf("corrupt artifact %s restored", artifact)
+		}
+	})
+
+	t.Run("corrupt snapshot", func(t *testing.T) {
+		f := newFixture(t)
+		f.install("./...")
+		f.mustRun("save", "./...")
+		f.mustRun("snapshot", "save", "./...")
+		snapshots, err := filepath.Glob(filepath.Join(snapshotDir(f.cache), "*.tar.gz"))
+		if err != nil || len(snapshots) != 1 {
+			t.Fatalf("snapshots: %q, %v", snapshots, err)
+		}
+		corruptSnapshot(t, snapshots[0])
+		f.expectExit(exitIntegrity, "snapshot", "restore", "./...")
+		// The packages are restored individually instead.
+		for _, pkg := range f.load("./...") {
+			if pkg.cached() && !exists(pkg.Target) {
+				t.Errorf("%s: not restored", pkg.ImportPath)
+			}
+		}
+	})
+
+	t.Run("package failure", func(t *testing.T) {
+		f := newFixture(t)
+		f.install("./...")
+		fps := f.fingerprints("./...")
+		// lib.go alone is larger than 100 bytes.
+		args := []string{"-max-file-size", "100", "-fail-on-large-files", "save", "./..."}
+		out, err := f.run(args...)
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			t.Fatalf("build-cache %v: %v, want exit status 1:\n%s", args, err, out)
+		}
+		if !strings.Contains(out, "example.com/app/lib: lib.go: 132B is larger than -max-file-size") {
+			t.Errorf("save did not report the failure of example.com/app/lib:\n%s", out)
+		}
+		// The other packages are saved all the same.
+		for _, importPath := range []string{"example.com/app/util", "other.org/dep"} {
+			if lookupEntry(f.cache, fps[importPath]) == "" {
+				t.Errorf("%s: not saved:\n%s", importPath, out)
+			}
+		}
+	})
+}
+
+func TestBestEffort(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		setup func(f *fixture) // after the packages are installed and saved
+		args  []string
+		// The packages to be restored all the same, if any.
+		restored []string
+	}{
+		{
+			name: "cache unavailable",
+			setup: func(f *fixture) {
+				if err := os.RemoveAll(f.cache); err != nil {
+					f.t.Fatal(err)
+				}
+				if err := os.WriteFile(f.cache, []byte("not a directory"), 0644); err != nil {
+					f.t.Fatal(err)
+				}
+			},
+			args: []string{"restore", "./..."},
+		},
+		{
+			name: "missing go command",
+			args: []string{"-go", "/nonexistent/go", "restore", "./..."},
+		},
+		{
+			name: "load error",
+			setup: func(f *fixture) {
+				f.writeFile("example.com/app/broken/broken.go", "package broken\n\nimport _ \"example.com/missing\"\n")
+			},
+			args:     []string{"restore", "./..."},
+			restored: []string{"example.com/app/lib", "example.com/app/util"},
+		},
+		{
+			name:     "outside GOPATH",
+			args:     []string{"restore", "./...", "/"},
+			restored: []string{"example.com/app/lib", "example.com/app/util"},
+		},
+		{
+			name: "package failure",
+			// lib.go alone is larger than 100 bytes.
+			args:     []string{"-max-file-size", "100", "-fail-on-large-files", "restore", "./..."},
+			restored: []string{"example.com/app/util"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.install("./...")
+			f.mustRun("save", "./...")
+			pkgs := map[string]*Package{}
+			for _, pkg := range f.load("./...") {
+				pkgs[pkg.ImportPath] = pkg
+				if pkg.cached() && pkg.Target != "" {
+					if err := os.Remove(pkg.Target); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if test.setup != nil {
+				test.setup(f)
+			}
+
+			// Without --best-effort the restore fails.
+			if out, err := f.run(test.args...); err == nil {
+				t.Errorf("build-cache %v succeeded:\n%s", test.args, out)
+			}
+			args := append([]string{"-best-effort"}, test.args...)
+			out, err := f.run(args...)
+			if err != nil {
+				t.Fatalf("build-cache %v: %v, want exit status 0:\n%s", args, err, out)
+			}
+			var warnings []string
+			for _, line := range strings.Split(out, "\n") {
+				if strings.HasPrefix(strings.ToLower(line), "warning") || strings.Contains(line, "can't load") {
+					warnings = append(warnings, line)
+				}
+			}
+			if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "warning: build-cache: ") {
+				t.Errorf("build-cache %v warned %q, want a single warning:\n%s", args, warnings, out)
+			}
+			for _, importPath := range test.restored {
+				if pkg := pkgs[importPath]; !exists(pkg.Target) {
+					t.Errorf("%s: not restored:\n%s", importPath, out)
+				}
+			}
+		})
+	}
+}
+
+// expectExit runs build-cache with args, failing the test unless it
+// exits with status.
+func (f *fixture) expectExit(status int, args ...string) {
+	f.t.Helper()
+	out, err := f.run(args...)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != status {
+		f.t.Errorf("build-cache %v: %v, want exit status %d:\n%s", args, err, status, out)
+	}
+}
+
+// corruptSnapshot replaces the content of the first member of the
+// snapshot at path, keeping its recorded checksum.
+func corruptSnapshot(t *testing.T, path string) {
+	t.Helper()
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(out)
+	tr, tw := tar.NewReader(zr), tar.NewWriter(zw)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			data = []byte("corrupt")
+			hdr.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []io.Closer{tw, zw, out, in} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	maxEntryAge = flag.Duration("max-entry-age", 0,
+		"when restoring, treat entries created longer ago than this as expired")
+	expireOnRead = flag.Bool("expire-on-read", false,
+		"remove the entries found expired when restoring")
+)
+
+// expiredEntry reports whether the entry at src was created longer than
+// --max-entry-age ago, according to its metadata or else its
+// modification time, and is not pinned. With --expire-on-read expired
+// entries are removed.
+func expiredEntry(src string) bool {
+	if *maxEntryAge <= 0 || entryPinned(src) {
+		return false
+	}
+	e := &cacheEntry{Path: src}
+	if meta, err := readMeta(src); err == nil {
+		e.Meta = meta
+	}
+	if fi, err := os.Stat(src); err == nil {
+		e.ModTime = fi.ModTime()
+	}
+	if time.Since(e.Created()) <= *maxEntryAge {
+		return false
+	}
+	if *expireOnRead {
+		if err := e.remove(); err != nil {
+			log.Printf("warning: unable to remove expired entry: %s", err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// An extraInput is a file given with --extra-input whose contents are
+// part of the fingerprint of the packages matching its pattern, or of
+// all the packages outside GOROOT if it has none.
+type extraInput struct {
+	path    string // as given
+	pattern string // import path pattern, possibly with "..."
+	match   func(importPath string) bool
+	input   string // the fingerprinted name and digest of the file
+}
+
+// extraInputsFlag is the flag.Value of the repeatable --extra-input.
+type extraInputsFlag []*extraInput
+
+func (f *extraInputsFlag) String() string {
+	var s []string
+	for _, in := range *f {
+		if in.pattern == "" {
+			s = append(s, in.path)
+		} else {
+			s = append(s, in.path+":"+in.pattern)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// Set parses PATH[:PATTERN]. A volume name is part of the path.
+func (f *extraInputsFlag) Set(s string) error {
+	vol := filepath.VolumeName(s)
+	path, pattern := s, ""
+	if i := strings.LastIndexByte(s[len(vol):], ':'); i != -1 {
+		path, pattern = s[:len(vol)+i], s[len(vol)+i+1:]
+	}
+	if path == "" {
+		return fmt.Errorf("missing path in \"%s\"", s)
+	}
+	in := &extraInput{path: path, pattern: pattern}
+	if pattern != "" {
+		in.match = matchPattern(pattern)
+	}
+	*f = append(*f, in)
+	return nil
+}
+
+var extraInputs extraInputsFlag
+
+func init() {
+	flag.Var(&extraInputs, "extra-input",
+		"file fingerprinted as an input of every package, or of those matching the import path pattern "+
+			"given as PATH:PATTERN (repeatable)")
+}
+
+var (
+	extraInputsOnce sync.Once
+	extraInputsErr  error
+)
+
+// extraInputRoot returns the directory relative to which extra inputs
+// are resolved and named in fingerprints: the root of the module
+// containing the current directory, or else of its repository.
+func extraInputRoot() string {
+	if root := findUp(cwd, "go.mod"); root != "" {
+		return root
+	}
+	return repoRoot(cwd)
+}
+
+// hashExtraInputs digests the extra inputs once. An extra input which
+// cannot be read is an error, as ignoring it would leave fingerprints
+// silently missing it.
+func hashExtraInputs() error {
+	extraInputsOnce.Do(func() {
+		root := extraInputRoot()
+		for _, in := range extraInputs {
+			path := in.path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(root, path)
+			}
+			if _, err := os.Stat(path); err != nil {
+				extraInputsErr = fmt.Errorf("extra input: %w", err)
+				return
+			}
+			digest := hashFileContents(path)
+			if digest == "" {
+				extraInputsErr = fmt.Errorf("extra input: unable to read %s", path)
+				return
+			}
+			// Name the file relative to the root so that fingerprints
+			// do not depend on where the tree is checked out.
+			name := filepath.ToSlash(path)
+			if rel, err := filepath.Rel(root, path); err == nil && rel != ".." &&
+				!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				name = filepath.ToSlash(rel)
+			}
+			in.input = "extra=" + name + ":" + digest
+		}
+	})
+	return extraInputsErr
+}
+
+// extraInputs returns the fingerprinted names and digests of the extra
+// inputs of p.
+func (p *Package) extraInputs() ([]string, error) {
+	if len(extraInputs) == 0 || p.Goroot {
+		return nil, nil
+	}
+	if err := hashExtraInputs(); err != nil {
+		return nil, err
+	}
+	var inputs []string
+	for _, in := range extraInputs {
+		if in.match == nil || in.match(p.baseImportPath) {
+			inputs = append(inputs, in.input)
+		}
+	}
+	return inputs, nil
+}
@@ -0,0 +1,315 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// An artifactFlag is a file given with --artifact, or a directory of
+// files given with --artifact-dir, associated with the package with the
+// import path, or with the root package if it has none.
+type artifactFlag struct {
+	path       string
+	importPath string
+}
+
+// artifactsFlag is the flag.Value of the repeatable --artifact and
+// --artifact-dir.
+type artifactsFlag []artifactFlag
+
+func (f *artifactsFlag) String() string {
+	var s []string
+	for _, a := range *f {
+		if a.importPath == "" {
+			s = append(s, a.path)
+		} else {
+			s = append(s, a.path+":"+a.importPath)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// Set parses PATH[:IMPORT-PATH]. A volume name is part of the path.
+func (f *artifactsFlag) Set(s string) error {
+	vol := filepath.VolumeName(s)
+	a := artifactFlag{path: s}
+	if i := strings.LastIndexByte(s[len(vol):], ':'); i != -1 {
+		a.path, a.importPath = s[:len(vol)+i], s[len(vol)+i+1:]
+	}
+	if a.path == "" {
+		return fmt.Errorf("missing path in \"%s\"", s)
+	}
+	*f = append(*f, a)
+	return nil
+}
+
+var (
+	artifacts    artifactsFlag
+	artifactDirs artifactsFlag
+)
+
+func init() {
+	flag.Var(&artifacts, "artifact",
+		"file produced for a package outside its install target, such as a binary built with go build -o, "+
+			"saved and restored with its output, given as PATH[:IMPORT-PATH] (repeatable)")
+	flag.Var(&artifactDirs, "artifact-dir",
+		"directory of files produced for a package, saved and restored with its output, "+
+			"given as DIR[:IMPORT-PATH] (repeatable)")
+}
+
+// An extraArtifact is a file saved with the output of a package by
+// --artifact or --artifact-dir, recorded in the metadata of its entry.
+// It is stored under the extra directory of the entry and restored to
+// its path, relative to the current directory.
+type extraArtifact struct {
+	Path     string      `json:"path"` // slash-separated, relative
+	Mode     os.FileMode `json:"mode"` // permission bits
+	Size     int64       `json:"size"`
+	Checksum string      `json:"checksum"` // hex SHA-256
+}
+
+// extraDir returns the directory holding the extra artifacts of the
+// cache entry at path.
+func extraDir(entry string) string {
+	return entry + ".extra"
+}
+
+// artifactPath returns the slash-separated clean form of the path of an
+// extra artifact, which must be relative and stay under the current
+// directory.
+func artifactPath(path string) (string, error) {
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" || strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("artifact %s: absolute paths are not allowed", path)
+	}
+	clean := filepath.ToSlash(filepath.Clean(path))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("artifact %s: not under the current directory", path)
+	}
+	return clean, nil
+}
+
+// artifactsByPackage returns the paths of the extra artifacts given by
+// --artifact and --artifact-dir by package among pkgs.
+func artifactsByPackage(pkgs []*Package) (map[*Package][]string, error) {
+	if len(artifacts) == 0 && len(artifactDirs) == 0 {
+		return nil, nil
+	}
+	var roots []*Package
+	for _, pkg := range pkgs {
+		if pkg.root && pkg.cached() {
+			roots = append(roots, pkg)
+		}
+	}
+	owner := func(importPath, path string) (*Package, error) {
+		if importPath == "" {
+			if len(roots) != 1 {
+				return nil, fmt.Errorf("artifact %s: %d root packages; give the import path as PATH:IMPORT-PATH",
+					path, len(roots))
+			}
+			return roots[0], nil
+		}
+		for _, pkg := range pkgs {
+			if pkg.ImportPath == importPath || pkg.baseImportPath == importPath {
+				return pkg, nil
+			}
+		}
+		return nil, fmt.Errorf("artifact %s: package %s is not being saved", path, importPath)
+	}
+	add := func(m map[*Package][]string, pkg *Package, path string) error {
+		clean, err := artifactPath(path)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return fmt.Errorf("artifact: %w", err)
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("artifact %s: not a regular file", path)
+		}
+		m[pkg] = append(m[pkg], clean)
+		return nil
+	}
+
+	m := map[*Package][]string{}
+	for _, a := range artifacts {
+		pkg, err := owner(a.importPath, a.path)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(m, pkg, a.path); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range artifactDirs {
+		pkg, err := owner(a.importPath, a.path)
+		if err != nil {
+			return nil, err
+		}
+		err = filepath.WalkDir(a.path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			return add(m, pkg, path)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("artifact dir: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// saveExtras stores the extra artifacts at paths with the cache entry
+// at entry in the cache dir, recording them in its metadata. An
+// artifact already recorded with the same contents is kept. It returns
+// the number of artifacts stored.
+func saveExtras(dir, entry string, paths []string) (int, error) {
+	m, err := readMeta(entry)
+	if err != nil {
+		return 0, err
+	}
+	if m == nil {
+		return 0, fmt.Errorf("%s has no metadata to record artifacts in", entry)
+	}
+	recorded := map[string]int{}
+	for i, x := range m.Extras {
+		recorded[x.Path] = i
+	}
+	stored := 0
+	for _, path := range paths {
+		fi, err := os.Stat(filepath.FromSlash(path))
+		if err != nil {
+			return stored, err
+		}
+		sum, size, err := fileChecksum(filepath.FromSlash(path))
+		if err != nil {
+			return stored, err
+		}
+		x := &extraArtifact{Path: path, Mode: fi.Mode().Perm(), Size: size, Checksum: sum}
+		i, ok := recorded[path]
+		if ok && *m.Extras[i] == *x {
+			continue
+		}
+		dst := filepath.Join(extraDir(entry), filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return stored, err
+		}
+		_ = os.Remove(dst)
+		if err := storeEntry(dir, filepath.FromSlash(path), dst); err != nil {
+			return stored, err
+		}
+		if ok {
+			m.Extras[i] = x
+		} else {
+			recorded[path] = len(m.Extras)
+			m.Extras = append(m.Extras, x)
+		}
+		stored++
+	}
+	if stored == 0 {
+		return 0, nil
+	}
+	return stored, writeMeta(entry, m)
+}
+
+// restoreExtras restores the extra artifacts recorded with the cache
+// entry at src to their paths, stamping them with now. Each artifact is
+// verified against its checksum before it is moved into place.
+func restoreExtras(src string, now time.Time) error {
+	m, err := readMeta(src)
+	if err != nil || m == nil {
+		return err
+	}
+	for _, x := range m.Extras {
+		// The metadata is not trusted with paths outside the current
+		// directory.
+		path, err := artifactPath(x.Path)
+		if err != nil {
+			return err
+		}
+		if err := restoreExtra(filepath.Join(extraDir(src), filepath.FromSlash(path)),
+			filepath.Join(cwd, filepath.FromSlash(path)), x, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreExtra(from, to string, x *extraArtifact, now time.Time) error {
+	if _, err := checkTarget(to, cwd); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := createTemp(filepath.Dir(to))
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != x.Checksum {
+		err = fmt.Errorf("artifact %s %w", x.Path, errIntegrity)
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), x.Mode.Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), now, now)
+	}
+	if err == nil {
+		// Removes a symlink itself rather than its referent.
+		_ = os.Remove(to)
+		err = os.Rename(tmp.Name(), to)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// checkExtras verifies the extra artifacts recorded in m for the cache
+// entry at path, returning a description of the problem or "".
+func checkExtras(path string, m *entryMeta) string {
+	for _, x := range m.Extras {
+		sum, _, err := fileChecksum(filepath.Join(extraDir(path), filepath.FromSlash(x.Path)))
+		if err != nil {
+			return fmt.Sprintf("artifact %s: %s", x.Path, err)
+		}
+		if sum != x.Checksum {
+			return fmt.Sprintf("artifact %s: checksum does not match", x.Path)
+		}
+	}
+	return ""
+}
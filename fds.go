@@ -0,0 +1,132 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+)
+
+const (
+	// fdHeadroom is the number of file descriptors left to the rest of
+	// the process (the cache lock, go command pipes, stdio) when sizing
+	// the budget from the open file limit.
+	fdHeadroom = 64
+	// defaultFDBudget is the budget where the open file limit cannot be
+	// determined.
+	defaultFDBudget = 256
+	// minFDBudget is enough for a single worker to copy a file.
+	minFDBudget = 2
+)
+
+// An fdBudget bounds the number of files opened concurrently for hashing
+// and copying, so that running many workers does not exceed the open
+// file limit.
+type fdBudget struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	used     int // descriptors held by acquire
+	waiting  int // holders waiting in retry for a release
+	released int // incremented by each release
+}
+
+// fds is the budget shared by all the workers, sized by applyLimits.
+var fds = newFDBudget(defaultFDBudget)
+
+func newFDBudget(limit int) *fdBudget {
+	if limit < minFDBudget {
+		limit = minFDBudget
+	}
+	b := &fdBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// fdBudgetFor returns the budget for the open file limit, or the
+// default budget if the limit is 0 (unknown).
+func fdBudgetFor(limit uint64) int {
+	if limit == 0 {
+		return defaultFDBudget
+	}
+	if limit <= fdHeadroom+minFDBudget {
+		return minFDBudget
+	}
+	if n := limit - fdHeadroom; n < 1<<20 {
+		return int(n)
+	}
+	return 1 << 20
+}
+
+// acquire blocks until n descriptors are available and takes them.
+func (b *fdBudget) acquire(n int) {
+	b.mu.Lock()
+	for b.used > 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+}
+
+// release returns n descriptors taken by acquire.
+func (b *fdBudget) release(n int) {
+	b.mu.Lock()
+	b.used -= n
+	b.released++
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// retry calls open, which opens files within descriptors already
+// acquired, until it succeeds or fails for another reason than the
+// process or system running out of descriptors. After running out it is
+// retried once another holder releases its descriptors; if no other
+// holder could, the error is returned.
+func (b *fdBudget) retry(open func() error) error {
+	for {
+		err := open()
+		if !tooManyOpenFiles(err) || !b.waitRelease() {
+			return err
+		}
+	}
+}
+
+// waitRelease waits for another holder to release descriptors. It
+// returns false without waiting if all the other holders are waiting
+// themselves.
+func (b *fdBudget) waitRelease() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.waiting++
+	b.cond.Broadcast()
+	defer func() { b.waiting-- }()
+	gen := b.released
+	for b.released == gen {
+		// The caller holds descriptors too and is now waiting.
+		if b.used-b.waiting <= 0 {
+			return false
+		}
+		b.cond.Wait()
+	}
+	return true
+}
+
+// tooManyOpenFiles reports whether err is the failure to open a file
+// because the process or the system has too many open files.
+func tooManyOpenFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
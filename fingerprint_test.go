@@ -0,0 +1,102 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// goldenFingerprints holds the fingerprints of the fixture packages by
+// fingerprint algorithm version. A change to the way fingerprints are
+// computed changes them: increment fingerprintVersion and add the new
+// fingerprints here under it, rather than updating those of a released
+// version.
+var goldenFingerprints = map[int]map[string]string{
+	3: {
+		"example.com/app/cmd/tool": "58f18d4ceae90ce582fd4aa3542f27cbb9c85dfc",
+		"example.com/app/lib":      "c0218d896503b7160f8be4e8def1c8f8ce7306ef",
+		"example.com/app/util":     "1015889aa7040d11be2596ec34aca7b496cc1b56",
+		"other.org/dep":            "a069033e750611c3842e1689b6c4c4dd075f37ed",
+	},
+	4: {
+		"example.com/app/cmd/tool": "03339945a9b5e1557cd15cd92cad38b8d58fa068",
+		"example.com/app/lib":      "1c5cec63cd2090759fa7dbdeeeadb32f7d47ed8a",
+		"example.com/app/util":     "43b9c88bd2548312441a61632f7a65e1870f7663",
+		"other.org/dep":            "260562716b9ef29e170249183bdf99fa81f75a8e",
+	},
+}
+
+// goldenGoVersion stands in for the Go release build-cache is built
+// with, which is part of every fingerprint.
+const goldenGoVersion = "go1.21.0"
+
+func TestGoldenFingerprints(t *testing.T) {
+	f := newFixture(t)
+	saved := goVersion
+	goVersion = goldenGoVersion
+	defer func() { goVersion = saved }()
+
+	got := f.fingerprints("./...")
+	want, ok := goldenFingerprints[fingerprintVersion]
+	if !ok {
+		t.Fatalf("no golden fingerprints for fingerprint version %d; add them:\n%s",
+			fingerprintVersion, formatGolden(got))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("fingerprinted %d packages, want %d:\n%s", len(got), len(want), formatGolden(got))
+	}
+	for importPath, fp := range want {
+		if got[importPath] != fp {
+			t.Fatalf("the fingerprints of fingerprint version %d changed; "+
+				"increment fingerprintVersion and add the new ones to goldenFingerprints:\n%s",
+				fingerprintVersion, formatGolden(got))
+		}
+	}
+}
+
+// TestFingerprintInputs checks that editing a source changes the
+// fingerprints of its package and of the packages importing it only.
+func TestFingerprintInputs(t *testing.T) {
+	f := newFixture(t)
+	before := f.fingerprints("./...")
+	f.writeFile("example.com/app/util/util.go", "package util\n\nfunc Hello() string { return \"hello\" }\n")
+	after := f.fingerprints("./...")
+	for importPath, changed := range map[string]bool{
+		"example.com/app/util":     true,
+		"example.com/app/lib":      true,
+		"example.com/app/cmd/tool": true,
+		"other.org/dep":            false,
+	} {
+		if (before[importPath] != after[importPath]) != changed {
+			t.Errorf("%s: fingerprint changed = %t, want %t", importPath, !changed, changed)
+		}
+	}
+}
+
+func formatGolden(fps map[string]string) string {
+	var importPaths []string
+	for importPath := range fps {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+	s := ""
+	for _, importPath := range importPaths {
+		s += fmt.Sprintf("\t\t%q: %q,\n", importPath, fps[importPath])
+	}
+	return s
+}
@@ -0,0 +1,318 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain runs build-cache itself rather than the tests when the test
+// binary is run again by fixture.run.
+func TestMain(m *testing.M) {
+	if os.Getenv("BUILDCACHE_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// sourceTime is the modification time of the fixture sources, older
+// than installTime, that of the outputs installed by fixture.install.
+var (
+	sourceTime  = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	installTime = sourceTime.Add(time.Hour)
+)
+
+// A fixture is a copy of the GOPATH and GOROOT in testdata, installed as
+// the environment of the code under test: example.com/app/cmd/tool
+// imports example.com/app/lib, which imports example.com/app/util,
+// other.org/dep and fmt. The packages are loaded for linux/amd64 from
+// example.com/app, with $CACHE in the temporary directory.
+type fixture struct {
+	t      testing.TB
+	root   string // the temporary directory holding the others
+	gopath string
+	goroot string
+	cache  string
+}
+
+func newFixture(t testing.TB) *fixture {
+	t.Helper()
+	root := t.TempDir()
+	f := &fixture{
+		t:      t,
+		root:   root,
+		gopath: filepath.Join(root, "gopath"),
+		goroot: filepath.Join(root, "goroot"),
+		cache:  filepath.Join(root, "cache"),
+	}
+	copyTree(t, filepath.Join("testdata", "gopath"), f.gopath)
+	copyTree(t, filepath.Join("testdata", "goroot"), f.goroot)
+
+	for name, value := range map[string]string{
+		"GOPATH": f.gopath, "GOROOT": f.goroot, "GO111MODULE": "off", "GOFLAGS": "",
+		"GOENV": "off", "GOBIN": "", "GOOS": "", "GOARCH": "", "CACHE": f.cache,
+		// Gives the fixture GOROOT packages install targets.
+		"GODEBUG": "installgoroot=all",
+	} {
+		t.Setenv(name, value)
+	}
+	savedDefault, savedCwd, savedGobin := build.Default, cwd, gobin
+	build.Default.GOPATH, build.Default.GOROOT = f.gopath, f.goroot
+	cwd, gobin = f.dir("example.com/app"), ""
+	f.setFlag("goos", "linux")
+	f.setFlag("goarch", "amd64")
+	resetState()
+	t.Cleanup(func() {
+		build.Default, cwd, gobin = savedDefault, savedCwd, savedGobin
+		resetState()
+	})
+	return f
+}
+
+// dir returns the directory of the package with the given import path
+// in the fixture GOPATH.
+func (f *fixture) dir(importPath string) string {
+	return filepath.Join(f.gopath, "src", filepath.FromSlash(importPath))
+}
+
+// moduleMode switches the fixture to module mode, with modules resolved
+// offline. go/build runs the go command of the GOROOT to find the
+// packages of modules, so the fixture GOROOT is given one which runs the
+// real go command with its own GOROOT.
+func (f *fixture) moduleMode() {
+	f.t.Helper()
+	if runtime.GOOS == "windows" {
+		f.t.Skip("the go command of the fixture GOROOT is a shell script")
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		f.t.Skip("no go command")
+	}
+	cmd := exec.Command(goCmd, "env", "GOROOT")
+	cmd.Env = append(os.Environ(), "GOROOT=")
+	out, err := cmd.Output()
+	if err != nil {
+		f.t.Fatalf("go env GOROOT: %v", err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\nGOROOT='%s' exec '%s' \"$@\"\n", strings.TrimSpace(string(out)), goCmd)
+	if err := os.MkdirAll(filepath.Join(f.goroot, "bin"), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(f.goroot, "bin", "go"), []byte(script), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"GO111MODULE": "on", "GOWORK": "off", "GOFLAGS": "-mod=mod", "GOPROXY": "off",
+		"GOMODCACHE": filepath.Join(f.root, "modcache"),
+	} {
+		f.t.Setenv(name, value)
+	}
+	resetState()
+}
+
+// setFlag sets the named flag for the duration of the test.
+func (f *fixture) setFlag(name, value string) {
+	f.t.Helper()
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		f.t.Fatal(err)
+	}
+	f.t.Cleanup(func() { _ = flag.Set(name, old) })
+}
+
+// writeFile writes the file of the fixture GOPATH at the slash-separated
+// path relative to its src directory, stamped with sourceTime.
+func (f *fixture) writeFile(path, data string) {
+	f.t.Helper()
+	path = filepath.Join(f.gopath, "src", filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.Chtimes(path, sourceTime, sourceTime); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// writeFiles writes the files, by slash-separated path relative to dir,
+// stamped with sourceTime.
+func (f *fixture) writeFiles(dir string, files map[string]string) {
+	f.t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			f.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			f.t.Fatal(err)
+		}
+		if err := os.Chtimes(path, sourceTime, sourceTime); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+// load loads the packages named by args and their dependencies as the
+// commands do, afresh.
+func (f *fixture) load(args ...string) []*Package {
+	f.t.Helper()
+	resetState()
+	return loadAll(args)
+}
+
+// fingerprints returns the fingerprints of the cached packages named by
+// args and their dependencies, by import path.
+func (f *fixture) fingerprints(args ...string) map[string]string {
+	f.t.Helper()
+	fps := map[string]string{}
+	for _, pkg := range f.load(args...) {
+		if pkg.cached() {
+			fps[pkg.ImportPath] = pkg.Fingerprint()
+		}
+	}
+	return fps
+}
+
+// install writes an output, newer than the sources, at the Target of
+// each package named by args and their dependencies, including those of
+// the fixture GOROOT, as the go command would.
+func (f *fixture) install(args ...string) {
+	f.t.Helper()
+	for _, pkg := range f.load(args...) {
+		if pkg.Target == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(pkg.Target), 0755); err != nil {
+			f.t.Fatal(err)
+		}
+		if err := os.WriteFile(pkg.Target, []byte("output of "+pkg.ImportPath+"\n"), 0644); err != nil {
+			f.t.Fatal(err)
+		}
+		if err := os.Chtimes(pkg.Target, installTime, installTime); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	resetState()
+}
+
+// removeOutputs removes the outputs of the cached packages of the
+// fixture, as installed by install.
+func (f *fixture) removeOutputs() {
+	f.t.Helper()
+	for _, pkg := range f.load("./...") {
+		if pkg.cached() && pkg.Target != "" {
+			if err := os.Remove(pkg.Target); err != nil && !os.IsNotExist(err) {
+				f.t.Fatal(err)
+			}
+		}
+	}
+	resetState()
+}
+
+// command returns the command running build-cache with args from
+// example.com/app.
+func (f *fixture) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = f.dir("example.com/app")
+	cmd.Env = append(os.Environ(), "BUILDCACHE_TEST_MAIN=1")
+	return cmd
+}
+
+// run runs build-cache with args for linux/amd64 and returns its
+// combined output.
+func (f *fixture) run(args ...string) (string, error) {
+	f.t.Helper()
+	out, err := f.command(append([]string{"-goos=linux", "-goarch=amd64"}, args...)...).CombinedOutput()
+	return string(out), err
+}
+
+// mustRun is run failing the test if build-cache fails.
+func (f *fixture) mustRun(args ...string) string {
+	f.t.Helper()
+	out, err := f.run(args...)
+	if err != nil {
+		f.t.Fatalf("build-cache %v: %s\n%s", args, err, out)
+	}
+	return out
+}
+
+// copyTree copies the directory tree src to dst, stamping the files with
+// sourceTime.
+func copyTree(t testing.TB, src, dst string) {
+	t.Helper()
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		return os.Chtimes(target, sourceTime, sourceTime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resetState forgets what the previous test loaded and computed.
+func resetState() {
+	packageCache = map[string]*Package{}
+	loadedDirs.dirs = map[string]string{}
+	buildSettingsOnce = sync.Once{}
+	buildTagsOnce = sync.Once{}
+	raceOnce = sync.Once{}
+	workspaceOnce, workspace = sync.Once{}, nil
+	extraInputsOnce, extraInputsErr = sync.Once{}, nil
+	envFingerprintOnce = sync.Once{}
+	namespaceOnce = sync.Once{}
+	remoteOnce, theRemote = sync.Once{}, nil
+	modMode.once, modMode.mode = sync.Once{}, ""
+	mainGodebug.once, mainGodebug.inputs = sync.Once{}, nil
+	moduleInputsCache.m = nil
+	vendorDigests.m = nil
+	gorootIdentities.m = nil
+	entryIndexes = map[string]map[string]string{}
+	packageIndexes.byDir = nil
+	policySkips.byDir = nil
+	checkedEntries.byPath = nil
+	denylist.loaded, denylist.entries, denylist.changes, denylist.skipped = false, nil, nil, 0
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var hashIndex = flag.String("hash-index", "none",
+	"how source files are identified in fingerprints: none to hash their contents, "+
+		"or git to use the blob IDs of a git checkout's index for files that are unmodified")
+
+// useGitIndex reports whether --hash-index=git is in effect.
+func useGitIndex() bool {
+	switch *hashIndex {
+	case "none", "":
+		return false
+	case "git":
+		return true
+	}
+	log.Fatalf("unknown hash index \"%s\"", *hashIndex)
+	return false
+}
+
+// A gitIndex holds the blob IDs of the files of a git checkout which are
+// unmodified in the work tree, by path relative to the top of the
+// checkout.
+type gitIndex struct {
+	top   string
+	blobs map[string]string
+}
+
+var gitIndexMu sync.Mutex
+var gitIndexes = map[string]*gitIndex{} // by top
+
+// loadGitIndex returns the index of the checkout whose top is top,
+// reading it on first use from "git ls-files" and dropping the files
+// "git status" reports as modified. If git fails the index is empty, so
+// that all files are hashed directly.
+func loadGitIndex(top string) *gitIndex {
+	gitIndexMu.Lock()
+	defer gitIndexMu.Unlock()
+	if idx := gitIndexes[top]; idx != nil {
+		return idx
+	}
+	idx := &gitIndex{top: top, blobs: map[string]string{}}
+	gitIndexes[top] = idx
+
+	out, err := exec.Command("git", "-C", top, "ls-files", "-s", "-z").Output()
+	if err != nil {
+		log.Printf("warning: unable to read the git index of %s, hashing its files: %s", top, err)
+		return idx
+	}
+	for _, rec := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <object> SP <stage> TAB <file>
+		tab := bytes.IndexByte(rec, '\t')
+		if tab == -1 {
+			continue
+		}
+		fields := strings.Fields(string(rec[:tab]))
+		if len(fields) != 3 || fields[2] != "0" || fields[0] == "160000" {
+			// Conflicted entries and submodules.
+			continue
+		}
+		idx.blobs[string(rec[tab+1:])] = fields[1]
+	}
+
+	out, err = exec.Command("git", "-C", top, "status", "--porcelain", "-z", "--untracked-files=no").Output()
+	if err != nil {
+		log.Printf("warning: unable to get the git status of %s, hashing its files: %s", top, err)
+		idx.blobs = map[string]string{}
+		return idx
+	}
+	recs := bytes.Split(out, []byte{0})
+	for i := 0; i < len(recs); i++ {
+		// XY SP <path>, followed by the original path of renames.
+		rec := recs[i]
+		if len(rec) < 4 {
+			continue
+		}
+		delete(idx.blobs, string(rec[3:]))
+		if rec[0] == 'R' || rec[0] == 'C' {
+			i++
+		}
+	}
+	vlogf("git index of %s: %d unmodified files", top, len(idx.blobs))
+	return idx
+}
+
+// gitBlob returns the blob ID of the file at path if it is in a git
+// checkout and unmodified.
+func gitBlob(path string) (string, bool) {
+	top := findUp(filepath.Dir(path), ".git")
+	if top == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(top, path)
+	if err != nil {
+		return "", false
+	}
+	id, ok := loadGitIndex(top).blobs[filepath.ToSlash(rel)]
+	return id, ok
+}
+
+// computedBlobs holds the blob IDs computed for the files outside the
+// index or modified, so that the packages loaded for each of -targets
+// share them.
+var computedBlobs struct {
+	sync.Mutex
+	m map[string]computedBlob // by path
+}
+
+type computedBlob struct {
+	size    int64
+	modTime time.Time
+	id      string
+}
+
+// cachedBlobID returns the blob ID computed for the file at path, if it
+// has not changed since.
+func cachedBlobID(path string, fi os.FileInfo) (string, bool) {
+	computedBlobs.Lock()
+	defer computedBlobs.Unlock()
+	b, ok := computedBlobs.m[path]
+	if !ok || b.size != fi.Size() || !b.modTime.Equal(fi.ModTime()) {
+		return "", false
+	}
+	return b.id, true
+}
+
+// storeBlobID records the blob ID computed for the file at path.
+func storeBlobID(path string, fi os.FileInfo, id string) {
+	computedBlobs.Lock()
+	defer computedBlobs.Unlock()
+	if computedBlobs.m == nil {
+		computedBlobs.m = map[string]computedBlob{}
+	}
+	computedBlobs.m[path] = computedBlob{size: fi.Size(), modTime: fi.ModTime(), id: id}
+}
+
+// gitBlobID returns the blob ID git computes for the size bytes read
+// from r.
+func gitBlobID(r io.Reader, size int64) (string, error) {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", size)
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
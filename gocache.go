@@ -0,0 +1,325 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	gocacheMaxAge = flag.Duration("gocache-max-age", 0,
+		"with gocache save, only archive the files of GOCACHE used this recently (0 for all)")
+	gocacheKeep = flag.Int("gocache-keep", 2,
+		"with gocache save, the number of archives kept for each toolchain and platform")
+)
+
+// gocacheDir returns the directory of the archives of the go command's
+// build cache (GOCACHE) in the cache dir. In module mode the go command
+// installs no package archives, so GOCACHE is all there is to save.
+func gocacheDir(dir string) string {
+	return filepath.Join(dir, "gocache")
+}
+
+// goEnvGOCACHE returns the build cache directory of the go command, or
+// an error if there is none.
+func goEnvGOCACHE() (string, error) {
+	out, err := runGo(goCmd(), "env", "GOCACHE")
+	if err != nil {
+		return "", err
+	}
+	d := strings.TrimSpace(string(out))
+	if strings.Contains(d, "\n") {
+		return "", fmt.Errorf("go env GOCACHE: unexpected output %s", outputExcerpt(out))
+	}
+	if d == "" || d == "off" {
+		return "", fmt.Errorf("the go command's build cache is disabled")
+	}
+	return d, nil
+}
+
+// gocacheToolchainKey returns the part of the keys of the archives of
+// GOCACHE identifying the go command and target platform: the contents
+// of GOCACHE are only of use to the same toolchain.
+func gocacheToolchainKey() string {
+	_, version, _ := findGo()
+	ctx := targetContext()
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %s %s %s", version, ctx.GOOS, ctx.GOARCH, gorootIdentity(ctx.GOROOT))
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// gocacheKey returns the key of the archive of GOCACHE for the packages:
+// the toolchain key followed by a digest of the packages' import paths
+// and fingerprints.
+func gocacheKey(pkgs []*Package) string {
+	var lines []string
+	for _, pkg := range pkgs {
+		if !pkg.cached() {
+			continue
+		}
+		fp := pkg.Fingerprint()
+		if fp == "" {
+			fp = "-"
+		}
+		lines = append(lines, fp+" "+pkg.ImportPath+"\n")
+	}
+	sort.Strings(lines)
+	h := sha1.New()
+	for _, line := range lines {
+		io.WriteString(h, line)
+	}
+	return gocacheToolchainKey() + "-" + hex.EncodeToString(h.Sum(nil))
+}
+
+func gocachePath(dir, key string) string {
+	return filepath.Join(gocacheDir(dir), key+".tar.gz")
+}
+
+// gocacheArchives returns the paths of the archives of GOCACHE for the
+// toolchain key, newest first.
+func gocacheArchives(dir, toolchainKey string) []string {
+	paths, _ := filepath.Glob(filepath.Join(gocacheDir(dir), toolchainKey+"-*.tar.gz"))
+	sort.Slice(paths, func(i, j int) bool {
+		return fileModTime(paths[i]).After(fileModTime(paths[j]))
+	})
+	return paths
+}
+
+func fileModTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// writeGocacheArchive archives the files of the GOCACHE directory src
+// modified since the cutoff (if not zero) into path, returning their
+// number and size.
+func writeGocacheArchive(src, path string, cutoff time.Time) (int, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, 0, err
+	}
+	f, err := createTemp(filepath.Dir(path))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(f.Name())
+
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	var files int
+	var size int64
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Trimmed by a concurrent go command.
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() || (!cutoff.IsZero() && fi.ModTime().Before(cutoff)) {
+			return nil
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := copyInto(tw, p); err != nil {
+			return err
+		}
+		files++
+		size += fi.Size()
+		return nil
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return files, size, os.Rename(f.Name(), path)
+}
+
+// extractGocacheArchive unpacks the archive at path into the GOCACHE
+// directory dst, keeping the files already there, which are named after
+// their contents, and returns the number of files added.
+func extractGocacheArchive(path, dst string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	tr := tar.NewReader(zr)
+	added := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return added, nil
+		}
+		if err != nil {
+			return added, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		target := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		if !underDir(target, dst) {
+			return added, fmt.Errorf("%s: member %s is outside of the cache", path, hdr.Name)
+		}
+		if exists(target) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return added, err
+		}
+		tmp, err := createTemp(filepath.Dir(target))
+		if err != nil {
+			return added, err
+		}
+		_, err = io.Copy(tmp, tr)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), os.FileMode(hdr.Mode).Perm())
+		}
+		if err == nil {
+			// The go command trims the files unused for days by their
+			// modification times.
+			err = os.Chtimes(tmp.Name(), hdr.ModTime, hdr.ModTime)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), target)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return added, err
+		}
+		added++
+	}
+}
+
+// gocache saves or restores the build cache of the go command, GOCACHE,
+// as a single archive keyed by the fingerprints of the packages named by
+// args and the toolchain. "gocache save" archives GOCACHE unless an
+// archive with the same key exists. "gocache restore" unpacks the
+// archive with the same key or, failing that, the newest archive for the
+// same toolchain into GOCACHE before the build.
+func gocache(args []string) {
+	if len(args) == 0 {
+		log.Printf("usage: %s gocache save|restore [packages]", os.Args[0])
+		os.Exit(1)
+	}
+	cmd, args := args[0], args[1:]
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	dir := cacheDir()
+	src, err := goEnvGOCACHE()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch cmd {
+	case "save":
+		key := gocacheKey(loadAll(args))
+		if err := createCacheDir(dir); err != nil {
+			log.Fatal(err)
+		}
+		defer useCache(dir, false)()
+		path := gocachePath(dir, key)
+		if exists(path) {
+			log.Printf("GOCACHE archive %s already saved", key)
+			return
+		}
+		var cutoff time.Time
+		if *gocacheMaxAge > 0 {
+			cutoff = time.Now().Add(-*gocacheMaxAge)
+		}
+		start := time.Now()
+		files, size, err := writeGocacheArchive(src, path, cutoff)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("saved %s to GOCACHE archive %s: %d files (%s, %s compressed) in %s",
+			src, key, files, humanSize(size), humanSize(fileSize(path)), time.Since(start))
+		archives := gocacheArchives(dir, gocacheToolchainKey())
+		for i := *gocacheKeep; i < len(archives); i++ {
+			if err := os.Remove(archives[i]); err != nil {
+				log.Printf("warning: %s", err)
+			}
+		}
+
+	case "restore":
+		key := gocacheKey(loadAll(args))
+		if !exists(dir) {
+			log.Printf("%s does not exist", dir)
+			return
+		}
+		defer useCache(dir, false)()
+		path := gocachePath(dir, key)
+		if !exists(path) {
+			archives := gocacheArchives(dir, gocacheToolchainKey())
+			if len(archives) == 0 {
+				log.Printf("no GOCACHE archive for this toolchain")
+				return
+			}
+			path = archives[0]
+			log.Printf("no GOCACHE archive %s, using the newest one for this toolchain", key)
+		}
+		start := time.Now()
+		added, err := extractGocacheArchive(path, src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("restored %d files to %s from %s in %s", added, src,
+			strings.TrimSuffix(filepath.Base(path), ".tar.gz"), time.Since(start))
+
+	default:
+		log.Fatalf("unknown gocache command \"%s\"", cmd)
+	}
+}
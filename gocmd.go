@@ -0,0 +1,179 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var goCommand = flag.String("go", "", "the go command to run (default go on PATH)")
+
+// minGoVersion is the oldest release of the go command build-cache
+// supports: the first reporting GOVERSION in "go env", with the module
+// behavior build-cache mirrors.
+const minGoVersion = "go1.16"
+
+// exitNoGo is the exit status when the go command is missing or older
+// than minGoVersion.
+const exitNoGo = 3
+
+var goCmdOnce sync.Once
+var goCmdPath, goCmdVersion string
+var goCmdErr error
+
+// findGo locates the go command given by --go or found on PATH and
+// determines its version, returning an error if it cannot be run or is
+// older than minGoVersion.
+func findGo() (path, version string, err error) {
+	goCmdOnce.Do(func() {
+		name := *goCommand
+		if name == "" {
+			name = "go"
+		}
+		goCmdPath, goCmdErr = exec.LookPath(name)
+		if goCmdErr != nil {
+			goCmdErr = fmt.Errorf("the go command was not found: %s", goCmdErr)
+			return
+		}
+		out, err := runGo(goCmdPath, "version")
+		if err != nil {
+			goCmdErr = fmt.Errorf("%s: %w", goCmdPath, err)
+			return
+		}
+		// go version go1.21.3 linux/amd64
+		var fields []string
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "go version ") {
+				fields = strings.Fields(line)
+				break
+			}
+		}
+		if len(fields) < 3 {
+			goCmdErr = fmt.Errorf("%s version: unexpected output %s", goCmdPath, outputExcerpt(out))
+			return
+		}
+		goCmdVersion = fields[2]
+		if fields[2] == "devel" && len(fields) > 3 {
+			goCmdVersion = "devel " + fields[3]
+		}
+		if goVersionOlder(goCmdVersion, minGoVersion) {
+			goCmdErr = fmt.Errorf("%s is %s but build-cache needs %s or later", goCmdPath, goCmdVersion, minGoVersion)
+		}
+	})
+	return goCmdPath, goCmdVersion, goCmdErr
+}
+
+// maxOutputExcerpt bounds the output of the go command quoted in
+// errors.
+const maxOutputExcerpt = 500
+
+// runGo runs the go command at path with args and returns its standard
+// output. Its standard error, such as warnings about ignored symlinks or
+// deprecated settings, is kept apart from the output to parse and logged
+// with -v. The failure of the command quotes the beginning of its
+// standard error, or of its output if it printed nothing else.
+func runGo(path string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	name := "go " + strings.Join(args, " ")
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			vlogf("%s: %s", name, line)
+		}
+	}
+	if err != nil {
+		out := stderr.Bytes()
+		if len(bytes.TrimSpace(out)) == 0 {
+			out = stdout.Bytes()
+		}
+		return nil, fmt.Errorf("%s: %s: %s", name, err, outputExcerpt(out))
+	}
+	return stdout.Bytes(), nil
+}
+
+// outputExcerpt quotes the first maxOutputExcerpt bytes of out, the
+// output of the go command, for errors.
+func outputExcerpt(out []byte) string {
+	out = bytes.TrimSpace(out)
+	if len(out) > maxOutputExcerpt {
+		return fmt.Sprintf("%q...", out[:maxOutputExcerpt])
+	}
+	return fmt.Sprintf("%q", out)
+}
+
+// goCmd returns the path of the go command, as located by findGo.
+func goCmd() string {
+	if path, _, _ := findGo(); path != "" {
+		return path
+	}
+	return "go"
+}
+
+// checkGo exits with a dedicated status if the go command is missing or
+// too old, or as fatal does with --best-effort. It is called before the
+// cache is touched by the commands which need it.
+func checkGo() {
+	path, version, err := findGo()
+	if err != nil && failSoftly {
+		fatal(err)
+	}
+	if err != nil {
+		log.Printf("build-cache: %s", err)
+		log.Printf("install Go %s or later, or point -go at it", strings.TrimPrefix(minGoVersion, "go"))
+		os.Exit(exitNoGo)
+	}
+	vlogf("go: %s (%s)", path, version)
+}
+
+// goVersionOlder reports whether the release v, such as "go1.15.2", is
+// older than min, such as "go1.16". Development versions are never
+// older.
+func goVersionOlder(v, min string) bool {
+	parse := func(v string) (int, int, bool) {
+		if !strings.HasPrefix(v, "go") {
+			return 0, 0, false
+		}
+		elems := strings.SplitN(strings.TrimPrefix(v, "go"), ".", 3)
+		major, err1 := strconv.Atoi(elems[0])
+		minor := 0
+		var err2 error
+		if len(elems) > 1 {
+			// e.g. "21rc1"
+			n := strings.IndexFunc(elems[1], func(c rune) bool { return c < '0' || c > '9' })
+			if n == -1 {
+				n = len(elems[1])
+			}
+			minor, err2 = strconv.Atoi(elems[1][:n])
+		}
+		return major, minor, err1 == nil && err2 == nil
+	}
+	vMajor, vMinor, ok := parse(v)
+	if !ok {
+		return false
+	}
+	mMajor, mMinor, _ := parse(min)
+	return vMajor < mMajor || vMajor == mMajor && vMinor < mMinor
+}
@@ -0,0 +1,194 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGo installs as the go command a script printing stdout and stderr
+// and exiting with status, whatever its arguments.
+func fakeGo(t *testing.T, stdout, stderr string, status int) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range map[string]string{"stdout": stdout, "stderr": stderr} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := "#!/bin/sh\ncat '" + dir + "/stderr' >&2\ncat '" + dir + "/stdout'\nexit " + strconv.Itoa(status) + "\n"
+	path := filepath.Join(dir, "go")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	saved := *goCommand
+	*goCommand = path
+	goCmdOnce, goCmdPath, goCmdVersion, goCmdErr = sync.Once{}, "", "", nil
+	t.Cleanup(func() {
+		*goCommand = saved
+		goCmdOnce, goCmdPath, goCmdVersion, goCmdErr = sync.Once{}, "", "", nil
+	})
+}
+
+// TestGoOutput checks that the warnings the go command prints to
+// standard error are logged with -v rather than parsed, and that its
+// failures and undecodable outputs are errors quoting the beginning of
+// the output.
+func TestGoOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake go command is a shell script")
+	}
+	const symlinkWarning = "go: warning: ignoring symlink /src/example.com/link\n"
+	long := strings.Repeat("x", 2*maxOutputExcerpt)
+	version := func() (interface{}, error) {
+		_, v, err := findGo()
+		return v, err
+	}
+	gocache := func() (interface{}, error) {
+		findGo()
+		return goEnvGOCACHE()
+	}
+	goflags := func() (interface{}, error) {
+		findGo()
+		return goFlags(), nil
+	}
+	std := func() (interface{}, error) {
+		findGo()
+		return stdPackages()
+	}
+	for _, test := range []struct {
+		name           string
+		run            func() (interface{}, error)
+		stdout, stderr string
+		status         int
+		want           interface{}
+		wantErr        []string // substrings
+	}{
+		{
+			name:   "version with warnings",
+			run:    version,
+			stdout: "go version go1.21.3 linux/amd64\n",
+			stderr: symlinkWarning + "go: GOPATH set to GOROOT (/usr/local/go) has no effect\n",
+			want:   "go1.21.3",
+		},
+		{
+			name:   "version after a download",
+			run:    version,
+			stdout: "go: downloading go1.21.3 (linux/amd64)\ngo version go1.21.3 linux/amd64\n",
+			want:   "go1.21.3",
+		},
+		{
+			name:    "version undecodable",
+			run:     version,
+			stdout:  "warning: " + long,
+			wantErr: []string{"version: unexpected output", `"warning: ` + long[:maxOutputExcerpt-len("warning: ")] + `"...`},
+		},
+		{
+			name:    "version failing",
+			run:     version,
+			stderr:  long,
+			status:  2,
+			wantErr: []string{"go version: exit status 2", `"` + long[:maxOutputExcerpt] + `"...`},
+		},
+		{
+			name:   "GOCACHE with warnings",
+			run:    gocache,
+			stdout: "/home/ci/.cache/go-build\n",
+			stderr: symlinkWarning,
+			want:   "/home/ci/.cache/go-build",
+		},
+		{
+			name:    "GOCACHE failing",
+			run:     gocache,
+			stderr:  "go: GOPATH entry is relative; must be absolute path: \"gopath\".\n",
+			status:  1,
+			wantErr: []string{"go env GOCACHE: exit status 1", "GOPATH entry is relative"},
+		},
+		{
+			name:    "GOCACHE undecodable",
+			run:     gocache,
+			stdout:  "go: warning: GOPATH is deprecated\n/home/ci/.cache/go-build\n",
+			wantErr: []string{"go env GOCACHE: unexpected output", "GOPATH is deprecated"},
+		},
+		{
+			name:   "GOFLAGS with warnings",
+			run:    goflags,
+			stdout: "-mod=mod -tags=integration\n",
+			stderr: symlinkWarning,
+			want:   []string{"-mod=mod", "-tags=integration"},
+		},
+		{
+			name:   "std with warnings",
+			run:    std,
+			stdout: "bufio\nfmt\nvendor/golang.org/x/net/http2/hpack\n",
+			stderr: symlinkWarning,
+			want:   []string{"bufio", "fmt"},
+		},
+		{
+			name:    "std undecodable",
+			run:     std,
+			stdout:  "bufio\ngo: warning: ignoring symlink /src/example.com/link\nfmt\n",
+			wantErr: []string{"go list std: unexpected output", "ignoring symlink"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fakeGo(t, test.stdout, test.stderr, test.status)
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			*verbose = true
+			defer func() {
+				log.SetOutput(os.Stderr)
+				*verbose = false
+			}()
+
+			got, err := test.run()
+			if test.wantErr != nil {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				for _, want := range test.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q does not contain %q", err, want)
+					}
+				}
+				if len(err.Error()) > 2*maxOutputExcerpt {
+					t.Errorf("error of %d bytes quotes more than the beginning of the output", len(err.Error()))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			for _, line := range strings.Split(strings.TrimSpace(test.stderr), "\n") {
+				if line != "" && !strings.Contains(logged.String(), line) {
+					t.Errorf("%q not logged with -v:\n%s", line, logged.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The default GODEBUG settings of a binary are baked into its main
+// package when it is linked: they derive from the go directive and the
+// godebug directives of the main module (or workspace), from the
+// //go:debug directives of the main package and from -ldflags, which
+// can override them with -X runtime.godebugDefault. None of these
+// change the compiled libraries, so only main packages fingerprint
+// them.
+
+// godebugDefaultsGOPATH is the go version the defaults of GOPATH mode
+// builds derive from.
+const godebugDefaultsGOPATH = "go1.20"
+
+var mainGodebug struct {
+	once   sync.Once
+	inputs []string
+}
+
+// mainModuleGodebug returns the fingerprint inputs of the default
+// GODEBUG settings shared by every main package built: the go version
+// and godebug directives of go.work or of the main module, and the
+// -ldflags set in GOFLAGS.
+func mainModuleGodebug() []string {
+	mainGodebug.once.Do(func() {
+		var in []string
+		file := goWorkFile()
+		if file == "" {
+			if dirs := mainModuleDirs(); len(dirs) > 0 {
+				file = filepath.Join(dirs[0], "go.mod")
+			}
+		}
+		if file == "" {
+			in = append(in, "godebug.go="+godebugDefaultsGOPATH)
+		} else {
+			goVersion, settings := parseGodebugDirectives(file)
+			in = append(in, "godebug.go="+goVersion)
+			for _, s := range settings {
+				in = append(in, "godebug="+s)
+			}
+		}
+		if ldflags, ok := goFlagValue(goFlags(), "ldflags"); ok {
+			in = append(in, "godebug.ldflags="+ldflags)
+		}
+		mainGodebug.inputs = in
+	})
+	return mainGodebug.inputs
+}
+
+// parseGodebugDirectives returns the version of the go directive and
+// the settings of the godebug directives in the go.mod or go.work file.
+// A file without a go directive is treated as go 1.16 by the go
+// command.
+func parseGodebugDirectives(file string) (goVersion string, settings []string) {
+	goVersion = "go1.16"
+	f, err := os.Open(file)
+	if err != nil {
+		return goVersion, nil
+	}
+	defer f.Close()
+
+	inGodebug := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case inGodebug && fields[0] == ")":
+			inGodebug = false
+		case inGodebug:
+			settings = append(settings, strings.Join(fields, ""))
+		case fields[0] == "godebug" && len(fields) >= 2 && fields[1] == "(":
+			inGodebug = true
+		case fields[0] == "godebug" && len(fields) >= 2:
+			settings = append(settings, strings.Join(fields[1:], ""))
+		case fields[0] == "go" && len(fields) >= 2:
+			goVersion = "go" + fields[1]
+		}
+	}
+	return goVersion, settings
+}
+
+// godebugInputs returns the fingerprint inputs of the default GODEBUG
+// settings of p if it is a main package, those of the main module and
+// its own //go:debug directives, or nil otherwise.
+func (p *Package) godebugInputs() []string {
+	if p.Name != "main" || p.Standard {
+		return nil
+	}
+	in := append([]string(nil), mainModuleGodebug()...)
+	var directives []string
+	for _, d := range p.Directives {
+		if strings.HasPrefix(d.Text, "//go:debug ") {
+			directives = append(directives, "go:debug="+strings.TrimSpace(strings.TrimPrefix(d.Text, "//go:debug ")))
+		}
+	}
+	// Directives are reported in the order of the files; the settings
+	// do not depend on it.
+	sort.Strings(directives)
+	return append(in, directives...)
+}
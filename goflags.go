@@ -0,0 +1,156 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"go/build"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	installSuffix = flag.String("installsuffix", "",
+		"install suffix of package outputs (defaults to -installsuffix in GOFLAGS)")
+	pkgDir = flag.String("pkgdir", "",
+		"directory of package outputs (defaults to -pkgdir in GOFLAGS)")
+)
+
+// goFlags returns the flags in GOFLAGS as reported by "go env", which
+// also reflects "go env -w". If the go command cannot be run the
+// environment is used.
+func goFlags() []string {
+	out, err := runGo(goCmd(), "env", "GOFLAGS")
+	if err != nil {
+		vlogf("%s; using $GOFLAGS", err)
+		return strings.Fields(os.Getenv("GOFLAGS"))
+	}
+	return strings.Fields(string(out))
+}
+
+// goFlagValue returns the value of the named flag in flags, given as
+// -name=value or --name=value.
+func goFlagValue(flags []string, name string) (string, bool) {
+	for i := len(flags) - 1; i >= 0; i-- {
+		f := strings.TrimPrefix(strings.TrimPrefix(flags[i], "-"), "-")
+		if strings.HasPrefix(f, name+"=") {
+			return f[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+var buildTagsOnce sync.Once
+var effectiveTags []string
+
+// buildTags returns the build tags in effect: those given by -tags
+// after "--" or else those set in GOFLAGS, as the go command's own
+// flags override GOFLAGS. Both the comma-separated and the older
+// space-separated lists are accepted.
+func buildTags() []string {
+	buildTagsOnce.Do(func() {
+		v, ok := tagsFlag(passArgs)
+		if !ok {
+			v, _ = tagsFlag(goFlags())
+		}
+		effectiveTags = strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	})
+	return append([]string(nil), effectiveTags...)
+}
+
+// tagsFlag returns the value of the last -tags flag in flags, given as
+// -tags=list or -tags list.
+func tagsFlag(flags []string) (string, bool) {
+	for i := len(flags) - 1; i >= 0; i-- {
+		f := strings.TrimPrefix(strings.TrimPrefix(flags[i], "-"), "-")
+		if strings.HasPrefix(f, "tags=") {
+			return f[len("tags="):], true
+		}
+		if f == "tags" && i+1 < len(flags) && strings.HasPrefix(flags[i], "-") {
+			return flags[i+1], true
+		}
+	}
+	return "", false
+}
+
+var buildSettingsOnce sync.Once
+var effectiveSuffix, effectivePkgdir string
+
+// buildSettings returns the install suffix and package directory in
+// effect: those given by -installsuffix and -pkgdir, or else those set
+// in GOFLAGS. A flag disagreeing with GOFLAGS is reported loudly as the
+// go command will install the package outputs elsewhere.
+func buildSettings() (suffix, pkgdir string) {
+	buildSettingsOnce.Do(func() {
+		flags := goFlags()
+		effectiveSuffix, effectivePkgdir = *installSuffix, *pkgDir
+		settings := []struct {
+			name  string
+			value *string
+		}{
+			{"installsuffix", &effectiveSuffix},
+			{"pkgdir", &effectivePkgdir},
+		}
+		for _, s := range settings {
+			v, ok := goFlagValue(flags, s.name)
+			if !ok {
+				continue
+			}
+			if *s.value == "" {
+				*s.value = v
+			} else if *s.value != v {
+				log.Printf("warning: -%s=%s disagrees with -%s=%s in GOFLAGS; "+
+					"package outputs will not be found where the go command installs them",
+					s.name, *s.value, s.name, v)
+			}
+		}
+		if effectivePkgdir != "" && !filepath.IsAbs(effectivePkgdir) {
+			effectivePkgdir = filepath.Join(cwd, effectivePkgdir)
+		}
+	})
+	return effectiveSuffix, effectivePkgdir
+}
+
+// checkTargets reports loudly when the output of a package is missing
+// from the location derived from the install suffix and package
+// directory in effect but present at the default location, which would
+// otherwise show up only as silent misses.
+func checkTargets(pkgs []*Package) {
+	if suffix, pkgdir := buildSettings(); suffix == "" && pkgdir == "" {
+		return
+	}
+	for _, pkg := range pkgs {
+		if pkg.Standard || pkg.Name == "main" || pkg.Target == "" || exists(pkg.Target) {
+			continue
+		}
+		ctx := *pkg.buildContext
+		ctx.InstallSuffix = ""
+		if pkg.race {
+			ctx.InstallSuffix = "race"
+		}
+		bp, err := ctx.Import(pkg.baseImportPath, pkg.Dir, build.FindOnly)
+		if err == nil && bp.PkgObj != pkg.Target && exists(bp.PkgObj) {
+			log.Printf("warning: %s is installed at %s, not %s: check -installsuffix and -pkgdir",
+				pkg.ImportPath, bp.PkgObj, pkg.Target)
+			return
+		}
+	}
+}
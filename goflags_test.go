@@ -0,0 +1,163 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestBuildTags(t *testing.T) {
+	for _, test := range []struct {
+		goflags  string
+		passArgs []string
+		want     []string
+	}{
+		{"", nil, nil},
+		{"", []string{"-run", "TestX"}, nil},
+		{"-tags=integration", nil, []string{"integration"}},
+		{"-tags=a,b -race", nil, []string{"a", "b"}},
+		{"", []string{"-tags", "a,b"}, []string{"a", "b"}},
+		{"", []string{"--tags=a b"}, []string{"a", "b"}},
+		{"", []string{"-tags", "a", "-tags=b"}, []string{"b"}},
+		// The go command's own flags override GOFLAGS.
+		{"-tags=a", []string{"-tags=b"}, []string{"b"}},
+		{"-tags=a", []string{"-v"}, []string{"a"}},
+	} {
+		newFixture(t)
+		t.Setenv("GOFLAGS", test.goflags)
+		passArgs = test.passArgs
+		resetState()
+		got := targetContext().BuildTags
+		passArgs = nil
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GOFLAGS=%q -- %q: tags %q, want %q", test.goflags, test.passArgs, got, test.want)
+		}
+	}
+}
+
+func TestTagGatedFile(t *testing.T) {
+	const gated = "example.com/app/util/integration.go"
+	missed := regexp.MustCompile(`(?m)^-\s+example\.com/app/util \(`)
+	for _, test := range []struct {
+		goflags  string
+		passArgs []string
+		miss     bool
+	}{
+		{"", nil, false},
+		{"-tags=integration", nil, true},
+		{"", []string{"--", "-tags", "integration"}, true},
+		{"", []string{"--", "-tags=integration"}, true},
+	} {
+		f := newFixture(t)
+		t.Setenv("GOFLAGS", test.goflags)
+		f.writeFile(gated, "//go:build integration\n\npackage util\n\nconst Integration = 1\n")
+		passArgs = test.passArgs
+		f.install("./...")
+		f.mustRun(append([]string{"save", "./..."}, test.passArgs...)...)
+		f.writeFile(gated, "//go:build integration\n\npackage util\n\nconst Integration = 2\n")
+		out := f.mustRun(append([]string{"-v", "restore", "./..."}, test.passArgs...)...)
+		passArgs = nil
+		if miss := missed.MatchString(out); miss != test.miss {
+			t.Errorf("GOFLAGS=%q %q: miss %v after editing %s, want %v:\n%s",
+				test.goflags, test.passArgs, miss, gated, test.miss, out)
+		}
+	}
+}
+
+// TestPkgdir checks that the package directory, however it is set, moves
+// the outputs of packages without changing their fingerprints, unlike the
+// install suffix, so that outputs saved from a -pkgdir are restored to
+// the default location.
+func TestPkgdir(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		flags   []string // name and value pairs; $ROOT is the fixture root
+		goflags string
+		pkgdir  string // the package directory in effect, relative to the fixture root
+		changed bool   // the fingerprints
+	}{
+		{name: "none"},
+		{name: "flag", flags: []string{"pkgdir", "$ROOT/pkgdir"}, pkgdir: "pkgdir"},
+		{name: "relative flag", flags: []string{"pkgdir", "pkgdir"}, pkgdir: "gopath/src/example.com/app/pkgdir"},
+		{name: "GOFLAGS", goflags: "-pkgdir=$ROOT/pkgdir", pkgdir: "pkgdir"},
+		{name: "install suffix", flags: []string{"installsuffix", "shared"}, changed: true},
+		{name: "install suffix in GOFLAGS", goflags: "-installsuffix=shared", changed: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			want := f.fingerprints("./...")
+			for i := 0; i < len(test.flags); i += 2 {
+				f.setFlag(test.flags[i], strings.Replace(test.flags[i+1], "$ROOT", f.root, 1))
+			}
+			t.Setenv("GOFLAGS", strings.Replace(test.goflags, "$ROOT", f.root, 1))
+			pkgdir := ""
+			if test.pkgdir != "" {
+				pkgdir = filepath.Join(f.root, filepath.FromSlash(test.pkgdir))
+			}
+			for _, pkg := range f.load("./...") {
+				if !pkg.cached() {
+					continue
+				}
+				if changed := pkg.Fingerprint() != want[pkg.ImportPath]; changed != test.changed {
+					t.Errorf("%s: fingerprint changed %v, want %v", pkg.ImportPath, changed, test.changed)
+				}
+				if pkgdir != "" && pkg.Name != "main" && !strings.HasPrefix(pkg.Target, pkgdir+string(filepath.Separator)) {
+					t.Errorf("%s: Target %s not in %s", pkg.ImportPath, pkg.Target, pkgdir)
+				}
+			}
+		})
+	}
+
+	t.Run("save and restore", func(t *testing.T) {
+		f := newFixture(t)
+		pkgdir := filepath.Join(f.root, "pkgdir")
+		f.setFlag("pkgdir", pkgdir)
+		f.install("./...")
+		f.mustRun("-pkgdir", pkgdir, "save", "./...")
+		if err := flag.Set("pkgdir", ""); err != nil {
+			t.Fatal(err)
+		}
+		pkgs := f.load("./...")
+		for _, pkg := range pkgs {
+			if pkg.cached() {
+				if err := os.RemoveAll(pkg.Target); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		f.mustRun("restore", "./...")
+		for _, pkg := range pkgs {
+			if !pkg.cached() {
+				continue
+			}
+			if strings.HasPrefix(pkg.Target, pkgdir) {
+				t.Errorf("%s: Target %s in the package directory", pkg.ImportPath, pkg.Target)
+			}
+			if data, err := os.ReadFile(pkg.Target); err != nil || string(data) != "output of "+pkg.ImportPath+"\n" {
+				t.Errorf("%s: restored %q, %v", pkg.ImportPath, data, err)
+			}
+		}
+	})
+}
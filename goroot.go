@@ -0,0 +1,85 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+var hashToolchain = flag.Bool("hash-toolchain", false,
+	"identify the toolchain by the contents of its tools rather than their sizes and modification times")
+
+var gorootIdentities struct {
+	sync.Mutex
+	m map[string]string
+}
+
+// gorootIdentity returns a stable identity of the toolchain installed in
+// goroot, distinguishing installations reporting the same version, such
+// as patched ones, without depending on where it is installed: a hash
+// of its VERSION file and of the names and sizes and modification times
+// (or contents, with --hash-toolchain) of the tools in pkg/tool.
+func gorootIdentity(goroot string) string {
+	gorootIdentities.Lock()
+	defer gorootIdentities.Unlock()
+	if id, ok := gorootIdentities.m[goroot]; ok {
+		return id
+	}
+
+	h := sha1.New()
+	if data, err := os.ReadFile(filepath.Join(goroot, "VERSION")); err == nil {
+		_, _ = h.Write(data)
+	}
+	toolDir := filepath.Join(goroot, "pkg", "tool", runtime.GOOS+"_"+runtime.GOARCH)
+	infos, _ := os.ReadDir(toolDir)
+	for _, info := range infos {
+		fi, err := info.Info()
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if !*hashToolchain {
+			fmt.Fprintf(h, "%s %d %d\n", fi.Name(), fi.Size(), fi.ModTime().UnixNano())
+			continue
+		}
+		fmt.Fprintf(h, "%s\n", fi.Name())
+		f, err := os.Open(filepath.Join(toolDir, fi.Name()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	id := hex.EncodeToString(h.Sum(nil))
+	if gorootIdentities.m == nil {
+		gorootIdentities.m = map[string]string{}
+	}
+	gorootIdentities.m[goroot] = id
+	vlogf("toolchain %s: %s", goroot, id)
+	return id
+}
@@ -0,0 +1,117 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestGorootIdentity checks that two copies of the fixture GOROOT, with
+// host tools, give the packages the same fingerprints unless the copies
+// differ.
+func TestGorootIdentity(t *testing.T) {
+	tool := func(goroot, name string) string {
+		return filepath.Join(goroot, "pkg", "tool", runtime.GOOS+"_"+runtime.GOARCH, name)
+	}
+	write := func(path, data string, mtime time.Time) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		hash    bool                // with -hash-toolchain
+		edit    func(goroot string) // of the second copy
+		changed bool
+	}{
+		{name: "copy", edit: func(string) {}},
+		{
+			name: "VERSION",
+			edit: func(goroot string) {
+				write(filepath.Join(goroot, "VERSION"), "go1.4.2-patched\n", sourceTime)
+			},
+			changed: true,
+		},
+		{
+			name:    "added tool",
+			edit:    func(goroot string) { write(tool(goroot, "vet"), "vet", sourceTime) },
+			changed: true,
+		},
+		{
+			name:    "tool size",
+			edit:    func(goroot string) { write(tool(goroot, "compile"), "compile, patched", sourceTime) },
+			changed: true,
+		},
+		{
+			name:    "tool modification time",
+			edit:    func(goroot string) { write(tool(goroot, "compile"), "compile", installTime) },
+			changed: true,
+		},
+		{
+			name: "tool modification time, hashing tools",
+			hash: true,
+			edit: func(goroot string) { write(tool(goroot, "compile"), "compile", installTime) },
+		},
+		{
+			name:    "tool contents, hashing tools",
+			hash:    true,
+			edit:    func(goroot string) { write(tool(goroot, "compile"), "COMPILE", sourceTime) },
+			changed: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			if test.hash {
+				f.setFlag("hash-toolchain", "true")
+			}
+			a, b := filepath.Join(f.root, "goroot-a"), filepath.Join(f.root, "goroot-b")
+			for _, goroot := range []string{a, b} {
+				copyTree(t, f.goroot, goroot)
+				write(tool(goroot, "compile"), "compile", sourceTime)
+				write(tool(goroot, "link"), "link", sourceTime)
+			}
+			test.edit(b)
+
+			fps := map[string]map[string]string{}
+			for _, goroot := range []string{a, b} {
+				build.Default.GOROOT = goroot
+				t.Setenv("GOROOT", goroot)
+				fps[goroot] = f.fingerprints("./...")
+				if len(fps[goroot]) == 0 {
+					t.Fatal("no packages fingerprinted")
+				}
+			}
+			for importPath, fp := range fps[a] {
+				if changed := fps[b][importPath] != fp; changed != test.changed {
+					t.Errorf("%s: fingerprint changed %v, want %v", importPath, changed, test.changed)
+				}
+			}
+		})
+	}
+}
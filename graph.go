@@ -0,0 +1,149 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var (
+	outputFormat = flag.String("format", "",
+		"output format of graph (dot, the default, or json) and report (markdown, the default)")
+	onlyMisses = flag.Bool("only-misses", false,
+		"limit the graph to uncached packages and their paths to the roots")
+)
+
+// graphNode is a package in the dependency graph emitted by graph.
+type graphNode struct {
+	ImportPath  string   `json:"importPath"`
+	Fingerprint string   `json:"fingerprint"`
+	Cached      bool     `json:"cached"`
+	Stale       bool     `json:"stale"`
+	Root        bool     `json:"root,omitempty"`
+	Imports     []string `json:"imports,omitempty"`
+}
+
+// dependencyGraph returns the nodes of the dependency graph of pkgs
+// annotated with their cache status in dir, in the order of pkgs.
+// Standard packages are omitted as they are not part of the cache. With
+// onlyMisses, only the packages which are uncached or import uncached
+// packages are kept.
+func dependencyGraph(pkgs []*Package, dir string, onlyMisses bool) []*graphNode {
+	inGraph := func(pkg *Package) bool {
+		return pkg.cached()
+	}
+
+	nodes := map[*Package]*graphNode{}
+	for _, pkg := range pkgs {
+		if !inGraph(pkg) {
+			continue
+		}
+		fp := pkg.Fingerprint()
+		nodes[pkg] = &graphNode{
+			ImportPath:  pkg.ImportPath,
+			Fingerprint: fp,
+			Cached:      lookupEntry(dir, fp) != "",
+			Stale:       pkg.Stale || !exists(pkg.Target),
+			Root:        pkg.root,
+		}
+	}
+
+	// A package misses if it is uncached or if any of its dependencies
+	// misses, as the miss changes its fingerprint.
+	misses := map[*Package]bool{}
+	var visit func(pkg *Package) bool
+	visit = func(pkg *Package) bool {
+		if miss, ok := misses[pkg]; ok {
+			return miss
+		}
+		misses[pkg] = false
+		miss := !nodes[pkg].Cached
+		for _, dep := range pkg.imports {
+			if nodes[dep] != nil && visit(dep) {
+				miss = true
+			}
+		}
+		misses[pkg] = miss
+		return miss
+	}
+
+	keep := func(pkg *Package) bool {
+		return nodes[pkg] != nil && (!onlyMisses || visit(pkg))
+	}
+
+	var result []*graphNode
+	for _, pkg := range pkgs {
+		if !keep(pkg) {
+			continue
+		}
+		n := nodes[pkg]
+		for _, dep := range pkg.imports {
+			if keep(dep) {
+				n.Imports = append(n.Imports, dep.ImportPath)
+			}
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// writeDot writes the graph in the Graphviz DOT language, coloring hits
+// green and misses red. Stale packages are drawn dashed.
+func writeDot(nodes []*graphNode) {
+	fmt.Println("digraph deps {")
+	fmt.Println("\tnode [shape=box];")
+	for _, n := range nodes {
+		color := "red"
+		if n.Cached {
+			color = "green"
+		}
+		attrs := fmt.Sprintf("color=%s, label=\"%s\\n%.8s\"", color, n.ImportPath, n.Fingerprint)
+		if n.Stale {
+			attrs += ", style=dashed"
+		}
+		if n.Root {
+			attrs += ", penwidth=2"
+		}
+		fmt.Printf("\t%q [%s];\n", n.ImportPath, attrs)
+	}
+	for _, n := range nodes {
+		for _, imp := range n.Imports {
+			fmt.Printf("\t%q -> %q;\n", n.ImportPath, imp)
+		}
+	}
+	fmt.Println("}")
+}
+
+func graph(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	pkgs := loadAll(args)
+	nodes := dependencyGraph(pkgs, cacheDir(), *onlyMisses)
+	switch *outputFormat {
+	case "dot", "":
+		writeDot(nodes)
+	case "json":
+		fmt.Println(prettyJSON(nodes))
+	default:
+		log.Printf("unknown graph format \"%s\"", *outputFormat)
+		os.Exit(1)
+	}
+}
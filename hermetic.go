@@ -0,0 +1,140 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var hermeticEnvFlag = flag.Bool("hermetic-env", false,
+	"run with a minimal environment (PATH, HOME, GOPATH, GOCACHE and those given by -pass-env) rather than the inherited one")
+
+var passEnv stringsFlag
+
+func init() {
+	flag.Var(&passEnv, "pass-env",
+		"environment variable passed through by -hermetic-env (repeatable, or comma separated)")
+}
+
+// hermeticMarker is set in the environment of the build-cache process
+// run by -hermetic-env.
+const hermeticMarker = "BUILDCACHE_HERMETIC"
+
+// hermeticBase holds the variables always kept by -hermetic-env, in
+// addition to those of build-cache itself (CACHE and BUILDCACHE_*).
+var hermeticBase = []string{"PATH", "HOME", "GOPATH", "GOCACHE"}
+
+// hermeticWindows holds the variables the go command needs on Windows.
+var hermeticWindows = []string{"SystemRoot", "USERPROFILE", "LOCALAPPDATA", "APPDATA", "TEMP", "TMP"}
+
+// hermeticNames returns the names of the variables kept by
+// -hermetic-env.
+func hermeticNames() map[string]bool {
+	names := map[string]bool{"CACHE": true}
+	for _, name := range hermeticBase {
+		names[name] = true
+	}
+	if runtime.GOOS == "windows" {
+		for _, name := range hermeticWindows {
+			names[name] = true
+		}
+	}
+	for _, list := range passEnv {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// hermeticEnv returns the minimal environment built from environ by
+// -hermetic-env.
+func hermeticEnv(environ []string) []string {
+	names := hermeticNames()
+	var env []string
+	for _, kv := range environ {
+		name := kv
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			name = kv[:i]
+		}
+		if runtime.GOOS == "windows" {
+			// Windows variable names are case insensitive.
+			for n := range names {
+				if strings.EqualFold(n, name) {
+					name = n
+				}
+			}
+		}
+		if names[name] || strings.HasPrefix(name, "BUILDCACHE_") {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+	return append(env, hermeticMarker+"=1")
+}
+
+// hermeticEnvNames returns the names of the variables of the
+// environment when running hermetically, to be recorded in the
+// provenance of saved entries, or nil.
+func hermeticEnvNames() []string {
+	if os.Getenv(hermeticMarker) == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range envNames(os.Environ()) {
+		if name != hermeticMarker {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// useHermeticEnv runs build-cache again in the minimal environment of
+// -hermetic-env, so that go/build and all the commands it runs (go,
+// git and hooks) only see that environment, unless this is that run.
+func useHermeticEnv() {
+	if !*hermeticEnvFlag || os.Getenv(hermeticMarker) != "" {
+		return
+	}
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatalf("-hermetic-env: %s", err)
+	}
+	env := hermeticEnv(os.Environ())
+	vlogf("hermetic environment: %s", strings.Join(envNames(env), " "))
+	if err := reexec(self, os.Args, env); err != nil {
+		log.Fatalf("-hermetic-env: %s", err)
+	}
+}
+
+// envNames returns the sorted names of the variables of env.
+func envNames(env []string) []string {
+	var names []string
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			names = append(names, kv[:i])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
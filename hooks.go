@@ -0,0 +1,173 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	onHitCmd = flag.String("on-hit-cmd", "",
+		"command to run for packages found in the cache")
+	onMissCmd = flag.String("on-miss-cmd", "",
+		"command to run for packages missing from the cache")
+	onCompleteCmd = flag.String("on-complete-cmd", "",
+		"command to run once when a save or restore completes")
+	hookBatchSize = flag.Int("hook-batch", 100,
+		"maximum number of packages passed to one invocation of a per-package hook")
+	hookMaxRuns = flag.Int("hook-max-runs", 10,
+		"maximum number of invocations of each per-package hook")
+	hooksStrict = flag.Bool("hooks-strict", false,
+		"exit non-zero if a hook fails")
+)
+
+// hooks runs the user commands specified by the --on-*-cmd flags. The
+// per-package hooks are batched: each invocation receives up to
+// --hook-batch packages as newline separated lists in
+// BUILDCACHE_IMPORT_PATH and BUILDCACHE_FINGERPRINT, and no more than
+// --hook-max-runs invocations are made so that a cold cache doesn't
+// fork a process per package. The completion hook always runs exactly
+// once. The per-package hooks receive the running hit rate in
+// BUILDCACHE_HIT_RATE, as of their batch, and the completion hook the
+// final one.
+type hooks struct {
+	summary  *summary
+	batches  map[string]*hookBatch
+	failures int
+}
+
+// A hookBatch holds the packages awaiting a per-package hook.
+type hookBatch struct {
+	cmd     string
+	result  string
+	paths   []string
+	fps     []string
+	runs    int
+	dropped int
+}
+
+func newHooks(s *summary) *hooks {
+	h := &hooks{
+		summary: s,
+		batches: map[string]*hookBatch{},
+	}
+	if *onHitCmd != "" {
+		h.batches[resultHit] = &hookBatch{cmd: *onHitCmd, result: resultHit}
+	}
+	if *onMissCmd != "" {
+		h.batches[resultMiss] = &hookBatch{cmd: *onMissCmd, result: resultMiss}
+	}
+	return h
+}
+
+func (h *hooks) record(importPath, fp, result string) {
+	b := h.batches[result]
+	if b == nil {
+		return
+	}
+	if b.runs >= *hookMaxRuns {
+		b.dropped++
+		return
+	}
+	b.paths = append(b.paths, importPath)
+	b.fps = append(b.fps, fp)
+	if len(b.paths) >= *hookBatchSize {
+		h.flush(b)
+	}
+}
+
+func (h *hooks) flush(b *hookBatch) {
+	if len(b.paths) == 0 {
+		return
+	}
+	b.runs++
+	h.run(b.cmd,
+		"BUILDCACHE_IMPORT_PATH="+strings.Join(b.paths, "\n"),
+		"BUILDCACHE_FINGERPRINT="+strings.Join(b.fps, "\n"),
+		"BUILDCACHE_RESULT="+b.result,
+		fmt.Sprintf("BUILDCACHE_HIT_RATE=%.4f", h.summary.HitRate))
+	b.paths = b.paths[:0]
+	b.fps = b.fps[:0]
+}
+
+// finish runs the remaining batches of the per-package hooks and the
+// completion hook. It returns an error if a hook failed and
+// --hooks-strict was specified.
+func (h *hooks) finish() error {
+	for _, result := range []string{resultHit, resultMiss} {
+		if b := h.batches[result]; b != nil {
+			h.flush(b)
+			if b.dropped > 0 {
+				log.Printf("%s hook: %d packages dropped after %d runs", result, b.dropped, b.runs)
+			}
+		}
+	}
+
+	if *onCompleteCmd != "" {
+		path, err := writeSummaryJSON(h.summary)
+		if err != nil {
+			log.Printf("complete hook: %s", err)
+			h.failures++
+		} else {
+			h.run(*onCompleteCmd,
+				"BUILDCACHE_RESULT=complete",
+				fmt.Sprintf("BUILDCACHE_HIT_RATE=%.4f", h.summary.HitRate),
+				"BUILDCACHE_SUMMARY_JSON="+path)
+			_ = os.Remove(path)
+		}
+	}
+
+	if h.failures > 0 && *hooksStrict {
+		return fmt.Errorf("%d hook runs failed (--hooks-strict)", h.failures)
+	}
+	return nil
+}
+
+// run executes cmd using the shell with env added to the environment.
+// Failures are logged and counted but are otherwise ignored.
+func (h *hooks) run(cmd string, env ...string) {
+	c := exec.Command("/bin/sh", "-c", cmd)
+	c.Env = append(os.Environ(), env...)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		log.Printf("hook \"%s\" failed: %s", cmd, err)
+		h.failures++
+	}
+}
+
+// writeSummaryJSON writes s to a temporary file in the scratch directory
+// of the run, so that it goes with it, returning its path.
+func writeSummaryJSON(s *summary) (string, error) {
+	// Without a scratch directory, scratchDir returns "", the default
+	// directory for temporary files.
+	f, err := os.CreateTemp(scratchDir(), "summary-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(s); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestHooks checks that a failing hook fails restore only with
+// -hooks-strict, and then only after the run has cleaned up: the
+// journal and scratch directory are removed, the summary JSON given to
+// the completion hook among them.
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run with /bin/sh")
+	}
+	for _, test := range []struct {
+		name   string
+		strict bool
+		fail   bool
+		status int
+	}{
+		{name: "succeeding"},
+		{name: "succeeding, strict", strict: true},
+		{name: "failing", fail: true},
+		{name: "failing, strict", strict: true, fail: true, status: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.install("./...")
+			f.mustRun("save", "./...")
+			f.removeOutputs()
+
+			scratch := filepath.Join(f.root, "scratch")
+			record := filepath.Join(f.root, "record")
+			complete := `cp "$BUILDCACHE_SUMMARY_JSON" '` + record + `.json' && echo "$BUILDCACHE_SUMMARY_JSON" > '` + record + `.path'`
+			if test.fail {
+				complete += " && exit 1"
+			}
+			args := []string{
+				"-tmp-dir", scratch,
+				"-on-hit-cmd", `echo "$BUILDCACHE_HIT_RATE" >> '` + record + `.rates'`,
+				"-on-complete-cmd", complete,
+			}
+			if test.strict {
+				args = append(args, "-hooks-strict")
+			}
+			args = append(args, "restore", "./...")
+			if test.status == 0 {
+				f.mustRun(args...)
+			} else {
+				f.expectExit(test.status, args...)
+			}
+
+			data, err := os.ReadFile(record + ".path")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path := strings.TrimSpace(string(data)); !underDir(path, scratch) {
+				t.Errorf("summary JSON at %s, want it in %s", path, scratch)
+			}
+			var s summary
+			if data, err := os.ReadFile(record + ".json"); err != nil {
+				t.Fatal(err)
+			} else if err := json.Unmarshal(data, &s); err != nil {
+				t.Fatal(err)
+			}
+			if s.Command != "restore" || s.Hits != 4 {
+				t.Errorf("summary JSON of %s with %d hits, want restore with 4", s.Command, s.Hits)
+			}
+			if data, err := os.ReadFile(record + ".rates"); err != nil {
+				t.Fatal(err)
+			} else if rates := strings.Fields(string(data)); len(rates) != 1 || rates[0] != "1.0000" {
+				t.Errorf("per-package hooks got the hit rates %v, want [1.0000]", rates)
+			}
+
+			for _, dir := range []string{scratch, journalDir(f.cache)} {
+				if entries, err := os.ReadDir(dir); err != nil && !os.IsNotExist(err) {
+					t.Fatal(err)
+				} else if len(entries) > 0 {
+					t.Errorf("%s left after the run: %v", dir, entries)
+				}
+			}
+		})
+	}
+}
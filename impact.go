@@ -0,0 +1,223 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// impactTop is the number of changed packages listed by impact.
+const impactTop = 10
+
+// latestKey returns the key identifying the outputs of the package with
+// the import path in the build configuration: its platform and
+// instrumentation.
+func latestKey(importPath, goos, goarch string, inst instrumentation) string {
+	return fmt.Sprintf("%s %s/%s %s", importPath, goos, goarch, inst)
+}
+
+// latestEntries returns the most recently saved entry of each package
+// in dir, keyed by latestKey. Entries without metadata and test
+// binaries are ignored.
+func latestEntries(dir string) map[string]*cacheEntry {
+	entries, err := listEntries(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	latest := map[string]*cacheEntry{}
+	for _, e := range entries {
+		m := e.Meta
+		if m == nil || m.Test {
+			continue
+		}
+		key := latestKey(m.ImportPath, m.GOOS, m.GOARCH, metaInstrumentation(m))
+		if l := latest[key]; l == nil || e.Created().After(l.Created()) {
+			latest[key] = e
+		}
+	}
+	return latest
+}
+
+// An impactRecord is a package whose own inputs changed since its last
+// saved entry, with the number of packages this invalidates.
+type impactRecord struct {
+	ImportPath  string `json:"importPath"`
+	Fingerprint string `json:"fingerprint"`
+	Previous    string `json:"previous"` // the fingerprint of the last saved entry
+	Dependents  int    `json:"dependents"`
+}
+
+// An impactReport summarizes the packages invalidated since their last
+// saved entries.
+type impactReport struct {
+	Packages    int             `json:"packages"`
+	Unknown     int             `json:"unknown"`     // never saved
+	Invalidated int             `json:"invalidated"` // dependents of the Changed packages
+	Changed     []*impactRecord `json:"changed"`     // by decreasing Dependents
+
+	// The build time recorded for the changed and invalidated
+	// packages, and the number of them with none recorded.
+	RebuildSeconds float64 `json:"rebuildSeconds"`
+	Unestimated    int     `json:"unestimated"`
+	// The build time recorded for the unaffected packages, which are
+	// restored from the cache.
+	SavedSeconds float64 `json:"savedSeconds"`
+
+	previous map[*Package]*cacheEntry // the latest entry of each package
+}
+
+// computeImpact compares the fingerprints of pkgs with the latest ones
+// saved in dir. A package whose fingerprint changed although those of
+// its imports did not changed itself, and invalidates its dependents
+// among pkgs.
+func computeImpact(pkgs []*Package, dir string) *impactReport {
+	latest := latestEntries(dir)
+	importers := map[*Package][]*Package{}
+	previous := map[*Package]*cacheEntry{}
+	changed := map[*Package]string{} // previous fingerprint
+	rep := &impactReport{Changed: []*impactRecord{}, previous: previous}
+	for _, pkg := range pkgs {
+		if !pkg.cached() {
+			continue
+		}
+		rep.Packages++
+		for _, imp := range pkg.imports {
+			importers[imp] = append(importers[imp], pkg)
+		}
+		key := latestKey(pkg.ImportPath, pkg.buildContext.GOOS, pkg.buildContext.GOARCH,
+			packageInstrumentation(pkg))
+		e, ok := latest[key]
+		if !ok {
+			rep.Unknown++
+			continue
+		}
+		previous[pkg] = e
+		if prev := e.Fingerprint(); prev != pkg.Fingerprint() {
+			changed[pkg] = prev
+		}
+	}
+
+	invalidated := map[*Package]bool{}
+	sources := map[*Package]bool{}
+	for pkg, prev := range changed {
+		source := true
+		for _, imp := range pkg.imports {
+			if _, ok := changed[imp]; ok {
+				source = false
+				break
+			}
+		}
+		if !source {
+			continue
+		}
+		sources[pkg] = true
+		// Collect the transitive importers.
+		seen := map[*Package]bool{}
+		queue := []*Package{pkg}
+		for len(queue) > 0 {
+			p := queue[0]
+			queue = queue[1:]
+			for _, imp := range importers[p] {
+				if !seen[imp] {
+					seen[imp] = true
+					invalidated[imp] = true
+					queue = append(queue, imp)
+				}
+			}
+		}
+		rep.Changed = append(rep.Changed, &impactRecord{
+			ImportPath:  pkg.ImportPath,
+			Fingerprint: pkg.Fingerprint(),
+			Previous:    prev,
+			Dependents:  len(seen),
+		})
+	}
+	for pkg := range invalidated {
+		// A package whose own inputs changed may also import another
+		// one which did; it is counted as changed.
+		if !sources[pkg] {
+			rep.Invalidated++
+		}
+	}
+	for pkg := range sources {
+		invalidated[pkg] = true
+	}
+	for pkg := range invalidated {
+		if e := previous[pkg]; e != nil && e.Meta.BuildSeconds > 0 {
+			rep.RebuildSeconds += e.Meta.BuildSeconds
+		} else {
+			rep.Unestimated++
+		}
+	}
+	for pkg, e := range previous {
+		if !invalidated[pkg] {
+			rep.SavedSeconds += e.Meta.BuildSeconds
+		}
+	}
+	sort.Slice(rep.Changed, func(i, j int) bool {
+		a, b := rep.Changed[i], rep.Changed[j]
+		if a.Dependents != b.Dependents {
+			return a.Dependents > b.Dependents
+		}
+		return a.ImportPath < b.ImportPath
+	})
+	return rep
+}
+
+// impact reports the packages whose fingerprints changed since their
+// last saved entries, tracing the changes to the packages whose own
+// inputs changed and counting the dependents each invalidates.
+func impact(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	dir := cacheDir()
+	start := time.Now()
+	pkgs := loadAll(args)
+	vlogf("finished loading: %s", time.Since(start))
+
+	rep := computeImpact(pkgs, dir)
+	if *jsonOutput {
+		fmt.Println(prettyJSON(rep))
+		return
+	}
+
+	fmt.Printf("%d packages changed since their last saved entries, invalidating %d dependents (of %d packages)\n",
+		len(rep.Changed), rep.Invalidated, rep.Packages)
+	if rep.Unknown > 0 {
+		fmt.Printf("%d packages have never been saved\n", rep.Unknown)
+	}
+	if rep.RebuildSeconds > 0 {
+		fmt.Printf("estimated rebuild time %s", time.Duration(rep.RebuildSeconds*float64(time.Second)).Round(100*time.Millisecond))
+		if rep.Unestimated > 0 {
+			fmt.Printf(" (%d packages without a recorded build time)", rep.Unestimated)
+		}
+		fmt.Println()
+	}
+	if line := formatSaved(rep.SavedSeconds); line != "" {
+		fmt.Println(line)
+	}
+	for i, r := range rep.Changed {
+		if i == impactTop && !*verbose {
+			fmt.Printf("... and %d more (-v lists all)\n", len(rep.Changed)-i)
+			break
+		}
+		fmt.Printf("%6d dependents  %s\n", r.Dependents, r.ImportPath)
+	}
+}
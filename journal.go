@@ -0,0 +1,132 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A restoreJournal records the package outputs restored by a restore so
+// that a rerun after an interruption can skip them. Each line holds the
+// fingerprint and Target of a restored package. The journal is removed
+// when a restore completes.
+type restoreJournal struct {
+	path string
+	done map[string]string // Target by fingerprint, from the previous run
+
+	mu sync.Mutex // protects f
+	f  *os.File
+}
+
+// journalDir returns the directory of the restore journals in the
+// cache dir.
+func journalDir(dir string) string {
+	return filepath.Join(dir, "journal")
+}
+
+// openJournal opens the journal of the restore of args from the current
+// directory, reading the packages restored by a previous, interrupted
+// run. Errors are logged and leave the journal disabled.
+func openJournal(dir string, args []string) *restoreJournal {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n%s", cwd, strings.Join(args, " "), altRoot)
+	j := &restoreJournal{
+		path: filepath.Join(journalDir(dir), hex.EncodeToString(h.Sum(nil))),
+		done: map[string]string{},
+	}
+
+	if f, err := os.Open(j.path); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			// A partial line left by the interruption is corrupt or,
+			// unframed, has no Target.
+			record, ok := unframeLine(s.Bytes(), func([]byte) bool { return true })
+			if !ok {
+				continue
+			}
+			if fields := strings.SplitN(string(record), " ", 2); len(fields) == 2 && fields[1] != "" {
+				j.done[fields[0]] = fields[1]
+			}
+		}
+		_ = f.Close()
+		if len(j.done) > 0 {
+			log.Printf("resuming an interrupted restore: %d packages already restored", len(j.done))
+		}
+	}
+
+	err := os.MkdirAll(journalDir(dir), 0755)
+	if err == nil {
+		j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	}
+	if err != nil {
+		log.Printf("warning: unable to write restore journal: %s", err)
+	}
+	return j
+}
+
+// completed reports whether the previous run restored the package
+// output with fingerprint fp to target and target still exists.
+func (j *restoreJournal) completed(fp, target string) bool {
+	return j.done[fp] == target && exists(target)
+}
+
+// record notes that the package output with fingerprint fp has been
+// restored to target.
+func (j *restoreJournal) record(fp, target string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f != nil {
+		_, _ = j.f.Write(frameLine([]byte(fp + " " + target)))
+	}
+}
+
+// remove removes the journal once the restore has completed.
+func (j *restoreJournal) remove() {
+	if j.f != nil {
+		_ = j.f.Close()
+	}
+	_ = os.Remove(j.path)
+}
+
+// alreadyRestored reports whether the Target of pkg is up to date and
+// holds the contents of the cache entry at src, so that it need not be
+// copied again.
+func alreadyRestored(pkg *Package, src string) bool {
+	if pkg.Stale {
+		return false
+	}
+	fi, err := os.Stat(pkg.Target)
+	if err != nil {
+		return false
+	}
+	if srcInfo, err := os.Stat(src); err == nil && os.SameFile(fi, srcInfo) {
+		return true
+	}
+	meta, err := readMeta(src)
+	if err != nil || meta == nil || meta.Size != fi.Size() {
+		return false
+	}
+	sum, _, err := fileChecksum(pkg.Target)
+	return err == nil && sum == meta.Checksum
+}
@@ -0,0 +1,86 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// keyInputs returns the inputs of the key printed by the key command:
+// the toolchain and the module resolution inputs of the main modules,
+// including the contents of the local directories replacing modules.
+// Main modules are named by their module paths and replacements by the
+// module path@version replaced and the directory as written, relative
+// to the module root, so that the key depends neither on where the tree
+// is checked out nor on the directory build-cache runs in.
+func keyInputs() []string {
+	ctx := targetContext()
+	inputs := toolchain(&ctx)
+	for _, root := range mainModuleDirs() {
+		name := modulePath(filepath.Join(root, "go.mod"))
+		if name == "" {
+			name = filepath.Base(root)
+		}
+		in := mainModuleInputs(root)
+		for _, s := range in.fingerprintInputs() {
+			inputs = append(inputs, name+" "+s)
+		}
+		for _, file := range moduleFiles(root) {
+			for _, r := range localReplacements(file) {
+				digest, err := vendorContents(replacementDir(file, r.Dir))
+				if err != nil {
+					log.Fatalf("%s: replacement %s: %s", file, r.Dir, err)
+				}
+				inputs = append(inputs, fmt.Sprintf("%s replace=%s=>%s files=%s", name, r.Module, filepath.ToSlash(r.Dir), digest))
+			}
+		}
+	}
+	return inputs
+}
+
+// key prints a key for caching the cache directory in CI: a digest of
+// the inputs which change fingerprints without changing any source
+// file, such as the toolchain and the go.mod, go.sum and go.work files
+// of the main modules. -v lists the inputs. With -targets it prints
+// the key of each platform followed by the platform, as sha256sum
+// prints files.
+func key(args []string) {
+	if *targetList == "" {
+		fmt.Println(computeKey())
+		return
+	}
+	err := forEachTarget(func() (*summary, error) {
+		fmt.Printf("%s  %s\n", computeKey(), platform{*targetGOOS, *targetGOARCH})
+		return nil, nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// computeKey returns the key printed by key for the target platform.
+func computeKey() string {
+	h := sha256.New()
+	for _, s := range keyInputs() {
+		vlogf("%s", s)
+		fmt.Fprintf(h, "%s\n", s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
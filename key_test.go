@@ -0,0 +1,122 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const keyGoMod = `module example.com/mod
+
+go 1.20
+
+require example.com/dep v1.0.0
+
+replace example.com/dep v1.0.0 => ../dep
+`
+
+func TestKeyInputs(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		edit func(f *fixture)
+		cwd  string // to compute the key in after the edit, if not the module root
+		// Whether the key changes.
+		changed bool
+	}{
+		{
+			name: "replace directive",
+			edit: func(f *fixture) {
+				f.writeFile("mods/mod/go.mod", strings.Replace(keyGoMod, "=> ../dep", "=> ../dep2", 1))
+			},
+			changed: true,
+		},
+		{
+			name: "replaced module version",
+			edit: func(f *fixture) {
+				f.writeFile("mods/mod/go.mod", strings.Replace(keyGoMod, "v1.0.0", "v1.1.0", -1))
+			},
+			changed: true,
+		},
+		{
+			name: "required module version",
+			edit: func(f *fixture) {
+				f.writeFile("mods/mod/go.mod", strings.Replace(keyGoMod, "require example.com/dep v1.0.0",
+					"require (\n\texample.com/dep v1.0.0\n\texample.com/other v1.2.0\n)", 1))
+			},
+			changed: true,
+		},
+		{
+			name: "toolchain directive",
+			edit: func(f *fixture) {
+				f.writeFile("mods/mod/go.mod", strings.Replace(keyGoMod, "go 1.20\n", "go 1.20\n\ntoolchain go1.21.0\n", 1))
+			},
+			changed: true,
+		},
+		{
+			name:    "go.sum",
+			edit:    func(f *fixture) { f.writeFile("mods/mod/go.sum", "example.com/other v1.2.0 h1:abc=\n") },
+			changed: true,
+		},
+		{
+			name:    "replacement contents",
+			edit:    func(f *fixture) { f.writeFile("mods/dep/dep.go", "package dep\n\nvar X = 2\n") },
+			changed: true,
+		},
+		{
+			name: "subdirectory",
+			edit: func(f *fixture) { f.writeFile("mods/mod/sub/sub.go", "package sub\n") },
+			cwd:  "mods/mod/sub",
+		},
+		{
+			name: "moved checkout",
+			edit: func(f *fixture) {
+				if err := os.Rename(f.dir("mods"), filepath.Join(f.root, "checkout")); err != nil {
+					f.t.Fatal(err)
+				}
+			},
+			cwd: "../../checkout/mod",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			t.Setenv("GO111MODULE", "on")
+			t.Setenv("GOWORK", "off")
+			f.writeFile("mods/mod/go.mod", keyGoMod)
+			f.writeFile("mods/mod/mod.go", "package mod\n")
+			for _, dep := range []string{"dep", "dep2"} {
+				f.writeFile("mods/"+dep+"/go.mod", "module example.com/dep\n")
+				f.writeFile("mods/"+dep+"/dep.go", "package dep\n\nvar X = 1\n")
+			}
+			root := f.dir("mods/mod")
+			cwd = root
+			resetState()
+			before := computeKey()
+
+			test.edit(f)
+			if test.cwd != "" {
+				cwd = filepath.Join(f.gopath, "src", filepath.FromSlash(test.cwd))
+			}
+			resetState()
+			if after := computeKey(); (after != before) != test.changed {
+				t.Errorf("key changed: %v, want %v; inputs:\n%s", after != before, test.changed,
+					strings.Join(keyInputs(), "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"sort"
+	"sync"
+)
+
+var (
+	maxFileSize      = byteSize(256 << 20)
+	failOnLargeFiles = flag.Bool("fail-on-large-files", false,
+		"fail instead of warning when a source file exceeds -max-file-size")
+)
+
+func init() {
+	flag.Var(&maxFileSize, "max-file-size",
+		"fingerprint source files larger than this by size and modification time only")
+}
+
+// numLargestFiles is the number of largest hashed files reported with -v.
+const numLargestFiles = 10
+
+// A hashedFile is a source file whose contents were hashed.
+type hashedFile struct {
+	path string
+	size int64
+}
+
+var largestMu sync.Mutex
+var largest []hashedFile // the largest hashed files, largest first
+
+// noteHashedFile notes the size of a hashed file for reportLargestFiles.
+func noteHashedFile(path string, size int64) {
+	if !*verbose {
+		return
+	}
+	largestMu.Lock()
+	defer largestMu.Unlock()
+	if len(largest) == numLargestFiles && size <= largest[len(largest)-1].size {
+		return
+	}
+	found := false
+	for i := range largest {
+		if largest[i].path == path {
+			// Hashed again, e.g. after changing while it was hashed.
+			largest[i].size = size
+			found = true
+		}
+	}
+	if !found {
+		largest = append(largest, hashedFile{path, size})
+	}
+	sort.Slice(largest, func(i, j int) bool {
+		return largest[i].size > largest[j].size
+	})
+	if len(largest) > numLargestFiles {
+		largest = largest[:numLargestFiles]
+	}
+}
+
+// reportLargestFiles logs the largest files hashed so far with -v, to
+// help find generated or fixture files that slow down fingerprinting.
+func reportLargestFiles() {
+	largestMu.Lock()
+	defer largestMu.Unlock()
+	if len(largest) == 0 {
+		return
+	}
+	log.Printf("largest hashed files:")
+	for _, f := range largest {
+		log.Printf("%10s  %s", humanSize(f.size), f.path)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+var quietLegacyWarning = flag.Bool("quiet-legacy-warning", false,
+	"do not explain the missing package archives of module mode once, listing each package instead")
+
+// archivesInGoCache reports whether save finds none of the library
+// packages of pkgs installed because the go command keeps their archives
+// in its build cache: in module mode with Go 1.20 or later, "go install"
+// writes no archives to GOPATH/pkg. It is only a heuristic, as the
+// packages may just not have been built, so one notice explains it
+// rather than a line per package, unless --quiet-legacy-warning.
+func archivesInGoCache(pkgs []*Package) bool {
+	if *quietLegacyWarning || !moduleMode() {
+		return false
+	}
+	_, version, err := findGo()
+	if err != nil || goVersionOlder(version, "go1.20") {
+		return false
+	}
+	libs := 0
+	for _, pkg := range pkgs {
+		if !pkg.cached() || pkg.Name == "main" || pkg.Target == "" {
+			continue
+		}
+		if exists(pkg.Target) {
+			return false
+		}
+		libs++
+	}
+	if libs == 0 {
+		return false
+	}
+	log.Printf("notice: none of the %d library packages has an archive installed: in module mode, "+
+		"the go command (%s) keeps them in its build cache instead of GOPATH/pkg, so save has nothing to save; "+
+		"use \"gocache save\" to cache the build cache (-quiet-legacy-warning lists the packages instead)",
+		libs, version)
+	return true
+}
@@ -0,0 +1,96 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"testing"
+)
+
+// TestArchivesInGoCache checks that save explains the library archives
+// missing in module mode with recent go commands with a single notice,
+// and lists the packages not built otherwise, as in GOPATH mode.
+func TestArchivesInGoCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the go command reporting the version is a shell script")
+	}
+	notice := regexp.MustCompile(`(?m)^notice: none of the 1 library packages has an archive installed`)
+	libLine := regexp.MustCompile(`(?m)^-\s+example\.com/mod/lib \(([a-z-]+)`)
+	for _, test := range []struct {
+		name      string
+		gopath    bool   // the legacy layout: the fixture in GOPATH mode
+		version   string // reported by the go command
+		installed bool   // the library archive
+		flags     []string
+		notice    bool
+		libLine   string // the reason printed for the library, "" for none
+		skipped   string // in the summary
+	}{
+		{name: "GOPATH mode", gopath: true, version: "go1.27.1", skipped: "not saved: 4 not-built"},
+		{name: "module mode", version: "go1.27.1", notice: true, skipped: "not saved: 1 in-gocache, 1 not-built"},
+		{name: "module mode, verbose", version: "go1.27.1", flags: []string{"-v"}, notice: true, libLine: "in-gocache", skipped: "not saved: 1 in-gocache, 1 not-built"},
+		{name: "quiet", version: "go1.27.1", flags: []string{"-quiet-legacy-warning"}, libLine: "not-built", skipped: "not saved: 2 not-built"},
+		{name: "go1.19", version: "go1.19.13", libLine: "not-built", skipped: "not saved: 2 not-built"},
+		{name: "archive installed", version: "go1.27.1", installed: true, skipped: "not saved: 1 not-built"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			goCmd := filepath.Join(f.root, "go")
+			if err := os.WriteFile(goCmd, []byte("#!/bin/sh\necho go version "+test.version+" linux/amd64\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			dir := f.dir("example.com/app")
+			if !test.gopath {
+				f.moduleMode()
+				dir = filepath.Join(f.root, "mod")
+				f.writeFiles(dir, map[string]string{
+					"go.mod":           "module example.com/mod\n\ngo 1.16\n",
+					"lib/lib.go":       "package lib\n\nimport \"fmt\"\n\nvar X = fmt.Sprint(1)\n",
+					"cmd/tool/main.go": "package main\n\nimport \"example.com/mod/lib\"\n\nfunc main() { println(lib.X) }\n",
+				})
+				cwd = dir
+			}
+			if test.installed {
+				f.install("./lib")
+			}
+
+			args := append([]string{"-goos=linux", "-goarch=amd64", "-go", goCmd}, test.flags...)
+			cmd := f.command(append(args, "save", "./...")...)
+			cmd.Dir = dir
+			data, err := cmd.CombinedOutput()
+			out := string(data)
+			if err != nil {
+				t.Fatalf("save: %v\n%s", err, out)
+			}
+			if got := notice.MatchString(out); got != test.notice {
+				t.Errorf("notice %v, want %v:\n%s", got, test.notice, out)
+			}
+			reason := ""
+			if m := libLine.FindStringSubmatch(out); m != nil {
+				reason = m[1]
+			}
+			if reason != test.libLine {
+				t.Errorf("library listed as %q, want %q:\n%s", reason, test.libLine, out)
+			}
+			if !regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(test.skipped) + `$`).MatchString(out) {
+				t.Errorf("summary without %q:\n%s", test.skipped, out)
+			}
+		})
+	}
+}
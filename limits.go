@@ -0,0 +1,69 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"runtime"
+)
+
+var (
+	cpuLimit = flag.Int("cpu-limit", 0,
+		"maximum number of CPUs used for hashing and copying (default GOMAXPROCS)")
+	lowPriority = flag.Bool("low-priority", false,
+		"lower the CPU and I/O priority to leave room for a concurrent build")
+)
+
+// resourceLimits are the limits in effect, as recorded in the summary.
+type resourceLimits struct {
+	CPUs          int    `json:"cpus"`
+	Workers       int    `json:"workers"`
+	LowPriority   bool   `json:"lowPriority"`
+	OpenFileLimit uint64 `json:"openFileLimit,omitempty"` // 0 if unknown
+	FileBudget    int    `json:"fileBudget"`              // files opened at once by the workers
+}
+
+// limits holds the limits applied by applyLimits.
+var limits = &resourceLimits{CPUs: runtime.GOMAXPROCS(0), Workers: 1, FileBudget: defaultFDBudget}
+
+// applyLimits applies --cpu-limit and --low-priority to the process,
+// sizes the worker pool to the smaller of -j and the CPU limit and the
+// budget of open files to the open file limit.
+func applyLimits() {
+	if *cpuLimit > 0 {
+		runtime.GOMAXPROCS(*cpuLimit)
+	}
+	limits.CPUs = runtime.GOMAXPROCS(0)
+	limits.Workers = *jobs
+	if limits.Workers > limits.CPUs {
+		limits.Workers = limits.CPUs
+	}
+	if limits.Workers < 1 {
+		limits.Workers = 1
+	}
+	if *lowPriority {
+		if err := lowerPriority(); err != nil {
+			vlogf("unable to lower priority: %s", err)
+		} else {
+			limits.LowPriority = true
+		}
+	}
+	limits.OpenFileLimit, _ = openFileLimit()
+	limits.FileBudget = fdBudgetFor(limits.OpenFileLimit)
+	fds = newFDBudget(limits.FileBudget)
+	vlogf("limits: cpus=%d workers=%d low-priority=%t files=%d",
+		limits.CPUs, limits.Workers, limits.LowPriority, limits.FileBudget)
+}
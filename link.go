@@ -0,0 +1,149 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"syscall"
+)
+
+var linkMode = flag.String("link-mode", "auto",
+	"how files are placed into and out of the cache: hardlink, reflink, copy or auto")
+
+// errReflinkUnsupported is returned by reflink on platforms where it is
+// not implemented.
+var errReflinkUnsupported = errors.New("reflinks are not supported on this platform")
+
+// errLinkSaturated is the error of hardlinking an entry known to have
+// reached the filesystem's limit on links.
+var errLinkSaturated = errors.New("entry has too many links")
+
+// isLinkLimit reports whether err is the failure of a hardlink because
+// the file has reached the filesystem's limit on links.
+func isLinkLimit(err error) bool {
+	return err == errLinkSaturated || errors.Is(err, syscall.EMLINK)
+}
+
+// linkSaturated reports whether the metadata of the entry at path
+// records that it cannot be hardlinked any more.
+func linkSaturated(path string) bool {
+	if !exists(metaPath(path)) {
+		return false
+	}
+	m, err := readMeta(path)
+	return err == nil && m != nil && m.LinkSaturated
+}
+
+// markLinkSaturated records in the metadata of the entry at path that
+// it cannot be hardlinked any more, so that later restores copy it
+// without trying. Files without metadata are not entries and are left
+// alone.
+func markLinkSaturated(path string) {
+	m, err := readMeta(path)
+	if err != nil || m == nil || m.LinkSaturated {
+		return
+	}
+	m.LinkSaturated = true
+	if err := writeMeta(path, m); err != nil {
+		log.Printf("warning: %s", err)
+	}
+}
+
+// linkOrCopy places the file src at dst using the mechanism selected by
+// --link-mode. In "auto" mode a hardlink is tried first, then a reflink
+// (a copy-on-write clone, which unlike a hardlink does not share later
+// writes) and finally a byte copy; failures of the faster mechanisms,
+// e.g. across devices or on filesystems without reflinks, fall through
+// silently. The mechanism used is reported with -v. An entry with too
+// many links is copied even with --link-mode=hardlink.
+func linkOrCopy(src, dst string) error {
+	if exists(dst) {
+		return nil
+	}
+
+	var mechanisms []string
+	switch *linkMode {
+	case "auto":
+		mechanisms = []string{"hardlink", "reflink", "copy"}
+	case "hardlink", "reflink", "copy":
+		mechanisms = []string{*linkMode}
+	default:
+		return fmt.Errorf("unknown link mode \"%s\"", *linkMode)
+	}
+
+	// Reflinks and copies hold both files open.
+	fds.acquire(2)
+	defer fds.release(2)
+	var err error
+	for i := 0; i < len(mechanisms); i++ {
+		m := mechanisms[i]
+		switch m {
+		case "hardlink":
+			if linkSaturated(src) {
+				err = errLinkSaturated
+			} else if err = os.Link(src, dst); os.IsExist(err) {
+				return nil
+			}
+			if isLinkLimit(err) {
+				vlogf("%s: %s, copying", src, err)
+				markLinkSaturated(src)
+				if *linkMode == "hardlink" {
+					mechanisms = append(mechanisms, "copy")
+				}
+			}
+		case "reflink":
+			err = fds.retry(func() error { return reflink(src, dst) })
+		case "copy":
+			err = fds.retry(func() error { return copyFile(src, dst) })
+		}
+		if err == nil {
+			vlogf("%s %s -> %s", m, src, dst)
+			return nil
+		}
+	}
+	return err
+}
+
+// copyFile copies src to dst, preserving its permissions.
+func copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+	if err := dstFile.Chmod(srcInfo.Mode() & os.ModePerm); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	_, err = io.Copy(dstFile, srcFile)
+	return err
+}
@@ -0,0 +1,188 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	lockOut    = flag.String("out", "buildcache.lock", "file written by the lock command")
+	maxChanged = flag.Int("max-changed", 0, "number of changed lock file entries tolerated by check")
+)
+
+// A lockFile records the fingerprints of the packages loaded from a set
+// of roots. It is written in a line oriented text format so that it can
+// be committed and reviewed:
+//
+//	roots <package-path>...
+//	salt <digest of the toolchain>
+//	<fingerprint> <import path>
+//	...
+//
+// The fingerprint of an uncacheable package is uncacheableLockEntry.
+type lockFile struct {
+	roots        []string
+	salt         string
+	fingerprints map[string]string
+}
+
+// uncacheableLockEntry stands in the lock file for the empty fingerprint
+// of an uncacheable package.
+const uncacheableLockEntry = "-"
+
+func toolchainSalt() string {
+	h := sha1.New()
+	ctx := targetContext()
+	for _, s := range toolchain(&ctx) {
+		_, _ = h.Write([]byte(s))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func computeLockFile(roots []string) *lockFile {
+	l := &lockFile{
+		roots:        roots,
+		salt:         toolchainSalt(),
+		fingerprints: map[string]string{},
+	}
+	for _, pkg := range loadAll(roots) {
+		if !pkg.cached() {
+			continue
+		}
+		fp := pkg.Fingerprint()
+		if fp == "" {
+			fp = uncacheableLockEntry
+		}
+		l.fingerprints[pkg.ImportPath] = fp
+	}
+	return l
+}
+
+func (l *lockFile) importPaths() []string {
+	paths := make([]string, 0, len(l.fingerprints))
+	for path := range l.fingerprints {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (l *lockFile) write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "roots %s\n", strings.Join(l.roots, " "))
+	fmt.Fprintf(w, "salt %s\n", l.salt)
+	for _, importPath := range l.importPaths() {
+		fmt.Fprintf(w, "%s %s\n", l.fingerprints[importPath], importPath)
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readLockFile(path string) (*lockFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &lockFile{fingerprints: map[string]string{}}
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "roots":
+			l.roots = fields[1:]
+		case fields[0] == "salt" && len(fields) == 2:
+			l.salt = fields[1]
+		case len(fields) == 2:
+			l.fingerprints[fields[1]] = fields[0]
+		default:
+			return nil, fmt.Errorf("%s:%d: malformed line", path, line)
+		}
+	}
+	return l, s.Err()
+}
+
+func lock(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	log.Printf("locking %s to %s", args, *lockOut)
+	l := computeLockFile(args)
+	if err := l.write(*lockOut); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d packages", len(l.fingerprints))
+}
+
+func check(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: %s check <lock-file>", os.Args[0])
+	}
+	expected, err := readLockFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("checking %s against %s", expected.roots, args[0])
+	actual := computeLockFile(expected.roots)
+
+	if expected.salt != actual.salt {
+		log.Printf("toolchain changed: every entry is invalidated")
+	}
+
+	changed := 0
+	for _, importPath := range expected.importPaths() {
+		fp, ok := actual.fingerprints[importPath]
+		switch {
+		case !ok:
+			log.Printf("%-8s %s", "removed", importPath)
+			changed++
+		case fp != expected.fingerprints[importPath]:
+			log.Printf("%-8s %s", "changed", importPath)
+			changed++
+		}
+	}
+	for _, importPath := range actual.importPaths() {
+		if _, ok := expected.fingerprints[importPath]; !ok {
+			log.Printf("%-8s %s", "added", importPath)
+			changed++
+		}
+	}
+
+	log.Printf("%d of %d entries changed", changed, len(expected.fingerprints))
+	if changed > *maxChanged {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLockFileRoundTrip(t *testing.T) {
+	f := newFixture(t)
+	f.writeFile("example.com/app/lib/.buildcache-package.json", `{"cacheable": false}`)
+
+	l := computeLockFile([]string{"./..."})
+	for importPath, want := range map[string]string{
+		"example.com/app/lib":      uncacheableLockEntry,
+		"example.com/app/cmd/tool": uncacheableLockEntry,
+	} {
+		if got := l.fingerprints[importPath]; got != want {
+			t.Errorf("%s: fingerprint %q, want %q", importPath, got, want)
+		}
+	}
+	path := filepath.Join(f.root, "buildcache.lock")
+	if err := l.write(path); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readLockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, l) {
+		t.Errorf("read back %+v, want %+v", read, l)
+	}
+
+	// check accepts the lock file lock writes, and reports the package
+	// becoming cacheable again.
+	f.mustRun("-out", path, "lock", "./...")
+	if out := f.mustRun("check", path); !strings.Contains(out, "0 of 4 entries changed") {
+		t.Errorf("check reported changes:\n%s", out)
+	}
+	f.writeFile("example.com/app/lib/.buildcache-package.json", `{"cacheable": true}`)
+	out, err := f.run("check", path)
+	if err == nil {
+		t.Errorf("check succeeded with changes:\n%s", out)
+	}
+	if !strings.Contains(out, "changed  example.com/app/lib") {
+		t.Errorf("check did not report example.com/app/lib:\n%s", out)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var jsonOutput = flag.Bool("json", false, "emit JSON output from ls, info, deps, impact and bench")
+
+// ls lists the entries in the cache.
+func ls(args []string) {
+	dir := cacheDir()
+	entries, err := listEntries(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pinned := pinnedEntries(dir, entries)
+	if *onlyPinned {
+		var kept []*cacheEntry
+		for _, e := range entries {
+			if pinned[e] != nil {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+	if *jsonOutput {
+		if entries == nil {
+			entries = []*cacheEntry{}
+		}
+		fmt.Println(prettyJSON(entries))
+		return
+	}
+	width := fingerprintWidth
+	for _, e := range entries {
+		if len(e.Name) > width {
+			width = len(e.Name)
+		}
+	}
+	for _, e := range entries {
+		importPath := e.ImportPath()
+		if importPath == "" {
+			importPath = "-"
+		}
+		if p := pinned[e]; p != nil {
+			importPath += " (" + formatPin(p) + ")"
+		}
+		if e.Meta != nil && e.Meta.EnvFingerprint != "" {
+			importPath += " [env " + shortEnvFingerprint(e.Meta.EnvFingerprint) + "]"
+		}
+		fmt.Printf("%-*s %8s %s %s\n", width, e.Name, humanSize(e.Size),
+			e.Created().Local().Format("2006-01-02 15:04"), importPath)
+	}
+}
+
+// findEntry returns the cache entry with the given name or, failing
+// that, the single entry whose name has the given prefix.
+func findEntry(dir, name string) (*cacheEntry, error) {
+	entries, err := listEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*cacheEntry
+	for _, e := range entries {
+		if e.Name == name {
+			return e, nil
+		}
+		if strings.HasPrefix(e.Name, name) {
+			matches = append(matches, e)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w \"%s\" in %s", errCacheMiss, name, dir)
+	case 1:
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("\"%s\" is ambiguous: matches %d entries", name, len(matches))
+}
+
+// info prints the details of the named cache entries.
+func info(args []string) {
+	if len(args) == 0 {
+		log.Printf("usage: %s info <fingerprint>...", os.Args[0])
+		os.Exit(1)
+	}
+	dir := cacheDir()
+	var entries []*cacheEntry
+	for _, name := range args {
+		e, err := findEntry(dir, name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if *jsonOutput {
+		fmt.Println(prettyJSON(entries))
+		return
+	}
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Println()
+		}
+		printEntry(e)
+	}
+}
+
+func printEntry(e *cacheEntry) {
+	field := func(name, format string, args ...interface{}) {
+		fmt.Printf("%-12s "+format+"\n", append([]interface{}{name + ":"}, args...)...)
+	}
+	field("entry", "%s", e.Path)
+	field("size", "%s", humanSize(e.Size))
+	m := e.Meta
+	if m == nil {
+		field("modified", "%s", e.ModTime.Format(time.RFC3339))
+		fmt.Println("(no metadata)")
+		return
+	}
+	field("import path", "%s", m.ImportPath)
+	field("platform", "%s/%s", m.GOOS, m.GOARCH)
+	field("race", "%t", m.Race)
+	if m.Msan {
+		field("msan", "%t", m.Msan)
+	}
+	if m.InstallSuffix != "" {
+		field("installsuffix", "%s", m.InstallSuffix)
+	}
+	field("test", "%t", m.Test)
+	if m.FingerprintVersion != 0 {
+		field("fingerprint", "version %d", m.FingerprintVersion)
+	}
+	if m.EnvFingerprint != "" {
+		field("environment", "%s", m.EnvFingerprint)
+	}
+	field("created", "%s", m.Created.Format(time.RFC3339))
+	field("checksum", "%s", m.Checksum)
+	for _, x := range m.Extras {
+		field("artifact", "%s (%s, %s)", x.Path, humanSize(x.Size), x.Mode)
+	}
+	if in := m.Modules; in != nil {
+		field("mod", "%s", in.Mode)
+		field("go.mod", "%s", in.GoMod)
+		field("go.sum", "%s", in.GoSum)
+		if in.GoWork != "" {
+			field("go.work", "%s", in.GoWork)
+			field("go.work.sum", "%s", in.GoWorkSum)
+		}
+		for _, dir := range in.replacedDirs() {
+			field("replaced", "%s (go.mod %s)", dir, in.Replaced[dir])
+		}
+	}
+	if p := m.Provenance; p != nil {
+		field("builder", "%s@%s (build-cache %s)", p.User, p.Hostname, p.Version)
+		var names []string
+		for name := range p.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field("env", "%s=%s", name, p.Env[name])
+		}
+		if len(p.HermeticEnv) > 0 {
+			field("hermetic", "%s", strings.Join(p.HermeticEnv, " "))
+		}
+	}
+}
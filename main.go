@@ -19,14 +19,33 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
-	"io"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
+// version is the version of build-cache, recorded in the metadata of
+// saved entries. Release builds set it with -ldflags "-X main.version=...".
+var version = "devel"
+
+var onlyFingerprintVersion = flag.Bool("fingerprint-version", false,
+	"make the version command print only the version of the fingerprint algorithm")
+
+// printVersion prints the version of build-cache and of its fingerprint
+// algorithm, which tools deriving cache keys can check.
+func printVersion(args []string) {
+	if *onlyFingerprintVersion {
+		fmt.Println(fingerprintVersion)
+		return
+	}
+	fmt.Printf("build-cache %s (fingerprint version %d, %s)\n", version, fingerprintVersion, goVersion)
+}
+
 func prettyJSON(v interface{}) string {
 	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
@@ -42,151 +61,631 @@ func exists(path string) bool {
 	return true
 }
 
-func cacheDir() string {
-	d := os.Getenv("CACHE")
-	if d == "" {
-		d = os.ExpandEnv("${HOME}/buildcache")
+// fileSize returns the size of the file at path, or 0 if it cannot be
+// determined.
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
 	}
-	return d
+	return fi.Size()
 }
 
-func linkOrCopy(src, dst string) error {
-	if exists(dst) {
-		return nil
-	}
-	if err := os.Link(src, dst); err == nil || os.IsExist(err) {
-		return nil
-	}
+var lockTimeout = flag.Duration("lock-timeout", 10*time.Minute,
+	"how long to wait for other invocations using the cache")
 
-	srcFile, err := os.Open(src)
+// useCache locks the cache dir for the duration of a command, exclusively
+// for commands removing entries, and returns a function releasing the
+// lock.
+func useCache(dir string, exclusive bool) func() {
+	unlock, err := lockCacheUse(dir, exclusive, *lockTimeout)
 	if err != nil {
-		return err
+		fatal(err)
 	}
-	defer srcFile.Close()
+	return unlock
+}
 
-	srcInfo, err := os.Stat(src)
-	if err != nil {
-		return err
+// cacheRoot returns the cache directory given by $CACHE, holding the
+// default namespace and the others.
+func cacheRoot() string {
+	d := os.Getenv("CACHE")
+	if d == "" {
+		d = os.ExpandEnv("${HOME}/buildcache")
 	}
+	return d
+}
 
-	dstFile, err := os.Create(dst)
+// cacheDir returns the cache directory of the namespace in use.
+func cacheDir() string {
+	return namespaceDir(cacheRoot(), namespace())
+}
+
+// saveMeta records the metadata for the cache entry at dst holding the
+// output of pkg.
+func saveMeta(pkg *Package, dst string) error {
+	m, err := newEntryMeta(pkg, dst)
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
-	if err := dstFile.Chmod(srcInfo.Mode() & os.ModePerm); err != nil {
-		_ = os.Remove(dst)
-		return err
-	}
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+	return writeMeta(dst, m)
 }
 
-func save(args []string) {
+// save saves the outputs of the packages named by args and their
+// dependencies to the cache. Failures to save some of the packages are
+// logged as they occur and returned, along with the summary, once the
+// others are saved.
+func save(args []string) (*summary, error) {
 	if len(args) == 0 {
 		args = []string{"."}
 	}
 
+	if err := checkTestFlags(); err != nil {
+		return nil, err
+	}
+	if err := setAltRoot(*fromRoot); err != nil {
+		return nil, err
+	}
 	dir := cacheDir()
 	log.Printf("saving %s to %s", args, dir)
-	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
-		log.Fatal(err)
+	if err := createCacheDir(dir); err != nil {
+		return nil, err
+	}
+	unlock, err := lockCacheUse(dir, false, *lockTimeout)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
+	removeStaleScratch()
 
 	start := time.Now()
-	pkgs := loadAll(args)
+	pkgs, loadErr := loadPackages(args)
+	if loadErr != nil && !failSoftly {
+		return nil, loadErr
+	}
 	log.Printf("finished loading: %s", time.Since(start))
 
-	for _, pkg := range pkgs {
-		if pkg.Standard && !pkg.race {
-			continue
+	checkTargets(pkgs)
+
+	s := newSummary("save")
+	if loadErr != nil {
+		// The packages which could be loaded are saved all the same.
+		s.fail(loadErr)
+	}
+	s.Roots = sortedRoots(installRoots(pkgs, false))
+	s.recordAnnotations(pkgs)
+	var policyMu sync.Mutex
+	skipped := map[string]*policySkip{}
+	var indexMu sync.Mutex
+	indexed := map[string][]*indexedEntry{}
+	var toPush []string // entry names, for --remote
+	extras, err := artifactsByPackage(pkgs)
+	if err != nil {
+		return nil, err
+	}
+	inGoCache := archivesInGoCache(pkgs)
+	prog := startProgress("saved", len(pkgs))
+	forEachPackage(pkgs, s, func(pkg *Package, r *pkgReport) {
+		prog.inc()
+		if !pkg.cached() {
+			return
 		}
-		if pkg.Stale || !exists(pkg.Target) {
-			log.Printf("%-40s  %s (%s)", "-", pkg.ImportPath, pkg.Target)
+		if skip := skipSave(pkg); skip != nil {
+			if inGoCache && skip.Reason == skipNotBuilt && pkg.Name != "main" {
+				skip = &saveSkip{skipInGoCache, ""}
+			}
+			r.skip(pkg.ImportPath, pkg.Target, skip)
+			if skip.Reason == skipTooSmall {
+				fp, _ := pkg.computeFingerprint()
+				policyMu.Lock()
+				skipped[fp] = &policySkip{
+					ImportPath: pkg.ImportPath,
+					Size:       fileSize(pkg.Target),
+					Recorded:   time.Now().UTC(),
+				}
+				policyMu.Unlock()
+			}
+		} else if fp, err := pkg.computeFingerprint(); err != nil {
+			r.fail(fmt.Errorf("%s: %w", pkg.ImportPath, err))
+		} else if dst, err := saveEntry(pkg, dir, fp, extras[pkg], r); err != nil {
+			r.fail(fmt.Errorf("%s: %w", pkg.ImportPath, err))
 		} else {
-			fp := pkg.Fingerprint()
-			tag := "*"
-			dst := filepath.Join(dir, fp)
-			if exists(dst) {
-				tag = " "
-			} else if err := linkOrCopy(pkg.Target, dst); err != nil {
-				log.Fatal(err)
+			indexMu.Lock()
+			if dst != "" {
+				prog.addBytes(fileSize(dst))
+				indexed[pkg.ImportPath] = append(indexed[pkg.ImportPath], &indexedEntry{
+					Name:    filepath.Base(dst),
+					Created: time.Now().UTC(),
+					Size:    fileSize(dst),
+				})
+			}
+			// The entries already in the cache are pushed too, in case
+			// the remote tier lacks them.
+			if entry := lookupEntry(dir, fp); entry != "" && remote() != nil {
+				toPush = append(toPush, filepath.Base(entry))
+			}
+			indexMu.Unlock()
+		}
+		if pkg.root && *includeTests {
+			saveTest(pkg, dir, r)
+		}
+		pkg.release()
+	})
+	prog.stop()
+	if err := recordPolicySkips(dir, skipped); err != nil {
+		s.fail(err)
+	}
+	if err := indexEntries(dir, indexed); err != nil {
+		log.Printf("warning: unable to update the package index: %s", err)
+	}
+	pushEntries(dir, toPush, s)
+	recordRemote(s)
+	s.finish()
+	return s, s.err()
+}
+
+// saveEntry saves the output of pkg, whose fingerprint is fp, and the
+// artifacts at paths to the cache dir, and records the result in r. It
+// returns the path of the entry if it was stored, or "" if the cache
+// already had it.
+func saveEntry(pkg *Package, dir, fp string, paths []string, r *pkgReport) (string, error) {
+	end, coalesced := flights.start("save " + fp)
+	defer end()
+	if coalesced {
+		vlogf("%s: waited for the save of %s by another package", pkg.ImportPath, fp)
+		r.coalesce()
+	}
+	tag := "*"
+	result := resultMiss
+	dst := filepath.Join(dir, entryFileName(fp, pkg.ImportPath))
+	stored := false
+	if src := lookupEntry(dir, fp); src != "" {
+		tag = " "
+		result = resultHit
+		detail, err := entryConflict(src, pkg.Target)
+		if err != nil {
+			return "", err
+		}
+		if detail != "" {
+			tag = "!"
+			if stored, err = saveConflicting(pkg, dir, pkg.Target, src, dst, detail, r); err != nil {
+				return "", err
 			}
-			log.Printf("%-40s %s%s (%s)", fp, tag, pkg.ImportPath, pkg.Target)
 		}
+	} else if err := storeEntry(dir, pkg.Target, dst); err != nil {
+		return "", err
+	} else {
+		stored = true
+	}
+	if stored {
+		if err := saveMeta(pkg, dst); err != nil {
+			return "", err
+		}
+		addEntry(dir, dst)
 	}
+	if len(paths) > 0 {
+		entry := dst
+		if !stored {
+			entry = lookupEntry(dir, fp)
+		}
+		n, err := saveExtras(dir, entry, paths)
+		if err != nil {
+			return "", err
+		}
+		if n > 0 {
+			vlogf("%s: saved %d artifacts", pkg.ImportPath, n)
+		}
+	}
+	r.logResult(result, fp, tag, pkg.ImportPath, pkg.Target)
+	r.record(pkg.ImportPath, fp, result)
+	if !stored {
+		r.report(pkg.ImportPath, fp, result, actionPresent, pkg.Target, time.Time{})
+		return "", nil
+	}
+	r.report(pkg.ImportPath, fp, result, actionSaved, pkg.Target, time.Time{})
+	return dst, nil
 }
 
-func restore(args []string) {
+// restore restores the outputs of the packages named by args and their
+// dependencies from the cache. Like save, it returns the failures to
+// restore some of the packages along with the summary, which is nil if
+// the cache dir does not exist.
+func restore(args []string) (*summary, error) {
 	if len(args) == 0 {
 		args = []string{"."}
 	}
 
+	if err := checkTestFlags(); err != nil {
+		return nil, err
+	}
+	if err := setAltRoot(*targetRoot); err != nil {
+		return nil, err
+	}
 	dir := cacheDir()
-	if !exists(dir) {
+	if ok, err := ensureNamespaceDir(dir); err != nil {
+		return nil, err
+	} else if !ok {
 		log.Printf("%s does not exist", dir)
-		os.Exit(0)
+		if cause := coldNamespaces(dir, nil); cause != "" {
+			log.Printf("hint: %s", cause)
+		}
+		return nil, nil
 	}
 	log.Printf("restoring %s from %s", args, dir)
+	unlock, err := lockCacheUse(dir, false, *lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	unlockFallbacks, err := useFallbacks()
+	if err != nil {
+		return nil, err
+	}
+	defer unlockFallbacks()
 
 	start := time.Now()
-	pkgs := loadAll(args)
+	pkgs, loadErr := loadPackages(args)
+	if loadErr != nil && !failSoftly {
+		return nil, loadErr
+	}
 	log.Printf("finished loading: %s", time.Since(start))
 
-	now := time.Now()
+	s := newSummary("restore")
+	if loadErr != nil {
+		s.fail(loadErr)
+	}
+	if *onlyMissingTargets {
+		pkgs, s.Fresh = missingTargets(pkgs)
+	}
+	s.recordAnnotations(pkgs)
+	boot := newBootstrap(pkgs, time.Now())
+	roots := installRoots(pkgs, true)
+	s.Roots = sortedRoots(roots)
+	writable := pkgs[:0:0]
 	for _, pkg := range pkgs {
-		if pkg.Standard && !pkg.race {
-			continue
+		if rs := roots[pkg.installRoot()]; rs == nil || rs.Writable {
+			writable = append(writable, pkg)
 		}
-		fp := pkg.Fingerprint()
-		src := filepath.Join(dir, fp)
-		if !exists(src) {
-			log.Printf("%-40s  %s (%s:%s)", "-", pkg.ImportPath, fp, pkg.Target)
+	}
+	if s.Preflight, err = preflight(writable, dir); err != nil {
+		return nil, err
+	}
+	s.Preflight.Created = len(boot.dirs)
+	now := restoreStamp(pkgs, time.Now())
+	stamps := planStamps(dir, pkgs, now)
+	journal := openJournal(dir, args)
+	hint := newMissHint()
+	causes := newMissIndex(dir)
+	budget := newFetchBudget(dir)
+	prog := startProgress("restored", len(pkgs))
+	forEachPackage(pkgs, s, func(pkg *Package, r *pkgReport) {
+		prog.inc()
+		if !pkg.cached() {
+			return
+		}
+		fp, err := pkg.computeFingerprint()
+		if err != nil {
+			r.fail(fmt.Errorf("%s: %w", pkg.ImportPath, err))
+			pkg.release()
+			return
+		}
+		src, ns := lookupNamespaced(dir, fp, nil)
+		var symlink bool
+		var unsafe error
+		if pkg.Target != "" {
+			symlink, unsafe = checkTarget(pkg.Target, pkg.targetRoot())
+		}
+		rs := roots[pkg.installRoot()]
+		var temp bool
+		if src != "" {
+			budget.use(src)
+		} else if t := remote(); t != nil && fp != "" && pkg.Target != "" && unsafe == nil && (rs == nil || rs.Writable) {
+			if src, temp, err = t.fetch(budget, fp, pkg); err != nil {
+				r.fail(fmt.Errorf("%s: %w", pkg.ImportPath, err))
+			} else if src != "" {
+				r.fetch()
+			}
+		}
+		if temp {
+			defer os.Remove(metaPath(src))
+			defer os.Remove(src)
+		}
+		if rs != nil && !rs.Writable {
+			r.logResult(resultStale, "", "", pkg.ImportPath, "not writable: "+pkg.Target)
+			r.record(pkg.ImportPath, fp, resultStale)
+			r.report(pkg.ImportPath, fp, resultStale, actionSkipped, pkg.Target, time.Time{})
+		} else if pkg.Target == "" {
+			// e.g. a cross-compiled binary with GOBIN set.
+			r.logResult(resultStale, "", "", pkg.ImportPath, "no install target")
+			r.record(pkg.ImportPath, fp, resultStale)
+			r.report(pkg.ImportPath, fp, resultStale, actionSkipped, "", time.Time{})
+		} else if unsafe != nil {
+			log.Printf("WARNING: %s: refusing to replace target: %s", pkg.ImportPath, unsafe)
+			r.logResult(resultStale, "", "", pkg.ImportPath, "unsafe target: "+unsafe.Error())
+			r.record(pkg.ImportPath, fp, resultStale)
+			r.report(pkg.ImportPath, fp, resultStale, actionSkipped, pkg.Target, time.Time{})
+		} else if fp == "" {
+			r.logResult(resultStale, "", "", pkg.ImportPath, pkg.uncacheableErr().Error())
+			r.record(pkg.ImportPath, fp, resultStale)
+			r.report(pkg.ImportPath, fp, resultStale, actionSkipped, pkg.Target, time.Time{})
+		} else if src == "" && skippedByPolicy(dir, fp) {
+			r.logResult(resultPolicy, "", "", pkg.ImportPath, fp+":"+pkg.Target+", skipped by policy")
+			r.record(pkg.ImportPath, fp, resultPolicy)
+			r.reportMiss(pkg.ImportPath, fp, resultPolicy, actionSkipped, pkg.Target, missCause{Cause: causeExcluded})
+		} else if src == "" {
+			c := causes.classify(pkg, fp, false)
+			r.logResult(resultMiss, "", "", pkg.ImportPath, fp+":"+pkg.Target+" "+c.String())
+			r.record(pkg.ImportPath, fp, resultMiss)
+			r.reportMiss(pkg.ImportPath, fp, resultMiss, actionMissed, pkg.Target, c)
+			hint.miss(pkg)
+		} else if expiredEntry(src) {
+			r.logResult(resultExpired, "", "", pkg.ImportPath, fp+":"+pkg.Target+", expired")
+			r.record(pkg.ImportPath, fp, resultExpired)
+			r.reportMiss(pkg.ImportPath, fp, resultExpired, actionMissed, pkg.Target, missCause{Cause: causeExpired})
+		} else if reason := entryOwnerMismatch(src, pkg); reason != "" {
+			log.Printf("WARNING: %s: refusing to restore %s: %s", pkg.ImportPath, src, reason)
+			c := missCause{Cause: causeIntegrity}
+			r.logResult(resultMiss, "", "", pkg.ImportPath, fp+":"+pkg.Target+", "+reason+" "+c.String())
+			r.record(pkg.ImportPath, fp, resultMiss)
+			r.reportMiss(pkg.ImportPath, fp, resultMiss, actionMissed, pkg.Target, c)
+		} else if !symlink && (journal.completed(fp, pkg.Target) || alreadyRestored(pkg, src)) {
+			// Still stamp the Target so that it is not older than the
+			// dependencies restored by this run.
+			r.logResult(resultHit, fp, "=", pkg.ImportPath, pkg.Target)
+			r.hitFrom(ns)
+			stamp := stamps.stamp(pkg)
+			if err := os.Chtimes(pkg.Target, stamp, stamp); err != nil {
+				r.fail(fmt.Errorf("%s: %w", pkg.ImportPath, err))
+			} else {
+				r.record(pkg.ImportPath, fp, resultHit)
+				r.report(pkg.ImportPath, fp, resultHit, actionKept, pkg.Target, stamp)
+				r.buildSaved(entryBuildTime(src))
+				restoreArtifacts(pkg, src, now, r)
+			}
 		} else {
-			log.Printf("%-40s  %s (%s)", fp, pkg.ImportPath, pkg.Target)
+			r.logResult(resultHit, fp, "", pkg.ImportPath, pkg.Target)
+			r.hitFrom(ns)
+			// Removes a symlink itself rather than its referent.
 			_ = os.Remove(pkg.Target)
 			_ = os.MkdirAll(filepath.Dir(pkg.Target), 0755)
+			stamp := stamps.stamp(pkg)
 			if err := linkOrCopy(src, pkg.Target); err != nil {
-				log.Fatal(err)
-			}
-			if err := os.Chtimes(pkg.Target, now, now); err != nil {
-				log.Fatal(err)
+				r.fail(fmt.Errorf("%s: %w", pkg.ImportPath, err))
+			} else if err := os.Chtimes(pkg.Target, stamp, stamp); err != nil {
+				r.fail(fmt.Errorf("%s: %w", pkg.ImportPath, err))
+			} else {
+				prog.addBytes(fileSize(src))
+				journal.record(fp, pkg.Target)
+				r.record(pkg.ImportPath, fp, resultHit)
+				r.report(pkg.ImportPath, fp, resultHit, actionRestored, pkg.Target, stamp)
+				r.buildSaved(entryBuildTime(src))
+				restoreArtifacts(pkg, src, now, r)
 			}
 		}
+		if pkg.root && *includeTests {
+			restoreTest(pkg, dir, now, causes, r)
+		}
+		pkg.release()
+	})
+	prog.stop()
+	boot.restamp()
+	journal.remove()
+	recordRemote(s)
+	s.finish()
+	if s.Hits == 0 && s.Misses > 0 {
+		reportColdCache(dir, hint, s.Misses)
+	} else {
+		hint.report(dir)
+	}
+
+	if *audit {
+		auditRestore(args, dir)
+	}
+	return s, s.err()
+}
+
+// restoreArtifacts restores the artifacts saved with the entry src of
+// pkg. Failing to is not fatal to the restore of the package, but an
+// integrity failure is recorded in r so that corrupt cache content does
+// not go unnoticed by scripts.
+func restoreArtifacts(pkg *Package, src string, now time.Time, r *pkgReport) {
+	err := restoreExtras(src, now)
+	if err == nil {
+		return
 	}
+	if errors.Is(err, errIntegrity) {
+		r.fail(fmt.Errorf("%s: not restoring its artifacts: %w", pkg.ImportPath, err))
+		return
+	}
+	log.Printf("WARNING: %s: not restoring its artifacts: %s", pkg.ImportPath, err)
 }
 
 func clear(args []string) {
 	// TODO(pmattis): Instead of removing everything, only clear entries
 	// that are older than a day or week.
 	dir := cacheDir()
+	if !exists(dir) {
+		return
+	}
 	log.Printf("clearing %s", dir)
-	if err := os.RemoveAll(dir); err != nil {
+	defer useCache(dir, true)()
+
+	// Keep the lock files so that invocations waiting on them see the
+	// cleared cache rather than a removed directory, and the tag.
+	infos, err := os.ReadDir(dir)
+	if err != nil {
 		log.Fatal(err)
 	}
+	for _, info := range infos {
+		if name := info.Name(); name != ".lock" && name != ".use" && name != cacheDirTagName {
+			if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
 }
 
+// needsGo holds the commands which load packages or run the go command,
+// and so check for it first.
+var needsGo = map[string]bool{
+	"save": true, "restore": true, "lock": true, "check": true, "selfcheck": true,
+	"test": true, "warm": true, "graph": true, "why": true, "deps": true, "snapshot": true,
+	"impact": true, "pin": true, "unpin": true, "gocache": true,
+	"clean": true, "report": true, "key": true, "bench": true,
+}
+
+// passArgs holds the arguments following "--" on the command line which
+// are passed through to the go command.
+var passArgs []string
+
 func main() {
 	log.SetFlags(0)
 
-	flag.Parse()
+	cmdArgs := os.Args[1:]
+	for i, arg := range cmdArgs {
+		if arg == "--" {
+			passArgs = cmdArgs[i+1:]
+			cmdArgs = cmdArgs[:i]
+			break
+		}
+	}
+	_ = flag.CommandLine.Parse(cmdArgs)
 	args := flag.Args()
+	if len(args) >= 1 {
+		// Flags may also follow the command name.
+		_ = flag.CommandLine.Parse(args[1:])
+		args = append([]string{args[0]}, flag.Args()...)
+	}
+	loadConfig()
+	if *veryVerbose {
+		*verbose = true
+	}
+	useHermeticEnv()
+	applyLimits()
+	removeScratchOnInterrupt()
+	defer removeScratch()
 
 	if len(args) >= 1 {
+		failSoftly = *bestEffort && (args[0] == "save" || args[0] == "restore")
+		if needsGo[args[0]] {
+			checkGo()
+		}
+		if err := checkCacheLayout(cacheDir()); err != nil {
+			fatal(err)
+		}
+		if args[0] != "migrate" && args[0] != "clear" {
+			if err := checkCacheFormat(cacheDir()); err != nil {
+				fatal(err)
+			}
+			repairCacheSubdirs(cacheDir())
+		}
+		defer reportRepairs()
 		switch args[0] {
-		case "save":
-			save(args[1:])
-			return
-		case "restore":
-			restore(args[1:])
+		case "save", "restore":
+			cmd := save
+			if args[0] == "restore" {
+				cmd = restore
+			}
+			var err error
+			if *targetList != "" {
+				err = forEachTarget(func() (*summary, error) { return cmd(args[1:]) })
+			} else {
+				_, err = cmd(args[1:])
+			}
+			if err != nil {
+				fatal(err)
+			}
 			return
 		case "clear":
 			clear(args[1:])
 			return
+		case "clean":
+			clean(args[1:])
+			return
+		case "lock":
+			lock(args[1:])
+			return
+		case "check":
+			check(args[1:])
+			return
+		case "selfcheck":
+			selfcheck(args[1:])
+			return
+		case "test":
+			test(args[1:])
+			return
+		case "warm":
+			warm(args[1:])
+			return
+		case "prune":
+			prune(args[1:])
+			return
+		case "graph":
+			graph(args[1:])
+			return
+		case "why":
+			why(args[1:])
+			return
+		case "ls":
+			ls(args[1:])
+			return
+		case "info":
+			info(args[1:])
+			return
+		case "verify":
+			verify(args[1:])
+			return
+		case "quarantine":
+			quarantineCmd(args[1:])
+			return
+		case "pin":
+			pinPackages(args[1:])
+			return
+		case "unpin":
+			unpinPackages(args[1:])
+			return
+		case "stats":
+			stats(args[1:])
+			return
+		case "du":
+			du(args[1:])
+			return
+		case "doctor":
+			doctor(args[1:])
+			return
+		case "deps":
+			deps(args[1:])
+			return
+		case "migrate":
+			migrate(args[1:])
+			return
+		case "snapshot":
+			snapshot(args[1:])
+			return
+		case "gocache":
+			gocache(args[1:])
+			return
+		case "impact":
+			impact(args[1:])
+			return
+		case "report":
+			reportCmd(args[1:])
+			return
+		case "key":
+			key(args[1:])
+			return
+		case "bench":
+			bench(args[1:])
+			return
+		case "push":
+			pushCmd(args[1:])
+			return
+		case "version":
+			printVersion(args[1:])
+			return
 		}
 		log.Printf("unknown command \"%s\"\n\n", args[0])
 	}
 
-	log.Printf("usage: %s [save|restore|clear]", os.Args[0])
+	log.Printf("usage: %s [save|restore|clear|clean|lock|check|selfcheck|test|warm|prune|graph|why|ls|info|verify|quarantine|pin|unpin|stats|du|doctor|deps|migrate|snapshot|gocache|impact|report|key|bench|push|version]", os.Args[0])
 	os.Exit(1)
 }
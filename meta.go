@@ -0,0 +1,224 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var provenanceEnv = flag.String("provenance-env",
+	"BUILD_URL,JOB_URL,CI_JOB_URL,BUILDKITE_BUILD_URL,GITHUB_SERVER_URL,GITHUB_REPOSITORY,GITHUB_RUN_ID",
+	"comma-separated environment variables recorded in the metadata of saved entries")
+
+// An entryMeta is the metadata recorded alongside a cache entry in a
+// "<fingerprint>.meta" file. Entries saved by older versions of
+// build-cache have no metadata.
+type entryMeta struct {
+	ImportPath    string        `json:"importPath"`
+	Module        string        `json:"module,omitempty"` // path of the enclosing module
+	GOOS          string        `json:"goos"`
+	GOARCH        string        `json:"goarch"`
+	Race          bool          `json:"race"`
+	Msan          bool          `json:"msan,omitempty"`
+	InstallSuffix string        `json:"installSuffix,omitempty"` // without "race"
+	GoVersion     string        `json:"goVersion,omitempty"`     // the toolchain in the fingerprint
+	Modules       *moduleInputs `json:"modules,omitempty"`       // of the main module
+	Test          bool          `json:"test,omitempty"`          // a test binary
+	LinkSaturated bool          `json:"linkSaturated,omitempty"` // at the filesystem's limit on hardlinks
+	BuildSeconds  float64       `json:"buildSeconds,omitempty"`  // approximate, if built by warm
+	Size          int64         `json:"size"`
+	Mode          os.FileMode   `json:"mode,omitempty"` // permission bits of the entry
+	Checksum      string        `json:"checksum"`       // hex SHA-256 of the entry
+	Created       time.Time     `json:"created"`
+
+	// The version of the fingerprint algorithm, 0 for entries saved
+	// before it was recorded.
+	FingerprintVersion int `json:"fingerprintVersion,omitempty"`
+
+	Provenance *provenance `json:"provenance,omitempty"`
+
+	// The -env-fingerprint in the fingerprint, if any.
+	EnvFingerprint string `json:"envFingerprint,omitempty"`
+
+	// The files saved with the output by -artifact and -artifact-dir.
+	Extras []*extraArtifact `json:"extras,omitempty"`
+
+	// The inputs of the fingerprint, if saved with -manifest.
+	Manifest map[string]string `json:"manifest,omitempty"`
+
+	// The modification time of the output when it was saved, for
+	// restore -preserve-mtime; zero for entries saved before it was
+	// recorded and for test binaries.
+	TargetModTime time.Time `json:"targetModTime"`
+}
+
+// A provenance identifies the builder which saved an entry. It is
+// informational only and never part of a fingerprint.
+type provenance struct {
+	Hostname string            `json:"hostname,omitempty"`
+	User     string            `json:"user,omitempty"`
+	Version  string            `json:"version"` // of build-cache
+	Env      map[string]string `json:"env,omitempty"`
+	// The names of the variables of the environment, with -hermetic-env.
+	HermeticEnv []string `json:"hermeticEnv,omitempty"`
+}
+
+var provenanceOnce sync.Once
+var builder *provenance
+
+// currentProvenance returns the provenance of entries saved by this
+// process.
+func currentProvenance() *provenance {
+	provenanceOnce.Do(func() {
+		builder = &provenance{Version: version}
+		builder.Hostname, _ = os.Hostname()
+		if u, err := user.Current(); err == nil {
+			builder.User = u.Username
+		}
+		for _, name := range strings.Split(*provenanceEnv, ",") {
+			name = strings.TrimSpace(name)
+			if value := os.Getenv(name); name != "" && value != "" {
+				if builder.Env == nil {
+					builder.Env = map[string]string{}
+				}
+				builder.Env[name] = value
+			}
+		}
+		builder.HermeticEnv = hermeticEnvNames()
+	})
+	return builder
+}
+
+func metaPath(entry string) string {
+	return entry + ".meta"
+}
+
+// newEntryMeta returns the metadata for the entry at path holding the
+// output of pkg.
+func newEntryMeta(pkg *Package, path string) (*entryMeta, error) {
+	sum, size, err := fileChecksum(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	inst := packageInstrumentation(pkg)
+	var modTime time.Time
+	if fi, err := os.Stat(pkg.Target); err == nil {
+		modTime = fi.ModTime().UTC()
+	}
+	return &entryMeta{
+		ImportPath:    pkg.ImportPath,
+		Module:        packageModule(pkg),
+		GOOS:          pkg.buildContext.GOOS,
+		GOARCH:        pkg.buildContext.GOARCH,
+		Race:          inst.Race,
+		Msan:          inst.Msan,
+		InstallSuffix: inst.InstallSuffix,
+		GoVersion:     goVersion,
+		Modules:       pkg.moduleInputs(),
+		BuildSeconds:  buildDurations[pkg.ImportPath].Seconds(),
+		Size:          size,
+		Mode:          fi.Mode().Perm(),
+		Checksum:      sum,
+		Created:       time.Now().UTC(),
+		Provenance:    currentProvenance(),
+
+		FingerprintVersion: fingerprintVersion,
+		EnvFingerprint:     pkg.envFingerprint(),
+		Manifest:           pkg.manifest,
+		TargetModTime:      modTime,
+	}, nil
+}
+
+// readMeta returns the metadata for the entry at path, or nil if the
+// entry has no metadata.
+func readMeta(entry string) (*entryMeta, error) {
+	data, err := os.ReadFile(metaPath(entry))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &entryMeta{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeMeta writes the metadata for the entry at path.
+func writeMeta(entry string, m *entryMeta) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(metaPath(entry), data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into
+// place so that readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := createTemp(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// fileChecksum returns the hex SHA-256 digest and size of the file at
+// path.
+func fileChecksum(path string) (string, int64, error) {
+	fds.acquire(1)
+	defer fds.release(1)
+	var f *os.File
+	err := fds.retry(func() (err error) {
+		f, err = os.Open(path)
+		return err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
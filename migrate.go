@@ -0,0 +1,175 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The cache formats. Format 1 is the legacy layout of entries named by
+// their bare fingerprint without metadata; format 2 has metadata for
+// every entry.
+const (
+	legacyCacheFormat = 1
+	cacheFormat       = 2
+)
+
+// formatPath returns the path of the file recording the format of the
+// cache dir.
+func formatPath(dir string) string {
+	return filepath.Join(dir, ".format")
+}
+
+// readCacheFormat returns the format recorded in the cache dir, or 0 if
+// none is.
+func readCacheFormat(dir string) (int, error) {
+	data, err := os.ReadFile(formatPath(dir))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var format int
+	if _, err := fmt.Sscanf(string(data), "build-cache format %d", &format); err != nil {
+		return 0, fmt.Errorf("%s: malformed cache format", formatPath(dir))
+	}
+	return format, nil
+}
+
+func writeCacheFormat(dir string, format int) error {
+	return writeFileAtomic(formatPath(dir), []byte("build-cache format "+strconv.Itoa(format)+"\n"))
+}
+
+// legacyEntries returns the paths of the entries in the cache dir saved
+// in the legacy format: named by a bare fingerprint, without metadata.
+func legacyEntries(dir string) ([]string, error) {
+	infos, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || len(name) != fingerprintWidth || strings.Trim(name, "0123456789abcdef") != "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if !exists(metaPath(path)) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// checkCacheFormat checks the format of the cache dir before it is used,
+// refusing to use a cache written in a newer format than this build
+// understands. A cache without a recorded format is checked for legacy
+// entries, which are announced once, and its format is recorded.
+func checkCacheFormat(dir string) error {
+	if !exists(dir) {
+		return nil
+	}
+	format, err := readCacheFormat(dir)
+	if err != nil {
+		// Detect the format again, as for a cache without one.
+		if merr := moveAside(formatPath(dir)); merr != nil {
+			return err
+		}
+		noteRepair(repairFormat, err.Error())
+		format = 0
+	}
+	if format > cacheFormat {
+		return fmt.Errorf("%s has cache format %d but this build-cache only understands formats up to %d; upgrade build-cache",
+			dir, format, cacheFormat)
+	}
+	if format != 0 {
+		return nil
+	}
+
+	legacy, err := legacyEntries(dir)
+	if err != nil {
+		return err
+	}
+	format = cacheFormat
+	if len(legacy) > 0 {
+		log.Printf("%s has %d entries in the legacy format without metadata; run \"build-cache migrate\" to upgrade them",
+			dir, len(legacy))
+		format = legacyCacheFormat
+	}
+	if err := writeCacheFormat(dir, format); err != nil {
+		log.Printf("warning: unable to record the cache format: %s", err)
+	}
+	return nil
+}
+
+// migrate upgrades the entries of the cache dir saved in the legacy
+// format, synthesizing their metadata from the entry itself. Only what
+// can be determined from the entry is recorded: its size, checksum,
+// toolchain and modification time as creation time. The metadata of
+// each entry is written atomically, so an interrupted migration is
+// resumed by running it again.
+func migrate(args []string) {
+	dir := cacheDir()
+	if !exists(dir) {
+		log.Printf("%s does not exist", dir)
+		return
+	}
+	defer useCache(dir, true)()
+
+	format, err := readCacheFormat(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if format > cacheFormat {
+		log.Fatalf("%s has cache format %d, newer than %d", dir, format, cacheFormat)
+	}
+
+	legacy, err := legacyEntries(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("migrating %d entries in %s", len(legacy), dir)
+	for _, path := range legacy {
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sum, size, err := fileChecksum(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m := &entryMeta{
+			GoVersion: artifactGoVersion(path),
+			Size:      size,
+			Checksum:  sum,
+			Created:   fi.ModTime().UTC(),
+		}
+		if err := writeMeta(path, m); err != nil {
+			log.Fatal(err)
+		}
+		vlogf("%s  %s", filepath.Base(path), humanSize(size))
+	}
+	if err := writeCacheFormat(dir, cacheFormat); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("migrated %d entries to cache format %d", len(legacy), cacheFormat)
+}
@@ -0,0 +1,154 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// The causes of a miss reported by restore. A cause is a best guess from
+// the entries in the cache: "unknown" when there is nothing to go on.
+const (
+	causeNeverCached     = "never-cached"             // no entry for the package
+	causeInputChanged    = "input-changed"            // entries for other inputs
+	causeExpired         = "expired"                  // the entry has expired
+	causeIntegrity       = "integrity-failure"        // the entry is quarantined or not the package's
+	causeInstrumentation = "instrumentation-mismatch" // entries for another instrumentation or platform
+	causeExcluded        = "excluded"                 // the package is skipped by policy
+	causeUnknown         = "unknown"
+)
+
+// maxChangedInputs is the number of changed inputs reported for a miss
+// caused by changed inputs.
+const maxChangedInputs = 3
+
+// A missCause classifies a miss.
+type missCause struct {
+	Cause   string
+	Changed []string // a sample of the changed inputs, if known
+}
+
+// A missIndex indexes the entries of a cache dir by package in order to
+// classify the misses of a restore. The test binaries are indexed on the
+// first miss, and the metadata of the entries of a package is read from
+// the package index on its first miss.
+type missIndex struct {
+	once        sync.Once
+	dir         string
+	mu          sync.Mutex              // protects byPath
+	byPath      map[string][]*entryMeta // by import path without options, then test
+	quarantined map[string]bool         // by fingerprint
+	anonymous   bool                    // some test binaries have no metadata
+}
+
+func newMissIndex(dir string) *missIndex {
+	return &missIndex{dir: dir}
+}
+
+func (x *missIndex) load() {
+	x.byPath = map[string][]*entryMeta{}
+	x.quarantined = map[string]bool{}
+	if entries, err := listEntries(testDir(x.dir)); err == nil {
+		for _, e := range entries {
+			if e.Meta == nil {
+				x.anonymous = true
+				continue
+			}
+			key := missKey(packageBaseImportPath(e.Meta.ImportPath), e.Meta.Test)
+			x.byPath[key] = append(x.byPath[key], e.Meta)
+		}
+	}
+	for _, metas := range x.byPath {
+		sort.Slice(metas, func(i, j int) bool { return metas[i].Created.After(metas[j].Created) })
+	}
+	quarantined, _ := listQuarantine(x.dir)
+	for _, q := range quarantined {
+		x.quarantined[entryFingerprint(q.Name)] = true
+	}
+}
+
+// metas returns the metadata of the entries of the package with the
+// given import path, or of its test binaries if test is set, newest
+// first, and whether entries without metadata may be its too.
+func (x *missIndex) metas(importPath string, test bool) ([]*entryMeta, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	key := missKey(importPath, test)
+	if test {
+		return x.byPath[key], x.anonymous
+	}
+	paths, anonymous := packageEntries(x.dir, importPath)
+	metas, ok := x.byPath[key]
+	if !ok {
+		for _, path := range paths {
+			if m, err := readMeta(path); err == nil && m != nil {
+				metas = append(metas, m)
+			}
+		}
+		x.byPath[key] = metas
+	}
+	return metas, anonymous
+}
+
+func missKey(importPath string, test bool) string {
+	if test {
+		return importPath + " test"
+	}
+	return importPath
+}
+
+// classify returns the cause of the miss of the entry with fingerprint
+// fp for pkg, or for its test binary if test is set, which was not in
+// the cache.
+func (x *missIndex) classify(pkg *Package, fp string, test bool) missCause {
+	x.once.Do(x.load)
+	if x.quarantined[fp] {
+		return missCause{Cause: causeIntegrity}
+	}
+	metas, anonymous := x.metas(pkg.baseImportPath, test)
+	if len(metas) == 0 {
+		if anonymous {
+			// Any of the entries without metadata may be the
+			// package's.
+			return missCause{Cause: causeUnknown}
+		}
+		return missCause{Cause: causeNeverCached}
+	}
+	want := packageInstrumentation(pkg)
+	for _, m := range metas {
+		if metaInstrumentation(m) != want ||
+			m.GOOS != pkg.buildContext.GOOS || m.GOARCH != pkg.buildContext.GOARCH {
+			continue
+		}
+		// The newest entry for the same instrumentation and platform.
+		c := missCause{Cause: causeInputChanged}
+		if m.Manifest != nil && pkg.manifest != nil && !test {
+			c.Changed = manifestDiff(m.Manifest, pkg.manifest)
+			if len(c.Changed) > maxChangedInputs {
+				c.Changed = c.Changed[:maxChangedInputs]
+			}
+		}
+		return c
+	}
+	// Only entries for another instrumentation or platform.
+	return missCause{Cause: causeInstrumentation}
+}
+
+// String returns the terse form of the cause for the output of restore.
+func (c missCause) String() string {
+	return "(" + c.Cause + ")"
+}
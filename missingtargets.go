@@ -0,0 +1,78 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"os"
+)
+
+var onlyMissingTargets = flag.Bool("only-missing-targets", false,
+	"only restore the packages whose outputs are missing or older than their sources, "+
+		"fingerprinting only those and their dependencies")
+
+// missingTargets returns the packages of pkgs restored with
+// --only-missing-targets and the number of cached packages skipped as
+// fresh. A cached package is restored if its Target is missing or older
+// than one of its sources, or if one of its imports is restored, since
+// the restored output would be newer than its Target. The cached
+// dependencies of the packages restored are fingerprinted with them, as
+// inputs of their fingerprints, but are not restored; the other fresh
+// packages are never fingerprinted.
+func missingTargets(pkgs []*Package) ([]*Package, int) {
+	missing := map[*Package]bool{}
+	var isMissing func(pkg *Package) bool
+	isMissing = func(pkg *Package) bool {
+		if m, ok := missing[pkg]; ok {
+			return m
+		}
+		m := false
+		if pkg.cached() {
+			fi, err := os.Stat(pkg.Target)
+			m = pkg.Target == "" || err != nil || newestSource([]*Package{pkg}).After(fi.ModTime())
+			for _, imp := range pkg.imports {
+				if isMissing(imp) {
+					m = true
+				}
+			}
+		}
+		missing[pkg] = m
+		return m
+	}
+
+	deps := map[*Package]bool{}
+	for _, pkg := range pkgs {
+		if isMissing(pkg) {
+			for _, dep := range pkg.deps {
+				deps[dep] = true
+			}
+		}
+	}
+	var restored []*Package
+	fresh := 0
+	for _, pkg := range pkgs {
+		switch {
+		case isMissing(pkg):
+			restored = append(restored, pkg)
+		case pkg.cached():
+			fresh++
+		case deps[pkg]:
+			// Reported as usual, e.g. as uncacheable.
+			restored = append(restored, pkg)
+		}
+	}
+	return restored, fresh
+}
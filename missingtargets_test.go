@@ -0,0 +1,126 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOnlyMissingTargets checks which packages restore
+// -only-missing-targets restores: those whose outputs are missing or
+// older than their sources, and the packages importing them, fresh as
+// they may be, so that no output is older than those of its imports.
+func TestOnlyMissingTargets(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		setup    func(f *fixture, pkgs map[string]*Package)
+		restored []string
+	}{
+		{name: "fresh", setup: func(*fixture, map[string]*Package) {}},
+		{
+			name: "missing dependency",
+			setup: func(f *fixture, pkgs map[string]*Package) {
+				if err := os.Remove(pkgs["other.org/dep"].Target); err != nil {
+					f.t.Fatal(err)
+				}
+			},
+			restored: []string{"example.com/app/cmd/tool", "example.com/app/lib", "other.org/dep"},
+		},
+		{
+			name: "stale dependency",
+			setup: func(f *fixture, pkgs map[string]*Package) {
+				// Touched since it was built.
+				later := installTime.Add(time.Hour)
+				util := pkgs["example.com/app/util"]
+				if err := os.Chtimes(filepath.Join(util.Dir, util.GoFiles[0]), later, later); err != nil {
+					f.t.Fatal(err)
+				}
+			},
+			restored: []string{"example.com/app/cmd/tool", "example.com/app/lib", "example.com/app/util"},
+		},
+		{
+			name: "missing command",
+			setup: func(f *fixture, pkgs map[string]*Package) {
+				if err := os.Remove(pkgs["example.com/app/cmd/tool"].Target); err != nil {
+					f.t.Fatal(err)
+				}
+			},
+			restored: []string{"example.com/app/cmd/tool"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.install("./...")
+			f.mustRun("save", "./...")
+			pkgs := map[string]*Package{}
+			for _, pkg := range f.load("./...") {
+				if pkg.cached() {
+					pkgs[pkg.ImportPath] = pkg
+					// Built since, as far as restore can tell; the
+					// output saved may be a hard link to the entry.
+					if err := os.Remove(pkg.Target); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(pkg.Target, []byte("built"), 0644); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.Chtimes(pkg.Target, installTime, installTime); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			test.setup(f, pkgs)
+
+			out := f.mustRun("-only-missing-targets", "restore", "./...")
+			var restored []string
+			for importPath, pkg := range pkgs {
+				data, err := os.ReadFile(pkg.Target)
+				if err != nil {
+					t.Errorf("%s: %v", importPath, err)
+				} else if string(data) != "built" {
+					restored = append(restored, importPath)
+				}
+			}
+			sort.Strings(restored)
+			if fmt.Sprint(restored) != fmt.Sprint(test.restored) {
+				t.Errorf("restored %v, want %v:\n%s", restored, test.restored, out)
+			}
+			fresh := len(pkgs) - len(test.restored)
+			if want := fmt.Sprintf("%d skipped as fresh", fresh); fresh > 0 && !strings.Contains(out, want) {
+				t.Errorf("summary without %q:\n%s", want, out)
+			}
+
+			// No output is older than those of the packages it imports.
+			for _, pkg := range f.load("./...") {
+				fi, err := os.Stat(pkg.Target)
+				if !pkg.cached() || err != nil {
+					continue
+				}
+				for _, imp := range pkg.imports {
+					if di, err := os.Stat(imp.Target); imp.cached() && err == nil && di.ModTime().After(fi.ModTime()) {
+						t.Errorf("%s: output older than that of %s", pkg.ImportPath, imp.ImportPath)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// moduleInputs are the inputs to the module resolution of a main
+// module: the effective -mod mode and the hashes of its go.mod and
+// go.sum files, of the go.work and go.work.sum files of its workspace,
+// and of the go.mod files of the local directories replacing modules.
+// The go.mod hash covers its go, toolchain and replace directives.
+type moduleInputs struct {
+	Mode  string `json:"mode,omitempty"`
+	GoMod string `json:"goMod,omitempty"`
+	GoSum string `json:"goSum,omitempty"`
+
+	GoWork    string `json:"goWork,omitempty"`
+	GoWorkSum string `json:"goWorkSum,omitempty"`
+	// By replacement directory, as written in the replace directive.
+	Replaced map[string]string `json:"replaced,omitempty"`
+}
+
+// fingerprintInputs returns the module resolution inputs folded into
+// the fingerprints of the packages of the main module.
+func (in *moduleInputs) fingerprintInputs() []string {
+	inputs := []string{"mod=" + in.Mode, "go.mod=" + in.GoMod, "go.sum=" + in.GoSum}
+	if in.GoWork != "" {
+		inputs = append(inputs, "go.work="+in.GoWork, "go.work.sum="+in.GoWorkSum)
+	}
+	for _, dir := range in.replacedDirs() {
+		inputs = append(inputs, "replace="+dir+":"+in.Replaced[dir])
+	}
+	return inputs
+}
+
+// replacedDirs returns the replacement directories of in, sorted.
+func (in *moduleInputs) replacedDirs() []string {
+	var dirs []string
+	for dir := range in.Replaced {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+var modMode struct {
+	once sync.Once
+	mode string
+}
+
+// moduleResolutionMode returns the effective -mod mode for the module
+// rooted at root: the one from GOFLAGS or else the go command's
+// default, vendor if the module has a vendor directory and readonly
+// otherwise.
+func moduleResolutionMode(root string) string {
+	modMode.once.Do(func() {
+		modMode.mode, _ = goFlagValue(goFlags(), "mod")
+	})
+	if modMode.mode != "" {
+		return modMode.mode
+	}
+	if exists(filepath.Join(root, "vendor", "modules.txt")) {
+		return "vendor"
+	}
+	return "readonly"
+}
+
+// mainModuleDirs returns the root directories of the main modules: the
+// members of the workspace, or else the module enclosing the current
+// directory.
+func mainModuleDirs() []string {
+	if !moduleMode() {
+		return nil
+	}
+	if dirs := workspaceDirs(); len(dirs) > 0 {
+		return dirs
+	}
+	if root := findUp(cwd, "go.mod"); root != "" {
+		return []string{root}
+	}
+	return nil
+}
+
+var moduleInputsCache struct {
+	sync.Mutex
+	m map[string]*moduleInputs // by module root
+}
+
+// hashFileContents returns the hex SHA-256 of the contents of the file,
+// or "" if it does not exist.
+func hashFileContents(path string) string {
+	fds.acquire(1)
+	defer fds.release(1)
+	var data []byte
+	err := fds.retry(func() (err error) {
+		data, err = os.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// moduleInputs returns the module resolution inputs of the main module
+// containing p, or nil if p is not in a main module. Dependencies are
+// identified by their module versions through their import paths and
+// directories, so only main module packages need them.
+func (p *Package) moduleInputs() *moduleInputs {
+	if p.Goroot {
+		return nil
+	}
+	root := findUp(p.Dir, "go.mod")
+	if root == "" || !contains(mainModuleDirs(), root) {
+		return nil
+	}
+	return mainModuleInputs(root)
+}
+
+// mainModuleInputs returns the module resolution inputs of the main
+// module rooted at root.
+func mainModuleInputs(root string) *moduleInputs {
+	moduleInputsCache.Lock()
+	defer moduleInputsCache.Unlock()
+	if in, ok := moduleInputsCache.m[root]; ok {
+		return in
+	}
+	in := &moduleInputs{
+		Mode:  moduleResolutionMode(root),
+		GoMod: hashFileContents(filepath.Join(root, "go.mod")),
+		GoSum: hashFileContents(filepath.Join(root, "go.sum")),
+	}
+	if work := goWorkFile(); work != "" {
+		in.GoWork = hashFileContents(work)
+		in.GoWorkSum = hashFileContents(work + ".sum")
+	}
+	for _, file := range moduleFiles(root) {
+		for _, r := range localReplacements(file) {
+			if in.Replaced == nil {
+				in.Replaced = map[string]string{}
+			}
+			in.Replaced[r.Dir] = hashFileContents(filepath.Join(replacementDir(file, r.Dir), "go.mod"))
+		}
+	}
+	if moduleInputsCache.m == nil {
+		moduleInputsCache.m = map[string]*moduleInputs{}
+	}
+	moduleInputsCache.m[root] = in
+	return in
+}
+
+// moduleFiles returns the go.mod file of the main module rooted at root
+// and the go.work file of its workspace, if any.
+func moduleFiles(root string) []string {
+	files := []string{filepath.Join(root, "go.mod")}
+	if work := goWorkFile(); work != "" {
+		files = append(files, work)
+	}
+	return files
+}
+
+// A replacement is a replace directive replacing a module with a local
+// directory.
+type replacement struct {
+	Module string // the module replaced, as path or path@version
+	Dir    string // as written, relative to the go.mod or go.work file
+}
+
+// localReplacements returns the replace directives of the go.mod or
+// go.work file which replace modules with local directories rather than
+// other module versions.
+func localReplacements(file string) []replacement {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rs []replacement
+	inReplace := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inReplace && fields[0] == ")":
+			inReplace = false
+			continue
+		case fields[0] == "replace" && len(fields) >= 2 && fields[1] == "(":
+			inReplace = true
+			continue
+		case fields[0] == "replace":
+			fields = fields[1:]
+		case !inReplace:
+			continue
+		}
+		// old [version] => new [version]
+		for i, field := range fields {
+			if field != "=>" || i+1 >= len(fields) {
+				continue
+			}
+			dir := fields[i+1]
+			if u, err := strconv.Unquote(dir); err == nil {
+				dir = u
+			}
+			if isLocalReplacement(dir) {
+				rs = append(rs, replacement{Module: strings.Join(fields[:i], "@"), Dir: dir})
+			}
+		}
+	}
+	return rs
+}
+
+// isLocalReplacement reports whether the target of a replace directive
+// is a directory: a path beginning with ./ or ../, or an absolute one.
+func isLocalReplacement(path string) bool {
+	return strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") ||
+		path == "." || path == ".." || filepath.IsAbs(path) ||
+		strings.HasPrefix(path, `.\`) || strings.HasPrefix(path, `..\`)
+}
+
+// replacementDir returns the directory dir of a replace directive of
+// the go.mod or go.work file, which is relative to the file.
+func replacementDir(file, dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(file), filepath.FromSlash(dir))
+}
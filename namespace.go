@@ -0,0 +1,207 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A stringsFlag is a flag.Value collecting the values of a repeatable
+// flag in order.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+var (
+	namespaceFlag = flag.String("namespace", "",
+		"use the namespace of the cache with this name (default the cache itself)")
+	namespaceFromGit = flag.Bool("namespace-from-git", false,
+		"use the namespace named after the current git branch")
+	fallbackNamespaces stringsFlag
+)
+
+func init() {
+	flag.Var(&fallbackNamespaces, "fallback-namespace",
+		"namespace consulted, in order, for the packages missing from the namespace in use when restoring (repeatable)")
+}
+
+// namespacesDir returns the directory holding the namespaces of the
+// cache root. Each namespace is laid out like a cache of its own.
+func namespacesDir(root string) string {
+	return filepath.Join(root, "namespaces")
+}
+
+// namespaceDir returns the cache directory of the namespace ns of the
+// cache root, which is the root itself for the default namespace "".
+func namespaceDir(root, ns string) string {
+	if ns == "" {
+		return root
+	}
+	return filepath.Join(namespacesDir(root), ns)
+}
+
+var namespaceOnce sync.Once
+var currentNamespace string
+
+// namespace returns the namespace in use: the one given by --namespace
+// or derived from the current git branch by --namespace-from-git, or
+// "" for the default namespace.
+func namespace() string {
+	namespaceOnce.Do(func() {
+		ns := *namespaceFlag
+		if ns == "" && *namespaceFromGit {
+			out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+			switch branch := strings.TrimSpace(string(out)); {
+			case err != nil:
+				log.Printf("warning: unable to determine the git branch, using the default namespace: %s", err)
+			case branch == "HEAD":
+				log.Printf("warning: detached HEAD, using the default namespace")
+			default:
+				ns = branch
+			}
+		}
+		currentNamespace = namespaceName(ns)
+	})
+	return currentNamespace
+}
+
+// namespaceName returns the directory name of the namespace ns, with
+// the characters unsafe in file names (such as the "/" of branch names)
+// replaced.
+func namespaceName(ns string) string {
+	name := strings.Map(func(c rune) rune {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '.', c == '_', c == '-':
+			return c
+		}
+		return '_'
+	}, ns)
+	if strings.HasPrefix(name, ".") {
+		// Not hidden, nor "." or "..".
+		name = "_" + name[1:]
+	}
+	return name
+}
+
+// fallbacks returns the cache directories of the fallback namespaces by
+// namespace, in order, omitting the namespace in use and those which do
+// not exist.
+func fallbacks() []string {
+	var names []string
+	for _, ns := range fallbackNamespaces {
+		ns = namespaceName(ns)
+		if ns != namespace() && !contains(names, ns) && exists(namespaceDir(cacheRoot(), ns)) {
+			names = append(names, ns)
+		}
+	}
+	return names
+}
+
+// lookupNamespaced returns the path of the entry with fingerprint fp in
+// the cache directory dir of the namespace in use, or its subdirectory
+// given by sub (e.g. testDir) if not nil, or else in that of the first
+// fallback namespace holding it, and the namespace it was found in.
+func lookupNamespaced(dir, fp string, sub func(string) string) (string, string) {
+	if sub == nil {
+		sub = func(dir string) string { return dir }
+	}
+	if src := lookupEntry(sub(dir), fp); src != "" || fp == "" {
+		return src, namespace()
+	}
+	for _, ns := range fallbacks() {
+		if src := lookupEntry(sub(namespaceDir(cacheRoot(), ns)), fp); src != "" {
+			return src, ns
+		}
+	}
+	return "", ""
+}
+
+// useFallbacks locks the caches of the fallback namespaces for reading
+// and returns a function releasing them.
+func useFallbacks() (func(), error) {
+	var unlocks []func()
+	unlockAll := func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+	for _, ns := range fallbacks() {
+		unlock, err := lockCacheUse(namespaceDir(cacheRoot(), ns), false, *lockTimeout)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// formatNamespaceHits returns the hits by namespace, the namespace in
+// use first, e.g. "feature-x 10, main 30", or "" if no fallback
+// namespace was consulted.
+func formatNamespaceHits(hits map[string]int) string {
+	if len(fallbackNamespaces) == 0 || len(hits) == 0 {
+		return ""
+	}
+	var names []string
+	for ns := range hits {
+		names = append(names, ns)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == namespace()) != (names[j] == namespace()) {
+			return names[i] == namespace()
+		}
+		return names[i] < names[j]
+	})
+	var parts []string
+	for _, ns := range names {
+		name := ns
+		if name == "" {
+			name = "(default)"
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", name, hits[ns]))
+	}
+	return "hits by namespace: " + strings.Join(parts, ", ")
+}
+
+// ensureNamespaceDir creates the cache directory dir of the namespace
+// in use for a restore, if fallback namespaces or --remote can provide
+// its entries, and reports whether it exists.
+func ensureNamespaceDir(dir string) (bool, error) {
+	if exists(dir) {
+		return true, nil
+	}
+	if len(fallbacks()) == 0 && remote() == nil {
+		return false, nil
+	}
+	if err := createCacheDir(dir); err != nil {
+		return false, err
+	}
+	return true, nil
+}
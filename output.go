@@ -0,0 +1,145 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+var (
+	noColor = flag.Bool("no-color", false, "disable colored output")
+	verbose = flag.Bool("v", false, "verbose output")
+	// -vv implies -v; see main.
+	veryVerbose = flag.Bool("vv", false, "very verbose output, including fingerprinting retries")
+)
+
+// vlogf logs the message if -v was specified.
+func vlogf(format string, args ...interface{}) {
+	if *verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// vvlogf logs the message if -vv was specified.
+func vvlogf(format string, args ...interface{}) {
+	if *veryVerbose {
+		log.Printf(format, args...)
+	}
+}
+
+// fingerprintWidth is the width of the fingerprint column: the length
+// of a hex SHA-1 digest.
+const fingerprintWidth = 40
+
+// ANSI escape sequences.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiDim   = "\x1b[2m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+)
+
+var resultColors = map[string]string{
+	resultHit:     ansiGreen,
+	resultMiss:    ansiRed,
+	resultStale:   ansiDim,
+	resultExpired: ansiRed,
+	resultPolicy:  ansiDim,
+}
+
+var colorOnce sync.Once
+var colorEnabled bool
+
+// useColor reports whether output should be colored: stderr must be a
+// terminal and neither --no-color nor NO_COLOR may be set.
+func useColor() bool {
+	colorOnce.Do(func() {
+		colorEnabled = !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr)
+	})
+	return colorEnabled
+}
+
+func colorize(color, s string) string {
+	if color == "" || !useColor() {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// logResult logs the line describing the result of saving or restoring
+// a package: its fingerprint ("-" if it has none), a one character tag,
+// its name and details.
+func logResult(result, fp, tag, name, detail string) {
+	log.Print(formatResult(result, fp, tag, name, detail))
+}
+
+// formatResult returns the line logged by logResult.
+func formatResult(result, fp, tag, name, detail string) string {
+	if fp == "" {
+		fp = "-"
+	}
+	line := fmt.Sprintf("%-*s %1s%s (%s)", fingerprintWidth, fp, tag, name, detail)
+	return colorize(resultColors[result], line)
+}
+
+// logSummary logs the summary line of a save or restore.
+func logSummary(s *summary) {
+	extra := ""
+	if s.Expired > 0 {
+		extra = fmt.Sprintf(", %d expired", s.Expired)
+	}
+	if s.PolicySkips > 0 {
+		extra += fmt.Sprintf(", %d skipped by policy", s.PolicySkips)
+	}
+	if s.Conflicts > 0 {
+		extra += fmt.Sprintf(", %d fingerprint conflicts", s.Conflicts)
+	}
+	if s.Fresh > 0 {
+		extra += fmt.Sprintf(", %d skipped as fresh", s.Fresh)
+	}
+	if s.Coalesced > 0 {
+		extra += fmt.Sprintf(", %d coalesced", s.Coalesced)
+	}
+	if s.Errors > 0 {
+		extra += fmt.Sprintf(", %d errors", s.Errors)
+	}
+	line := fmt.Sprintf("%d packages: %d hits, %d misses, %d stale%s (%.1f%% hit rate)",
+		s.Packages, s.Hits, s.Misses, s.Stale, extra, 100*s.HitRate)
+	log.Print(colorize(ansiBold, line))
+	if line := formatNamespaceHits(s.NamespaceHits); line != "" {
+		log.Print(line)
+	}
+	if line := formatRoots(s.Roots); line != "" {
+		log.Print(line)
+	}
+	if line := formatAnnotated(s.Annotated); line != "" {
+		log.Print(line)
+	}
+	if len(s.Skipped) > 0 {
+		log.Printf("not saved: %s", formatSkipped(s.Skipped))
+	}
+	if line := formatSaved(s.SavedSeconds); line != "" {
+		log.Print(line)
+	}
+	if line := s.remoteCounts.format(s.PushQueued); line != "" {
+		log.Print(line)
+	}
+}
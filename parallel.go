@@ -0,0 +1,231 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// A pkgReport collects the output lines and results of processing a
+// package so that they can be reported in package order.
+type pkgReport struct {
+	lines     []string
+	records   [][3]string // import path, fingerprint and result
+	skipped   []string    // reasons
+	conflicts int
+	coalesced int
+	hitsFrom  []string // namespaces
+	fetched   int      // from --remote
+	saved     time.Duration
+	reported  []*reportPackage
+	errs      []error
+}
+
+// logResult buffers the line describing a result; see logResult.
+func (r *pkgReport) logResult(result, fp, tag, name, detail string) {
+	r.lines = append(r.lines, formatResult(result, fp, tag, name, detail))
+}
+
+// record buffers a result for the summary; see summary.record.
+func (r *pkgReport) record(importPath, fp, result string) {
+	r.records = append(r.records, [3]string{importPath, fp, result})
+}
+
+// skip buffers the line and result of a package whose output, target,
+// is not saved, and the reason for the summary.
+func (r *pkgReport) skip(name, target string, s *saveSkip) {
+	// The outputs in the build cache are explained by a single notice
+	// (see archivesInGoCache).
+	if s.Reason != skipInGoCache || *verbose {
+		r.logResult(resultStale, "", "", name, s.String())
+	}
+	r.record(name, "", resultStale)
+	r.skipped = append(r.skipped, s.Reason)
+	r.report(name, "", resultStale, actionSkipped, target, time.Time{})
+}
+
+// fail logs a failure of the package immediately, unlike its results,
+// and records it for the summary. err should be tagged with the import
+// path.
+func (r *pkgReport) fail(err error) {
+	logFailure("%s", err)
+	r.errs = append(r.errs, err)
+}
+
+// conflict counts a fingerprint conflict for the summary.
+func (r *pkgReport) conflict() {
+	r.conflicts++
+}
+
+// coalesce counts a save which waited for that of the same fingerprint
+// by another package for the summary.
+func (r *pkgReport) coalesce() {
+	r.coalesced++
+}
+
+// hitFrom notes a hit in the namespace ns for the summary.
+func (r *pkgReport) hitFrom(ns string) {
+	r.hitsFrom = append(r.hitsFrom, ns)
+}
+
+// fetch counts a hit fetched from --remote for the summary.
+func (r *pkgReport) fetch() {
+	r.fetched++
+}
+
+// buildSaved counts the build time of a restored output for the
+// summary.
+func (r *pkgReport) buildSaved(d time.Duration) {
+	r.saved += d
+}
+
+// flush logs the buffered lines and records the buffered results in s.
+func (r *pkgReport) flush(s *summary) {
+	for _, line := range r.lines {
+		log.Print(line)
+	}
+	for _, err := range r.errs {
+		s.fail(err)
+	}
+	for _, rec := range r.records {
+		s.record(rec[0], rec[1], rec[2])
+	}
+	for _, reason := range r.skipped {
+		s.skip(reason)
+	}
+	if r.conflicts > 0 {
+		s.Conflicts += r.conflicts
+	}
+	if r.coalesced > 0 {
+		s.Coalesced += r.coalesced
+	}
+	for _, ns := range r.hitsFrom {
+		s.hitFrom(ns)
+	}
+	if r.fetched > 0 {
+		s.RemoteHits += r.fetched
+	}
+	if r.saved > 0 {
+		s.SavedSeconds += r.saved.Seconds()
+	}
+	if len(r.reported) > 0 {
+		s.reported = append(s.reported, r.reported...)
+	}
+}
+
+// forEachPackage calls fn for each of pkgs on the workers allowed by
+// -j and --cpu-limit. The output and results buffered in each package's
+// report are flushed as soon as those of all the preceding packages have
+// been, so the output is the same however the work is scheduled.
+// Failures, recorded with pkgReport.fail, and warnings, logged directly,
+// surface immediately instead, tagged with the import path. s may be nil
+// if fn records no results.
+//
+// The packages are processed in the waves returned by waves, each only
+// once the previous one is done, so that the dependencies of a package
+// have been handled, and their file lists released, by the time it is
+// fingerprinted.
+func forEachPackage(pkgs []*Package, s *summary, fn func(pkg *Package, r *pkgReport)) {
+	workers := limits.Workers
+
+	type job struct {
+		i    int
+		wave *sync.WaitGroup
+	}
+	work := make(chan job)
+	done := make(chan int)
+	var wg sync.WaitGroup
+	reports := make([]*pkgReport, len(pkgs))
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				r := &pkgReport{}
+				fn(pkgs[j.i], r)
+				reports[j.i] = r
+				done <- j.i
+				j.wave.Done()
+			}
+		}()
+	}
+	go func() {
+		for _, wave := range waves(pkgs) {
+			var waveWG sync.WaitGroup
+			waveWG.Add(len(wave))
+			for _, i := range wave {
+				work <- job{i, &waveWG}
+			}
+			waveWG.Wait()
+		}
+		close(work)
+		wg.Wait()
+		close(done)
+	}()
+
+	ready := make([]bool, len(pkgs))
+	next := 0
+	for i := range done {
+		ready[i] = true
+		for ; next < len(pkgs) && ready[next]; next++ {
+			reports[next].flush(s)
+			reports[next] = nil
+		}
+	}
+}
+
+// waves groups the indexes of pkgs into topological waves: the first
+// holds the packages none of whose dependencies are among pkgs, and each
+// following one those whose dependencies among pkgs are all in earlier
+// waves. The indexes of a wave are in the order of pkgs.
+func waves(pkgs []*Package) [][]int {
+	index := make(map[*Package]int, len(pkgs))
+	for i, pkg := range pkgs {
+		index[pkg] = i
+	}
+	depth := make([]int, len(pkgs))
+	for i := range depth {
+		depth[i] = -1
+	}
+	var visit func(i int) int
+	visit = func(i int) int {
+		if depth[i] >= 0 {
+			return depth[i]
+		}
+		depth[i] = 0 // in case of an import cycle
+		d := 0
+		for _, dep := range pkgs[i].deps {
+			if j, ok := index[dep]; ok {
+				if dd := visit(j) + 1; dd > d {
+					d = dd
+				}
+			}
+		}
+		depth[i] = d
+		return d
+	}
+	var waves [][]int
+	for i := range pkgs {
+		d := visit(i)
+		for len(waves) <= d {
+			waves = append(waves, nil)
+		}
+		waves[d] = append(waves[d], i)
+	}
+	return waves
+}
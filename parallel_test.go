@@ -0,0 +1,106 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// timings matches the lines of the output which vary from run to run.
+var timings = regexp.MustCompile(`(?m)^finished loading: .*\n`)
+
+// TestParallelOutput checks that save and restore print the same output
+// however many packages they work on at once. Run it with -race.
+func TestParallelOutput(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		reset func(f *fixture) // before each run
+		args  []string
+	}{
+		{
+			name: "save",
+			reset: func(f *fixture) {
+				if err := os.RemoveAll(f.cache); err != nil {
+					t.Fatal(err)
+				}
+			},
+			args: []string{"-include-tests", "-artifacts-from", ".", "save", "./..."},
+		},
+		{
+			name: "restore",
+			reset: func(f *fixture) {
+				for _, pkg := range f.load("./...") {
+					if pkg.cached() && pkg.Target != "" {
+						_ = os.Remove(pkg.Target)
+					}
+				}
+			},
+			args: []string{"restore", "./..."},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.install("./...")
+			f.mustRun("save", "./...")
+
+			var want string
+			for _, jobs := range []string{"1", "8", "8", "2"} {
+				test.reset(f)
+				args := append([]string{"-j", jobs}, test.args...)
+				out := timings.ReplaceAllString(f.mustRun(args...), "")
+				if jobs == "1" {
+					want = out
+					continue
+				}
+				if out != want {
+					t.Errorf("build-cache %v printed:\n%s\nwant, as with -j 1:\n%s", args, out, want)
+				}
+			}
+		})
+	}
+}
+
+// TestForEachPackageWaves checks that forEachPackage handles the
+// dependencies of each package before the package itself, however many
+// packages it works on at once.
+func TestForEachPackageWaves(t *testing.T) {
+	for _, workers := range []int{1, 8} {
+		f := newFixture(t)
+		saved := limits.Workers
+		limits.Workers = workers
+		pkgs := f.load("./...")
+
+		var mu sync.Mutex
+		handled := map[*Package]bool{}
+		forEachPackage(pkgs, nil, func(pkg *Package, r *pkgReport) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, dep := range pkg.deps {
+				if !handled[dep] {
+					t.Errorf("-j %d: %s handled before its dependency %s", workers, pkg.ImportPath, dep.ImportPath)
+				}
+			}
+			handled[pkg] = true
+		})
+		limits.Workers = saved
+		if len(handled) != len(pkgs) {
+			t.Errorf("-j %d: handled %d packages, want %d", workers, len(handled), len(pkgs))
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	pinLabel      = flag.String("pin-label", "", "label of the pins added by pin, or removed by unpin")
+	pinTTL        = flag.Duration("pin-ttl", 0, "how long the pins added by pin last (0 for ever)")
+	onlyPinned    = flag.Bool("pinned", false, "only list the pinned entries with ls")
+	includePinned = flag.Bool("include-pinned", false, "let prune remove pinned entries")
+)
+
+// A pin protects a cache entry from prune until it expires.
+type pin struct {
+	ImportPath string     `json:"importPath"`
+	Label      string     `json:"label,omitempty"`
+	Pinned     time.Time  `json:"pinned"`
+	Expires    *time.Time `json:"expires,omitempty"`
+}
+
+// expired reports whether the pin no longer applies at now.
+func (p *pin) expired(now time.Time) bool {
+	return p.Expires != nil && now.After(*p.Expires)
+}
+
+// pinsPath returns the path of the pins of the cache dir, by entry
+// fingerprint.
+func pinsPath(dir string) string {
+	return filepath.Join(dir, "pins.json")
+}
+
+// readPins returns the pins of the cache dir which have not expired.
+func readPins(dir string) map[string]*pin {
+	var pins map[string]*pin
+	if !readAux(pinsPath(dir), "pins", &pins) || pins == nil {
+		pins = map[string]*pin{}
+	}
+	now := time.Now()
+	for fp, p := range pins {
+		if p.expired(now) {
+			delete(pins, fp)
+		}
+	}
+	return pins
+}
+
+// updatePins applies update to the pins of the cache dir under the
+// cache lock, dropping the expired ones.
+func updatePins(dir string, update func(pins map[string]*pin)) error {
+	return withCacheLock(dir, func() error {
+		pins := readPins(dir)
+		update(pins)
+		if len(pins) == 0 {
+			if err := os.Remove(pinsPath(dir)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		return writeAux(pinsPath(dir), "pins", pins)
+	})
+}
+
+var pinsMu sync.Mutex
+var pinsByDir = map[string]map[string]*pin{}
+
+// entryPinned reports whether the entry at src is pinned, unless
+// --include-pinned is given.
+func entryPinned(src string) bool {
+	if *includePinned {
+		return false
+	}
+	dir := filepath.Dir(src)
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	pins, ok := pinsByDir[dir]
+	if !ok {
+		pins = readPins(dir)
+		pinsByDir[dir] = pins
+	}
+	return pins[entryFingerprint(filepath.Base(src))] != nil
+}
+
+// pinnedEntries returns the entries pinned among entries, with their
+// pins.
+func pinnedEntries(dir string, entries []*cacheEntry) map[*cacheEntry]*pin {
+	pins := readPins(dir)
+	pinned := map[*cacheEntry]*pin{}
+	for _, e := range entries {
+		if p := pins[e.Fingerprint()]; p != nil {
+			pinned[e] = p
+		}
+	}
+	return pinned
+}
+
+// pinPackages pins the entries of the packages, and their dependencies,
+// named by args as they are currently fingerprinted, with --pin-label
+// and --pin-ttl.
+func pinPackages(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	dir := cacheDir()
+	if !exists(dir) {
+		log.Fatalf("%s does not exist", dir)
+	}
+	pkgs := loadAll(args)
+
+	now := time.Now().UTC()
+	var expires *time.Time
+	if *pinTTL > 0 {
+		t := now.Add(*pinTTL)
+		expires = &t
+	}
+	added := map[string]*pin{}
+	missing := 0
+	for _, pkg := range pkgs {
+		if !pkg.cached() {
+			continue
+		}
+		fp := pkg.Fingerprint()
+		if fp == "" || lookupEntry(dir, fp) == "" {
+			missing++
+			continue
+		}
+		added[fp] = &pin{ImportPath: pkg.ImportPath, Label: *pinLabel, Pinned: now, Expires: expires}
+	}
+	err := updatePins(dir, func(pins map[string]*pin) {
+		for fp, p := range added {
+			pins[fp] = p
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("pinned %d entries", len(added))
+	if missing > 0 {
+		log.Printf("%d packages have no entry to pin", missing)
+	}
+}
+
+// unpinPackages removes the pins of the entries of the packages named
+// by args, as they are currently fingerprinted, or with no arguments
+// and --pin-label, the pins with that label.
+func unpinPackages(args []string) {
+	dir := cacheDir()
+	if !exists(dir) {
+		log.Fatalf("%s does not exist", dir)
+	}
+	if len(args) == 0 && *pinLabel == "" {
+		log.Printf("usage: %s unpin [-pin-label LABEL] [packages]", os.Args[0])
+		os.Exit(1)
+	}
+	fps := map[string]bool{}
+	if len(args) > 0 {
+		for _, pkg := range loadAll(args) {
+			if pkg.cached() && pkg.Fingerprint() != "" {
+				fps[pkg.Fingerprint()] = true
+			}
+		}
+	}
+	removed := 0
+	err := updatePins(dir, func(pins map[string]*pin) {
+		for fp, p := range pins {
+			if (len(args) == 0 || fps[fp]) && (*pinLabel == "" || p.Label == *pinLabel) {
+				delete(pins, fp)
+				removed++
+			}
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("unpinned %d entries", removed)
+}
+
+// formatPin describes the pin of an entry listed by ls.
+func formatPin(p *pin) string {
+	s := "pinned"
+	if p.Label != "" {
+		s += " " + p.Label
+	}
+	if p.Expires != nil {
+		s += " until " + p.Expires.Local().Format("2006-01-02")
+	}
+	return s
+}
+
+// pinStats returns the number and total size of the pinned entries of
+// the cache dir, by label.
+func pinStats(dir string) (counts map[string]int, sizes map[string]int64) {
+	entries, err := listEntries(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	counts, sizes = map[string]int{}, map[string]int64{}
+	for e, p := range pinnedEntries(dir, entries) {
+		counts[p.Label]++
+		sizes[p.Label] += e.Size
+	}
+	return counts, sizes
+}
+
+// formatPinStats describes the pinned entries counted by pinStats, or
+// returns "" if there are none.
+func formatPinStats(counts map[string]int, sizes map[string]int64) string {
+	if len(counts) == 0 {
+		return ""
+	}
+	var labels []string
+	var total int
+	var size int64
+	for label := range counts {
+		labels = append(labels, label)
+		total += counts[label]
+		size += sizes[label]
+	}
+	sort.Strings(labels)
+	s := fmt.Sprintf("pinned   %d entries (%s)", total, humanSize(size))
+	for _, label := range labels {
+		name := label
+		if name == "" {
+			name = "unlabeled"
+		}
+		s += fmt.Sprintf("\n         %s: %d entries (%s)", name, counts[label], humanSize(sizes[label]))
+	}
+	return s
+}
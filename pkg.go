@@ -9,10 +9,12 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"go/build"
 	"go/scanner"
 	"go/token"
+	"hash"
 	"io"
 	"log"
 	"os"
@@ -20,13 +22,32 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 )
 
 var (
 	gobin = os.Getenv("GOBIN")
+
+	captureManifest = flag.Bool("manifest", false,
+		"record the inputs of each fingerprint for diagnostics")
+
+	targetRoot = flag.String("target-root", "",
+		"restore package outputs under this directory instead of their GOPATH entry, "+
+			"or under DIR for the GOPATH entry ROOT given as ROOT=DIR,...")
+	fromRoot = flag.String("from-root", "",
+		"save package outputs from under this directory instead of their GOPATH entry, "+
+			"or from under DIR for the GOPATH entry ROOT given as ROOT=DIR,...")
+
+	// altRoot is the value of --target-root when restoring or
+	// --from-root when saving, as set by setAltRoot.
+	altRoot string
+
+	targetGOOS   = flag.String("goos", "", "target operating system (defaults to $GOOS or the host)")
+	targetGOARCH = flag.String("goarch", "", "target architecture (defaults to $GOARCH or the host)")
 )
 
 type packageList []*Package
@@ -65,17 +86,32 @@ type Package struct {
 	buildContext   *build.Context
 	baseImportPath string
 
-	Target     string        // install path
-	Standard   bool          // is this package part of the standard Go library?
-	Stale      bool          // would 'go install' do anything for this package?
-	Incomplete bool          // was there an error loading this package or dependencies?
-	Error      *PackageError // error loading this package (not dependencies)
-
-	imports     []*Package
-	deps        []*Package
-	local       bool // imported via local path (./ or ../)
-	fingerprint *string
-	race        bool
+	Target      string        // install path
+	Standard    bool          // is this package part of the standard Go library?
+	Stale       bool          // would 'go install' do anything for this package?
+	StaleReason string        // why Stale is true
+	Incomplete  bool          // was there an error loading this package or dependencies?
+	Error       *PackageError // error loading this package (not dependencies)
+
+	imports []*Package
+	deps    []*Package
+	local   bool // imported via local path (./ or ../)
+	race    bool
+	root    bool // named on the command line
+
+	ambiguous  string             // why the import path does not identify the sources, if it does not
+	broken     string             // the broken symlink among its source files, if any
+	annotation *packageAnnotation // from its packageAnnotationFile, if any
+
+	fingerprintMu  sync.Mutex // protects fingerprint, manifest and uncacheable
+	fingerprint    *string
+	manifest       map[string]string // fingerprint inputs, if captured
+	uncacheable    string            // why the fingerprint is empty, if it is
+	fingerprintErr error             // the failure to fingerprint p, if any
+
+	testImports       []*Package // loaded for root packages with -include-tests
+	testFingerprintMu sync.Mutex // protects testFingerprint
+	testFingerprint   *string
 }
 
 // A PackageError describes an error loading information about a package.
@@ -198,9 +234,7 @@ func loadImport(buildContext *build.Context, path string, srcDir string,
 	// See issue 3268 for mistakes to avoid.
 	bp, err := buildContext.Import(path, srcDir, build.ImportComment)
 	bp.ImportPath = fullImportPath
-	if gobin != "" {
-		bp.BinDir = gobin
-	}
+	bp.BinDir = binDir(bp)
 	if err == nil && !isLocal && bp.ImportComment != "" && bp.ImportComment != path {
 		err = fmt.Errorf("code in directory %s expects import %q", bp.Dir, bp.ImportComment)
 	}
@@ -288,6 +322,13 @@ func (p *Package) load(buildContext *build.Context, stk *importStack, bp *build.
 	p.Standard = p.Goroot && p.ImportPath != "" && !strings.Contains(p.ImportPath, ".")
 	p.race = contains(p.buildContext.BuildTags, "race")
 
+	if err != nil && !p.Goroot {
+		// The package is uncacheable rather than failing the run.
+		if p.broken = brokenSymlink(bp.Dir); p.broken != "" {
+			log.Printf("warning: %s: %s", bp.ImportPath, p.broken)
+			err = nil
+		}
+	}
 	if err != nil {
 		p.Incomplete = true
 		err = expandScanner(err)
@@ -298,26 +339,34 @@ func (p *Package) load(buildContext *build.Context, stk *importStack, bp *build.
 		return p
 	}
 
-	if p.Name == "main" {
-		_, elem := filepath.Split(p.Dir)
-		full := buildContext.GOOS + "_" + buildContext.GOARCH + "/" + elem
-		if buildContext.GOOS != runtime.GOOS || buildContext.GOARCH != runtime.GOARCH {
-			// Install cross-compiled binaries to subdirectories of bin.
-			elem = full
-		}
-		if p.BinDir != "" {
-			// Install to GOBIN or bin of GOPATH entry.
-			p.Target = filepath.Join(p.BinDir, elem)
-		}
-		if p.Target != "" && buildContext.GOOS == "windows" {
-			p.Target += ".exe"
+	if p.ambiguous = p.checkAmbiguous(); p.ambiguous != "" {
+		log.Printf("warning: %s: ambiguous import path: %s", p.ImportPath, p.ambiguous)
+	}
+
+	if !p.Goroot {
+		if p.annotation, err = readPackageAnnotation(p.Dir); err != nil {
+			p.Incomplete = true
+			p.Error = &PackageError{
+				ImportStack: stk.copy(),
+				Err:         err.Error(),
+			}
+			return p
 		}
+	}
+
+	if p.Name == "main" {
+		p.Target = binTarget(buildContext, bp)
 	} else if p.local {
 		// Local import turned into absolute path.
 		// No permanent install target.
 		p.Target = ""
 	} else {
 		p.Target = p.PkgObj
+		if _, pkgdir := buildSettings(); pkgdir != "" && !p.Goroot {
+			p.Target = filepath.Join(pkgdir, filepath.FromSlash(p.baseImportPath)+".a")
+		} else if p.Goroot && p.race {
+			p.Target = stdRaceTarget(p)
+		}
 	}
 
 	importPaths := p.Imports
@@ -380,12 +429,26 @@ func (p *Package) load(buildContext *build.Context, stk *importStack, bp *build.
 		p.deps = append(p.deps, dep)
 	}
 	sort.Sort(packageList(p.deps))
+	p.trim()
 
 	// unsafe is a fake package.
 	if p.Standard && (p.baseImportPath == "unsafe" || buildContext.Compiler == "gccgo") {
 		p.Target = ""
 	}
 
+	// The outputs of GOROOT packages stay in GOROOT.
+	if root := p.altRootFor(); root != "" && p.Target != "" && !p.Goroot {
+		target, err := p.rebaseTarget(root)
+		if err != nil {
+			p.Error = &PackageError{
+				ImportStack: stk.copy(),
+				Err:         err.Error(),
+			}
+			return p
+		}
+		p.Target = target
+	}
+
 	// Check for C code compiled with Plan 9 C compiler.
 	// No longer allowed except in runtime and runtime/cgo, for now.
 	if len(p.CFiles) > 0 && !p.usesCgo() && (!p.Standard || p.baseImportPath != "runtime") {
@@ -399,6 +462,165 @@ func (p *Package) load(buildContext *build.Context, stk *importStack, bp *build.
 	return p
 }
 
+// binDir returns the directory into which "go install" places the
+// binary of the main package bp: GOBIN if set, otherwise the bin
+// directory of the GOPATH entry containing the package or, in module
+// mode, of the first GOPATH entry, although go/build gives module
+// packages the bin directory of their module as if it were a GOPATH
+// entry.
+func binDir(bp *build.Package) string {
+	if gobin != "" {
+		return gobin
+	}
+	if (bp.BinDir == "" || moduleMode()) && bp.Name == "main" && !bp.Goroot {
+		if entries := gopathEntries(); len(entries) > 0 {
+			return filepath.Join(entries[0], "bin")
+		}
+	}
+	return bp.BinDir
+}
+
+// binTarget returns the path at which "go install" places the binary of
+// the main package bp, or "" if it does not install it. All binary path
+// resolution goes through here, following the go command: the binary is
+// named after the package directory (in module mode, after the last
+// element of the import path without a major version suffix) and
+// cross-compiled binaries go into a bin/<goos>_<goarch> subdirectory,
+// except that they are not installed at all when GOBIN is set.
+func binTarget(ctx *build.Context, bp *build.Package) string {
+	if bp.BinDir == "" {
+		return ""
+	}
+	elem := filepath.Base(bp.Dir)
+	if moduleMode() && !bp.Goroot {
+		importPath := packageBaseImportPath(bp.ImportPath)
+		elem = path.Base(importPath)
+		if isMajorVersionSuffix(elem) && path.Dir(importPath) != "." {
+			elem = path.Base(path.Dir(importPath))
+		}
+	}
+	if ctx.GOOS != runtime.GOOS || ctx.GOARCH != runtime.GOARCH {
+		if gobin != "" {
+			// go install refuses to create $GOBIN/<goos>_<goarch>.
+			return ""
+		}
+		// Install cross-compiled binaries to subdirectories of bin.
+		elem = filepath.Join(ctx.GOOS+"_"+ctx.GOARCH, elem)
+	}
+	target := filepath.Join(bp.BinDir, elem)
+	if ctx.GOOS == "windows" {
+		target += ".exe"
+	}
+	return target
+}
+
+// isMajorVersionSuffix reports whether elem is a module major version
+// suffix such as "v2".
+func isMajorVersionSuffix(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' || elem == "v1" || elem[1] == '0' {
+		return false
+	}
+	for _, c := range elem[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// rebaseTarget returns p.Target rebased from the package's root onto
+// root. The portion of the Target under the package's root (e.g.
+// "pkg/linux_amd64_race/x/y.a" or "bin/z") is preserved. Commands
+// installed to $GOBIN are rebased into the "bin" directory of root. An
+// error is returned if the rebased path would lie outside root.
+func (p *Package) rebaseTarget(root string) (string, error) {
+	under := func(base string) (string, bool) {
+		if base == "" {
+			return "", false
+		}
+		rel, err := filepath.Rel(base, p.Target)
+		if err != nil || filepath.IsAbs(rel) || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", false
+		}
+		return rel, true
+	}
+
+	base := p.Root
+	if base == "" {
+		// Packages outside of GOPATH (module mode) install into the
+		// GOPATH entry containing their Target.
+		base = gopathEntry(p.Target)
+	}
+	rel, ok := under(base)
+	if !ok && p.Name == "main" {
+		if rel, ok = under(p.BinDir); ok {
+			rel = filepath.Join("bin", rel)
+		}
+	}
+	if !ok {
+		return "", fmt.Errorf("cannot rebase %s onto %s: not under %s", p.Target, root, base)
+	}
+	return filepath.Join(root, rel), nil
+}
+
+// trim drops the parts of the loaded package which are never used once
+// its imports have been loaded, to bound the memory held for large
+// trees.
+func (p *Package) trim() {
+	p.Doc = ""
+	p.ImportPos = nil
+	p.EmbedPatternPos = nil
+	p.TestEmbedPatternPos = nil
+	p.XTestEmbedPatternPos = nil
+	p.XTestImportPos = nil
+	p.IgnoredGoFiles = nil
+	p.IgnoredOtherFiles = nil
+	p.InvalidGoFiles = nil
+	p.AllTags = nil
+	if !*includeTests {
+		p.TestImportPos = nil
+	}
+}
+
+// release drops the file lists and flags of the package once its output
+// has been saved or restored. Its fingerprint, which the fingerprints of
+// the packages importing it depend on, is computed first and kept.
+func (p *Package) release() {
+	_, _ = p.computeFingerprint()
+	if p.root {
+		// The tests of root packages are fingerprinted after the
+		// package itself.
+		return
+	}
+	p.GoFiles = nil
+	p.CgoFiles = nil
+	p.CFiles = nil
+	p.CXXFiles = nil
+	p.MFiles = nil
+	p.HFiles = nil
+	p.FFiles = nil
+	p.SFiles = nil
+	p.SwigFiles = nil
+	p.SwigCXXFiles = nil
+	p.SysoFiles = nil
+	p.CgoCFLAGS = nil
+	p.CgoCPPFLAGS = nil
+	p.CgoCXXFLAGS = nil
+	p.CgoFFLAGS = nil
+	p.CgoLDFLAGS = nil
+	p.CgoPkgConfig = nil
+	p.TestGoFiles = nil
+	p.XTestGoFiles = nil
+	p.TestImports = nil
+	p.XTestImports = nil
+	p.TestImportPos = nil
+	p.EmbedPatterns = nil
+	p.TestEmbedPatterns = nil
+	p.XTestEmbedPatterns = nil
+	p.manifest = nil
+}
+
 // usesSwig reports whether the package needs to run SWIG.
 func (p *Package) usesSwig() bool {
 	return len(p.SwigFiles) > 0 || len(p.SwigCXXFiles) > 0
@@ -410,49 +632,171 @@ func (p *Package) usesCgo() bool {
 }
 
 // Fingerprint the package returning a digest that changes if any of
-// the sources of the packages or its dependencies change.
+// the sources of the packages or its dependencies change. It exits if
+// the package cannot be fingerprinted; see computeFingerprint.
 func (p *Package) Fingerprint() string {
-	if p.fingerprint != nil {
-		return *p.fingerprint
+	fp, err := p.computeFingerprint()
+	if err != nil {
+		fatal(fmt.Errorf("%s: %w", p.ImportPath, err))
+	}
+	return fp
+}
+
+// computeFingerprint returns the fingerprint of p, "" if p is
+// uncacheable, or the failure to fingerprint it, e.g. a source file
+// which cannot be read, for the caller to tag with the import path. The
+// importers of a package which cannot be fingerprinted are uncacheable
+// rather than failing themselves, so that the failure is reported once.
+func (p *Package) computeFingerprint() (string, error) {
+	p.fingerprintMu.Lock()
+	defer p.fingerprintMu.Unlock()
+	if p.fingerprint == nil {
+		p.fingerprintErr = p.fingerprintLocked()
+	}
+	return *p.fingerprint, p.fingerprintErr
+}
+
+// fingerprintLocked computes the fingerprint of p, with fingerprintMu
+// held, setting p.fingerprint.
+func (p *Package) fingerprintLocked() error {
+	if p.ambiguous != "" {
+		p.uncacheable = "ambiguous import path: " + p.ambiguous
+		p.fingerprint = new(string)
+		return nil
+	}
+	if p.broken != "" {
+		p.uncacheable = p.broken
+		p.fingerprint = new(string)
+		return nil
+	}
+	if p.Error != nil {
+		// Its imports may be missing, e.g. with --best-effort.
+		p.uncacheable = "errors loading package"
+		p.fingerprint = new(string)
+		return nil
+	}
+	if reason := p.annotation.uncacheable(); reason != "" {
+		p.uncacheable = reason
+		p.fingerprint = new(string)
+		return nil
+	}
+	if reason := previouslyUncacheable(p); reason != "" {
+		p.uncacheable = reason
+		p.fingerprint = new(string)
+		return nil
 	}
 
 	h := sha1.New()
+	if *captureManifest {
+		p.manifest = map[string]string{}
+	}
 
 	for _, dep := range p.deps {
-		if !p.race && dep.Standard {
+		if !dep.cached() {
 			continue
 		}
-		fp := dep.Fingerprint()
+		fp, _ := dep.computeFingerprint()
 		if fp == "" {
+			p.uncacheable = "imports uncacheable " + dep.ImportPath
 			p.fingerprint = &fp
-			return *p.fingerprint
+			return nil
 		}
 		_, err := h.Write([]byte(fp))
 		if err != nil {
 			log.Fatal(err)
 		}
+		p.record("dep "+dep.ImportPath, fp)
 	}
 
-	// TODO(pmattis): I need to add the output of "go version", not the
-	// version/GOOS/GOARCH that build-cache was compiled with.
 	flags := stringList(
-		runtime.Version(),
-		runtime.GOOS,
-		runtime.GOARCH,
-		p.ImportPath,
+		toolchain(p.buildContext),
+		p.fingerprintImportPath(),
 		p.CgoCFLAGS,
 		p.CgoCPPFLAGS,
 		p.CgoCXXFLAGS,
 		p.CgoLDFLAGS,
 		p.CgoPkgConfig)
+	if in := p.moduleInputs(); in != nil {
+		flags = append(flags, in.fingerprintInputs()...)
+	}
+	extraInputs, err := p.extraInputs()
+	if err != nil {
+		p.uncacheable = err.Error()
+		p.fingerprint = new(string)
+		return err
+	}
+	flags = append(flags, extraInputs...)
+	annotationInputs, err := p.annotationInputs()
+	if err != nil {
+		p.uncacheable = err.Error()
+		p.fingerprint = new(string)
+		return nil
+	}
+	flags = append(flags, annotationInputs...)
+	flags = append(flags, p.godebugInputs()...)
+	flags = append(flags, p.envFingerprintInputs()...)
 	for _, flag := range flags {
+		flag = p.normalizePath(flag)
 		_, err := h.Write([]byte(flag))
 		if err != nil {
 			log.Fatal(err)
 		}
+		p.record("flag "+flag, "")
+	}
+
+	if vendor, digest := p.vendorDigest(); digest != "" {
+		// The digest covers the contents of the files; their names
+		// still identify those of the package.
+		fmt.Fprintf(h, "vendor %s", digest)
+		p.record("vendor "+vendor, digest)
+		for _, file := range p.sourceFiles() {
+			_, _ = h.Write([]byte(file))
+		}
+	} else {
+		for _, file := range p.sourceFiles() {
+			if err := p.hashFile(h, file); err != nil {
+				p.uncacheable = file + " " + err.Error()
+				if err == errVanished {
+					markUncacheable(p, p.uncacheable)
+				}
+				p.fingerprint = new(string)
+				if uncacheableHashErr(err) {
+					return nil
+				}
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
 	}
+	clearUncacheable(p)
+
+	s := hex.EncodeToString(h.Sum(nil))
+	p.fingerprint = &s
+	return nil
+}
 
-	files := stringList(
+// uncacheableHashErr reports whether err, returned by hashFile, makes
+// the package uncacheable rather than failing to fingerprint it: the
+// file vanished, did not settle or is a broken symlink, all of which
+// the build itself may get past.
+func uncacheableHashErr(err error) bool {
+	var broken *brokenSymlinkError
+	return err == errVanished || err == errTorn || errors.As(err, &broken)
+}
+
+// cached reports whether the output of p is saved to and restored from
+// the cache, and so whether its fingerprint is an input to those of the
+// packages importing it. The outputs of standard packages come with the
+// toolchain, which is fingerprinted instead, except for those built
+// with -race. This is the one place deciding it, so that the packages
+// saved and restored are those fingerprinted.
+func (p *Package) cached() bool {
+	return !p.Standard || p.race
+}
+
+// sourceFiles returns the names of the source files of p which are
+// fingerprinted.
+func (p *Package) sourceFiles() []string {
+	return stringList(
 		p.GoFiles,
 		p.CgoFiles,
 		p.CFiles,
@@ -463,26 +807,234 @@ func (p *Package) Fingerprint() string {
 		p.SwigFiles,
 		p.SwigCXXFiles,
 		p.SysoFiles)
-	for _, file := range files {
-		_, err := h.Write([]byte(file))
-		if err != nil {
+}
+
+// TestFingerprint returns a digest that changes if any of the sources of
+// the package, its tests or their dependencies change. Test fingerprints
+// are distinct from the fingerprint of the package itself.
+func (p *Package) TestFingerprint() string {
+	p.testFingerprintMu.Lock()
+	defer p.testFingerprintMu.Unlock()
+	if p.testFingerprint != nil {
+		return *p.testFingerprint
+	}
+
+	fp, _ := p.computeFingerprint()
+	if fp == "" {
+		p.testFingerprint = new(string)
+		return ""
+	}
+	h := sha1.New()
+	for _, s := range []string{"test", fp} {
+		if _, err := h.Write([]byte(s)); err != nil {
 			log.Fatal(err)
 		}
-		f, err := os.Open(filepath.Join(p.Dir, file))
+	}
+	for _, imp := range p.testImports {
+		if !imp.cached() {
+			continue
+		}
+		// A test import which cannot be fingerprinted reports the
+		// failure itself.
+		fp, err := imp.computeFingerprint()
 		if err != nil {
-			log.Fatal(err)
+			p.testFingerprint = new(string)
+			return ""
 		}
-		if _, err := io.Copy(h, f); err != nil {
+		if _, err := h.Write([]byte(fp)); err != nil {
 			log.Fatal(err)
 		}
-		if err := f.Close(); err != nil {
-			log.Fatal(err)
+	}
+	for _, file := range stringList(p.TestGoFiles, p.XTestGoFiles) {
+		if p.hashFile(h, file) != nil {
+			p.testFingerprint = new(string)
+			return ""
 		}
 	}
 
 	s := hex.EncodeToString(h.Sum(nil))
-	p.fingerprint = &s
-	return *p.fingerprint
+	p.testFingerprint = &s
+	return *p.testFingerprint
+}
+
+// normalizePath replaces the GOPATH entry containing the package in s
+// (e.g. in cgo flags where ${SRCDIR} has been expanded) with "$GOPATH" so
+// that the fingerprint does not depend on which GOPATH entry, or which
+// checkout, holds the package.
+func (p *Package) normalizePath(s string) string {
+	if p.Goroot {
+		return s
+	}
+	root := p.Root
+	if root == "" {
+		root = gopathEntry(p.Dir)
+	}
+	if root == "" {
+		return s
+	}
+	return strings.Replace(s, root, "$GOPATH", -1)
+}
+
+// hashFileOnce writes the name and contents of the package source file
+// to h, recording the file in the manifest. With --hash-index=git the git
+// blob ID of the file is written instead of its contents, read from the
+// index for unmodified files other than symlinks, whose blobs hold their
+// targets. It returns errVanished if the file no longer exists, a
+// brokenSymlinkError if it is a broken symlink and errTorn if it changed
+// while it was read; see hashFile. Other errors, such as a file which
+// cannot be read, fail the fingerprint.
+func (p *Package) hashFileOnce(h hash.Hash, file string) error {
+	_, err := h.Write([]byte(file))
+	if err != nil {
+		log.Fatal(err)
+	}
+	path := filepath.Join(p.Dir, file)
+	symlink, err := p.hashSymlink(h, file, path)
+	if err != nil {
+		return err
+	}
+	gitMode := useGitIndex()
+	if gitMode && !symlink {
+		if id, ok := gitBlob(path); ok {
+			p.hashBlobID(h, file, id)
+			return nil
+		}
+	}
+	fds.acquire(1)
+	defer fds.release(1)
+	var f *os.File
+	err = fds.retry(func() (err error) {
+		f, err = os.Open(path)
+		return err
+	})
+	if os.IsNotExist(err) {
+		return errVanished
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var w io.Writer = h
+	var fh hash.Hash
+	if p.manifest != nil {
+		fh = sha1.New()
+		w = io.MultiWriter(h, fh)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if maxFileSize > 0 && fi.Size() > int64(maxFileSize) {
+		if *failOnLargeFiles {
+			return fmt.Errorf("%s is larger than -max-file-size", humanSize(fi.Size()))
+		}
+		log.Printf("WARNING: %s: %s is larger than -max-file-size; fingerprinting it by size and modification time only",
+			path, humanSize(fi.Size()))
+		// The marker distinguishes the fingerprint from one over the
+		// contents of the file.
+		fmt.Fprintf(w, "size-only %d %d", fi.Size(), fi.ModTime().UnixNano())
+		if fh != nil {
+			p.record("file "+file+" (size only)", hex.EncodeToString(fh.Sum(nil)))
+		}
+		return nil
+	}
+	noteHashedFile(path, fi.Size())
+	if gitMode {
+		id, ok := cachedBlobID(path, fi)
+		if !ok {
+			cr := &countingReader{r: f}
+			id, err = gitBlobID(cr, fi.Size())
+			if err != nil {
+				return err
+			}
+			if cr.n != fi.Size() || modifiedSince(path, fi) {
+				return errTorn
+			}
+			storeBlobID(path, fi, id)
+		}
+		p.hashBlobID(h, file, id)
+		return nil
+	}
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return err
+	}
+	if n != fi.Size() || modifiedSince(path, fi) {
+		return errTorn
+	}
+	if fh != nil {
+		p.record("file "+file, hex.EncodeToString(fh.Sum(nil)))
+	}
+	return nil
+}
+
+// hashBlobID writes the git blob ID of the package source file to h in
+// place of its contents, recording it in the manifest.
+func (p *Package) hashBlobID(h hash.Hash, file, id string) {
+	fmt.Fprintf(h, "blob %s", id)
+	p.record("file "+file+" (git blob)", id)
+}
+
+// record adds an input to the package's fingerprint manifest if
+// manifest capture is enabled.
+func (p *Package) record(input, digest string) {
+	if p.manifest != nil {
+		p.manifest[input] = digest
+	}
+}
+
+// goVersion is the version of the Go release build-cache was built
+// with, whose toolchain is part of every fingerprint. The golden
+// fingerprint tests set it to a fixed version.
+var goVersion = runtime.Version()
+
+// fingerprintVersion is the version of the fingerprint algorithm. It is
+// folded into every fingerprint, so that fingerprints computed by
+// different releases of build-cache from the same inputs only match if
+// they are computed the same way. Any change to the fingerprints of the
+// same inputs, however innocuous, must increment it. Version 1 is the
+// algorithm of the releases which did not fold in a version.
+const fingerprintVersion = 4
+
+// toolchain returns the description of the toolchain and target
+// platform that is folded into every fingerprint, along with the
+// version of the fingerprint algorithm.
+//
+// TODO(pmattis): I need to add the output of "go version", not the
+// version that build-cache was compiled with.
+func toolchain(ctx *build.Context) []string {
+	t := []string{"fingerprint=" + strconv.Itoa(fingerprintVersion),
+		goVersion, ctx.GOOS, ctx.GOARCH, "goroot=" + gorootIdentity(ctx.GOROOT)}
+	// The package directory only moves the outputs, so unlike the
+	// install suffix it is not part of the fingerprint: outputs saved
+	// from a -pkgdir can be restored to the default location.
+	if suffix, _ := buildSettings(); suffix != "" {
+		t = append(t, "installsuffix="+suffix)
+	}
+	return t
+}
+
+// targetContext returns the build context for the target platform
+// specified by --goos and --goarch, with the build tags in effect. When
+// cross-compiling, cgo is disabled unless CGO_ENABLED is set, as the go
+// command does.
+func targetContext() build.Context {
+	ctx := build.Default
+	// In module mode, the go command run by Import resolves packages
+	// against the modules of the current directory.
+	ctx.Dir = cwd
+	if *targetGOOS != "" {
+		ctx.GOOS = *targetGOOS
+	}
+	if *targetGOARCH != "" {
+		ctx.GOARCH = *targetGOARCH
+	}
+	if ctx.GOOS != build.Default.GOOS || ctx.GOARCH != build.Default.GOARCH {
+		ctx.CgoEnabled = os.Getenv("CGO_ENABLED") == "1"
+	}
+	ctx.InstallSuffix, _ = buildSettings()
+	ctx.BuildTags = buildTags()
+	return ctx
 }
 
 // computeStale computes the Stale flag in the package dag that starts
@@ -516,7 +1068,7 @@ func computeStale(pkgs []*Package) {
 	}
 
 	for _, p := range packageList(pkgs) {
-		p.Stale = isStale(p, topRoot)
+		p.Stale, p.StaleReason = isStale(p, topRoot)
 	}
 }
 
@@ -524,16 +1076,16 @@ func computeStale(pkgs []*Package) {
 // "go1.X[.Y]" for Go releases, and "devel +hash" at tip.
 // Determine whether we are in a released copy by
 // inspecting the version.
-var isGoRelease = strings.HasPrefix(runtime.Version(), "go1")
+var isGoRelease = strings.HasPrefix(goVersion, "go1")
 
-// isStale reports whether package p needs to be rebuilt.
-func isStale(p *Package, topRoot map[string]bool) bool {
+// isStale reports whether package p needs to be rebuilt, and why.
+func isStale(p *Package, topRoot map[string]bool) (bool, string) {
 	if p.Standard && (p.baseImportPath == "unsafe" || p.buildContext.Compiler == "gccgo") {
 		// fake, builtin package
-		return false
+		return false, ""
 	}
 	if p.Error != nil {
-		return true
+		return true, "errors loading package"
 	}
 
 	// A package without Go sources means we only found
@@ -544,11 +1096,14 @@ func isStale(p *Package, topRoot map[string]bool) bool {
 	// created them.
 	if len(p.GoFiles) == 0 && len(p.CgoFiles) == 0 && len(p.TestGoFiles) == 0 &&
 		len(p.XTestGoFiles) == 0 && !p.usesSwig() {
-		return false
+		return false, ""
 	}
 
-	if p.Target == "" || p.Stale {
-		return true
+	if p.Target == "" {
+		return true, "no install target"
+	}
+	if p.Stale {
+		return true, p.StaleReason
 	}
 
 	// Package is stale if completely unbuilt.
@@ -557,7 +1112,7 @@ func isStale(p *Package, topRoot map[string]bool) bool {
 		built = fi.ModTime()
 	}
 	if built.IsZero() {
-		return true
+		return true, "not installed"
 	}
 
 	olderThan := func(file string) bool {
@@ -567,8 +1122,11 @@ func isStale(p *Package, topRoot map[string]bool) bool {
 
 	// Package is stale if a dependency is, or if a dependency is newer.
 	for _, p1 := range p.deps {
-		if p1.Stale || p1.Target != "" && olderThan(p1.Target) {
-			return true
+		if p1.Stale {
+			return true, "stale dependency: " + p1.ImportPath
+		}
+		if p1.Target != "" && olderThan(p1.Target) {
+			return true, "newer dependency: " + p1.ImportPath
 		}
 	}
 
@@ -583,18 +1141,18 @@ func isStale(p *Package, topRoot map[string]bool) bool {
 	// listed in $GOPATH a separate compilation world.
 	// See issue 3149.
 	if p.Root != "" && !topRoot[p.Root] {
-		return false
+		return false, ""
 	}
 
 	srcs := stringList(p.GoFiles, p.CFiles, p.CXXFiles, p.MFiles, p.HFiles,
 		p.SFiles, p.CgoFiles, p.SysoFiles, p.SwigFiles, p.SwigCXXFiles)
 	for _, src := range srcs {
 		if olderThan(filepath.Join(p.Dir, src)) {
-			return true
+			return true, "newer source file: " + src
 		}
 	}
 
-	return false
+	return false, ""
 }
 
 var cwd, _ = os.Getwd()
@@ -614,13 +1172,37 @@ func loadPackage(arg string, stk *importStack) *Package {
 	// referring to io/ioutil rather than a hypothetical import of
 	// "./ioutil".
 	if build.IsLocalImport(base) {
-		bp, _ := build.Default.ImportDir(filepath.Join(cwd, base), build.FindOnly)
-		if bp.ImportPath != "" && bp.ImportPath != "." {
-			base = bp.ImportPath
+		dir := filepath.Join(cwd, base)
+		bp, _ := build.Default.ImportDir(dir, build.FindOnly)
+		importPath := bp.ImportPath
+		if !bp.Goroot {
+			// In module mode, resolve the import path from the
+			// enclosing module, even within GOPATH, so that the
+			// package is identified as the go command does and the
+			// same way regardless of the current directory.
+			if mod := moduleImportPath(dir); mod != "" {
+				importPath = mod
+			}
+		}
+		if importPath != "" && importPath != "." {
+			base = importPath
+		} else {
+			// The package would get a pseudo-import path, like go's
+			// command-line-arguments, which cannot be cached.
+			ctx := targetContext()
+			return &Package{
+				Package:      &build.Package{ImportPath: arg, Dir: dir},
+				buildContext: &ctx,
+				Incomplete:   true,
+				Error: &PackageError{
+					ImportStack: stk.copy(),
+					Err:         fmt.Sprintf("%s is outside GOPATH and any module: its import path cannot be determined", dir),
+				},
+			}
 		}
 	}
 
-	buildContext := build.Default
+	buildContext := targetContext()
 	if contains(options, "race") {
 		if buildContext.InstallSuffix != "" {
 			buildContext.InstallSuffix += "_"
@@ -632,10 +1214,51 @@ func loadPackage(arg string, stk *importStack) *Package {
 	return loadImport(&buildContext, base, cwd, stk, nil)
 }
 
+// localArg rewrites a command-line argument naming a directory, which
+// may be absolute, use the OS path separator or end in a separator, as
+// a local import relative to the current directory ("./dir" or
+// "../dir"), the form matchPackages and loadPackage resolve. Other
+// arguments are returned unchanged.
+func localArg(arg string) string {
+	vol := filepath.VolumeName(arg) // the colon is not an option separator
+	base := vol + packageBaseImportPath(arg[len(vol):])
+	options := arg[len(base):]
+	dir := filepath.FromSlash(base)
+	if !filepath.IsAbs(dir) {
+		if !build.IsLocalImport(filepath.ToSlash(base)) {
+			return arg
+		}
+		dir = filepath.Join(cwd, dir)
+	}
+	rel, err := filepath.Rel(cwd, dir)
+	if err != nil {
+		// e.g. a directory on another volume.
+		return arg
+	}
+	rel = filepath.ToSlash(rel)
+	if rel != "." && rel != ".." && !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel + options
+}
+
 // packagesForBuild is like 'packages' but fails if any of
 // the packages or their dependencies have errors
 // (cannot be built).
 func packagesForBuild(args []string) []*Package {
+	pkgs, err := loadRoots(args)
+	if err != nil {
+		// The errors have been logged.
+		os.Exit(1)
+	}
+	return pkgs
+}
+
+// loadRoots loads the packages named by args, logging the errors of
+// those, or of their dependencies, which cannot be loaded. The packages
+// are returned even then, with an error counting them; those with
+// errors are stale.
+func loadRoots(args []string) ([]*Package, error) {
 	if len(args) == 0 {
 		args = []string{"."}
 	}
@@ -643,22 +1266,47 @@ func packagesForBuild(args []string) []*Package {
 	var stk importStack
 	var set = make(map[string]bool)
 
+	// Overlapping patterns, e.g. ./... and ./storage/..., match some
+	// packages more than once; each is loaded once, in the order of its
+	// first match.
+	var expanded []string
+	matched := map[string]bool{}
 	for _, arg := range args {
-		if !set[arg] {
-			pkgs = append(pkgs, loadPackage(arg, &stk))
-			set[arg] = true
+		for _, m := range matchPackages(localArg(arg)) {
+			if !matched[m] {
+				matched[m] = true
+				expanded = append(expanded, m)
+			}
+		}
+	}
+	for _, arg := range expanded {
+		// Arguments naming the same package in different ways, e.g. by
+		// import path and by directory, resolve to the same package.
+		pkg := loadPackage(raceArg(arg), &stk)
+		if !set[pkg.ImportPath] {
+			pkg.root = true
+			pkgs = append(pkgs, pkg)
+			set[pkg.ImportPath] = true
+		}
+	}
+
+	stale := pkgs
+	if *includeTests {
+		for _, pkg := range testExpansion(pkgs) {
+			pkg.loadTestImports(&stk)
+			stale = append(stale, pkg.testImports...)
 		}
 	}
-	computeStale(pkgs)
+	computeStale(stale)
 
-	errors := 0
+	failed := 0
 	printed := map[*PackageError]bool{}
 	for _, pkg := range pkgs {
 		if pkg.Error != nil {
-			log.Printf("can't load package: %s", pkg.Error)
-			errors++
+			logFailure("can't load package: %s", pkg.Error)
+			failed++
 		}
-		for _, dep := range pkg.deps {
+		for _, dep := range pkg.allDeps() {
 			if err := dep.Error; err != nil {
 				// Since these are errors in dependencies,
 				// the same error might show up multiple times,
@@ -666,20 +1314,36 @@ func packagesForBuild(args []string) []*Package {
 				// Only print each once.
 				if !printed[err] {
 					printed[err] = true
-					log.Printf("%s", err)
-					errors++
+					logFailure("%s", err)
+					failed++
 				}
 			}
 		}
 	}
-	if errors > 0 {
-		os.Exit(1)
+	if failed == 1 {
+		return pkgs, errors.New("1 package could not be loaded")
 	}
-	return pkgs
+	if failed > 0 {
+		return pkgs, fmt.Errorf("%d packages could not be loaded", failed)
+	}
+	return pkgs, nil
 }
 
+// loadAll loads the packages named by args and their dependencies,
+// exiting if any cannot be loaded; see loadPackages.
 func loadAll(args []string) []*Package {
-	roots := packagesForBuild(args)
+	all, err := loadPackages(args)
+	if err != nil {
+		os.Exit(1)
+	}
+	return all
+}
+
+// loadPackages loads the packages named by args and their dependencies,
+// sorted by import path. Like loadRoots, it returns them along with an
+// error if any could not be loaded.
+func loadPackages(args []string) ([]*Package, error) {
+	roots, err := loadRoots(args)
 
 	seen := map[*Package]bool{}
 	all := []*Package{}
@@ -687,7 +1351,7 @@ func loadAll(args []string) []*Package {
 		if !seen[root] {
 			seen[root] = true
 			all = append(all, root)
-			for _, dep := range root.deps {
+			for _, dep := range root.allDeps() {
 				if !seen[dep] {
 					seen[dep] = true
 					all = append(all, dep)
@@ -695,9 +1359,133 @@ func loadAll(args []string) []*Package {
 			}
 		}
 	}
+	// Add the test dependencies of non-root packages whose test imports
+	// were loaded.
+	for i := 0; i < len(all); i++ {
+		if all[i].root || len(all[i].testImports) == 0 {
+			continue
+		}
+		for _, dep := range all[i].allDeps() {
+			if !seen[dep] {
+				seen[dep] = true
+				all = append(all, dep)
+			}
+		}
+	}
 
 	sort.Sort(packageList(all))
-	return all
+	return all, err
+}
+
+// testExpansion returns the packages whose test imports are loaded with
+// -include-tests: the root packages and, in workspace mode, every loaded
+// package belonging to a module of the workspace.
+func testExpansion(roots []*Package) []*Package {
+	pkgs := roots
+	if dirs := workspaceDirs(); len(dirs) > 0 {
+		seen := map[*Package]bool{}
+		for _, root := range roots {
+			seen[root] = true
+		}
+		for _, root := range roots {
+			for _, dep := range root.deps {
+				if !seen[dep] && !dep.Standard && dep.inTree(dirs) {
+					seen[dep] = true
+					pkgs = append(pkgs, dep)
+				}
+			}
+		}
+	}
+	return pkgs
+}
+
+// loadTestImports loads the packages imported by the tests of p.
+func (p *Package) loadTestImports(stk *importStack) {
+	for _, path := range stringList(p.TestImports, p.XTestImports) {
+		if path == p.baseImportPath || path == "C" {
+			// The external tests import the package under test.
+			continue
+		}
+		p.testImports = append(p.testImports,
+			loadImport(p.buildContext, path, p.Dir, stk, p.TestImportPos[path]))
+	}
+}
+
+// allDeps returns the dependencies of p, including the dependencies of
+// its tests if they were loaded.
+func (p *Package) allDeps() []*Package {
+	if len(p.testImports) == 0 {
+		return p.deps
+	}
+	seen := map[*Package]bool{p: true}
+	var deps []*Package
+	add := func(dep *Package) {
+		if !seen[dep] {
+			seen[dep] = true
+			deps = append(deps, dep)
+		}
+	}
+	for _, dep := range p.deps {
+		add(dep)
+	}
+	for _, imp := range p.testImports {
+		add(imp)
+		for _, dep := range imp.deps {
+			add(dep)
+		}
+	}
+	sort.Sort(packageList(deps))
+	return deps
+}
+
+// repoRoot returns the root of the repository containing dir: the
+// closest enclosing directory holding version control metadata or a
+// go.mod file. If there is none, dir itself is returned.
+func repoRoot(dir string) string {
+	for d := dir; ; {
+		for _, name := range []string{".git", ".hg", ".bzr", ".svn", "go.mod"} {
+			if exists(filepath.Join(d, name)) {
+				return d
+			}
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// rootTrees returns the repository roots of the root packages in pkgs
+// along with the modules of the workspace, if any.
+func rootTrees(pkgs []*Package) []string {
+	dirs := append([]string(nil), workspaceDirs()...)
+	for _, pkg := range pkgs {
+		if pkg.root && pkg.Dir != "" {
+			if d := repoRoot(pkg.Dir); !contains(dirs, d) {
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	return dirs
+}
+
+// inTree reports whether p lives in one of the repository roots in dirs,
+// excluding vendored packages.
+func (p *Package) inTree(dirs []string) bool {
+	for _, d := range dirs {
+		rel, err := filepath.Rel(d, p.Dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+			if elem == "vendor" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
 
 // shortPath returns an absolute or relative name for path, whatever is shorter.
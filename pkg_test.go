@@ -0,0 +1,805 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCachedPackages checks that GOPATH packages whose import paths look
+// standard (no dot in the first element) or unusual are still cached
+// and fingerprinted, and that GOROOT packages are not.
+func TestCachedPackages(t *testing.T) {
+	f := newFixture(t)
+	f.writeFile("builds/internal/util/util.go", "package util\n\nconst Name = \"builds\"\n")
+	f.writeFile("gopkg.in/yaml.v2/yaml.go", "package yaml\n\nconst Version = 2\n")
+	f.writeFile("example.com/app/cfg/cfg.go", `package cfg
+
+import (
+	"fmt"
+
+	"builds/internal/util"
+	"gopkg.in/yaml.v2"
+)
+
+var Name = fmt.Sprint(util.Name, yaml.Version)
+`)
+
+	pkgs := map[string]*Package{}
+	for _, pkg := range f.load("example.com/app/cfg") {
+		pkgs[pkg.ImportPath] = pkg
+	}
+	for _, test := range []struct {
+		importPath string
+		cached     bool
+	}{
+		{"builds/internal/util", true},
+		{"gopkg.in/yaml.v2", true},
+		{"example.com/app/cfg", true},
+		{"fmt", false},
+		{"runtime", false},
+	} {
+		pkg := pkgs[test.importPath]
+		if pkg == nil {
+			t.Errorf("%s: not loaded", test.importPath)
+			continue
+		}
+		if pkg.Standard == test.cached {
+			t.Errorf("%s: Standard %v, want %v", test.importPath, pkg.Standard, !test.cached)
+		}
+		if pkg.cached() != test.cached {
+			t.Errorf("%s: cached %v, want %v", test.importPath, pkg.cached(), test.cached)
+		}
+	}
+
+	// The dependencies are inputs to the fingerprint of their importer.
+	before := f.fingerprints("example.com/app/cfg")
+	for _, path := range []string{"builds/internal/util", "gopkg.in/yaml.v2"} {
+		if _, ok := before[path]; !ok {
+			t.Errorf("%s: not fingerprinted", path)
+		}
+	}
+	for _, edit := range []struct {
+		importPath, file, data string
+	}{
+		{"builds/internal/util", "builds/internal/util/util.go", "package util\n\nconst Name = \"other\"\n"},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml.v2/yaml.go", "package yaml\n\nconst Version = 3\n"},
+	} {
+		f.writeFile(edit.file, edit.data)
+		after := f.fingerprints("example.com/app/cfg")
+		for _, path := range []string{edit.importPath, "example.com/app/cfg"} {
+			if after[path] == before[path] {
+				t.Errorf("editing %s left the fingerprint of %s unchanged", edit.file, path)
+			}
+		}
+		before = after
+	}
+}
+
+// TestLocalArgs checks that directories named on the command line,
+// relative or absolute, with or without a trailing separator, resolve
+// to the import paths of their packages, and that a directory outside
+// GOPATH and any module is rejected rather than given a pseudo-import
+// path.
+func TestLocalArgs(t *testing.T) {
+	sep := string(filepath.Separator)
+	for _, test := range []struct {
+		name  string
+		cwd   string // the import path of the current directory, example.com/app if ""
+		arg   func(f *fixture) string
+		local string // the rewritten argument
+		roots []string
+		err   string
+	}{
+		{name: "relative", arg: func(*fixture) string { return "./lib" }, local: "./lib", roots: []string{"example.com/app/lib"}},
+		{name: "relative, trailing slash", arg: func(*fixture) string { return "./lib/" }, local: "./lib", roots: []string{"example.com/app/lib"}},
+		{name: "relative, OS separators", arg: func(*fixture) string { return "." + sep + "cmd" + sep + "tool" + sep }, local: "./cmd/tool", roots: []string{"example.com/app/cmd/tool"}},
+		{name: "current directory", cwd: "example.com/app/lib", arg: func(*fixture) string { return "." }, local: ".", roots: []string{"example.com/app/lib"}},
+		{name: "parent", cwd: "example.com/app/cmd", arg: func(*fixture) string { return "../lib" }, local: "../lib", roots: []string{"example.com/app/lib"}},
+		{name: "parent, trailing slash", cwd: "example.com/app/cmd", arg: func(*fixture) string { return "../lib/" }, local: "../lib", roots: []string{"example.com/app/lib"}},
+		{name: "pattern", arg: func(*fixture) string { return "./cmd/.../" }, local: "./cmd/...", roots: []string{"example.com/app/cmd/tool"}},
+		{name: "absolute", arg: func(f *fixture) string { return f.dir("example.com/app/lib") }, local: "./lib", roots: []string{"example.com/app/lib"}},
+		{name: "absolute, trailing slash", arg: func(f *fixture) string { return f.dir("example.com/app/lib") + sep }, local: "./lib", roots: []string{"example.com/app/lib"}},
+		{name: "absolute, outside the current directory", arg: func(f *fixture) string { return f.dir("other.org/dep") }, local: "../../other.org/dep", roots: []string{"other.org/dep"}},
+		{name: "absolute, with options", arg: func(f *fixture) string { return f.dir("example.com/app/lib") + ":race" }, local: "./lib:race", roots: []string{"example.com/app/lib:race"}},
+		{name: "import path", arg: func(*fixture) string { return "example.com/app/lib" }, local: "example.com/app/lib", roots: []string{"example.com/app/lib"}},
+		{
+			name:  "outside GOPATH",
+			arg:   func(f *fixture) string { return filepath.Join(f.root, "outside") },
+			local: "../../../../outside",
+			err:   "is outside GOPATH and any module",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.writeFiles(filepath.Join(f.root, "outside"), map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+			if test.cwd != "" {
+				cwd = f.dir(test.cwd)
+			}
+			arg := test.arg(f)
+			if local := localArg(arg); local != test.local {
+				t.Errorf("localArg(%q) = %q, want %q", arg, local, test.local)
+			}
+
+			resetState()
+			pkgs, err := loadRoots([]string{arg})
+			if test.err != "" {
+				if err == nil || len(pkgs) != 1 || pkgs[0].Error == nil || !strings.Contains(pkgs[0].Error.Err, test.err) {
+					t.Fatalf("loaded %s without the error %q", arg, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var roots []string
+			for _, pkg := range pkgs {
+				roots = append(roots, pkg.ImportPath)
+			}
+			if !reflect.DeepEqual(roots, test.roots) {
+				t.Errorf("%s: loaded %v, want %v", arg, roots, test.roots)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	f := newFixture(t)
+	f.install("./...")
+	f.writeFile("example.com/app/broken/broken.go", "package broken\n\nimport _ \"example.com/missing\"\n")
+	resetState()
+	pkgs, err := loadPackages([]string{"./..."})
+	if err == nil {
+		t.Fatal("loadPackages succeeded with a missing import")
+	}
+	// The packages which loaded are returned, those with errors stale.
+	loaded := map[string]*Package{}
+	for _, pkg := range pkgs {
+		loaded[pkg.ImportPath] = pkg
+	}
+	for importPath, stale := range map[string]bool{
+		"example.com/app/lib":    false,
+		"example.com/app/broken": true,
+	} {
+		if pkg := loaded[importPath]; pkg == nil || pkg.Stale != stale {
+			t.Errorf("%s: loaded %v, want it loaded with Stale %v", importPath, pkg != nil, stale)
+		}
+	}
+}
+
+func TestFingerprintErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		before func(f *fixture) // before loading
+		after  func(f *fixture) // after loading
+		err    string           // in the error fingerprinting util, "" for none
+	}{
+		{name: "none"},
+		{
+			name: "large file",
+			before: func(f *fixture) {
+				f.setFlag("max-file-size", "10")
+				f.setFlag("fail-on-large-files", "true")
+			},
+			err: "util.go: 50B is larger than -max-file-size",
+		},
+		{
+			name:   "large file allowed",
+			before: func(f *fixture) { f.setFlag("max-file-size", "10") },
+		},
+		{
+			name: "missing extra input",
+			before: func(f *fixture) {
+				f.setFlag("extra-input", "missing.txt")
+				f.t.Cleanup(func() { extraInputs = nil })
+			},
+			err: "extra input",
+		},
+		{
+			// Uncacheable rather than failing.
+			name:   "vanished file",
+			before: func(f *fixture) { f.writeFile("example.com/app/util/gone.go", "package util\n") },
+			after: func(f *fixture) {
+				if err := os.Remove(filepath.Join(f.dir("example.com/app/util"), "gone.go")); err != nil {
+					f.t.Fatal(err)
+				}
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			if test.before != nil {
+				test.before(f)
+			}
+			pkgs := map[string]*Package{}
+			for _, pkg := range f.load("./...") {
+				pkgs[pkg.ImportPath] = pkg
+			}
+			if test.after != nil {
+				test.after(f)
+			}
+			fp, err := pkgs["example.com/app/util"].computeFingerprint()
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("util: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("util: %q, %v, want an error containing %q", fp, err, test.err)
+			}
+			if fp != "" {
+				t.Errorf("util: fingerprint %q with an error", fp)
+			}
+			if fp, _ := pkgs["example.com/app/lib"].computeFingerprint(); fp != "" {
+				t.Errorf("lib: fingerprint %q importing util", fp)
+			}
+		})
+	}
+}
+
+func TestRebaseTarget(t *testing.T) {
+	f := newFixture(t)
+	gopath := func(rel string) string { return filepath.Join(f.gopath, filepath.FromSlash(rel)) }
+	alt := filepath.Join(f.root, "alt")
+	gobin := filepath.Join(f.root, "gobin")
+	for _, test := range []struct {
+		name   string
+		pkg    build.Package // Root, BinDir and Name
+		target string
+		want   string // relative to alt, or "" for an error
+	}{
+		{
+			name:   "GOPATH package",
+			pkg:    build.Package{Name: "lib", Root: f.gopath},
+			target: gopath("pkg/linux_amd64/example.com/app/lib.a"),
+			want:   "pkg/linux_amd64/example.com/app/lib.a",
+		},
+		{
+			name:   "GOPATH package with an install suffix",
+			pkg:    build.Package{Name: "lib", Root: f.gopath},
+			target: gopath("pkg/linux_amd64_race/example.com/app/lib.a"),
+			want:   "pkg/linux_amd64_race/example.com/app/lib.a",
+		},
+		{
+			name:   "GOPATH command",
+			pkg:    build.Package{Name: "main", Root: f.gopath, BinDir: gopath("bin")},
+			target: gopath("bin/tool"),
+			want:   "bin/tool",
+		},
+		{
+			name:   "cross-compiled GOPATH command",
+			pkg:    build.Package{Name: "main", Root: f.gopath, BinDir: gopath("bin")},
+			target: gopath("bin/linux_arm64/tool"),
+			want:   "bin/linux_arm64/tool",
+		},
+		{
+			name:   "GOPATH command in GOBIN",
+			pkg:    build.Package{Name: "main", Root: f.gopath, BinDir: gobin},
+			target: filepath.Join(gobin, "tool"),
+			want:   "bin/tool",
+		},
+		{
+			name:   "module package",
+			pkg:    build.Package{Name: "lib"},
+			target: gopath("pkg/linux_amd64/example.com/mod/lib.a"),
+			want:   "pkg/linux_amd64/example.com/mod/lib.a",
+		},
+		{
+			name:   "module command",
+			pkg:    build.Package{Name: "main", BinDir: gopath("bin")},
+			target: gopath("bin/tool"),
+			want:   "bin/tool",
+		},
+		{
+			name:   "module command in GOBIN",
+			pkg:    build.Package{Name: "main", BinDir: gobin},
+			target: filepath.Join(gobin, "tool"),
+			want:   "bin/tool",
+		},
+		{
+			name:   "package outside its root",
+			pkg:    build.Package{Name: "lib", Root: f.gopath},
+			target: filepath.Join(f.root, "elsewhere", "lib.a"),
+		},
+		{
+			name:   "command outside its root and GOBIN",
+			pkg:    build.Package{Name: "main", Root: f.gopath, BinDir: gobin},
+			target: filepath.Join(f.root, "elsewhere", "tool"),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			bp := test.pkg
+			p := &Package{Package: &bp, Target: test.target}
+			got, err := p.rebaseTarget(alt)
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("rebaseTarget(%s) = %s, want an error", test.target, got)
+				}
+				return
+			}
+			if want := filepath.Join(alt, filepath.FromSlash(test.want)); err != nil || got != want {
+				t.Errorf("rebaseTarget(%s) = %s, %v, want %s", test.target, got, err, want)
+			}
+		})
+	}
+
+	// The outputs of a GOPATH tree restored under -target-root.
+	f.install("./...")
+	f.mustRun("save", "./...")
+	f.mustRun("-target-root", alt, "restore", "./...")
+	for _, pkg := range f.load("./...") {
+		if !pkg.cached() {
+			continue
+		}
+		rel, err := filepath.Rel(f.gopath, pkg.Target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists(filepath.Join(alt, rel)) {
+			t.Errorf("%s: %s not restored under %s", pkg.ImportPath, rel, alt)
+		}
+	}
+}
+
+func TestBinTarget(t *testing.T) {
+	f := newFixture(t)
+	second := filepath.Join(f.root, "gopath2")
+	build.Default.GOPATH = f.gopath + string(filepath.ListSeparator) + second
+	for _, test := range []struct {
+		name         string
+		moduleMode   bool
+		gobin        string // relative to the fixture root
+		goos, goarch string // if not those of the host
+		pkg          build.Package
+		want         string // relative to the fixture root, "" for none
+	}{
+		{
+			name: "GOPATH",
+			pkg:  build.Package{Name: "main", Dir: f.dir("example.com/app/cmd/tool"), Root: f.gopath, BinDir: filepath.Join(f.gopath, "bin")},
+			want: "gopath/bin/tool",
+		},
+		{
+			name: "second GOPATH entry",
+			pkg:  build.Package{Name: "main", Dir: filepath.Join(second, "src", "other.org", "tool"), Root: second, BinDir: filepath.Join(second, "bin")},
+			want: "gopath2/bin/tool",
+		},
+		{
+			name:  "GOPATH with GOBIN",
+			gobin: "gobin",
+			pkg:   build.Package{Name: "main", Dir: f.dir("example.com/app/cmd/tool"), Root: f.gopath, BinDir: filepath.Join(f.gopath, "bin")},
+			want:  "gobin/tool",
+		},
+		{
+			name:   "cross-compiled",
+			goos:   "plan9",
+			goarch: "arm",
+			pkg:    build.Package{Name: "main", Dir: f.dir("example.com/app/cmd/tool"), Root: f.gopath, BinDir: filepath.Join(f.gopath, "bin")},
+			want:   "gopath/bin/plan9_arm/tool",
+		},
+		{
+			name:   "cross-compiled with GOBIN",
+			gobin:  "gobin",
+			goos:   "plan9",
+			goarch: "arm",
+			pkg:    build.Package{Name: "main", Dir: f.dir("example.com/app/cmd/tool"), Root: f.gopath, BinDir: filepath.Join(f.gopath, "bin")},
+		},
+		{
+			name:   "Windows",
+			goos:   "windows",
+			goarch: "386",
+			pkg:    build.Package{Name: "main", Dir: f.dir("example.com/app/cmd/tool"), Root: f.gopath, BinDir: filepath.Join(f.gopath, "bin")},
+			want:   "gopath/bin/windows_386/tool.exe",
+		},
+		{
+			name: "library",
+			pkg:  build.Package{Name: "lib", Dir: f.dir("example.com/app/lib"), Root: f.gopath},
+		},
+		{
+			// go/build gives the package the bin directory of its
+			// module, but the go command installs it in the first
+			// GOPATH entry, named after its import path.
+			name:       "module",
+			moduleMode: true,
+			pkg:        build.Package{Name: "main", ImportPath: "example.com/mod/cmd/tool", Dir: filepath.Join(f.root, "checkout", "cmd", "main"), Root: filepath.Join(f.root, "checkout"), BinDir: filepath.Join(f.root, "checkout", "bin")},
+			want:       "gopath/bin/tool",
+		},
+		{
+			name:       "module with a major version suffix",
+			moduleMode: true,
+			pkg:        build.Package{Name: "main", ImportPath: "example.com/tool/v2", Dir: filepath.Join(f.root, "checkout"), Root: filepath.Join(f.root, "checkout"), BinDir: filepath.Join(f.root, "checkout", "bin")},
+			want:       "gopath/bin/tool",
+		},
+		{
+			name:       "module with GOBIN",
+			moduleMode: true,
+			gobin:      "gobin",
+			pkg:        build.Package{Name: "main", ImportPath: "example.com/mod/cmd/tool", Dir: filepath.Join(f.root, "checkout", "cmd", "tool"), Root: filepath.Join(f.root, "checkout")},
+			want:       "gobin/tool",
+		},
+		{
+			name:       "cross-compiled module",
+			moduleMode: true,
+			goos:       "plan9",
+			goarch:     "arm",
+			pkg:        build.Package{Name: "main", ImportPath: "example.com/mod/cmd/tool", Dir: filepath.Join(f.root, "checkout", "cmd", "tool"), Root: filepath.Join(f.root, "checkout")},
+			want:       "gopath/bin/plan9_arm/tool",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.goos == runtime.GOOS && test.goarch == runtime.GOARCH {
+				t.Skip("not cross-compiling on", runtime.GOOS, runtime.GOARCH)
+			}
+			if test.moduleMode {
+				t.Setenv("GO111MODULE", "on")
+			}
+			gobin = ""
+			if test.gobin != "" {
+				gobin = filepath.Join(f.root, test.gobin)
+			}
+			ctx := build.Default
+			ctx.GOOS, ctx.GOARCH = runtime.GOOS, runtime.GOARCH
+			if test.goos != "" {
+				ctx.GOOS, ctx.GOARCH = test.goos, test.goarch
+			}
+			bp := test.pkg
+			bp.BinDir = binDir(&bp)
+			want := ""
+			if test.want != "" {
+				want = filepath.Join(f.root, filepath.FromSlash(test.want))
+				if ctx.GOOS == "windows" && test.goos == "" {
+					want += ".exe"
+				}
+			}
+			if got := binTarget(&ctx, &bp); got != want {
+				t.Errorf("binTarget = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestLoadModes checks that the packages named in the ways each mode of
+// the go command allows are loaded from the expected roots, the same
+// way whichever way they are named.
+func TestLoadModes(t *testing.T) {
+	// writeModule writes the module example.com/mod, with a library and
+	// a command, in dir.
+	writeModule := func(f *fixture, dir string) {
+		f.writeFiles(dir, map[string]string{
+			"go.mod":           "module example.com/mod\n\ngo 1.16\n",
+			"lib/lib.go":       "package lib\n\nimport \"fmt\"\n\nvar X = fmt.Sprint(1)\n",
+			"cmd/tool/main.go": "package main\n\nimport \"example.com/mod/lib\"\n\nfunc main() { println(lib.X) }\n",
+		})
+		cwd = dir
+	}
+	app := map[string]string{
+		"example.com/app/cmd/tool": "gopath",
+		"example.com/app/lib":      "gopath",
+		"example.com/app/util":     "gopath",
+		"other.org/dep":            "gopath",
+	}
+	mod := map[string]string{
+		"example.com/mod/cmd/tool": "mod",
+		"example.com/mod/lib":      "mod",
+	}
+
+	for _, test := range []struct {
+		name      string
+		setup     func(f *fixture)
+		args      [][]string        // each naming the same packages; $ROOT is the fixture root
+		roots     map[string]string // of the cached packages, relative to the fixture root
+		unchanged bool              // the fingerprints are those of GOPATH mode
+	}{
+		{
+			name:      "GOPATH mode",
+			setup:     func(f *fixture) {},
+			args:      [][]string{{"./..."}, {"example.com/app/..."}, {"./cmd/tool", "./util"}},
+			roots:     app,
+			unchanged: true,
+		},
+		{
+			name: "module mode",
+			setup: func(f *fixture) {
+				f.moduleMode()
+				writeModule(f, filepath.Join(f.root, "mod"))
+			},
+			args:  [][]string{{"./..."}, {"example.com/mod/..."}, {"./cmd/tool"}},
+			roots: mod,
+		},
+		{
+			name: "module mode within GOPATH",
+			setup: func(f *fixture) {
+				f.moduleMode()
+				writeModule(f, f.dir("mods/mod"))
+			},
+			args: [][]string{{"./..."}, {"example.com/mod/..."}, {"./cmd/tool"}},
+			roots: map[string]string{
+				"example.com/mod/cmd/tool": "gopath/src/mods/mod",
+				"example.com/mod/lib":      "gopath/src/mods/mod",
+			},
+		},
+		{
+			name: "module mode with a filesystem path",
+			setup: func(f *fixture) {
+				f.moduleMode()
+				writeModule(f, filepath.Join(f.root, "mod"))
+				cwd = filepath.Join(cwd, "cmd")
+			},
+			args:  [][]string{{"$ROOT/mod/cmd/tool"}, {"./tool"}, {"../cmd/tool"}, {"example.com/mod/cmd/tool"}},
+			roots: mod,
+		},
+		{
+			name: "multiple GOPATH entries",
+			setup: func(f *fixture) {
+				second := filepath.Join(f.root, "gopath2")
+				if err := os.MkdirAll(filepath.Join(second, "src"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Rename(f.dir("other.org"), filepath.Join(second, "src", "other.org")); err != nil {
+					t.Fatal(err)
+				}
+				build.Default.GOPATH = f.gopath + string(filepath.ListSeparator) + second
+				t.Setenv("GOPATH", build.Default.GOPATH)
+			},
+			args: [][]string{{"./..."}, {"example.com/app/...", "other.org/..."}, {"./cmd/tool", "./util"}},
+			roots: map[string]string{
+				"example.com/app/cmd/tool": "gopath",
+				"example.com/app/lib":      "gopath",
+				"example.com/app/util":     "gopath",
+				"other.org/dep":            "gopath2",
+			},
+			unchanged: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			gopathFingerprints := f.fingerprints("./...")
+			test.setup(f)
+
+			var first map[string]string
+			for _, args := range test.args {
+				for i := range args {
+					args[i] = filepath.FromSlash(strings.Replace(args[i], "$ROOT", f.root, 1))
+				}
+				resetState()
+				pkgs, err := loadPackages(args)
+				if err != nil {
+					t.Fatalf("loading %v: %v", args, err)
+				}
+				fps := map[string]string{}
+				for _, pkg := range pkgs {
+					if !pkg.cached() {
+						continue
+					}
+					fps[pkg.ImportPath] = pkg.Fingerprint()
+					want, ok := test.roots[pkg.ImportPath]
+					if !ok {
+						t.Errorf("loading %v: unexpected package %s", args, pkg.ImportPath)
+						continue
+					}
+					if root := filepath.Join(f.root, filepath.FromSlash(want)); pkg.Root != root {
+						t.Errorf("loading %v: %s: Root %s, want %s", args, pkg.ImportPath, pkg.Root, root)
+					}
+					// Commands are installed in the first GOPATH entry.
+					targetRoot := pkg.Root
+					if pkg.Name == "main" {
+						targetRoot = f.gopath
+					}
+					if !strings.HasPrefix(pkg.Target, targetRoot+string(filepath.Separator)) {
+						t.Errorf("loading %v: %s: Target %s not under %s", args, pkg.ImportPath, pkg.Target, targetRoot)
+					}
+				}
+				if first == nil {
+					first = fps
+					if test.unchanged {
+						for importPath, fp := range gopathFingerprints {
+							if fps[importPath] != fp {
+								t.Errorf("loading %v: %s: fingerprint changed from GOPATH mode", args, importPath)
+							}
+						}
+					}
+					continue
+				}
+				if !reflect.DeepEqual(fps, first) {
+					t.Errorf("loading %v: fingerprints %v, want those of loading %v, %v", args, fps, test.args[0], first)
+				}
+			}
+		})
+	}
+}
+
+// TestOverlappingPatterns checks that arguments matching packages more
+// than once load, save and restore the packages as the minimal set of
+// arguments does.
+func TestOverlappingPatterns(t *testing.T) {
+	for _, test := range []struct {
+		args, minimal []string
+	}{
+		{[]string{"./...", "./lib/...", "./lib", "example.com/app/util"}, []string{"./..."}},
+		{[]string{"example.com/app/...", "./..."}, []string{"./..."}},
+		{[]string{"./lib", "./lib", "example.com/app/lib"}, []string{"./lib"}},
+		{[]string{"./cmd/...", "./cmd/tool", "./lib"}, []string{"./cmd/tool", "./lib"}},
+		{[]string{"./util", "./...", "./util:race"}, []string{"./...", "./util:race"}},
+	} {
+		f := newFixture(t)
+		f.install("./...")
+
+		// roots returns the import paths of the roots loaded for args,
+		// sorted, and of all the packages loaded.
+		roots := func(args []string) (roots, all []string) {
+			resetState()
+			pkgs, err := loadPackages(args)
+			if err != nil {
+				t.Fatalf("loading %v: %v", args, err)
+			}
+			for _, pkg := range pkgs {
+				if pkg.root {
+					roots = append(roots, pkg.ImportPath)
+				}
+				all = append(all, pkg.ImportPath)
+			}
+			sort.Strings(roots)
+			return roots, all
+		}
+		gotRoots, got := roots(test.args)
+		wantRoots, want := roots(test.minimal)
+		if !reflect.DeepEqual(gotRoots, wantRoots) || !reflect.DeepEqual(got, want) {
+			t.Errorf("loading %v: roots %v, packages %v; want %v, %v as for %v",
+				test.args, gotRoots, got, wantRoots, want, test.minimal)
+		}
+
+		// run runs the command for args with a cache of its own,
+		// returning its output without the arguments and timings.
+		run := func(cache, command string, args []string) string {
+			t.Setenv("CACHE", filepath.Join(f.root, cache))
+			out := f.mustRun(append([]string{command}, args...)...)
+			out = strings.Replace(out, fmt.Sprint(args), "ARGS", 1)
+			out = regexp.MustCompile(`(?m)^finished loading: .*\n`).ReplaceAllString(out, "")
+			return strings.Replace(out, cache, "CACHE", -1)
+		}
+		for _, command := range []string{"save", "restore"} {
+			if got, want := run("cache1", command, test.args), run("cache2", command, test.minimal); got != want {
+				t.Errorf("%s %v printed:\n%s\nwant, as for %v:\n%s", command, test.args, got, test.minimal, want)
+			}
+		}
+	}
+}
+
+// maxPeakHeap is the most heap save and restore may use for the tree of
+// benchmarkPackages packages of BenchmarkPeakHeap.
+const maxPeakHeap = 200 << 20
+
+// benchmarkPackages is the number of packages of BenchmarkPeakHeap.
+const benchmarkPackages = 2000
+
+// BenchmarkPeakHeap reports the peak heap of saving and restoring a tree
+// of benchmarkPackages packages, each with a few files and imports,
+// sampled with runtime.ReadMemStats, and fails if it exceeds
+// maxPeakHeap.
+func BenchmarkPeakHeap(b *testing.B) {
+	f := newFixture(b)
+	files := map[string]string{}
+	for i := 0; i < benchmarkPackages; i++ {
+		var imports []string
+		for _, j := range []int{i - 1, i / 2, i / 3} {
+			if j >= 0 && j < i && !contains(imports, fmt.Sprintf("other.org/big/p%04d", j)) {
+				imports = append(imports, fmt.Sprintf("other.org/big/p%04d", j))
+			}
+		}
+		for k := 0; k < 6; k++ {
+			var src strings.Builder
+			fmt.Fprintf(&src, "package p%04d\n\n", i)
+			if k == 0 {
+				for _, imp := range imports {
+					fmt.Fprintf(&src, "import _ %q\n", imp)
+				}
+			}
+			for l := 0; l < 20; l++ {
+				fmt.Fprintf(&src, "\nfunc F%d_%d() int { return %d }\n", k, l, l)
+			}
+			files[fmt.Sprintf("p%04d/f%d.go", i, k)] = src.String()
+		}
+	}
+	f.writeFiles(filepath.Join(f.gopath, "src", "other.org", "big"), files)
+	f.install("other.org/big/...")
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, test := range []struct {
+		name  string
+		reset func()
+		run   func([]string) (*summary, error)
+	}{
+		{
+			name:  "save",
+			reset: func() { _ = os.RemoveAll(f.cache) },
+			run:   save,
+		},
+		{
+			name: "restore",
+			reset: func() {
+				for _, pkg := range f.load("other.org/big/...") {
+					if pkg.cached() && pkg.Target != "" {
+						_ = os.Remove(pkg.Target)
+					}
+				}
+			},
+			run: restore,
+		},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			if test.name == "restore" {
+				resetState()
+				if _, err := save([]string{"other.org/big/..."}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				test.reset()
+				resetState()
+				runtime.GC()
+				b.StartTimer()
+
+				stop := make(chan bool)
+				var wg sync.WaitGroup
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var ms runtime.MemStats
+					for {
+						runtime.ReadMemStats(&ms)
+						if ms.HeapAlloc > peak {
+							peak = ms.HeapAlloc
+						}
+						select {
+						case <-stop:
+							return
+						case <-time.After(10 * time.Millisecond):
+						}
+					}
+				}()
+				s, err := test.run([]string{"other.org/big/..."})
+				close(stop)
+				wg.Wait()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if s.Packages != benchmarkPackages {
+					b.Fatalf("%s %d packages, want %d", test.name, s.Packages, benchmarkPackages)
+				}
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+			if peak > maxPeakHeap {
+				b.Errorf("peak heap %d MB, want at most %d MB", peak>>20, maxPeakHeap>>20)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An indexedEntry is an entry of a cache dir in its package index.
+type indexedEntry struct {
+	Name    string    `json:"name"` // the file name in the cache dir
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+}
+
+// A packageIndex indexes the package entries of a cache dir, those
+// outside its subdirectories, by import path, so that the newest entries
+// for a package are found without reading the metadata of every entry.
+// save adds the entries it stores; the entries removed since are
+// dropped when looking them up, and "verify -rebuild-index" rebuilds
+// the index from the entries.
+type packageIndex struct {
+	Packages  map[string][]*indexedEntry `json:"packages"`            // by import path without options, newest first
+	Anonymous bool                       `json:"anonymous,omitempty"` // some entries have no metadata
+}
+
+// packageIndexPath returns the path of the package index of the cache
+// dir.
+func packageIndexPath(dir string) string {
+	return filepath.Join(dir, "packages.json")
+}
+
+// scanPackageIndex builds the package index of dir from the metadata of
+// its entries.
+func scanPackageIndex(dir string) (*packageIndex, error) {
+	x := &packageIndex{Packages: map[string][]*indexedEntry{}}
+	entries, err := listEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if filepath.Dir(e.Path) != dir {
+			continue
+		}
+		if e.Meta == nil {
+			x.Anonymous = true
+			continue
+		}
+		x.add(e.Meta.ImportPath, &indexedEntry{Name: e.Name, Created: e.Meta.Created, Size: e.Size})
+	}
+	return x, nil
+}
+
+// add adds the entry of the package with the given import path.
+func (x *packageIndex) add(importPath string, e *indexedEntry) {
+	key := packageBaseImportPath(importPath)
+	list := x.Packages[key]
+	for i, old := range list {
+		if old.Name == e.Name {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	list = append(list, e)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	x.Packages[key] = list
+}
+
+// readPackageIndex reads the package index of dir, scanning the entries
+// if it is missing.
+func readPackageIndex(dir string) (*packageIndex, error) {
+	x := &packageIndex{}
+	if readAux(packageIndexPath(dir), "packages", x) && x.Packages != nil {
+		return x, nil
+	}
+	return scanPackageIndex(dir)
+}
+
+// indexEntries adds the entries stored by a save, by import path, to the
+// package index of dir.
+func indexEntries(dir string, added map[string][]*indexedEntry) error {
+	if len(added) == 0 {
+		return nil
+	}
+	return withCacheLock(dir, func() error {
+		x, err := readPackageIndex(dir)
+		if err != nil {
+			return err
+		}
+		for importPath, list := range added {
+			for _, e := range list {
+				x.add(importPath, e)
+			}
+		}
+		return writeAux(packageIndexPath(dir), "packages", x)
+	})
+}
+
+// rebuildPackageIndex replaces the package index of dir with one built
+// from its entries.
+func rebuildPackageIndex(dir string) error {
+	return withCacheLock(dir, func() error {
+		x, err := scanPackageIndex(dir)
+		if err != nil {
+			return err
+		}
+		return writeAux(packageIndexPath(dir), "packages", x)
+	})
+}
+
+var packageIndexes struct {
+	sync.Mutex
+	byDir map[string]*packageIndex
+}
+
+// packageEntries returns the paths of the entries of dir holding the
+// outputs of the package with the given import path, whatever its
+// options, newest first, and whether dir has entries without metadata
+// which may be the package's too. The package index is read once per
+// dir.
+func packageEntries(dir, importPath string) ([]string, bool) {
+	packageIndexes.Lock()
+	x, ok := packageIndexes.byDir[dir]
+	if !ok {
+		x, _ = readPackageIndex(dir)
+		if packageIndexes.byDir == nil {
+			packageIndexes.byDir = map[string]*packageIndex{}
+		}
+		packageIndexes.byDir[dir] = x
+	}
+	packageIndexes.Unlock()
+	if x == nil {
+		return nil, false
+	}
+	var paths, removed []string
+	for _, e := range x.Packages[packageBaseImportPath(importPath)] {
+		// Skip the entries removed since they were indexed.
+		path := filepath.Join(dir, e.Name)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		} else if os.IsNotExist(err) {
+			removed = append(removed, e.Name)
+		}
+	}
+	if len(removed) > 0 {
+		if err := unindexEntries(dir, importPath, removed); err != nil {
+			log.Printf("warning: unable to update the package index: %s", err)
+		}
+	}
+	return paths, x.Anonymous
+}
+
+// unindexEntries drops the named entries of the package with the given
+// import path, which no longer exist, from the package index of dir.
+// Entries removed by prune and clean are dropped this way when first
+// looked up.
+func unindexEntries(dir, importPath string, names []string) error {
+	if !exists(packageIndexPath(dir)) {
+		return nil
+	}
+	vlogf("dropping %d removed entries of %s from %s", len(names), importPath, packageIndexPath(dir))
+	return withCacheLock(dir, func() error {
+		x := &packageIndex{}
+		if !readAux(packageIndexPath(dir), "packages", x) || x.Packages == nil {
+			return nil
+		}
+		key := packageBaseImportPath(importPath)
+		list := x.Packages[key][:0]
+		for _, e := range x.Packages[key] {
+			if !contains(names, e.Name) || exists(filepath.Join(dir, e.Name)) {
+				list = append(list, e)
+			}
+		}
+		if len(list) == 0 {
+			delete(x.Packages, key)
+		} else {
+			x.Packages[key] = list
+		}
+		return writeAux(packageIndexPath(dir), "packages", x)
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var minArtifactSize byteSize
+
+func init() {
+	flag.Var(&minArtifactSize, "min-artifact-size",
+		"when saving, skip the outputs smaller than this, which are quicker to rebuild than to restore")
+}
+
+// policySkipMaxAge is how long the fingerprints of the outputs skipped by
+// policy are remembered.
+const policySkipMaxAge = 30 * 24 * time.Hour
+
+// A policySkip records the fingerprint of an output save skipped by
+// policy, so that restore reports it as such rather than as a miss.
+type policySkip struct {
+	ImportPath string    `json:"importPath"`
+	Size       int64     `json:"size"`
+	Recorded   time.Time `json:"recorded"`
+}
+
+// policySkipsPath returns the path of the index of the fingerprints
+// skipped by policy in the cache dir.
+func policySkipsPath(dir string) string {
+	return filepath.Join(dir, "policy-skips.json")
+}
+
+func readPolicySkips(dir string) map[string]*policySkip {
+	var skips map[string]*policySkip
+	if !readAux(policySkipsPath(dir), "policy-skips", &skips) || skips == nil {
+		skips = map[string]*policySkip{}
+	}
+	return skips
+}
+
+// recordPolicySkips adds the fingerprints skipped by a save to the index
+// of the cache dir, dropping those older than policySkipMaxAge.
+func recordPolicySkips(dir string, added map[string]*policySkip) error {
+	if len(added) == 0 {
+		return nil
+	}
+	return withCacheLock(dir, func() error {
+		skips := readPolicySkips(dir)
+		for fp, skip := range skips {
+			if time.Since(skip.Recorded) > policySkipMaxAge {
+				delete(skips, fp)
+			}
+		}
+		for fp, skip := range added {
+			skips[fp] = skip
+		}
+		return writeAux(policySkipsPath(dir), "policy-skips", skips)
+	})
+}
+
+var policySkips struct {
+	sync.Mutex
+	byDir map[string]map[string]*policySkip
+}
+
+// skippedByPolicy reports whether save skipped the output with
+// fingerprint fp by policy, according to the index of the cache dir.
+func skippedByPolicy(dir, fp string) bool {
+	policySkips.Lock()
+	defer policySkips.Unlock()
+	if policySkips.byDir == nil {
+		policySkips.byDir = map[string]map[string]*policySkip{}
+	}
+	skips, ok := policySkips.byDir[dir]
+	if !ok {
+		skips = readPolicySkips(dir)
+		policySkips.byDir[dir] = skips
+	}
+	return skips[fp] != nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// preflightResult describes the pre-flight checks of a restore.
+type preflightResult struct {
+	Dirs    int   `json:"dirs"`              // target directories checked
+	Bytes   int64 `json:"bytes"`             // bytes to copy, excluding hardlinks
+	Created int   `json:"created,omitempty"` // directories created by the bootstrap
+}
+
+// preflight checks, before restore touches any Target, that the Targets
+// of the packages found in the cache dir can be written: their
+// directories are created and checked for writability, and filesystems
+// other than the cache's are checked for the free space needed to copy
+// the entries. It returns an error if a check fails.
+func preflight(pkgs []*Package, dir string) (*preflightResult, error) {
+	var mu sync.Mutex
+	bytes := map[string]int64{} // by Target directory
+	forEachPackage(pkgs, nil, func(pkg *Package, r *pkgReport) {
+		if !pkg.cached() || pkg.Target == "" {
+			return
+		}
+		fp, err := pkg.computeFingerprint()
+		if err != nil {
+			return
+		}
+		src, _ := lookupNamespaced(dir, fp, nil)
+		if src == "" {
+			return
+		}
+		size := fileSize(src)
+		mu.Lock()
+		bytes[filepath.Dir(pkg.Target)] += size
+		mu.Unlock()
+	})
+
+	var dirs []string
+	for d := range bytes {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+
+	cacheFS, _, _ := diskSpace(dir)
+	canLink := *linkMode == "auto" || *linkMode == "hardlink"
+	result := &preflightResult{Dirs: len(dirs)}
+	needed := map[uint64]int64{} // by filesystem
+	free := map[uint64]uint64{}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, fmt.Errorf("pre-flight: %w", err)
+		}
+		f, err := os.CreateTemp(d, ".build-cache-preflight-")
+		if err != nil {
+			return nil, fmt.Errorf("pre-flight: %s is not writable: %w", d, err)
+		}
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+
+		fs, avail, err := diskSpace(d)
+		if err != nil {
+			continue
+		}
+		if canLink && fs == cacheFS {
+			// Hardlinked from the cache without using space.
+			continue
+		}
+		needed[fs] += bytes[d]
+		free[fs] = avail
+		result.Bytes += bytes[d]
+	}
+	for fs, n := range needed {
+		if uint64(n) > free[fs] {
+			return nil, fmt.Errorf("pre-flight: restoring needs %s but only %s is free", humanSize(n), humanSize(int64(free[fs])))
+		}
+	}
+	vlogf("pre-flight: %d target directories writable, %s to copy", result.Dirs, humanSize(result.Bytes))
+	return result, nil
+}
+
+// errDiskSpaceUnsupported is returned by diskSpace on platforms where
+// it is not implemented.
+var errDiskSpaceUnsupported = errors.New("free disk space is not available on this platform")
@@ -0,0 +1,51 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	niceLow         = 10
+	ioprioWhoThread = 1         // IOPRIO_WHO_PROCESS, which names a thread
+	ioprioLow       = 2<<13 | 7 // IOPRIO_CLASS_BE at the lowest level
+)
+
+// lowerPriority lowers the CPU and I/O priority of the process. On Linux
+// both are per thread, so they are applied to each of the threads of
+// the process; threads started later inherit them.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, niceLow); err != nil {
+			return err
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoThread, uintptr(tid), ioprioLow); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
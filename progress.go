@@ -0,0 +1,172 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var noProgress = flag.Bool("no-progress", false,
+	"log progress periodically instead of displaying a progress line")
+
+const (
+	progressInterval    = 10 * time.Second // between plain progress lines
+	progressPercent     = 10               // between plain progress lines
+	progressTTYInterval = 200 * time.Millisecond
+)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// A progress reports the progress of a save or restore. The counters
+// are updated atomically by the workers while a separate goroutine
+// displays them. When stderr is a terminal the display is a single line
+// updated in place (other log output is written above it); otherwise a
+// plain log line is written every 10s or 10% of progress.
+type progress struct {
+	verb  string
+	total int64
+	done  int64 // accessed atomically
+	bytes int64 // accessed atomically
+	start time.Time
+	tty   bool
+
+	mu      sync.Mutex // protects line and the terminal
+	line    string     // the progress line currently displayed
+	stopper chan struct{}
+	stopped sync.WaitGroup
+}
+
+func startProgress(verb string, total int) *progress {
+	p := &progress{
+		verb:    verb,
+		total:   int64(total),
+		start:   time.Now(),
+		tty:     !*noProgress && isTerminal(os.Stderr),
+		stopper: make(chan struct{}),
+	}
+	if p.tty {
+		log.SetOutput(p)
+	}
+	p.stopped.Add(1)
+	go p.run()
+	return p
+}
+
+// inc notes that another package is done.
+func (p *progress) inc() {
+	atomic.AddInt64(&p.done, 1)
+}
+
+// addBytes notes that n more bytes were transferred.
+func (p *progress) addBytes(n int64) {
+	atomic.AddInt64(&p.bytes, n)
+}
+
+// stop stops the display.
+func (p *progress) stop() {
+	close(p.stopper)
+	p.stopped.Wait()
+	if p.tty {
+		p.mu.Lock()
+		p.clear()
+		p.mu.Unlock()
+		log.SetOutput(os.Stderr)
+	}
+}
+
+func (p *progress) run() {
+	defer p.stopped.Done()
+
+	interval := time.Second
+	if p.tty {
+		interval = progressTTYInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastTime := p.start
+	var lastDone int64
+	for {
+		select {
+		case <-p.stopper:
+			return
+		case now := <-ticker.C:
+			if p.tty {
+				p.mu.Lock()
+				p.clear()
+				p.line = p.String()
+				fmt.Fprint(os.Stderr, p.line)
+				p.mu.Unlock()
+				continue
+			}
+			done := atomic.LoadInt64(&p.done)
+			if now.Sub(lastTime) >= progressInterval ||
+				(p.total > 0 && (done-lastDone)*100/p.total >= progressPercent) {
+				log.Print(p.String())
+				lastTime, lastDone = now, done
+			}
+		}
+	}
+}
+
+// clear erases the progress line. p.mu must be held.
+func (p *progress) clear() {
+	if p.line != "" {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		p.line = ""
+	}
+}
+
+// Write implements io.Writer, allowing the progress to be used as the
+// log output so that log lines are written above the progress line.
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	line := p.line
+	p.clear()
+	n, err := os.Stderr.Write(b)
+	if line != "" {
+		p.line = line
+		fmt.Fprint(os.Stderr, p.line)
+	}
+	return n, err
+}
+
+func (p *progress) String() string {
+	done := atomic.LoadInt64(&p.done)
+	bytes := atomic.LoadInt64(&p.bytes)
+	elapsed := time.Since(p.start)
+
+	s := fmt.Sprintf("%s %d/%d packages, %s", p.verb, done, p.total, humanSize(bytes))
+	if secs := elapsed.Seconds(); secs > 0 {
+		s += fmt.Sprintf(" (%s/s)", humanSize(int64(float64(bytes)/secs)))
+	}
+	if done > 0 && done < p.total {
+		eta := time.Duration(float64(elapsed) * float64(p.total-done) / float64(done))
+		s += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return s
+}
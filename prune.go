@@ -0,0 +1,174 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+var (
+	olderThan      = flag.Duration("older-than", 0, "prune entries created longer ago than this")
+	maxSize        byteSize
+	keepPerPackage = flag.Int("keep-per-package", 0,
+		"prune all but the newest N entries for each package")
+)
+
+func init() {
+	flag.Var(&maxSize, "max-size", "prune the oldest entries until the cache is smaller than this")
+}
+
+// groupKey returns the key by which --keep-per-package groups entries,
+// or "" if the entry has no metadata.
+func groupKey(e *cacheEntry) string {
+	m := e.Meta
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %s/%s %s test=%t", m.ImportPath, m.GOOS, m.GOARCH, metaInstrumentation(m), m.Test)
+}
+
+// prunePlan returns the entries to remove, mapped to the reason for
+// removing them. The policies are applied in order: --keep-per-package,
+// --older-than and then --max-size. Entries without metadata are only
+// subject to the age and size policies. The pinned entries are never
+// removed, but count towards --keep-per-package and --max-size.
+func prunePlan(entries []*cacheEntry, pinned map[*cacheEntry]*pin, now time.Time) map[*cacheEntry]string {
+	plan := map[*cacheEntry]string{}
+
+	if *keepPerPackage > 0 {
+		groups := map[string][]*cacheEntry{}
+		for _, e := range entries {
+			if key := groupKey(e); key != "" {
+				groups[key] = append(groups[key], e)
+			}
+		}
+		for _, group := range groups {
+			sort.Slice(group, func(i, j int) bool {
+				return group[i].Created().After(group[j].Created())
+			})
+			for i := *keepPerPackage; i < len(group); i++ {
+				if pinned[group[i]] != nil {
+					continue
+				}
+				plan[group[i]] = fmt.Sprintf("more than %d entries for package", *keepPerPackage)
+			}
+		}
+	}
+
+	if *olderThan > 0 {
+		for _, e := range entries {
+			if _, ok := plan[e]; !ok && pinned[e] == nil && now.Sub(e.Created()) > *olderThan {
+				plan[e] = fmt.Sprintf("older than %s", *olderThan)
+			}
+		}
+	}
+
+	if maxSize > 0 {
+		var remaining []*cacheEntry
+		var total int64
+		for _, e := range entries {
+			if _, ok := plan[e]; !ok {
+				remaining = append(remaining, e)
+				total += e.Size
+			}
+		}
+		sort.Slice(remaining, func(i, j int) bool {
+			return remaining[i].Created().Before(remaining[j].Created())
+		})
+		for _, e := range remaining {
+			if total <= int64(maxSize) {
+				break
+			}
+			if pinned[e] != nil {
+				continue
+			}
+			plan[e] = fmt.Sprintf("cache larger than %s", humanSize(int64(maxSize)))
+			total -= e.Size
+		}
+	}
+	return plan
+}
+
+// prune removes entries from the cache according to the retention
+// policies. With --dry-run the plan is printed without removing
+// anything.
+func prune(args []string) {
+	dir := cacheDir()
+	if !exists(dir) {
+		log.Printf("%s does not exist", dir)
+		return
+	}
+	log.Printf("pruning %s", dir)
+	defer useCache(dir, true)()
+
+	entries, err := listEntries(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var pinned map[*cacheEntry]*pin
+	if !*includePinned {
+		pinned = pinnedEntries(dir, entries)
+	}
+	plan := prunePlan(entries, pinned, time.Now())
+
+	var count int
+	var bytes int64
+	for _, e := range entries {
+		reason, ok := plan[e]
+		if !ok {
+			continue
+		}
+		if e.changed() {
+			// Replaced by a save not honoring the lock.
+			log.Printf("%-40s  %s (changed since listed, kept)", e.Name, e.ImportPath())
+			continue
+		}
+		log.Printf("%-40s  %s (%s)", e.Name, e.ImportPath(), reason)
+		if !*dryRun {
+			if err := e.remove(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		count++
+		bytes += e.Size
+	}
+
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	log.Printf("%s %d of %d entries (%s)", verb, count, len(entries), humanSize(bytes))
+	if len(pinned) > 0 {
+		log.Printf("kept %d pinned entries", len(pinned))
+	}
+
+	if !*dryRun {
+		n, size, err := gcBlobs(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n > 0 {
+			log.Printf("removed %d unreferenced blobs (%s)", n, humanSize(size))
+		}
+		if err := compactStats(dir, time.Now()); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentUse runs saves, restores and prunes of the same cache
+// at once, checking that none of them fails and that the entries and
+// the restored outputs are intact.
+func TestConcurrentUse(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the cache is not locked on windows")
+	}
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	f := newFixture(t)
+	f.install("./...")
+	targets := map[string]string{} // restored relative to the GOPATH entry
+	for _, pkg := range f.load("./...") {
+		if pkg.cached() && pkg.Target != "" && strings.HasPrefix(pkg.Target, f.gopath) {
+			targets[pkg.Target] = strings.TrimPrefix(pkg.Target, f.gopath)
+		}
+	}
+
+	const rounds = 10
+	roots := []string{filepath.Join(f.root, "root1"), filepath.Join(f.root, "root2")}
+	commands := [][]string{
+		{"save", "./..."},
+		{"save", "./..."},
+		{"-older-than", "1ns", "prune"},
+		{"-keep-per-package", "1", "prune"},
+	}
+	for _, root := range roots {
+		commands = append(commands, []string{"-target-root", root, "restore", "./..."})
+	}
+	var wg sync.WaitGroup
+	for _, args := range commands {
+		wg.Add(1)
+		go func(args []string) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if out, err := f.run(args...); err != nil {
+					t.Errorf("build-cache %v: %v\n%s", args, err, out)
+					return
+				}
+			}
+		}(args)
+	}
+	wg.Wait()
+
+	f.mustRun("verify")
+	restored := 0
+	for target, rel := range targets {
+		want, err := os.ReadFile(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, root := range roots {
+			got, err := os.ReadFile(root + rel)
+			if os.IsNotExist(err) {
+				continue // pruned before it could be restored
+			}
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("%s: restored as %s (%d bytes, %v), want %d bytes", target, root+rel, len(got), err, len(want))
+			}
+			restored++
+		}
+	}
+	if restored == 0 {
+		t.Errorf("nothing restored under %s", roots)
+	}
+}
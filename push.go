@@ -0,0 +1,190 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	pushFromJournal = flag.String("from-journal", "",
+		"make push push the entries listed in this journal of a save with --remote-push=async")
+	pushWait = flag.Bool("wait", false,
+		"make push wait for the background pushes in progress to complete")
+)
+
+// pushHelperFlags are the flags passed on to the push started in the
+// background by a save with --remote-push=async, which reads the same
+// environment and configuration file.
+var pushHelperFlags = []string{
+	"config", "namespace", "namespace-from-git", "remote", "remote-credentials-helper",
+	"remote-unavailable", "remote-max-failures", "remote-timeout", "remote-max-bandwidth",
+	"j", "lock-timeout", "tmp-dir", "v", "vv",
+}
+
+// pushDir returns the directory of the journals of the pushes handed off
+// to the background in the cache dir. Each journal lists the names of
+// the entries to push, one per line, and is removed once they have
+// been; a journal whose push is in progress is locked.
+func pushDir(dir string) string {
+	return filepath.Join(dir, "push")
+}
+
+// pushEntries pushes the entries of the cache dir with the given names,
+// saved by a save, to the remote tier as --remote-push says, and records
+// the outcome in s.
+func pushEntries(dir string, names []string, s *summary) {
+	t := remote()
+	if t == nil || len(names) == 0 || *remotePush == "off" {
+		return
+	}
+	sort.Strings(names)
+	if *remotePush == "async" {
+		err := handOffPush(dir, names)
+		if err == nil {
+			log.Printf("pushing %d entries to %s in the background", len(names), t.name)
+			s.PushQueued = len(names)
+			return
+		}
+		log.Printf("warning: unable to push in the background, pushing now: %s", err)
+	}
+	s.Pushed, s.PushFailures = t.push(dir, names)
+}
+
+// handOffPush writes the journal of the push of the entries of the cache
+// dir with the given names and starts a push of it in a process of its
+// own, which outlives the save. The journal is complete before the push
+// starts, so if it dies, it is left for "push" to resume.
+func handOffPush(dir string, names []string) error {
+	if err := os.MkdirAll(pushDir(dir), 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(pushDir(dir), fmt.Sprintf("%d-%d", time.Now().UnixNano(), os.Getpid()))
+	if err := writeFileAtomic(path, []byte(strings.Join(names, "\n")+"\n")); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		cmd := exec.Command(exe, pushHelperArgs(path)...)
+		detach(cmd)
+		if err = cmd.Start(); err == nil {
+			return cmd.Process.Release()
+		}
+	}
+	_ = os.Remove(path)
+	return err
+}
+
+// pushHelperArgs returns the arguments of the push of the journal at
+// path started by handOffPush.
+func pushHelperArgs(path string) []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range pushHelperFlags {
+			if f.Name == name {
+				args = append(args, "-"+f.Name+"="+f.Value.String())
+			}
+		}
+	})
+	return append(args, "push", "-from-journal", path)
+}
+
+// pushCmd pushes the entries handed off to the background by saves with
+// --remote-push=async: those of the journal given by --from-journal, as
+// the push started by the save does, or else those of the journals left
+// behind by pushes which died. The journals of the pushes in progress
+// are skipped, or waited for with --wait.
+func pushCmd(args []string) {
+	t := remote()
+	if t == nil {
+		log.Fatal("push: no --remote")
+	}
+	if *pushFromJournal != "" {
+		pushJournal(t, filepath.Dir(filepath.Dir(*pushFromJournal)), *pushFromJournal, true)
+		return
+	}
+	dir := cacheDir()
+	infos, err := os.ReadDir(pushDir(dir))
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	for _, info := range infos {
+		if name := info.Name(); !strings.HasPrefix(name, ".") {
+			pushJournal(t, dir, filepath.Join(pushDir(dir), name), *pushWait)
+		}
+	}
+}
+
+// pushJournal pushes the entries of the cache dir listed in the journal
+// at path, unless another process is pushing them and wait is not set,
+// then removes the journal and records the outcome in the stats.
+func pushJournal(t *remoteTier, dir, path string, wait bool) {
+	unlock, err := flockFile(path, wait)
+	if os.IsNotExist(err) {
+		return // pushed by another process
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if unlock == nil {
+		log.Printf("%s: in progress", path)
+		return
+	}
+	defer unlock()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return // pushed while waiting for the lock
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	unlockUse, err := lockCacheUse(dir, false, *lockTimeout)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlockUse()
+
+	names := strings.Fields(string(data))
+	s := newSummary("push")
+	s.Pushed, s.PushFailures = t.push(dir, names)
+	s.Seconds = time.Since(s.start).Seconds()
+	log.Printf("pushed %d of %d entries to %s%s", s.Pushed, len(names), t.name, formatPushFailures(s.PushFailures))
+	if err := os.Remove(path); err != nil {
+		log.Printf("warning: %s", err)
+	}
+	recordRemote(s)
+	appendStats(dir, s)
+	if err := s.err(); err != nil {
+		fatal(err)
+	}
+}
+
+// formatPushFailures describes the number of entries which could not be
+// pushed, if any.
+func formatPushFailures(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d failed)", n)
+}
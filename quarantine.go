@@ -0,0 +1,246 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var quarantineMaxSize = byteSize(64 << 20)
+
+func init() {
+	flag.Var(&quarantineMaxSize, "quarantine-max-size",
+		"evict the oldest quarantined entries beyond this total size")
+}
+
+// quarantineDir returns the directory holding the entries of the cache
+// dir which failed integrity checks. Each quarantined entry is a
+// directory holding the entry, its metadata (if any) and a reason file.
+// Restore never looks there.
+func quarantineDir(dir string) string {
+	return filepath.Join(dir, "quarantine")
+}
+
+const reasonFile = "reason.json"
+
+// A quarantineReason records why and from where an entry was
+// quarantined.
+type quarantineReason struct {
+	Path   string    `json:"path"` // the original path of the entry
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// A quarantined is an entry in the quarantine area.
+type quarantined struct {
+	Dir  string `json:"dir"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	quarantineReason
+}
+
+// quarantine moves the cache entry at path and its metadata into the
+// quarantine area of the cache dir. The entry is renamed out of the
+// cache in a single step, so it is atomically either in the cache or
+// in quarantine.
+func quarantine(dir, path, reason string) error {
+	qdir := quarantineDir(dir)
+	if err := os.MkdirAll(qdir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(qdir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&quarantineReason{
+		Path:   path,
+		Reason: reason,
+		Time:   time.Now().UTC(),
+	})
+	if err == nil {
+		err = os.WriteFile(filepath.Join(tmp, reasonFile), data, 0644)
+	}
+	name := filepath.Base(path)
+	if err == nil {
+		err = os.Rename(path, filepath.Join(tmp, name))
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(metaPath(path), metaPath(filepath.Join(tmp, name))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(extraDir(path), extraDir(filepath.Join(tmp, name))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dst := filepath.Join(qdir, fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	return evictQuarantine(dir, dst)
+}
+
+// listQuarantine returns the quarantined entries of the cache dir,
+// oldest first.
+func listQuarantine(dir string) ([]*quarantined, error) {
+	infos, err := os.ReadDir(quarantineDir(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*quarantined
+	for _, info := range infos {
+		if !info.IsDir() || info.Name()[0] == '.' {
+			continue
+		}
+		q := &quarantined{Dir: filepath.Join(quarantineDir(dir), info.Name())}
+		data, err := os.ReadFile(filepath.Join(q.Dir, reasonFile))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &q.quarantineReason); err != nil {
+			return nil, fmt.Errorf("%s: %s", q.Dir, err)
+		}
+		q.Name = filepath.Base(q.Path)
+		q.Size = fileSize(filepath.Join(q.Dir, q.Name))
+		entries = append(entries, q)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// evictQuarantine removes the oldest quarantined entries until the
+// quarantine area fits within -quarantine-max-size. The entry quarantined
+// at keep is never evicted.
+func evictQuarantine(dir, keep string) error {
+	entries, err := listQuarantine(dir)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, q := range entries {
+		total += q.Size
+	}
+	for _, q := range entries {
+		if total <= int64(quarantineMaxSize) {
+			break
+		}
+		if q.Dir == keep {
+			continue
+		}
+		if err := os.RemoveAll(q.Dir); err != nil {
+			return err
+		}
+		total -= q.Size
+	}
+	return nil
+}
+
+// findQuarantined returns the quarantined entries whose names or
+// quarantine directories match name.
+func findQuarantined(entries []*quarantined, name string) []*quarantined {
+	var matches []*quarantined
+	for _, q := range entries {
+		if q.Name == name || entryFingerprint(q.Name) == name || filepath.Base(q.Dir) == name {
+			matches = append(matches, q)
+		}
+	}
+	return matches
+}
+
+// quarantineCmd manages the quarantine area: "ls" lists the quarantined
+// entries, "restore <name>..." moves entries back into the cache and
+// "purge [name...]" deletes them.
+func quarantineCmd(args []string) {
+	if len(args) == 0 {
+		log.Printf("usage: %s quarantine ls|restore|purge [names]", os.Args[0])
+		os.Exit(1)
+	}
+	dir := cacheDir()
+	entries, err := listQuarantine(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	selected := entries
+	if len(args) > 1 {
+		selected = nil
+		for _, name := range args[1:] {
+			matches := findQuarantined(entries, name)
+			if len(matches) == 0 {
+				log.Fatalf("no quarantined entry \"%s\"", name)
+			}
+			selected = append(selected, matches...)
+		}
+	}
+
+	switch args[0] {
+	case "ls":
+		if *jsonOutput {
+			fmt.Println(prettyJSON(selected))
+			return
+		}
+		for _, q := range selected {
+			fmt.Printf("%-*s %8s %s %s\n", fingerprintWidth, q.Name, humanSize(q.Size),
+				q.Time.Local().Format("2006-01-02 15:04"), q.Reason)
+		}
+	case "restore":
+		if len(args) == 1 {
+			log.Fatalf("usage: %s quarantine restore <name>...", os.Args[0])
+		}
+		for _, q := range selected {
+			if exists(q.Path) {
+				log.Fatalf("%s: already exists", q.Path)
+			}
+			src := filepath.Join(q.Dir, q.Name)
+			if err := os.Rename(metaPath(src), metaPath(q.Path)); err != nil && !os.IsNotExist(err) {
+				log.Fatal(err)
+			}
+			if err := os.Rename(extraDir(src), extraDir(q.Path)); err != nil && !os.IsNotExist(err) {
+				log.Fatal(err)
+			}
+			if err := os.Rename(src, q.Path); err != nil {
+				log.Fatal(err)
+			}
+			if err := os.RemoveAll(q.Dir); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("restored %s", q.Path)
+		}
+	case "purge":
+		for _, q := range selected {
+			if err := os.RemoveAll(q.Dir); err != nil {
+				log.Fatal(err)
+			}
+		}
+		log.Printf("purged %d entries", len(selected))
+	default:
+		log.Printf("unknown quarantine command \"%s\"", args[0])
+		os.Exit(1)
+	}
+}
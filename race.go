@@ -0,0 +1,241 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A raceFlag is the value of --race: "true" or "false" to build all the
+// packages named on the command line with or without the race detector,
+// "auto" to follow -race in GOFLAGS, or empty to leave it to the
+// ":race" option of each argument.
+type raceFlag string
+
+func (r *raceFlag) String() string { return string(*r) }
+
+func (r *raceFlag) Set(s string) error {
+	if s != "auto" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("must be true, false or auto")
+		}
+		s = strconv.FormatBool(b)
+	}
+	*r = raceFlag(s)
+	return nil
+}
+
+func (r *raceFlag) IsBoolFlag() bool { return true }
+
+var raceMode raceFlag
+
+func init() {
+	flag.Var(&raceMode, "race",
+		"build the packages with the race detector: true, false or auto to follow GOFLAGS "+
+			"(defaults to the :race option of each argument)")
+}
+
+var raceOnce sync.Once
+var raceSet, raceOn bool
+
+// raceEnabled returns whether --race selects the race detector for all
+// the packages named on the command line, and whether it was specified
+// at all.
+func raceEnabled() (on, set bool) {
+	raceOnce.Do(func() {
+		switch raceMode {
+		case "":
+		case "auto":
+			raceSet = true
+			flags := goFlags()
+			if v, ok := goFlagValue(flags, "race"); ok {
+				raceOn, _ = strconv.ParseBool(v)
+			} else {
+				raceOn = contains(flags, "-race") || contains(flags, "--race")
+			}
+		default:
+			raceSet = true
+			raceOn = raceMode == "true"
+		}
+	})
+	return raceOn, raceSet
+}
+
+// raceArg applies --race to the command-line argument arg, adding or
+// removing its ":race" option.
+func raceArg(arg string) string {
+	on, set := raceEnabled()
+	if !set {
+		return arg
+	}
+	base := packageBaseImportPath(arg)
+	var options []string
+	for _, o := range packageOptions(arg) {
+		if o != "race" {
+			options = append(options, o)
+		}
+	}
+	if on {
+		options = append(options, "race")
+	}
+	if len(options) == 0 {
+		return base
+	}
+	return base + ":" + strings.Join(options, ",")
+}
+
+// An instrumentation describes how the output of a package was built
+// beyond its fingerprinted inputs, as recorded in the metadata of its
+// entry.
+type instrumentation struct {
+	Race          bool
+	Msan          bool
+	InstallSuffix string
+}
+
+func (i instrumentation) String() string {
+	s := fmt.Sprintf("race=%t", i.Race)
+	if i.Msan {
+		s += " msan=true"
+	}
+	if i.InstallSuffix != "" {
+		s += " installsuffix=" + i.InstallSuffix
+	}
+	return s
+}
+
+// packageInstrumentation returns the instrumentation pkg is built with.
+// The install suffix is the one given by --installsuffix or GOFLAGS,
+// without the "race" added for the race detector.
+func packageInstrumentation(pkg *Package) instrumentation {
+	suffix, _ := buildSettings()
+	return instrumentation{
+		Race: pkg.race,
+		// The go command installs packages built with the memory
+		// sanitizer with the "msan" install suffix.
+		Msan:          contains(pkg.buildContext.BuildTags, "msan") || contains(strings.Split(suffix, "_"), "msan"),
+		InstallSuffix: suffix,
+	}
+}
+
+// metaInstrumentation returns the instrumentation recorded in m.
+func metaInstrumentation(m *entryMeta) instrumentation {
+	return instrumentation{
+		Race:          m.Race,
+		Msan:          m.Msan,
+		InstallSuffix: m.InstallSuffix,
+	}
+}
+
+// A missHint collects the packages missed by a restore in order to
+// explain a run of misses caused by restoring with a different
+// instrumentation than the entries were saved with.
+type missHint struct {
+	mu     sync.Mutex
+	missed map[string]instrumentation // by import path without options
+}
+
+func newMissHint() *missHint {
+	return &missHint{missed: map[string]instrumentation{}}
+}
+
+// miss notes that the output of pkg was not in the cache.
+func (h *missHint) miss(pkg *Package) {
+	h.mu.Lock()
+	h.missed[pkg.baseImportPath] = packageInstrumentation(pkg)
+	h.mu.Unlock()
+}
+
+// report prints a hint when most of the missed packages have entries in
+// the cache dir for a different instrumentation.
+func (h *missHint) report(dir string) {
+	for _, hint := range h.hints(dir) {
+		log.Printf("hint: %s", hint)
+	}
+}
+
+// hints returns the hints explaining the misses by the entries in the
+// cache dir with a different instrumentation; see report.
+func (h *missHint) hints(dir string) []string {
+	if len(h.missed) == 0 {
+		return nil
+	}
+	entries, err := listEntries(dir)
+	if err != nil {
+		return nil
+	}
+	counts := map[instrumentation]int{}
+	covered := map[instrumentation]map[string]bool{}
+	for _, e := range entries {
+		if e.Meta == nil || e.Meta.Test {
+			continue
+		}
+		// The import path of an entry built with the race detector
+		// has a ":race" option.
+		path := packageBaseImportPath(e.Meta.ImportPath)
+		want, ok := h.missed[path]
+		have := metaInstrumentation(e.Meta)
+		if !ok || have == want {
+			continue
+		}
+		counts[have]++
+		if covered[have] == nil {
+			covered[have] = map[string]bool{}
+		}
+		covered[have][path] = true
+	}
+	var modes []instrumentation
+	for mode := range counts {
+		// Only explain misses consistently matched by other entries.
+		if 2*len(covered[mode]) >= len(h.missed) {
+			modes = append(modes, mode)
+		}
+	}
+	sort.Slice(modes, func(i, j int) bool { return counts[modes[i]] > counts[modes[j]] })
+	var want instrumentation
+	for _, w := range h.missed {
+		want = w
+		break
+	}
+	var hints []string
+	for _, mode := range modes {
+		hints = append(hints, fmt.Sprintf("%d entries exist for these packages with %s; %s",
+			counts[mode], mode, instrumentationAdvice(want, mode)))
+	}
+	return hints
+}
+
+// instrumentationAdvice suggests how to restore the entries saved with
+// have instead of want.
+func instrumentationAdvice(want, have instrumentation) string {
+	switch {
+	case have.Race && !want.Race:
+		return "did you forget -race?"
+	case !have.Race && want.Race:
+		return "were they saved without -race?"
+	case have.Msan != want.Msan:
+		return "check -msan in GOFLAGS"
+	default:
+		return "check -installsuffix"
+	}
+}
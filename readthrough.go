@@ -0,0 +1,226 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var remoteStreamLarge = flag.Bool("remote-stream-large", false,
+	"restore the entries fetched from -remote which would take the cache over -max-size without keeping them")
+
+// fetchSpaceMargin is the space left free on the filesystems written to
+// by the entries fetched from the remote tier, for their metadata and
+// the other files of the run.
+const fetchSpaceMargin = 16 << 20
+
+// errFetchSkipped is returned by fetchBudget.reserve once it failed to
+// make room for an entry, so that the failure is reported once.
+var errFetchSkipped = errors.New("out of space for fetched entries")
+
+// A fetchBudget keeps the entries restore fetches from the remote tier
+// within -max-size and the space available to the cache dir, evicting
+// the oldest entries not used by the run to make room for them, as
+// prune -max-size would.
+type fetchBudget struct {
+	dir     string
+	mu      sync.Mutex
+	loaded  bool
+	size    int64           // of the entries of dir
+	entries []*cacheEntry   // those which may be evicted, oldest first
+	refs    map[string]int  // the number of entries referencing each blob
+	used    map[string]bool // the paths of the entries used by the run
+	full    bool
+}
+
+func newFetchBudget(dir string) *fetchBudget {
+	return &fetchBudget{dir: dir, used: map[string]bool{}}
+}
+
+// use records that the run restores the entry at path, which is then
+// never evicted.
+func (b *fetchBudget) use(path string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used[path] = true
+}
+
+// load lists the entries of the cache dir the first time an entry is
+// fetched.
+func (b *fetchBudget) load() error {
+	if b.loaded {
+		return nil
+	}
+	entries, err := listEntries(b.dir)
+	if err != nil {
+		return err
+	}
+	pinned := pinnedEntries(b.dir, entries)
+	b.refs = map[string]int{}
+	for _, e := range entries {
+		b.size += e.Size
+		if e.Meta != nil {
+			b.refs[e.Meta.Checksum]++
+			for _, x := range e.Meta.Extras {
+				b.refs[x.Checksum]++
+			}
+		}
+		if pinned[e] == nil {
+			b.entries = append(b.entries, e)
+		}
+	}
+	sort.SliceStable(b.entries, func(i, j int) bool {
+		return b.entries[i].Created().Before(b.entries[j].Created())
+	})
+	b.loaded = true
+	return nil
+}
+
+// reserve makes room for the entry name with metadata m fetched from
+// the remote tier, reporting whether it is to be kept in the cache. One
+// which would take the cache over -max-size is not kept with
+// -remote-stream-large, or if evicting the entries the run does not use
+// is not enough. reserve fails with errCacheUnavailable if the
+// filesystem of the cache does not have the space for it even after
+// evicting them, and with errFetchSkipped from then on.
+func (b *fetchBudget) reserve(name string, m *entryMeta) (bool, error) {
+	size := m.Size
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.full {
+		return false, errFetchSkipped
+	}
+	if err := b.load(); err != nil {
+		return false, err
+	}
+	if limit := int64(maxSize); limit > 0 && b.size+size > limit {
+		if *remoteStreamLarge {
+			vlogf("%s: %s would take the cache over %s, restoring it without keeping it", name, humanSize(size), humanSize(limit))
+			return false, nil
+		}
+		for b.size+size > limit {
+			if !b.evict(fmt.Sprintf("cache larger than %s", humanSize(limit))) {
+				break
+			}
+		}
+		if b.size+size > limit {
+			vlogf("%s: no entry left to evict to keep it within %s, restoring it without keeping it", name, humanSize(limit))
+			return false, nil
+		}
+	}
+	for {
+		ok, err := b.checkSpace(name, b.dir, size)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			break
+		}
+		if !b.evict("out of disk space") {
+			b.full = true
+			return false, fmt.Errorf("%s: not enough space in %s to store it: %w", name, b.dir, errCacheUnavailable)
+		}
+	}
+	b.size += size
+	b.refs[m.Checksum]++
+	return true, nil
+}
+
+// reserveTarget checks that the directory dir, to which the entry name
+// of size bytes is fetched rather than to the cache, has the space for
+// it, failing with errCacheUnavailable if not.
+func (b *fetchBudget) reserveTarget(name, dir string, size int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.full {
+		return errFetchSkipped
+	}
+	ok, err := b.checkSpace(name, dir, size)
+	if err == nil && !ok {
+		b.full = true
+		err = fmt.Errorf("%s: not enough space in %s to restore it: %w", name, dir, errCacheUnavailable)
+	}
+	return err
+}
+
+// checkSpace reports whether the filesystem of dir has the space for
+// the entry name of size bytes. It is assumed to on the platforms where
+// the free space is not known.
+func (b *fetchBudget) checkSpace(name, dir string, size int64) (bool, error) {
+	_, avail, err := diskSpace(dir)
+	if errors.Is(err, errDiskSpaceUnsupported) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	need := uint64(size) + fetchSpaceMargin
+	vlogf("%s: %s needed in %s, %s free", name, humanSize(int64(need)), dir, humanSize(int64(avail)))
+	return avail >= need, nil
+}
+
+// evict removes the oldest entry the run does not use, and its blobs if
+// no other entry references them, reporting whether there was one.
+func (b *fetchBudget) evict(reason string) bool {
+	for len(b.entries) > 0 {
+		e := b.entries[0]
+		b.entries = b.entries[1:]
+		if b.used[e.Path] || e.changed() {
+			continue
+		}
+		if err := e.remove(); err != nil {
+			vlogf("%s: not evicting it: %s", e.Path, err)
+			continue
+		}
+		vlogf("evicted %s  %s (%s, %s)", e.Name, e.ImportPath(), humanSize(e.Size), reason)
+		b.size -= e.Size
+		dir := filepath.Dir(e.Path)
+		entryIndexMu.Lock()
+		if index := entryIndex(dir); index[e.Fingerprint()] == e.Path {
+			delete(index, e.Fingerprint())
+		}
+		entryIndexMu.Unlock()
+		checkedEntries.Lock()
+		delete(checkedEntries.byPath, e.Path)
+		checkedEntries.Unlock()
+		if e.Meta != nil {
+			b.release(e.Meta.Checksum)
+			for _, x := range e.Meta.Extras {
+				b.release(x.Checksum)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// release drops a reference to the blob sum, removing it once no entry
+// references it.
+func (b *fetchBudget) release(sum string) {
+	if b.refs[sum]--; b.refs[sum] > 0 {
+		return
+	}
+	delete(b.refs, sum)
+	if err := os.Remove(blobPath(b.dir, sum)); err != nil && !os.IsNotExist(err) {
+		vlogf("%s: %s", blobPath(b.dir, sum), err)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+// reflink creates dst as a copy-on-write clone of src. It is only
+// implemented on Linux: macOS's clonefile is not reachable from the
+// standard library's syscall package.
+func reflink(src, dst string) error {
+	return errReflinkUnsupported
+}
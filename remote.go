@@ -0,0 +1,670 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	remoteURL = flag.String("remote", "",
+		"URL of a remote cache tier shared between machines, file:///DIR or http(s)://HOST/PATH: "+
+			"restore fetches the entries missing from the cache from it and save pushes its entries to it")
+	remotePush = flag.String("remote-push", "sync",
+		"how save pushes its entries to --remote: sync, async (by a background push) or off")
+	remoteUnavailable = flag.String("remote-unavailable", "warn",
+		"what to do when --remote cannot be reached: ignore it, warn and continue with the local cache, "+
+			"or fail the command with status 5 once it is done")
+	remoteMaxFailures = flag.Int("remote-max-failures", 3,
+		"consecutive failures to reach --remote after which it is given up on for the rest of the run")
+	remoteTimeout = flag.Duration("remote-timeout", 10*time.Second,
+		"how long an HTTP --remote may take to accept a connection and to answer a request, not counting transfers")
+)
+
+// errRemoteDown is the failure of the requests to the remote tier once
+// it has been given up on.
+var errRemoteDown = errors.New("given up on after repeated failures")
+
+// A remoteBackend stores the objects of a remote cache tier: the entries
+// and their metadata, named as in a cache directory. Its methods may be
+// called concurrently.
+type remoteBackend interface {
+	// get copies the object name to w. It fails with errCacheMiss if
+	// there is no such object.
+	get(name string, w io.Writer) error
+	// put stores the size bytes read from r as the object name.
+	put(name string, r io.Reader, size int64) error
+	// has reports whether there is an object name.
+	has(name string) (bool, error)
+}
+
+// newRemoteBackend returns the backend of the remote tier at rawURL.
+func newRemoteBackend(rawURL string) (remoteBackend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("--remote: %w", err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("--remote %s: not a local directory", u.Redacted())
+		}
+		dir := u.Path
+		if runtime.GOOS == "windows" && len(dir) >= 3 && dir[0] == '/' && dir[2] == ':' {
+			dir = dir[1:] // file:///C:/dir
+		}
+		return &dirBackend{dir: filepath.FromSlash(dir)}, nil
+	case "http", "https":
+		return newHTTPBackend(u), nil
+	}
+	return nil, fmt.Errorf("--remote %s: unsupported scheme, want file, http or https", u.Redacted())
+}
+
+// redactURL returns rawURL without the password it may hold, for
+// messages.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "--remote"
+	}
+	return u.Redacted()
+}
+
+// A dirBackend stores the objects of a remote tier in a directory, such
+// as one on a shared filesystem. The directory must exist: a missing one
+// is likely an unmounted filesystem rather than an empty tier.
+type dirBackend struct {
+	dir string
+}
+
+func (b *dirBackend) path(name string) (string, error) {
+	if _, err := os.Stat(b.dir); err != nil {
+		return "", err
+	}
+	return filepath.Join(b.dir, filepath.FromSlash(name)), nil
+}
+
+func (b *dirBackend) get(name string, w io.Writer) error {
+	path, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return errCacheMiss
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// put writes the object under a temporary name and renames it so that
+// readers never observe a partial object.
+func (b *dirBackend) put(name string, r io.Reader, size int64) error {
+	path, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, size); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (b *dirBackend) has(name string) (bool, error) {
+	path, err := b.path(name)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// An httpBackend stores the objects of a remote tier on an HTTP server,
+// such as a WebDAV share or an artifact store, under a base URL: GET
+// fetches an object, HEAD tests for it and PUT stores it.
+type httpBackend struct {
+	base   string // without credentials and a trailing slash
+	client *http.Client
+	creds  *remoteCredentials
+}
+
+func newHTTPBackend(u *url.URL) *httpBackend {
+	base := *u
+	base.User = nil
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = ""
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: *remoteTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = *remoteTimeout
+	transport.ResponseHeaderTimeout = *remoteTimeout
+	return &httpBackend{
+		base:   base.String(),
+		client: &http.Client{Transport: transport},
+		creds:  newRemoteCredentials(u),
+	}
+}
+
+// do sends the request for the object name, with body unless it is nil.
+func (b *httpBackend) do(method, name string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.base+"/"+name, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if err := b.creds.authorize(req); err != nil {
+		return nil, err
+	}
+	return b.client.Do(req)
+}
+
+// statusError returns the failure of a request for the object name
+// answered with resp, a throttledError if the server throttled it.
+func statusError(method, name string, resp *http.Response) error {
+	if err := throttled(method, name, resp); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s %s: %s", method, name, resp.Status)
+}
+
+func (b *httpBackend) get(name string, w io.Writer) error {
+	resp, err := b.do(http.MethodGet, name, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		_, err := io.Copy(w, resp.Body)
+		return err
+	case http.StatusNotFound:
+		return errCacheMiss
+	}
+	return statusError(http.MethodGet, name, resp)
+}
+
+func (b *httpBackend) put(name string, r io.Reader, size int64) error {
+	resp, err := b.do(http.MethodPut, name, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+	return statusError(http.MethodPut, name, resp)
+}
+
+func (b *httpBackend) has(name string) (bool, error) {
+	resp, err := b.do(http.MethodHead, name, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, statusError(http.MethodHead, name, resp)
+}
+
+// A remoteTier is the remote cache tier given by --remote. The objects
+// of a namespace other than the default one are stored under
+// "namespaces/<namespace>/", as in the cache directory.
+//
+// The remote tier is best effort: the command continues with the local
+// cache alone when it cannot be reached, and gives up on it for the rest
+// of the run after --remote-max-failures consecutive failures, such as
+// connection or authorization failures, as --remote-unavailable says.
+// Requests the remote tier throttles are retried rather than counted as
+// failures, unless it keeps throttling them.
+type remoteTier struct {
+	backend   remoteBackend
+	name      string // the URL without credentials, for messages
+	prefix    string // of the objects of the namespace in use
+	transfers *transferLimiter
+
+	mu       sync.Mutex // protects the following
+	failures int        // consecutive
+	errors   int
+	down     bool
+}
+
+var remoteOnce sync.Once
+var theRemote *remoteTier
+
+// remote returns the remote tier given by --remote, or nil if there is
+// none. An invalid --remote is fatal.
+func remote() *remoteTier {
+	remoteOnce.Do(func() {
+		if *remoteURL == "" {
+			return
+		}
+		if err := checkRemoteFlags(); err != nil {
+			log.Fatal(err)
+		}
+		b, err := newRemoteBackend(*remoteURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		theRemote = &remoteTier{backend: b, name: redactURL(*remoteURL), transfers: newTransferLimiter()}
+		if ns := namespace(); ns != "" {
+			theRemote.prefix = "namespaces/" + ns + "/"
+		}
+	})
+	return theRemote
+}
+
+// checkRemoteFlags validates --remote-push and --remote-unavailable.
+func checkRemoteFlags() error {
+	switch *remotePush {
+	case "sync", "async", "off":
+	default:
+		return fmt.Errorf("unknown --remote-push \"%s\": want sync, async or off", *remotePush)
+	}
+	switch *remoteUnavailable {
+	case "ignore", "warn", "fail":
+	default:
+		return fmt.Errorf("unknown --remote-unavailable \"%s\": want ignore, warn or fail", *remoteUnavailable)
+	}
+	return nil
+}
+
+// get copies the object name of the namespace in use to w.
+func (t *remoteTier) get(name string, w io.Writer) error {
+	lw := &limitedWriter{w: w, l: t.transfers}
+	return t.transfers.do(func() error {
+		return t.backend.get(t.prefix+name, lw)
+	}, func() bool { return lw.n == 0 })
+}
+
+// put stores the size bytes read from r as the object name of the
+// namespace in use.
+func (t *remoteTier) put(name string, r io.ReadSeeker, size int64) error {
+	return t.transfers.do(func() error {
+		return t.backend.put(t.prefix+name, &limitedReader{r: r, l: t.transfers}, size)
+	}, func() bool {
+		_, err := r.Seek(0, io.SeekStart)
+		return err == nil
+	})
+}
+
+// has reports whether there is an object name in the namespace in use.
+func (t *remoteTier) has(name string) (bool, error) {
+	var ok bool
+	err := t.transfers.do(func() error {
+		var err error
+		ok, err = t.backend.has(t.prefix + name)
+		return err
+	}, func() bool { return true })
+	return ok, err
+}
+
+// available reports whether the remote tier has not been given up on.
+func (t *remoteTier) available() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.down
+}
+
+// succeeded notes a request the remote tier answered.
+func (t *remoteTier) succeeded() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = 0
+}
+
+// unavailable notes the failure to reach the remote tier, logged with
+// -v, and gives up on it after --remote-max-failures in a row, which is
+// logged unless --remote-unavailable=ignore.
+func (t *remoteTier) unavailable(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	vlogf("remote %s: %s", t.name, err)
+	t.errors++
+	t.failures++
+	if t.down || t.failures < *remoteMaxFailures {
+		return
+	}
+	t.down = true
+	switch *remoteUnavailable {
+	case "warn":
+		log.Printf("warning: remote %s unavailable, continuing with the local cache: %s", t.name, err)
+	case "fail":
+		log.Printf("remote %s unavailable: %s", t.name, err)
+	}
+}
+
+// A remoteCounts counts the use of --remote by runs.
+type remoteCounts struct {
+	RemoteHits   int `json:"remoteHits,omitempty"` // of restores
+	Pushed       int `json:"pushed,omitempty"`
+	PushFailures int `json:"pushFailures,omitempty"`
+	// The failures to reach the remote tier, and the runs which gave up
+	// on it.
+	RemoteErrors      int `json:"remoteErrors,omitempty"`
+	RemoteUnavailable int `json:"remoteUnavailable,omitempty"`
+	// The requests the remote tier throttled, and the bytes transferred
+	// to and from it in the time spent transferring them.
+	Throttled       int     `json:"throttled,omitempty"`
+	TransferBytes   int64   `json:"transferBytes,omitempty"`
+	TransferSeconds float64 `json:"transferSeconds,omitempty"`
+}
+
+func (c *remoteCounts) add(o remoteCounts) {
+	c.RemoteHits += o.RemoteHits
+	c.Pushed += o.Pushed
+	c.PushFailures += o.PushFailures
+	c.RemoteErrors += o.RemoteErrors
+	c.RemoteUnavailable += o.RemoteUnavailable
+	c.Throttled += o.Throttled
+	c.TransferBytes += o.TransferBytes
+	c.TransferSeconds += o.TransferSeconds
+}
+
+// format describes c, and the number of entries queued for a background
+// push, if there is anything to report.
+func (c *remoteCounts) format(queued int) string {
+	var parts []string
+	if c.RemoteHits > 0 {
+		parts = append(parts, fmt.Sprintf("%d fetched", c.RemoteHits))
+	}
+	if c.Pushed > 0 {
+		parts = append(parts, fmt.Sprintf("%d pushed", c.Pushed))
+	}
+	if c.PushFailures > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed to push", c.PushFailures))
+	}
+	if queued > 0 {
+		parts = append(parts, fmt.Sprintf("%d pushing in the background", queued))
+	}
+	if c.TransferBytes > 0 && c.TransferSeconds > 0 {
+		parts = append(parts, fmt.Sprintf("%s transferred at %s/s", humanSize(c.TransferBytes),
+			humanSize(int64(float64(c.TransferBytes)/c.TransferSeconds))))
+	}
+	if c.Throttled > 0 {
+		parts = append(parts, fmt.Sprintf("%d throttled", c.Throttled))
+	}
+	if c.RemoteErrors > 0 {
+		parts = append(parts, fmt.Sprintf("%d errors", c.RemoteErrors))
+	}
+	if c.RemoteUnavailable == 1 {
+		parts = append(parts, "given up on")
+	} else if c.RemoteUnavailable > 1 {
+		parts = append(parts, fmt.Sprintf("given up on by %d runs", c.RemoteUnavailable))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "remote: " + strings.Join(parts, ", ")
+}
+
+// recordRemote records the failures to reach the remote tier in s. With
+// --remote-unavailable=fail, they fail the command as the cache being
+// unavailable.
+func recordRemote(s *summary) {
+	t := remote()
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	s.RemoteErrors = t.errors
+	if t.down {
+		s.RemoteUnavailable = 1
+	}
+	t.mu.Unlock()
+	s.Throttled, s.TransferBytes, s.TransferSeconds = t.transfers.counts()
+	if s.RemoteErrors > 0 && *remoteUnavailable == "fail" {
+		s.fail(fmt.Errorf("remote %s: %d failures: %w", t.name, s.RemoteErrors, errCacheUnavailable))
+	}
+}
+
+// fetch copies the entry with fingerprint fp holding the output of pkg,
+// and its metadata, from the remote tier into the cache dir of b,
+// returning its path, or "" if the remote tier does not have it or
+// cannot be reached. An entry b does not keep in the cache is copied
+// next to the Target of pkg instead, and temp is then set: it is up to
+// the caller to remove it and its metadata. fetch fails if the entry
+// does not match the checksum in its metadata, or if it cannot be
+// stored.
+//
+// The metadata is fetched first as push stores it last: an entry
+// without metadata may be partially pushed. Entries with extra
+// artifacts are not pushed.
+func (t *remoteTier) fetch(b *fetchBudget, fp string, pkg *Package) (src string, temp bool, err error) {
+	if !t.available() {
+		return "", false, nil
+	}
+	name := entryFileName(fp, pkg.ImportPath)
+	var meta bytes.Buffer
+	err = t.get(metaPath(name), &meta)
+	if err != nil && !errors.Is(err, errCacheMiss) {
+		t.unavailable(err)
+		return "", false, nil
+	}
+	t.succeeded()
+	if err != nil {
+		return "", false, nil
+	}
+	m := &entryMeta{}
+	if err := json.Unmarshal(meta.Bytes(), m); err != nil {
+		return "", false, fmt.Errorf("remote metadata %s: %s: %w", metaPath(name), err, errIntegrity)
+	}
+
+	dir := b.dir
+	keep, err := b.reserve(name, m)
+	if err == nil && !keep {
+		dir = filepath.Dir(pkg.Target)
+		if err = os.MkdirAll(dir, 0755); err == nil {
+			err = b.reserveTarget(name, dir, m.Size)
+		}
+	}
+	if errors.Is(err, errFetchSkipped) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	f, err := createTemp(dir)
+	if err != nil {
+		return "", false, err
+	}
+	tmp := f.Name()
+	defer func() {
+		if src != tmp {
+			_ = os.Remove(tmp)
+		}
+	}()
+	h := sha256.New()
+	err = t.get(name, io.MultiWriter(f, h))
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		return "", false, closeErr
+	}
+	if err != nil {
+		if !errors.Is(err, errCacheMiss) {
+			t.unavailable(err)
+		}
+		return "", false, nil
+	}
+	t.succeeded()
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != m.Checksum {
+		return "", false, fmt.Errorf("remote entry %s: %w", name, errIntegrity)
+	}
+	mode := m.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return "", false, err
+	}
+
+	if !keep {
+		if err := os.WriteFile(metaPath(tmp), meta.Bytes(), 0644); err != nil {
+			return "", false, err
+		}
+		vlogf("%s: fetched %s from %s to %s", pkg.ImportPath, name, t.name, tmp)
+		return tmp, true, nil
+	}
+	dst := filepath.Join(dir, name)
+	if err := storeEntry(dir, tmp, dst); err != nil {
+		return "", false, err
+	}
+	if err := writeFileAtomic(metaPath(dst), meta.Bytes()); err != nil {
+		_ = os.Remove(dst)
+		return "", false, err
+	}
+	addEntry(dir, dst)
+	vlogf("%s: fetched %s from %s", pkg.ImportPath, name, t.name)
+	return dst, false, nil
+}
+
+// push copies the entries of the cache dir with the given names, and
+// their metadata, to the remote tier on the workers allowed by -j,
+// skipping those it already has. It returns the number of entries
+// pushed and of those which could not be.
+func (t *remoteTier) push(dir string, names []string) (pushed, failed int) {
+	var mu sync.Mutex
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < limits.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				ok, err := t.pushEntry(dir, name)
+				if err != nil && err != errRemoteDown {
+					t.unavailable(fmt.Errorf("pushing %s: %w", name, err))
+				} else if err == nil {
+					t.succeeded()
+				}
+				mu.Lock()
+				if err != nil {
+					failed++
+				} else if ok {
+					pushed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+	return pushed, failed
+}
+
+// pushEntry copies the entry of the cache dir with the given name to the
+// remote tier, and then its metadata, which marks the entry as complete.
+// It reports whether it did: not if the remote tier already has it, or
+// if it has been removed from the cache since it was saved. The entry is
+// named in the remote tier as fetch looks it up, even if it was saved by
+// a version of build-cache naming entries by their bare fingerprint.
+func (t *remoteTier) pushEntry(dir, name string) (bool, error) {
+	path := filepath.Join(dir, name)
+	meta, err := os.ReadFile(metaPath(path))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	m := &entryMeta{}
+	if err := json.Unmarshal(meta, m); err != nil {
+		return false, fmt.Errorf("%s: %w", metaPath(path), err)
+	}
+	if len(m.Extras) > 0 {
+		vlogf("%s: not pushing %s: it has extra artifacts", m.ImportPath, name)
+		return false, nil
+	}
+	if !t.available() {
+		return false, errRemoteDown
+	}
+	name = entryFileName(entryFingerprint(name), m.ImportPath)
+	if ok, err := t.has(metaPath(name)); err != nil || ok {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if m.Mode == 0 {
+		// Saved before the mode was recorded.
+		m.Mode = fi.Mode().Perm()
+		if meta, err = json.Marshal(m); err != nil {
+			return false, err
+		}
+	}
+	if err := t.put(name, f, fi.Size()); err != nil {
+		return false, err
+	}
+	if err := t.put(metaPath(name), bytes.NewReader(meta), int64(len(meta))); err != nil {
+		return false, err
+	}
+	vlogf("%s: pushed %s to %s", m.ImportPath, name, t.name)
+	return true, nil
+}
@@ -0,0 +1,498 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A remoteServer is an HTTP server storing the objects of a remote tier
+// in memory under /cache/. Requests without the Authorization header
+// auth, if set, are refused, and all of them fail with status, if set.
+// The first throttle requests are throttled.
+type remoteServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	objects  map[string][]byte
+	auth     string
+	status   int
+	throttle int
+	requests int
+}
+
+func newRemoteServer(t *testing.T) *remoteServer {
+	s := &remoteServer{objects: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/cache/")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if s.status != 0 {
+			http.Error(w, "", s.status)
+			return
+		}
+		if s.throttle > 0 {
+			s.throttle--
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "", http.StatusTooManyRequests)
+			return
+		}
+		if s.auth != "" && req.Header.Get("Authorization") != s.auth {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		switch req.Method {
+		case http.MethodPut:
+			data, err := io.ReadAll(req.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.objects[name] = data
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet, http.MethodHead:
+			data, ok := s.objects[name]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			_, _ = w.Write(data)
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// setAuth makes the server refuse the requests without the
+// Authorization header auth.
+func (s *remoteServer) setAuth(auth string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = auth
+}
+
+// names returns the names of the objects stored.
+func (s *remoteServer) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	return names
+}
+
+// countEntries returns the number of entries, with their metadata, in
+// the objects named by names.
+func countEntries(names []string) int {
+	n := 0
+	for _, name := range names {
+		if strings.HasSuffix(name, ".meta") {
+			n++
+		}
+	}
+	return n
+}
+
+// dirObjects returns the names of the objects of the remote tier stored
+// in dir, slash-separated.
+func dirObjects(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		names = append(names, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// TestRemoteCache checks that save pushes the entries to the remote
+// tier, unless it has them, and that restore fetches those missing from
+// the cache, with either backend.
+func TestRemoteCache(t *testing.T) {
+	for _, backend := range []string{"file", "http"} {
+		t.Run(backend, func(t *testing.T) {
+			f := newFixture(t)
+			var url string
+			var objects func() []string
+			if backend == "file" {
+				dir := filepath.Join(f.root, "remote")
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				url = "file://" + filepath.ToSlash(dir)
+				objects = func() []string { return dirObjects(t, dir) }
+			} else {
+				s := newRemoteServer(t)
+				url = s.URL + "/cache/"
+				objects = s.names
+			}
+
+			f.install("./...")
+			tool := f.load("example.com/app/cmd/tool")[0].Target
+			if err := os.Chmod(tool, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if out := f.mustRun("-remote", url, "save", "./..."); !strings.Contains(out, "remote: 4 pushed") {
+				t.Errorf("save did not push the 4 entries:\n%s", out)
+			}
+			if n := countEntries(objects()); n != 4 {
+				t.Errorf("%d entries in the remote tier, want 4: %v", n, objects())
+			}
+			if out := f.mustRun("-remote", url, "save", "./..."); strings.Contains(out, "pushed") {
+				t.Errorf("save pushed the entries the remote tier has:\n%s", out)
+			}
+
+			// Another machine, with an empty cache.
+			if err := os.RemoveAll(f.cache); err != nil {
+				t.Fatal(err)
+			}
+			f.removeOutputs()
+			out := f.mustRun("-remote", url, "restore", "./...")
+			if !strings.Contains(out, "4 hits, 0 misses") || !strings.Contains(out, "remote: 4 fetched") {
+				t.Errorf("restore did not fetch the 4 entries:\n%s", out)
+			}
+			for _, pkg := range f.load("./...") {
+				if pkg.cached() && !exists(pkg.Target) {
+					t.Errorf("%s not restored", pkg.ImportPath)
+				}
+			}
+			if fi, err := os.Stat(tool); err != nil {
+				t.Fatal(err)
+			} else if fi.Mode().Perm() != 0755 && runtime.GOOS != "windows" {
+				t.Errorf("%s restored with mode %v, want 0755", tool, fi.Mode().Perm())
+			}
+			f.removeOutputs()
+			if out := f.mustRun("-remote", url, "restore", "./..."); strings.Contains(out, "fetched") {
+				t.Errorf("restore fetched the entries the cache has:\n%s", out)
+			}
+		})
+	}
+}
+
+// TestRemoteCacheNamespace checks that the entries of a namespace are
+// kept apart in the remote tier.
+func TestRemoteCacheNamespace(t *testing.T) {
+	f := newFixture(t)
+	dir := filepath.Join(f.root, "remote")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	url := "file://" + filepath.ToSlash(dir)
+	f.install("./...")
+	f.mustRun("-remote", url, "-namespace", "feature", "save", "./...")
+	for _, name := range dirObjects(t, dir) {
+		if !strings.HasPrefix(name, "namespaces/feature/") {
+			t.Errorf("%s outside the namespace", name)
+		}
+	}
+	f.removeOutputs()
+	if out := f.mustRun("-remote", url, "restore", "./..."); !strings.Contains(out, "0 hits, 4 misses") {
+		t.Errorf("the default namespace restored entries of another:\n%s", out)
+	}
+}
+
+// TestRemoteUnavailable checks that an unreachable remote tier leaves
+// save and restore to the cache, with a warning, and that a corrupt
+// entry in it fails restore with the integrity exit status.
+func TestRemoteUnavailable(t *testing.T) {
+	f := newFixture(t)
+	s := newRemoteServer(t)
+	url := s.URL + "/cache/"
+	f.install("./...")
+	f.mustRun("-remote", url, "save", "./...")
+	s.Close()
+
+	if out := f.mustRun("-remote", url, "save", "./..."); !strings.Contains(out, "unavailable, continuing with the local cache") {
+		t.Errorf("save did not warn about the unreachable remote tier:\n%s", out)
+	}
+	if err := os.RemoveAll(f.cache); err != nil {
+		t.Fatal(err)
+	}
+	f.removeOutputs()
+	out := f.mustRun("-remote", url, "restore", "./...")
+	if !strings.Contains(out, "0 hits, 4 misses") || strings.Count(out, "unavailable") != 1 {
+		t.Errorf("restore did not warn once about the unreachable remote tier:\n%s", out)
+	}
+
+	dir := filepath.Join(f.root, "remote")
+	url = "file://" + filepath.ToSlash(dir)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(f.cache); err != nil {
+		t.Fatal(err)
+	}
+	f.install("./...")
+	f.mustRun("-remote", url, "save", "./...")
+	for _, name := range dirObjects(t, dir) {
+		if strings.HasSuffix(name, "-lib") {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("corrupt"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.RemoveAll(f.cache); err != nil {
+		t.Fatal(err)
+	}
+	f.removeOutputs()
+	f.expectExit(exitIntegrity, "-remote", url, "restore", "./...")
+}
+
+// TestRemoteAsyncPush checks that a save with -remote-push=async leaves
+// the push to a background process which push -wait waits for, and that
+// push resumes the journal of a push which died.
+func TestRemoteAsyncPush(t *testing.T) {
+	f := newFixture(t)
+	s := newRemoteServer(t)
+	url := s.URL + "/cache/"
+	f.install("./...")
+	if out := f.mustRun("-remote", url, "-remote-push", "async", "save", "./..."); !strings.Contains(out, "4 pushing in the background") {
+		t.Errorf("save did not hand off the push:\n%s", out)
+	}
+	f.mustRun("-remote", url, "push", "-wait")
+	if n := countEntries(s.names()); n != 4 {
+		t.Errorf("%d entries in the remote tier after push -wait, want 4", n)
+	}
+	if entries, err := os.ReadDir(pushDir(f.cache)); err != nil {
+		t.Fatal(err)
+	} else if len(entries) > 0 {
+		t.Errorf("journals left after push -wait: %v", entries)
+	}
+	_, events, err := readStats(f.cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed := 0
+	for _, e := range events {
+		if e.Command == "push" {
+			pushed += e.Pushed
+		}
+	}
+	if pushed != 4 {
+		t.Errorf("stats record %d entries pushed, want 4", pushed)
+	}
+
+	// The journal of a push which died before it started.
+	s.mu.Lock()
+	s.objects = map[string][]byte{}
+	s.mu.Unlock()
+	var names []string
+	for _, pkg := range f.load("./...") {
+		if fp, _ := pkg.computeFingerprint(); pkg.cached() && fp != "" {
+			names = append(names, filepath.Base(lookupEntry(f.cache, fp)))
+		}
+	}
+	journal := filepath.Join(pushDir(f.cache), "1-1")
+	if err := os.WriteFile(journal, []byte(strings.Join(names, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out := f.mustRun("-remote", url, "push"); !strings.Contains(out, "pushed 4 of 4 entries") {
+		t.Errorf("push did not resume the journal:\n%s", out)
+	}
+	if exists(journal) {
+		t.Errorf("%s left after push", journal)
+	}
+
+	// Lets a background push still starting up finish before the
+	// fixture is removed.
+	time.Sleep(100 * time.Millisecond)
+}
+
+// TestRemoteUnavailablePolicies checks that a failing remote tier is
+// given up on after -remote-max-failures failures in a row, which is
+// reported as -remote-unavailable says.
+func TestRemoteUnavailablePolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy      string
+		maxFailures string
+		requests    int
+		warned      bool
+		status      int
+	}{
+		{policy: "ignore", maxFailures: "3", requests: 3},
+		{policy: "warn", maxFailures: "3", requests: 3, warned: true},
+		{policy: "warn", maxFailures: "10", requests: 4},
+		{policy: "fail", maxFailures: "3", requests: 3, status: exitCacheUnavailable},
+	} {
+		t.Run(test.policy+"/"+test.maxFailures, func(t *testing.T) {
+			f := newFixture(t)
+			s := newRemoteServer(t)
+			s.status = http.StatusServiceUnavailable
+			f.install("./...")
+			f.removeOutputs()
+
+			args := []string{"-j", "1", "-remote", s.URL + "/cache/", "-remote-unavailable", test.policy,
+				"-remote-max-failures", test.maxFailures, "restore", "./..."}
+			out, err := f.run(args...)
+			if status := exitCode(err); status != test.status {
+				t.Fatalf("exit status %d, want %d:\n%s", status, test.status, out)
+			}
+			if !strings.Contains(out, "0 hits, 4 misses") {
+				t.Errorf("restore did not continue with the local cache:\n%s", out)
+			}
+			if warned := strings.Contains(out, "warning: remote"); warned != test.warned {
+				t.Errorf("warned %t, want %t:\n%s", warned, test.warned, out)
+			}
+			s.mu.Lock()
+			requests := s.requests
+			s.mu.Unlock()
+			if requests != test.requests {
+				t.Errorf("%d requests, want %d", requests, test.requests)
+			}
+
+			_, events, err := readStats(f.cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("%d stats events, want 1", len(events))
+			}
+			gaveUp := 0
+			if test.requests < 4 {
+				gaveUp = 1
+			}
+			if e := events[0]; e.RemoteErrors != test.requests || e.RemoteUnavailable != gaveUp {
+				t.Errorf("stats record %d remote errors and %d runs giving up, want %d and %d",
+					e.RemoteErrors, e.RemoteUnavailable, test.requests, gaveUp)
+			}
+		})
+	}
+}
+
+// TestRemoteReadThroughMaxSize checks that the entries restore fetches
+// keep the cache within -max-size, by evicting the oldest entries or,
+// with -remote-stream-large, by not keeping the fetched entries.
+func TestRemoteReadThroughMaxSize(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%t", stream), func(t *testing.T) {
+			f := newFixture(t)
+			dir := filepath.Join(f.root, "remote")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			url := "file://" + filepath.ToSlash(dir)
+			f.install("./...")
+			f.mustRun("-remote", url, "save", "./...")
+			sizes := map[string]int64{}
+			entries, err := listEntries(f.cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				sizes[e.ImportPath()] = e.Size
+			}
+			if err := os.RemoveAll(f.cache); err != nil {
+				t.Fatal(err)
+			}
+			f.removeOutputs()
+
+			f.mustRun("-remote", url, "restore", "other.org/dep")
+			maxSize := sizes["other.org/dep"] + sizes["example.com/app/util"] - 1
+			args := []string{"-v", "-remote", url, "-max-size", strconv.FormatInt(maxSize, 10)}
+			if stream {
+				args = append(args, "-remote-stream-large")
+			}
+			out := f.mustRun(append(args, "restore", "example.com/app/util")...)
+			if !strings.Contains(out, "remote: 1 fetched") {
+				t.Errorf("restore did not fetch util:\n%s", out)
+			}
+			if evicted := strings.Contains(out, "evicted"); evicted == stream {
+				t.Errorf("evicted %t, want %t:\n%s", evicted, !stream, out)
+			}
+
+			cached := map[string]bool{}
+			entries, err = listEntries(f.cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				cached[e.ImportPath()] = true
+			}
+			if cached["other.org/dep"] != stream || cached["example.com/app/util"] == stream {
+				t.Errorf("cache holds %v", cached)
+			}
+			util := f.load("example.com/app/util")[0].Target
+			if !exists(util) {
+				t.Errorf("%s not restored", util)
+			}
+			if infos, err := os.ReadDir(filepath.Dir(util)); err != nil {
+				t.Fatal(err)
+			} else if len(infos) != 1 {
+				t.Errorf("temporary files left next to %s: %v", util, infos)
+			}
+		})
+	}
+}
+
+// TestRemoteThrottled checks that the requests the remote tier throttles
+// are retried, and that the throttling and the throughput are reported.
+func TestRemoteThrottled(t *testing.T) {
+	f := newFixture(t)
+	s := newRemoteServer(t)
+	s.throttle = 3
+	f.install("./...")
+	out := f.mustRun("-remote", s.URL+"/cache/", "-remote-max-bandwidth", "50MB/s", "save", "./...")
+	if !strings.Contains(out, "4 pushed") || !strings.Contains(out, "3 throttled") || strings.Contains(out, "errors") {
+		t.Errorf("save did not push the 4 entries despite the throttling:\n%s", out)
+	}
+	if n := countEntries(s.names()); n != 4 {
+		t.Errorf("%d entries in the remote tier, want 4", n)
+	}
+	_, events, err := readStats(f.cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("%d stats events, want 1", len(events))
+	}
+	if e := events[0]; e.Throttled != 3 || e.TransferBytes == 0 || e.TransferSeconds == 0 {
+		t.Errorf("stats record %d throttled requests and %d bytes in %gs", e.Throttled, e.TransferBytes, e.TransferSeconds)
+	}
+}
+
+// exitCode returns the exit status of a command which failed with err.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return -1
+	}
+	return 0
+}
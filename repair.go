@@ -0,0 +1,198 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Caches restored from archives or copied around by CI systems may be
+// partially damaged: a truncated .format, a file where a subdirectory
+// should be, metadata whose entry is gone or which cannot be decoded.
+// Rather than failing confusingly half-way through a run, build-cache
+// repairs what it finds as it first touches each part of the cache:
+// cheap repairs are made in place, entries which cannot be trusted are
+// quarantined and files which cannot be repaired are moved aside. The
+// healthy case costs nothing beyond the directory listings and
+// metadata reads a run makes anyway.
+
+// The kinds of repairs, as reported.
+const (
+	repairFormat         = "malformed .format moved aside"
+	repairNotDir         = "files in place of subdirectories moved aside"
+	repairOrphanMeta     = "orphaned metadata removed"
+	repairUnreadableMeta = "entries with unreadable metadata quarantined"
+)
+
+var repairs struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+// noteRepair counts a repair of the given kind, described by detail
+// under -v.
+func noteRepair(kind, detail string) {
+	vlogf("repaired cache: %s", detail)
+	repairs.Lock()
+	defer repairs.Unlock()
+	if repairs.counts == nil {
+		repairs.counts = map[string]int{}
+	}
+	repairs.counts[kind]++
+}
+
+// reportRepairs logs the repairs made to the cache during the run on a
+// single line.
+func reportRepairs() {
+	repairs.Lock()
+	defer repairs.Unlock()
+	if len(repairs.counts) == 0 {
+		return
+	}
+	var kinds []string
+	for kind := range repairs.counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var parts []string
+	for _, kind := range kinds {
+		parts = append(parts, fmt.Sprintf("%d %s", repairs.counts[kind], kind))
+	}
+	log.Printf("repaired the cache: %s (run with -v for details)", strings.Join(parts, ", "))
+}
+
+// repairCacheSubdirs moves aside the files of the cache dir standing
+// where its subdirectories should be, which would otherwise fail the
+// first command creating or reading them. Missing subdirectories are
+// created when first needed.
+func repairCacheSubdirs(dir string) {
+	for _, sub := range []string{testDir(dir), resultsDir(dir), quarantineDir(dir), statsDir(dir),
+		journalDir(dir), snapshotDir(dir), gocacheDir(dir), blobDir(dir), namespacesDir(dir)} {
+		fi, err := os.Lstat(sub)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		if err := moveAside(sub); err != nil {
+			log.Printf("warning: %s is not a directory and cannot be moved aside: %s", sub, err)
+			continue
+		}
+		noteRepair(repairNotDir, sub+" is not a directory")
+	}
+}
+
+// moveAside renames the file at path to path.corrupt-<time>, as
+// moveAsideAux does with auxiliary files.
+func moveAside(path string) error {
+	return os.Rename(path, asideName(path))
+}
+
+// removeOrphans removes the metadata and extra artifacts in the
+// directory of entries dir, listed by infos, whose entry no longer
+// exists. save writes an entry before its metadata and prune removes
+// them the other way round, so these are never part of an entry being
+// written.
+func removeOrphans(dir string, infos []os.DirEntry) {
+	names := map[string]bool{}
+	for _, info := range infos {
+		names[info.Name()] = true
+	}
+	for _, info := range infos {
+		name := info.Name()
+		var entry string
+		switch {
+		case !info.IsDir() && strings.HasSuffix(name, ".meta"):
+			entry = strings.TrimSuffix(name, ".meta")
+		case info.IsDir() && strings.HasSuffix(name, ".extra"):
+			entry = strings.TrimSuffix(name, ".extra")
+		default:
+			continue
+		}
+		if !isEntryName(entry) || names[entry] {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("warning: unable to remove orphaned %s: %s", path, err)
+			continue
+		}
+		noteRepair(repairOrphanMeta, path+" has no entry")
+	}
+}
+
+// A checkedEntry is the result of checking the metadata of an entry.
+type checkedEntry struct {
+	once sync.Once
+	ok   bool
+}
+
+var checkedEntries struct {
+	sync.Mutex
+	byPath map[string]*checkedEntry
+}
+
+// checkEntryMeta reports whether the metadata of the entry at path, in
+// the directory of entries dir, can be decoded, checking it the first
+// time the entry is looked up. An entry whose metadata cannot be
+// decoded, e.g. as it was truncated, cannot be verified and is
+// quarantined, and looking it up misses.
+func checkEntryMeta(dir, path string) bool {
+	checkedEntries.Lock()
+	if checkedEntries.byPath == nil {
+		checkedEntries.byPath = map[string]*checkedEntry{}
+	}
+	c := checkedEntries.byPath[path]
+	if c == nil {
+		c = &checkedEntry{}
+		checkedEntries.byPath[path] = c
+	}
+	checkedEntries.Unlock()
+	c.once.Do(func() {
+		_, err := readMeta(path)
+		var perr *os.PathError
+		if c.ok = err == nil || errors.As(err, &perr); c.ok {
+			// Failures to read it are reported by its readers.
+			return
+		}
+		reason := "unreadable metadata: " + err.Error()
+		if err := quarantine(entriesCacheDir(dir), path, reason); err != nil {
+			log.Printf("warning: %s: %s, unable to quarantine it: %s", path, reason, err)
+		} else {
+			noteRepair(repairUnreadableMeta, path+": "+reason)
+		}
+		entryIndexMu.Lock()
+		delete(entryIndex(dir), entryFingerprint(filepath.Base(path)))
+		entryIndexMu.Unlock()
+	})
+	return c.ok
+}
+
+// entriesCacheDir returns the cache dir holding the directory of entries
+// dir, which is either the cache dir itself or one of its
+// subdirectories.
+func entriesCacheDir(dir string) string {
+	parent := filepath.Dir(dir)
+	if dir == testDir(parent) || dir == resultsDir(parent) {
+		return parent
+	}
+	return dir
+}
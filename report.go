@@ -0,0 +1,149 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+var reportFile = flag.String("report", "",
+	"write a JSON report of the action taken for each package to this file")
+
+// reportVersion is the version of the format written by --report. The
+// format is a contract with the tools reading it: fields may be added
+// without changing the version, but not removed or changed.
+const reportVersion = 1
+
+// The actions reported for a package.
+const (
+	actionRestored = "restored" // the output was restored from the cache
+	actionKept     = "kept"     // the output had already been restored
+	actionSaved    = "saved"    // the output was saved to the cache
+	actionPresent  = "present"  // the cache already held the output
+	actionMissed   = "missed"   // the cache held no usable output
+	actionSkipped  = "skipped"  // the package was not cacheable or installable
+)
+
+// A report is the file written by --report. It holds a section for
+// each phase, of which a restore or a save writes one.
+type report struct {
+	Version int            `json:"version"`
+	Restore *reportSection `json:"restore,omitempty"`
+	Save    *reportSection `json:"save,omitempty"`
+}
+
+// A reportSection lists the packages processed by a phase.
+type reportSection struct {
+	Cache    string           `json:"cache"`
+	Start    time.Time        `json:"start"`
+	Packages []*reportPackage `json:"packages"`
+}
+
+// A reportPackage describes what was done for a package, or for the
+// test binary of one.
+type reportPackage struct {
+	ImportPath  string     `json:"importPath"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Action      string     `json:"action"`
+	Result      string     `json:"result"`           // hit, miss, stale or expired
+	Target      string     `json:"target,omitempty"` // package output or test binary
+	Checksum    string     `json:"checksum,omitempty"`
+	Mtime       *time.Time `json:"mtime,omitempty"` // of the target, if it exists
+
+	// The cause of a miss, and a sample of the changed inputs if
+	// they changed and are known.
+	Cause         string   `json:"cause,omitempty"`
+	ChangedInputs []string `json:"changedInputs,omitempty"`
+}
+
+// report buffers the entry of --report for a package. The checksum and
+// modification time are those of target when it holds the output in
+// the cache; mtime is the one assigned by a restore, or zero to read
+// it.
+func (r *pkgReport) report(importPath, fp, result, action, target string, mtime time.Time) {
+	if *reportFile == "" {
+		return
+	}
+	p := &reportPackage{
+		ImportPath:  importPath,
+		Fingerprint: fp,
+		Action:      action,
+		Result:      result,
+		Target:      target,
+	}
+	switch action {
+	case actionRestored, actionKept, actionSaved, actionPresent:
+		sum, _, err := fileChecksum(target)
+		if err != nil {
+			log.Fatalf("%s: %s", importPath, err)
+		}
+		p.Checksum = sum
+		if mtime.IsZero() {
+			fi, err := os.Stat(target)
+			if err != nil {
+				log.Fatalf("%s: %s", importPath, err)
+			}
+			mtime = fi.ModTime()
+		}
+		p.Mtime = &mtime
+	}
+	r.reported = append(r.reported, p)
+}
+
+// reportMiss buffers the entry of --report for a package whose output
+// was missed for the cause c.
+func (r *pkgReport) reportMiss(importPath, fp, result, action, target string, c missCause) {
+	r.report(importPath, fp, result, action, target, time.Time{})
+	if *reportFile == "" {
+		return
+	}
+	p := r.reported[len(r.reported)-1]
+	p.Cause = c.Cause
+	p.ChangedInputs = c.Changed
+}
+
+// writeReport writes the packages reported by s to --report.
+func writeReport(s *summary) {
+	if *reportFile == "" {
+		return
+	}
+	rep := &report{Version: reportVersion}
+	section := &reportSection{
+		Cache:    cacheDir(),
+		Start:    s.start,
+		Packages: s.reported,
+	}
+	if section.Packages == nil {
+		section.Packages = []*reportPackage{}
+	}
+	switch s.Command {
+	case "restore":
+		rep.Restore = section
+	case "save":
+		rep.Save = section
+	}
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeFileAtomic(*reportFile, append(data, '\n')); err != nil {
+		log.Fatal(err)
+	}
+}
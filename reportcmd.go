@@ -0,0 +1,154 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	reportRows = flag.Int("max-rows", 20,
+		"maximum number of directories listed by the report command")
+	minHitRate = flag.Float64("min-hit-rate", 0,
+		"make the report command exit with status 1 if the predicted hit rate is below this percentage")
+)
+
+// A reportDir is a directory of packages to rebuild in the output of
+// the report command.
+type reportDir struct {
+	Dir         string
+	Packages    int
+	Seconds     float64 // the build time recorded for them
+	Unestimated int     // the packages without a recorded build time
+}
+
+// reportCmd prints what a restore of the packages named by args would
+// miss, for posting on a code review, without changing anything: the
+// packages to rebuild by directory, their estimated rebuild time and the
+// predicted hit rate. It exits with status 1 if the hit rate is below
+// --min-hit-rate.
+func reportCmd(args []string) {
+	if *outputFormat != "" && *outputFormat != "markdown" {
+		log.Fatalf("unknown report format \"%s\"", *outputFormat)
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	dir := cacheDir()
+	start := time.Now()
+	pkgs := loadAll(args)
+	vlogf("finished loading: %s", time.Since(start))
+
+	rep := computeImpact(pkgs, dir)
+	byDir := map[string]*reportDir{}
+	var cached, hits, rebuilt, unestimated int
+	var seconds float64
+	for _, pkg := range pkgs {
+		if !pkg.cached() {
+			continue
+		}
+		cached++
+		if src, _ := lookupNamespaced(dir, pkg.Fingerprint(), nil); src != "" {
+			hits++
+			continue
+		}
+		rebuilt++
+		d := path.Dir(pkg.baseImportPath)
+		if byDir[d] == nil {
+			byDir[d] = &reportDir{Dir: d}
+		}
+		byDir[d].Packages++
+		if e := rep.previous[pkg]; e != nil && e.Meta.BuildSeconds > 0 {
+			byDir[d].Seconds += e.Meta.BuildSeconds
+			seconds += e.Meta.BuildSeconds
+		} else {
+			byDir[d].Unestimated++
+			unestimated++
+		}
+	}
+	rate := 100.0
+	if cached > 0 {
+		rate = 100 * float64(hits) / float64(cached)
+	}
+
+	var dirs []*reportDir
+	for _, d := range byDir {
+		dirs = append(dirs, d)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		a, b := dirs[i], dirs[j]
+		if a.Packages != b.Packages {
+			return a.Packages > b.Packages
+		}
+		return a.Dir < b.Dir
+	})
+
+	title := fmt.Sprintf("This change will rebuild %d packages", rebuilt)
+	if seconds > 0 {
+		title += " (est. " + formatRebuildTime(seconds, unestimated) + ")"
+	}
+	fmt.Printf("### %s\n\n", title)
+	fmt.Printf("Predicted hit rate: **%.1f%%** (%d of %d packages)", rate, hits, cached)
+	if *minHitRate > 0 {
+		fmt.Printf(", minimum %.1f%%", *minHitRate)
+	}
+	fmt.Print("\n")
+	if len(dirs) > 0 {
+		fmt.Print("\n| Directory | Packages | Est. rebuild |\n|---|---:|---:|\n")
+		for i, d := range dirs {
+			if i == *reportRows {
+				fmt.Printf("| and %d more | | |\n", len(dirs)-i)
+				break
+			}
+			est := "-"
+			if d.Seconds > 0 {
+				est = formatRebuildTime(d.Seconds, d.Unestimated)
+			}
+			fmt.Printf("| `%s` | %d | %s |\n", d.Dir, d.Packages, est)
+		}
+	}
+	if len(rep.Changed) > 0 {
+		var top []string
+		for i, r := range rep.Changed {
+			if i == impactTop {
+				top = append(top, fmt.Sprintf("and %d more", len(rep.Changed)-i))
+				break
+			}
+			top = append(top, fmt.Sprintf("`%s` (%d dependents)", r.ImportPath, r.Dependents))
+		}
+		fmt.Printf("\nTop offenders: %s\n", strings.Join(top, ", "))
+	}
+	if *minHitRate > 0 && rate < *minHitRate {
+		os.Exit(1)
+	}
+}
+
+// formatRebuildTime formats the recorded build time seconds, noting the
+// packages without one as making it a lower bound.
+func formatRebuildTime(seconds float64, unestimated int) string {
+	s := time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+	if unestimated > 0 {
+		s = ">" + s
+	}
+	return s
+}
@@ -0,0 +1,210 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxResultOutput bounds the test output recorded in a test result.
+const maxResultOutput = 4096
+
+var noCache = flag.Bool("no-cache", false, "run tests even if a passing result is cached")
+
+// A testResult records a passing run of a package's tests.
+type testResult struct {
+	ImportPath string  `json:"importPath"`
+	Seconds    float64 `json:"seconds"`
+	Output     string  `json:"output"` // truncated to the last maxResultOutput bytes
+}
+
+// resultsDir returns the directory within the cache holding test
+// results.
+func resultsDir(dir string) string {
+	return filepath.Join(dir, "results")
+}
+
+// resultKey returns the key for the result of running the tests of pkg
+// with the specified go test flags. pkg must have a test fingerprint.
+func resultKey(pkg *Package, testFlags []string) string {
+	h := sha1.New()
+	inputs := stringList("result", pkg.ImportPath, pkg.TestFingerprint(), testFlags,
+		"GOEXPERIMENT="+os.Getenv("GOEXPERIMENT"))
+	for _, s := range inputs {
+		if _, err := h.Write([]byte(s)); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := h.Write([]byte{0}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bypassResults reports whether the go test flags ask for the tests to
+// be run regardless of cached results.
+func bypassResults(testFlags []string) bool {
+	if *noCache {
+		return true
+	}
+	for i, f := range testFlags {
+		f = "-" + strings.TrimLeft(f, "-")
+		if f == "-count=1" || (f == "-count" && i+1 < len(testFlags) && testFlags[i+1] == "1") {
+			return true
+		}
+	}
+	return false
+}
+
+// test runs the tests of the specified packages, replaying the cached
+// results of tests that passed with the same test fingerprint and
+// flags. The go test flags are given after "--". Failures are never
+// cached, nor are the results of packages which cannot be
+// fingerprinted, whose tests always run. The cache is locked only while results are read and written,
+// not while the tests run.
+func test(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	testFlags := passArgs
+	if contains(testFlags, "-race") || contains(testFlags, "--race") {
+		for i, arg := range args {
+			if !contains(packageOptions(arg), "race") {
+				args[i] = arg + ":race"
+			}
+		}
+	}
+
+	dir := cacheDir()
+	if err := os.MkdirAll(resultsDir(dir), 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	*includeTests = true
+	pkgs := packagesForBuild(args)
+	bypass := bypassResults(testFlags)
+
+	s := newSummary("test")
+	failed := false
+	for _, pkg := range pkgs {
+		if len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) == 0 {
+			fmt.Printf("?   \t%s\t[no test files]\n", pkg.baseImportPath)
+			continue
+		}
+		// A package which cannot be fingerprinted has no inputs to key
+		// its result by.
+		cacheable := pkg.TestFingerprint() != ""
+		var key, path string
+		if cacheable {
+			key = resultKey(pkg, testFlags)
+			path = filepath.Join(resultsDir(dir), key)
+		}
+		if cacheable && !bypass {
+			// The shared lock keeps a concurrent clear or prune from
+			// removing the result while it is read.
+			unlock := useCache(dir, false)
+			r, err := readTestResult(path)
+			unlock()
+			if err != nil {
+				log.Printf("%s: %s", path, err)
+			} else if r != nil {
+				fmt.Printf("ok  \t%s\t(cached)\n", pkg.baseImportPath)
+				s.record(pkg.ImportPath, key, resultHit)
+				continue
+			}
+		}
+		s.record(pkg.ImportPath, key, resultMiss)
+
+		r, err := runTests(pkg, testFlags)
+		if err != nil {
+			failed = true
+			continue
+		}
+		if !cacheable {
+			continue
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		unlock := useCache(dir, false)
+		err = writeFileAtomic(path, data)
+		unlock()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	s.finish()
+	if err := s.err(); err != nil {
+		fatal(err)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runTests runs "go test" for pkg, copying its output to stdout. It
+// returns the result if the tests passed.
+func runTests(pkg *Package, testFlags []string) (*testResult, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(goCmd(), stringList("test", testFlags, pkg.baseImportPath)...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	start := time.Now()
+	err := cmd.Run()
+	os.Stdout.Write(out.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	output := out.String()
+	if len(output) > maxResultOutput {
+		output = output[len(output)-maxResultOutput:]
+	}
+	return &testResult{
+		ImportPath: pkg.ImportPath,
+		Seconds:    time.Since(start).Seconds(),
+		Output:     output,
+	}, nil
+}
+
+// readTestResult reads the test result at path, returning nil if there
+// is no such result.
+func readTestResult(path string) (*testResult, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &testResult{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
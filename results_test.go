@@ -0,0 +1,87 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestTestResults checks that the tests of packages run once and are
+// then replayed from the cache, except for those of uncacheable
+// packages, which always run and have no result recorded, however many
+// of them there are.
+func TestTestResults(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the go command running the tests is a shell script")
+	}
+	pkgs := []string{"example.com/app/lib", "example.com/app/util"}
+	for _, test := range []struct {
+		name      string
+		annotated []string // uncacheable
+	}{
+		{name: "cacheable"},
+		{name: "one uncacheable", annotated: []string{"example.com/app/lib"}},
+		{name: "two uncacheable", annotated: pkgs},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.writeFile("example.com/app/lib/lib_test.go", "package lib\n\nimport \"testing\"\n\nfunc TestGreet(t *testing.T) {}\n")
+			for _, importPath := range test.annotated {
+				f.writeFile(importPath+"/"+packageAnnotationFile, `{"cacheable": false}`)
+			}
+			// The go command records the packages it tests.
+			runs := filepath.Join(f.root, "runs")
+			goCmd := filepath.Join(f.root, "go")
+			script := "#!/bin/sh\nif [ \"$1\" = test ]; then\n\tfor p; do last=$p; done\n\techo $last >> '" + runs + "'\n\techo \"ok  \t$last\t0.01s\"\n\texit 0\nfi\necho go version go1.27.1 linux/amd64\n"
+			if err := os.WriteFile(goCmd, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			for run := 0; run < 2; run++ {
+				if err := os.Remove(runs); err != nil && !os.IsNotExist(err) {
+					t.Fatal(err)
+				}
+				out := f.mustRun("-go", goCmd, "test", "./lib", "./util")
+				data, err := os.ReadFile(runs)
+				if err != nil && !os.IsNotExist(err) {
+					t.Fatal(err)
+				}
+				for _, importPath := range pkgs {
+					uncacheable := contains(test.annotated, importPath)
+					ran := contains(strings.Fields(string(data)), importPath)
+					if want := run == 0 || uncacheable; ran != want {
+						t.Errorf("run %d: %s: tests run = %v, want %v:\n%s", run, importPath, ran, want, out)
+					}
+					replayed := strings.Contains(out, "ok  \t"+importPath+"\t(cached)")
+					if want := run > 0 && !uncacheable; replayed != want {
+						t.Errorf("run %d: %s: result replayed = %v, want %v:\n%s", run, importPath, replayed, want, out)
+					}
+				}
+			}
+			results, err := os.ReadDir(resultsDir(f.cache))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := len(pkgs) - len(test.annotated); len(results) != want {
+				t.Errorf("%d results recorded, want %d", len(results), want)
+			}
+		})
+	}
+}
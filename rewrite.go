@@ -0,0 +1,102 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// An importRewrite fingerprints the packages under the import path from
+// as if they were under to, so that the same sources under a fork or a
+// vanity import path share entries with the canonical ones.
+type importRewrite struct {
+	from, to string
+}
+
+// importRewritesFlag is the flag.Value of the repeatable
+// --rewrite-import.
+type importRewritesFlag []importRewrite
+
+func (f *importRewritesFlag) String() string {
+	var s []string
+	for _, r := range *f {
+		s = append(s, r.from+"="+r.to)
+	}
+	return strings.Join(s, ",")
+}
+
+// Set parses FROM=TO.
+func (f *importRewritesFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || i == len(s)-1 {
+		return fmt.Errorf("invalid import path rewrite \"%s\": want FROM=TO", s)
+	}
+	*f = append(*f, importRewrite{from: strings.TrimSuffix(s[:i], "/"), to: strings.TrimSuffix(s[i+1:], "/")})
+	return nil
+}
+
+var importRewrites importRewritesFlag
+
+func init() {
+	flag.Var(&importRewrites, "rewrite-import",
+		"fingerprint the packages under the import path FROM as if they were under TO, given as FROM=TO (repeatable)")
+}
+
+// rewriteImportPath returns the import path path with the longest
+// matching --rewrite-import prefix replaced.
+func rewriteImportPath(path string) string {
+	var match *importRewrite
+	for i, r := range importRewrites {
+		if (path == r.from || strings.HasPrefix(path, r.from+"/")) &&
+			(match == nil || len(r.from) > len(match.from)) {
+			match = &importRewrites[i]
+		}
+	}
+	if match == nil {
+		return path
+	}
+	return match.to + path[len(match.from):]
+}
+
+// fingerprintImportPath returns the import path folded into the
+// fingerprint of p: its own, with options such as ":race", unless
+// --rewrite-import rewrites it. The metadata of entries still records
+// the actual import path, and outputs are restored to the actual Target.
+func (p *Package) fingerprintImportPath() string {
+	path := rewriteImportPath(p.baseImportPath)
+	if path == p.baseImportPath {
+		return p.ImportPath
+	}
+	log.Printf("notice: fingerprinting %s as %s (-rewrite-import)", p.baseImportPath, path)
+	return path + strings.TrimPrefix(p.ImportPath, p.baseImportPath)
+}
+
+// sameOwner reports whether the entry saved for the import path
+// entryPath may hold the output of the package with the import path
+// importPath, the same or rewritten to the same by --rewrite-import.
+func sameOwner(entryPath, importPath string) bool {
+	if entryPath == importPath {
+		return true
+	}
+	canonical := func(path string) string {
+		base := packageBaseImportPath(path)
+		return rewriteImportPath(base) + path[len(base):]
+	}
+	return canonical(entryPath) == canonical(importPath)
+}
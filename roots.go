@@ -0,0 +1,199 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	// altRootDefault is the root onto which Targets are rebased unless
+	// altRootsByRoot maps the GOPATH entry holding them elsewhere.
+	altRootDefault string
+	altRootsByRoot map[string]string
+)
+
+// setAltRoot sets altRoot to the value of --target-root or --from-root:
+// either a directory onto which all the Targets are rebased, or a
+// comma-separated list of ROOT=DIR rebasing the Targets under the GOPATH
+// entry ROOT onto DIR, optionally along with a directory for the Targets
+// under the other entries.
+func setAltRoot(s string) error {
+	altRoot = s
+	altRootDefault, altRootsByRoot = "", nil
+	if !strings.Contains(s, "=") {
+		altRootDefault = s
+		return nil
+	}
+	altRootsByRoot = map[string]string{}
+	for _, m := range strings.Split(s, ",") {
+		i := strings.IndexByte(m, '=')
+		if i == -1 {
+			if altRootDefault != "" {
+				return fmt.Errorf("invalid root mapping \"%s\": more than one default directory", s)
+			}
+			altRootDefault = m
+			continue
+		}
+		root, dir := m[:i], m[i+1:]
+		if root == "" || dir == "" {
+			return fmt.Errorf("invalid root mapping \"%s\": expected ROOT=DIR", m)
+		}
+		root = cleanRoot(root)
+		if !contains(gopathEntries(), root) {
+			log.Printf("warning: %s is not a GOPATH entry", root)
+		}
+		altRootsByRoot[root] = dir
+	}
+	return nil
+}
+
+// cleanRoot returns the absolute, clean form of the directory root.
+func cleanRoot(root string) string {
+	if abs, err := filepath.Abs(root); err == nil {
+		return abs
+	}
+	return filepath.Clean(root)
+}
+
+// installRoot returns the root under which p is installed: its GOPATH
+// entry or GOROOT, or in module mode the GOPATH entry containing its
+// Target.
+func (p *Package) installRoot() string {
+	if p.Goroot && p.Target != "" && !underDir(p.Target, p.Root) {
+		// A race-instrumented standard package installed under GOPATH
+		// as GOROOT cannot be written (see stdRacePkgdir).
+		if entry := gopathEntry(p.Target); entry != "" {
+			return cleanRoot(entry)
+		}
+	}
+	if p.Root != "" {
+		return cleanRoot(p.Root)
+	}
+	if entry := gopathEntry(p.Target); entry != "" {
+		return cleanRoot(entry)
+	}
+	return ""
+}
+
+// altRootFor returns the root onto which the Target of p is rebased, or
+// "" if it is not.
+func (p *Package) altRootFor() string {
+	if dir, ok := altRootsByRoot[p.installRoot()]; ok {
+		return dir
+	}
+	return altRootDefault
+}
+
+// A rootStats accounts for the packages installed under one root during
+// a save or restore.
+type rootStats struct {
+	Root     string `json:"root"`
+	Target   string `json:"target,omitempty"` // the directory Targets are rebased onto
+	Writable bool   `json:"writable"`
+	Packages int    `json:"packages"`
+	Skipped  int    `json:"skipped,omitempty"` // as not writable
+}
+
+// installRoots returns the roots of the non-standard packages in pkgs,
+// and of the standard ones instrumented with the race detector, by
+// root. Restoring also checks that the directories Targets are placed
+// under can be written; the packages of the other roots are to be
+// skipped rather than failing the restore.
+func installRoots(pkgs []*Package, restoring bool) map[string]*rootStats {
+	roots := map[string]*rootStats{}
+	for _, pkg := range pkgs {
+		if !pkg.cached() || pkg.Target == "" {
+			continue
+		}
+		root := pkg.installRoot()
+		rs := roots[root]
+		if rs == nil {
+			rs = &rootStats{Root: root, Writable: true}
+			if !pkg.Goroot {
+				rs.Target = pkg.altRootFor()
+			}
+			if restoring {
+				dir := rs.Target
+				if dir == "" {
+					dir = root
+				}
+				rs.Writable = writableDir(dir)
+			}
+			roots[root] = rs
+		}
+		rs.Packages++
+	}
+	for _, rs := range roots {
+		if !rs.Writable {
+			rs.Skipped = rs.Packages
+		}
+	}
+	return roots
+}
+
+// writableDir reports whether files can be created in dir, which is
+// created if needed.
+func writableDir(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false
+	}
+	f, err := os.CreateTemp(dir, ".build-cache-preflight-")
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return true
+}
+
+// sortedRoots returns the roots sorted by path.
+func sortedRoots(roots map[string]*rootStats) []*rootStats {
+	var list []*rootStats
+	for _, rs := range roots {
+		list = append(list, rs)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Root < list[j].Root })
+	return list
+}
+
+// formatRoots returns the per-root line of a summary, or "" if all the
+// packages are under a single writable root.
+func formatRoots(roots []*rootStats) string {
+	if len(roots) == 0 || len(roots) == 1 && roots[0].Writable {
+		return ""
+	}
+	var parts []string
+	for _, rs := range roots {
+		s := fmt.Sprintf("%s %d packages", rs.Root, rs.Packages)
+		if rs.Target != "" {
+			s += " onto " + rs.Target
+		}
+		if !rs.Writable {
+			s += fmt.Sprintf(" (not writable, %d skipped)", rs.Skipped)
+		}
+		parts = append(parts, s)
+	}
+	return "roots: " + strings.Join(parts, "; ")
+}
@@ -0,0 +1,192 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSetAltRoot(t *testing.T) {
+	f := newFixture(t)
+	second := filepath.Join(f.root, "gopath2")
+	build.Default.GOPATH = f.gopath + string(filepath.ListSeparator) + second
+	for _, test := range []struct {
+		value  string // $A and $B are the GOPATH entries
+		def    string
+		byRoot map[string]string // by GOPATH entry, $A or $B
+		err    bool
+	}{
+		{value: ""},
+		{value: "/alt", def: "/alt"},
+		{value: "$B=/alt2", byRoot: map[string]string{"$B": "/alt2"}},
+		{value: "$A=/alt1,$B=/alt2", byRoot: map[string]string{"$A": "/alt1", "$B": "/alt2"}},
+		{value: "$B=/alt2,/alt", def: "/alt", byRoot: map[string]string{"$B": "/alt2"}},
+		{value: "$B/=/alt2", byRoot: map[string]string{"$B": "/alt2"}},
+		{value: "$B=/alt2,/alt,/other", err: true},
+		{value: "$B=", err: true},
+		{value: "=/alt2", err: true},
+	} {
+		expand := strings.NewReplacer("$A", f.gopath, "$B", second).Replace
+		value := expand(test.value)
+		err := setAltRoot(value)
+		if (err != nil) != test.err {
+			t.Errorf("setAltRoot(%q): %v, want error %v", value, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		byRoot := map[string]string{}
+		for root, dir := range test.byRoot {
+			byRoot[expand(root)] = dir
+		}
+		if len(byRoot) == 0 {
+			byRoot = nil
+		}
+		if altRootDefault != test.def || !reflect.DeepEqual(altRootsByRoot, byRoot) {
+			t.Errorf("setAltRoot(%q): default %q, by root %v, want %q, %v",
+				value, altRootDefault, altRootsByRoot, test.def, byRoot)
+		}
+	}
+	_ = setAltRoot("")
+}
+
+// TestMixedRoots checks saves and restores of packages spread over two
+// GOPATH entries, other.org/dep being in the second, and the per-root
+// accounting of their summaries.
+func TestMixedRoots(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		setup func(f *fixture) // after saving and removing the outputs
+		args  []string         // $ROOT is the fixture root, $A and $B the GOPATH entries
+		// Where other.org/dep and the others are restored, relative to
+		// the fixture root, "" for not restored.
+		dep, others string
+		roots       string // the per-root summary line
+	}{
+		{
+			name:   "in place",
+			args:   []string{"restore", "./..."},
+			dep:    "gopath2",
+			others: "gopath",
+			roots:  "roots: $A 3 packages; $B 1 packages",
+		},
+		{
+			name:   "one root elsewhere",
+			args:   []string{"-target-root", "$B=$ROOT/alt2", "restore", "./..."},
+			dep:    "alt2",
+			others: "gopath",
+			roots:  "roots: $A 3 packages; $B 1 packages onto $ROOT/alt2",
+		},
+		{
+			name:   "both roots elsewhere",
+			args:   []string{"-target-root", "$B=$ROOT/alt2,$ROOT/alt", "restore", "./..."},
+			dep:    "alt2",
+			others: "alt",
+			roots:  "roots: $A 3 packages onto $ROOT/alt; $B 1 packages onto $ROOT/alt2",
+		},
+		{
+			name: "unwritable root",
+			setup: func(f *fixture) {
+				if err := os.WriteFile(filepath.Join(f.root, "file"), nil, 0644); err != nil {
+					f.t.Fatal(err)
+				}
+			},
+			args:   []string{"-target-root", "$B=$ROOT/file", "restore", "./..."},
+			others: "gopath",
+			roots:  "roots: $A 3 packages; $B 1 packages onto $ROOT/file (not writable, 1 skipped)",
+		},
+		{
+			name: "saved from elsewhere",
+			setup: func(f *fixture) {
+				// Save the outputs of the second root again from alt2.
+				if err := os.RemoveAll(f.cache); err != nil {
+					f.t.Fatal(err)
+				}
+				f.install("./...")
+				if err := os.Rename(filepath.Join(f.root, "gopath2", "pkg"), filepath.Join(f.root, "alt2", "pkg")); err != nil && !os.IsNotExist(err) {
+					f.t.Fatal(err)
+				}
+				f.mustRun("-from-root", filepath.Join(f.root, "gopath2")+"="+filepath.Join(f.root, "alt2"), "save", "./...")
+				f.removeOutputs()
+				if err := os.RemoveAll(filepath.Join(f.root, "alt2")); err != nil {
+					f.t.Fatal(err)
+				}
+			},
+			args:   []string{"restore", "./..."},
+			dep:    "gopath2",
+			others: "gopath",
+			roots:  "roots: $A 3 packages; $B 1 packages",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			second := filepath.Join(f.root, "gopath2")
+			if err := os.MkdirAll(filepath.Join(second, "src"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(f.dir("other.org"), filepath.Join(second, "src", "other.org")); err != nil {
+				t.Fatal(err)
+			}
+			build.Default.GOPATH = f.gopath + string(filepath.ListSeparator) + second
+			t.Setenv("GOPATH", build.Default.GOPATH)
+			f.install("./...")
+			f.mustRun("save", "./...")
+			f.removeOutputs()
+			if test.setup != nil {
+				if err := os.MkdirAll(filepath.Join(f.root, "alt2"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				test.setup(f)
+			}
+
+			expand := strings.NewReplacer("$ROOT", f.root, "$A", f.gopath, "$B", second).Replace
+			var args []string
+			for _, arg := range test.args {
+				args = append(args, expand(arg))
+			}
+			out := f.mustRun(args...)
+			if roots := regexp.MustCompile(`(?m)roots: .*$`).FindString(out); roots != expand(test.roots) {
+				t.Errorf("summary %q, want %q:\n%s", roots, expand(test.roots), out)
+			}
+			for _, pkg := range f.load("./...") {
+				if !pkg.cached() {
+					continue
+				}
+				where, root := test.others, f.gopath
+				if pkg.ImportPath == "other.org/dep" {
+					where, root = test.dep, second
+				}
+				rel, err := filepath.Rel(root, pkg.Target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, dir := range []string{"gopath", "gopath2", "alt", "alt2"} {
+					target := filepath.Join(f.root, dir, rel)
+					if restored := exists(target); restored != (dir == where) {
+						t.Errorf("%s: restored to %s %v, want %v", pkg.ImportPath, dir, restored, dir == where)
+					}
+				}
+			}
+		})
+	}
+}
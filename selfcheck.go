@@ -0,0 +1,128 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// fingerprintPass loads the packages named by args from scratch and
+// fingerprints them in a random order, returning the packages indexed
+// by import path.
+func fingerprintPass(args []string) map[string]*Package {
+	packageCache = map[string]*Package{}
+	pkgs := loadAll(args)
+	rand.Shuffle(len(pkgs), func(i, j int) {
+		pkgs[i], pkgs[j] = pkgs[j], pkgs[i]
+	})
+
+	m := map[string]*Package{}
+	for _, pkg := range pkgs {
+		if !pkg.cached() {
+			continue
+		}
+		pkg.Fingerprint()
+		m[pkg.ImportPath] = pkg
+	}
+	return m
+}
+
+// manifestDiff returns the inputs that differ between two manifests.
+func manifestDiff(a, b map[string]string) []string {
+	var diff []string
+	for input, digest := range a {
+		if d, ok := b[input]; !ok || d != digest {
+			diff = append(diff, input)
+		}
+	}
+	for input := range b {
+		if _, ok := a[input]; !ok {
+			diff = append(diff, input)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// selfcheck fingerprints the packages twice in independent passes and
+// reports any package whose fingerprint differs between the passes. It
+// also verifies that the cache entries for the packages match the
+// checksums recorded in their metadata.
+func selfcheck(args []string) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	log.Printf("checking %s", args)
+
+	first := fingerprintPass(args)
+	second := fingerprintPass(args)
+
+	var paths []string
+	for importPath := range second {
+		paths = append(paths, importPath)
+	}
+	sort.Strings(paths)
+
+	failures := 0
+	dir := cacheDir()
+	for _, importPath := range paths {
+		pkg := second[importPath]
+		fp := pkg.Fingerprint()
+		if prev, ok := first[importPath]; ok && prev.Fingerprint() != fp {
+			log.Printf("%-40s  %s: nondeterministic fingerprint", fp, importPath)
+			for _, input := range manifestDiff(prev.manifest, pkg.manifest) {
+				log.Printf("%-40s    %s", "", input)
+			}
+			markUncacheable(pkg, "nondeterministic fingerprint")
+			failures++
+			continue
+		}
+
+		entry := lookupEntry(dir, fp)
+		if entry == "" {
+			continue
+		}
+		meta, err := readMeta(entry)
+		if err != nil {
+			log.Printf("%-40s  %s: %s", fp, importPath, err)
+			failures++
+			continue
+		}
+		if meta == nil {
+			continue
+		}
+		sum, _, err := fileChecksum(entry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sum != meta.Checksum {
+			log.Printf("%-40s  %s: cache entry does not match its checksum", fp, importPath)
+			if err := quarantine(dir, entry, "checksum does not match"); err != nil {
+				log.Fatal(err)
+			}
+			failures++
+		}
+	}
+
+	saveDenylist(dir)
+	log.Printf("%d packages, %d failures", len(paths), failures)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// skewThreshold is the clock skew beyond which a filesystem's clock is
+// considered out of sync with the local clock. It allows for coarse
+// modification time granularity.
+const skewThreshold = 2 * time.Second
+
+// fsSkew returns how far ahead of the local clock the clock of the
+// filesystem holding dir is, measured by the modification time given to
+// a probe file created there. The nearest existing parent of dir is
+// used if dir does not exist.
+func fsSkew(dir string) (time.Duration, error) {
+	for !exists(dir) && filepath.Dir(dir) != dir {
+		dir = filepath.Dir(dir)
+	}
+	f, err := os.CreateTemp(dir, ".skew-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	before := time.Now()
+	_, err = f.Write([]byte{0})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	after := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return 0, err
+	}
+	switch mtime := fi.ModTime(); {
+	case mtime.After(after):
+		return mtime.Sub(after), nil
+	case mtime.Before(before):
+		return mtime.Sub(before), nil
+	}
+	return 0, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// checkSkew measures and reports the clock skew of the filesystem
+// holding dir, warning if it exceeds skewThreshold.
+func checkSkew(what, dir string) time.Duration {
+	skew, err := fsSkew(dir)
+	if err != nil {
+		vlogf("unable to measure clock skew of %s (%s): %s", what, dir, err)
+		return 0
+	}
+	vlogf("clock skew of %s (%s): %s", what, dir, skew)
+	if absDuration(skew) > skewThreshold {
+		log.Printf("warning: the clock of the filesystem holding %s (%s) is off by %s", what, dir, skew)
+	}
+	return skew
+}
+
+// newestSource returns the newest modification time of the source files
+// of pkgs.
+func newestSource(pkgs []*Package) time.Time {
+	var newest time.Time
+	for _, pkg := range pkgs {
+		srcs := stringList(pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles,
+			pkg.MFiles, pkg.HFiles, pkg.SFiles, pkg.SwigFiles, pkg.SwigCXXFiles, pkg.SysoFiles)
+		for _, src := range srcs {
+			if fi, err := os.Stat(filepath.Join(pkg.Dir, src)); err == nil && fi.ModTime().After(newest) {
+				newest = fi.ModTime()
+			}
+		}
+	}
+	return newest
+}
+
+// restoreStamp returns the modification time given to restored Targets.
+// Normally that is now, but when the clock of the filesystem holding the
+// sources is ahead of the local clock, freshly checked out sources would
+// be newer than Targets stamped now and everything would be rebuilt, so
+// the Targets are stamped after the newest source instead.
+func restoreStamp(pkgs []*Package, now time.Time) time.Time {
+	var srcDir, targetDir string
+	for _, pkg := range pkgs {
+		if pkg.root && srcDir == "" {
+			srcDir = pkg.Dir
+		}
+		if !pkg.Standard && pkg.Target != "" && targetDir == "" {
+			targetDir = filepath.Dir(pkg.Target)
+		}
+	}
+	if targetDir != "" {
+		checkSkew("package outputs", targetDir)
+	}
+	if srcDir == "" {
+		return now
+	}
+	skew := checkSkew("sources", srcDir)
+	if skew <= skewThreshold {
+		return now
+	}
+	stamp := newestSource(pkgs)
+	if now.After(stamp) {
+		stamp = now
+	}
+	stamp = stamp.Add(skew)
+	vlogf("stamping restored package outputs with %s", stamp.Format(time.RFC3339))
+	return stamp
+}
@@ -0,0 +1,109 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The reasons the output of a package is skipped by save.
+const (
+	skipNoTarget    = "no-target"          // the install target could not be determined
+	skipNotBuilt    = "not-built"          // nothing is installed at the target
+	skipStale       = "stale"              // the output is out of date with its inputs
+	skipToolchain   = "toolchain-mismatch" // the output was built by another Go version
+	skipUncacheable = "uncacheable"        // the inputs cannot be fingerprinted
+	skipTooSmall    = "too-small"          // the output is smaller than --min-artifact-size
+	skipInGoCache   = "in-gocache"         // the go command keeps the output in its build cache
+)
+
+// A saveSkip describes why the output of a package is not saved.
+type saveSkip struct {
+	Reason string // one of the skip constants
+	Detail string
+}
+
+func (s *saveSkip) String() string {
+	if s.Detail == "" {
+		return s.Reason
+	}
+	return s.Reason + ": " + s.Detail
+}
+
+// skipSave returns why the output of pkg cannot be saved, or nil if it
+// can.
+func skipSave(pkg *Package) *saveSkip {
+	switch {
+	case pkg.Target == "":
+		return &saveSkip{skipNoTarget, ""}
+	case !exists(pkg.Target):
+		return &saveSkip{skipNotBuilt, pkg.Target}
+	case pkg.Stale:
+		return &saveSkip{skipStale, pkg.StaleReason}
+	}
+	if reason := toolchainMismatch(pkg.Target, goVersion); reason != "" {
+		return &saveSkip{skipToolchain, reason}
+	}
+	// A package which cannot be fingerprinted fails to save instead.
+	if fp, err := pkg.computeFingerprint(); fp == "" && err == nil {
+		return &saveSkip{skipUncacheable, pkg.uncacheable}
+	}
+	if size := fileSize(pkg.Target); size < int64(minArtifactSize) {
+		return &saveSkip{skipTooSmall, humanSize(size)}
+	}
+	return nil
+}
+
+// skipSaveTest returns why the test binary bin of pkg cannot be saved,
+// or nil if it can.
+func skipSaveTest(pkg *Package, bin string) *saveSkip {
+	if _, err := os.Stat(bin); err != nil {
+		return &saveSkip{skipNotBuilt, bin}
+	}
+	if testBinaryStale(pkg, bin) {
+		return &saveSkip{skipStale, "newer source file"}
+	}
+	if reason := toolchainMismatch(bin, goVersion); reason != "" {
+		return &saveSkip{skipToolchain, reason}
+	}
+	if pkg.TestFingerprint() == "" {
+		return &saveSkip{skipUncacheable, pkg.uncacheable}
+	}
+	return nil
+}
+
+// formatSkipped returns the counts of skipped packages by reason, most
+// frequent first, e.g. "3 stale, 1 not-built".
+func formatSkipped(skipped map[string]int) string {
+	var reasons []string
+	for reason := range skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if skipped[reasons[i]] != skipped[reasons[j]] {
+			return skipped[reasons[i]] > skipped[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	var parts []string
+	for _, reason := range reasons {
+		parts = append(parts, fmt.Sprintf("%d %s", skipped[reason], reason))
+	}
+	return strings.Join(parts, ", ")
+}
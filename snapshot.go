@@ -0,0 +1,309 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotChecksumKey is the PAX record holding the checksum of a
+// snapshot member.
+const snapshotChecksumKey = "BUILDCACHE.checksum"
+
+// snapshotDir returns the directory of the snapshots in the cache dir.
+// A snapshot is a single archive of the outputs of all the packages of
+// a tree, restored in one go instead of entry by entry. The entries
+// remain the source of truth.
+func snapshotDir(dir string) string {
+	return filepath.Join(dir, "snapshots")
+}
+
+// snapshotPackages returns the packages of pkgs whose outputs are part
+// of a snapshot.
+func snapshotPackages(pkgs []*Package) []*Package {
+	var members []*Package
+	for _, pkg := range pkgs {
+		if pkg.cached() && pkg.Target != "" {
+			members = append(members, pkg)
+		}
+	}
+	return members
+}
+
+// snapshotKey returns the key of the snapshot of the members: a digest
+// of their import paths and fingerprints, or "" if one of them is
+// uncacheable.
+func snapshotKey(members []*Package) string {
+	h := sha1.New()
+	for _, pkg := range members {
+		fp := pkg.Fingerprint()
+		if fp == "" {
+			return ""
+		}
+		fmt.Fprintf(h, "%s %s\n", fp, pkg.ImportPath)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func snapshotPath(dir, key string) string {
+	return filepath.Join(snapshotDir(dir), key+".tar.gz")
+}
+
+// writeSnapshot writes the snapshot of the members from their cache
+// entries in dir.
+func writeSnapshot(dir, key string, members []*Package) error {
+	if err := os.MkdirAll(snapshotDir(dir), 0755); err != nil {
+		return err
+	}
+	f, err := createTemp(snapshotDir(dir))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	for _, pkg := range members {
+		fp := pkg.Fingerprint()
+		src := lookupEntry(dir, fp)
+		if src == "" {
+			_ = f.Close()
+			return fmt.Errorf("%s: not in the cache", pkg.ImportPath)
+		}
+		fi, err := os.Stat(src)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		sum, size, err := fileChecksum(src)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		hdr := &tar.Header{
+			Name:       fp,
+			Mode:       int64(fi.Mode().Perm()),
+			Size:       size,
+			ModTime:    time.Now(),
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{snapshotChecksumKey: sum},
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := copyInto(tw, src); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), snapshotPath(dir, key))
+}
+
+func copyInto(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// extractSnapshot restores the members from the snapshot at path,
+// stamping the Targets with now. Each member is written to a temporary
+// file and only moved into place once its checksum has been verified.
+func extractSnapshot(path string, members []*Package, now time.Time, s *summary) error {
+	byFingerprint := map[string]*Package{}
+	for _, pkg := range members {
+		byFingerprint[pkg.Fingerprint()] = pkg
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		pkg := byFingerprint[hdr.Name]
+		if pkg == nil {
+			return fmt.Errorf("%s: unexpected member %s", path, hdr.Name)
+		}
+		if _, err := checkTarget(pkg.Target, pkg.targetRoot()); err != nil {
+			log.Printf("WARNING: %s: refusing to replace target: %s", pkg.ImportPath, err)
+			logResult(resultStale, "", "", pkg.ImportPath, "unsafe target: "+err.Error())
+			s.record(pkg.ImportPath, hdr.Name, resultStale)
+			delete(byFingerprint, hdr.Name)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(pkg.Target), 0755); err != nil {
+			return err
+		}
+		tmp, err := createTemp(filepath.Dir(pkg.Target))
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(tmp, h), tr)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil && hex.EncodeToString(h.Sum(nil)) != hdr.PAXRecords[snapshotChecksumKey] {
+			err = fmt.Errorf("%s: member %s %w", path, hdr.Name, errIntegrity)
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), os.FileMode(hdr.Mode).Perm())
+		}
+		if err == nil {
+			err = os.Chtimes(tmp.Name(), now, now)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), pkg.Target)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+		logResult(resultHit, hdr.Name, "", pkg.ImportPath, pkg.Target)
+		s.record(pkg.ImportPath, hdr.Name, resultHit)
+		delete(byFingerprint, hdr.Name)
+	}
+	if len(byFingerprint) > 0 {
+		return fmt.Errorf("%s: %d packages missing", path, len(byFingerprint))
+	}
+	return nil
+}
+
+// snapshot saves or restores the packages named by args together with a
+// snapshot of their outputs. "snapshot save" saves the packages as save
+// does and, if all of them could be saved, writes their snapshot.
+// "snapshot restore" restores the snapshot matching the packages if
+// there is one and otherwise restores them as restore does.
+func snapshot(args []string) {
+	if len(args) == 0 {
+		log.Printf("usage: %s snapshot save|restore [packages]", os.Args[0])
+		os.Exit(1)
+	}
+	cmd, args := args[0], args[1:]
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	dir := cacheDir()
+
+	switch cmd {
+	case "save":
+		s, err := save(args)
+		if err != nil {
+			fatal(err)
+		}
+		if s.Stale > 0 {
+			log.Printf("not writing a snapshot: %d packages were not saved", s.Stale)
+			return
+		}
+		members := snapshotPackages(loadAll(args))
+		key := snapshotKey(members)
+		if key == "" || exists(snapshotPath(dir, key)) {
+			return
+		}
+		unlock := useCache(dir, false)
+		defer unlock()
+		if err := writeSnapshot(dir, key, members); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote snapshot %s of %d packages", key, len(members))
+
+	case "restore":
+		if err := checkTestFlags(); err != nil {
+			log.Fatal(err)
+		}
+		if err := setAltRoot(*targetRoot); err != nil {
+			log.Fatal(err)
+		}
+		pkgs := loadAll(args)
+		members := snapshotPackages(pkgs)
+		key := snapshotKey(members)
+		if key == "" || !exists(snapshotPath(dir, key)) {
+			log.Printf("no snapshot, restoring packages individually")
+			if _, err := restore(args); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		unlock := useCache(dir, false)
+		log.Printf("restoring snapshot %s", key)
+		s := newSummary("restore")
+		boot := newBootstrap(pkgs, time.Now())
+		err := extractSnapshot(snapshotPath(dir, key), members, restoreStamp(pkgs, time.Now()), s)
+		boot.restamp()
+		unlock()
+		if err != nil {
+			// Restore whatever the snapshot did not provide.
+			log.Printf("warning: %s; restoring packages individually", err)
+			var errs commandErrors
+			if errors.Is(err, errIntegrity) {
+				errs = append(errs, err)
+			}
+			if _, err := restore(args); err != nil {
+				errs = append(errs, err)
+			}
+			if err := errs.err(); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		s.finish()
+		if err := s.err(); err != nil {
+			fatal(err)
+		}
+
+	default:
+		log.Fatalf("unknown snapshot command \"%s\"", cmd)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var preserveMtime = flag.Bool("preserve-mtime", false,
+	"stamp restored outputs with their modification times when saved, unless that would make them stale")
+
+// A stampPlan gives the modification times of the Targets restored by a
+// restore. Normally all of them are stamped with the time given by
+// restoreStamp. With -preserve-mtime a Target is stamped with the time
+// its output had when it was saved instead, so that restoring the same
+// output gives the same file, unless that time is older than one of its
+// sources or than the stamp of one of its imports: the go command would
+// then find it stale and rebuild it.
+type stampPlan struct {
+	now    time.Time
+	stamps map[*Package]time.Time // with -preserve-mtime
+}
+
+// planStamps plans the stamps of the Targets of pkgs restored from the
+// cache dir, stamped now unless preserved.
+func planStamps(dir string, pkgs []*Package, now time.Time) *stampPlan {
+	plan := &stampPlan{now: now}
+	if !*preserveMtime {
+		return plan
+	}
+	plan.stamps = map[*Package]time.Time{}
+	var fellBack []string
+	var stamp func(pkg *Package) time.Time
+	stamp = func(pkg *Package) time.Time {
+		if t, ok := plan.stamps[pkg]; ok {
+			return t
+		}
+		t, reason := now, ""
+		if !pkg.cached() {
+			// Not restored: as installed, if at all.
+			t = time.Time{}
+			if fi, err := os.Stat(pkg.Target); pkg.Target != "" && err == nil {
+				t = fi.ModTime()
+			}
+		} else if saved := savedModTime(dir, pkg); !saved.IsZero() {
+			t = saved
+			if newestSource([]*Package{pkg}).After(saved) {
+				reason = "older than its sources"
+			}
+			for _, imp := range pkg.imports {
+				if reason == "" && stamp(imp).After(saved) {
+					reason = "older than " + imp.ImportPath
+				}
+			}
+		}
+		if reason != "" {
+			vlogf("%s: stamping with the current time: the saved time is %s", pkg.ImportPath, reason)
+			fellBack = append(fellBack, pkg.ImportPath)
+			t = now
+		}
+		plan.stamps[pkg] = t
+		return t
+	}
+	for _, pkg := range pkgs {
+		stamp(pkg)
+	}
+	if len(fellBack) > 0 {
+		names := fellBack
+		if len(names) > 5 {
+			names = append(names[:5:5], "...")
+		}
+		log.Printf("-preserve-mtime: %d outputs stamped with the current time, their saved times being stale: %s",
+			len(fellBack), strings.Join(names, ", "))
+	}
+	return plan
+}
+
+// savedModTime returns the modification time recorded for the output
+// of pkg in its cache entry, or the zero time if there is no such entry
+// or it was saved without it.
+func savedModTime(dir string, pkg *Package) time.Time {
+	fp, _ := pkg.computeFingerprint()
+	if fp == "" {
+		return time.Time{}
+	}
+	src, _ := lookupNamespaced(dir, fp, nil)
+	if src == "" {
+		return time.Time{}
+	}
+	m, err := readMeta(src)
+	if err != nil || m == nil {
+		return time.Time{}
+	}
+	return m.TargetModTime
+}
+
+// stamp returns the modification time to give to the restored Target of
+// pkg.
+func (plan *stampPlan) stamp(pkg *Package) time.Time {
+	if t, ok := plan.stamps[pkg]; ok && pkg.cached() {
+		return t
+	}
+	return plan.now
+}
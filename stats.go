@@ -0,0 +1,359 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var statsSince = flag.String("since", "",
+	"report stats for the runs in this period, e.g. 7d or 12h (default all)")
+
+// statsCompactAge is the age beyond which the events in the stats log
+// are folded into the snapshot.
+const statsCompactAge = 24 * time.Hour
+
+// The stats of the cache are kept in the "stats" directory of the cache
+// as an append-only log of events, one JSON summary per run, and a
+// snapshot into which old events are folded by day. Appends and
+// compactions are done under the cache lock. The snapshot records the
+// time through which events have been folded, so events are never
+// counted twice even if a compaction is interrupted, and malformed
+// lines left by interrupted appends are ignored.
+func statsDir(dir string) string {
+	return filepath.Join(dir, "stats")
+}
+
+func statsEventsPath(dir string) string {
+	return filepath.Join(statsDir(dir), "events.ndjson")
+}
+
+func statsSnapshotPath(dir string) string {
+	return filepath.Join(statsDir(dir), "snapshot.json")
+}
+
+// A statsEvent is the record of a run in the stats log.
+type statsEvent struct {
+	Time time.Time `json:"time"`
+	summary
+}
+
+// statsCounts accumulates the summaries of runs.
+type statsCounts struct {
+	Runs      int     `json:"runs"`
+	Packages  int     `json:"packages"`
+	Hits      int     `json:"hits"`
+	Misses    int     `json:"misses"`
+	Stale     int     `json:"stale"`
+	Expired   int     `json:"expired"`
+	Conflicts int     `json:"conflicts,omitempty"`
+	Seconds   float64 `json:"seconds"`
+	// The recorded build time of the outputs restored.
+	SavedSeconds float64 `json:"savedSeconds,omitempty"`
+	// Neither hits nor misses.
+	PolicySkips int `json:"skippedByPolicy,omitempty"`
+	remoteCounts
+}
+
+func (c *statsCounts) add(o statsCounts) {
+	c.Runs += o.Runs
+	c.Packages += o.Packages
+	c.Hits += o.Hits
+	c.Misses += o.Misses
+	c.Stale += o.Stale
+	c.Expired += o.Expired
+	c.Conflicts += o.Conflicts
+	c.Seconds += o.Seconds
+	c.SavedSeconds += o.SavedSeconds
+	c.PolicySkips += o.PolicySkips
+	c.remoteCounts.add(o.remoteCounts)
+}
+
+func (c *statsCounts) hitRate() float64 {
+	if n := c.Hits + c.Misses + c.Expired; n > 0 {
+		return float64(c.Hits) / float64(n)
+	}
+	return 0
+}
+
+func (e *statsEvent) counts() statsCounts {
+	return statsCounts{
+		Runs:         1,
+		Packages:     e.Packages,
+		Hits:         e.Hits,
+		Misses:       e.Misses,
+		Stale:        e.Stale,
+		Expired:      e.Expired,
+		Conflicts:    e.Conflicts,
+		Seconds:      e.Seconds,
+		SavedSeconds: e.SavedSeconds,
+		PolicySkips:  e.PolicySkips,
+		remoteCounts: e.remoteCounts,
+	}
+}
+
+// A statsSnapshot holds the counts of the events folded out of the log,
+// by UTC date and command.
+type statsSnapshot struct {
+	Through time.Time                          `json:"through"`
+	Days    map[string]map[string]*statsCounts `json:"days"`
+}
+
+func (s *statsSnapshot) add(e *statsEvent) {
+	day := e.Time.UTC().Format("2006-01-02")
+	if s.Days == nil {
+		s.Days = map[string]map[string]*statsCounts{}
+	}
+	if s.Days[day] == nil {
+		s.Days[day] = map[string]*statsCounts{}
+	}
+	c := s.Days[day][e.Command]
+	if c == nil {
+		c = &statsCounts{}
+		s.Days[day][e.Command] = c
+	}
+	c.add(e.counts())
+	if e.Time.After(s.Through) {
+		s.Through = e.Time
+	}
+}
+
+// appendStats appends the summary of a run to the stats log of the
+// cache dir. Failures are logged but otherwise ignored.
+func appendStats(dir string, s *summary) {
+	if !exists(dir) {
+		return
+	}
+	data, err := json.Marshal(&statsEvent{Time: time.Now().UTC(), summary: *s})
+	if err == nil {
+		err = withCacheLock(dir, func() error {
+			if err := os.MkdirAll(statsDir(dir), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(statsEventsPath(dir), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			line := frameLine(data)
+			// Terminate a partial line left by an interrupted append so
+			// that it does not swallow this event.
+			last := make([]byte, 1)
+			if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+				if _, err := f.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+					line = append([]byte{'\n'}, line...)
+				}
+			}
+			if _, err := f.Write(line); err != nil {
+				_ = f.Close()
+				return err
+			}
+			return f.Close()
+		})
+	}
+	if err != nil {
+		log.Printf("warning: unable to record stats: %s", err)
+	}
+}
+
+// withCacheLock runs fn while holding the lock on the cache dir.
+func withCacheLock(dir string, fn func() error) error {
+	unlock, err := lockCache(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// readStats returns the stats snapshot of the cache dir and the events
+// of the log not yet folded into it.
+func readStats(dir string) (*statsSnapshot, []*statsEvent, error) {
+	snap := &statsSnapshot{}
+	if !readAux(statsSnapshotPath(dir), "stats-snapshot", snap) {
+		snap = &statsSnapshot{}
+	}
+
+	f, err := os.Open(statsEventsPath(dir))
+	if os.IsNotExist(err) {
+		return snap, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var events []*statsEvent
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	corrupt := 0
+	for s.Scan() {
+		e := &statsEvent{}
+		record, ok := unframeLine(s.Bytes(), json.Valid)
+		if !ok || json.Unmarshal(record, e) != nil {
+			// e.g. a partial line left by an interrupted run.
+			corrupt++
+			continue
+		}
+		if e.Time.After(snap.Through) {
+			events = append(events, e)
+		}
+	}
+	if corrupt > 0 {
+		vlogf("%s: skipped %d corrupt events", statsEventsPath(dir), corrupt)
+	}
+	return snap, events, s.Err()
+}
+
+// compactStats folds the events of the stats log older than
+// statsCompactAge into the snapshot.
+func compactStats(dir string, now time.Time) error {
+	if !exists(statsDir(dir)) {
+		return nil
+	}
+	return withCacheLock(dir, func() error {
+		snap, events, err := readStats(dir)
+		if err != nil {
+			return err
+		}
+		var keep []*statsEvent
+		folded := 0
+		for _, e := range events {
+			if now.Sub(e.Time) > statsCompactAge {
+				snap.add(e)
+				folded++
+			} else {
+				keep = append(keep, e)
+			}
+		}
+		if folded == 0 {
+			return nil
+		}
+		if err := writeAux(statsSnapshotPath(dir), "stats-snapshot", snap); err != nil {
+			return err
+		}
+		var buf []byte
+		for _, e := range keep {
+			line, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			buf = append(buf, frameLine(line)...)
+		}
+		return writeFileAtomic(statsEventsPath(dir), buf)
+	})
+}
+
+// parseAge parses a duration which, in addition to the units accepted
+// by time.ParseDuration, may be given in days (e.g. "7d").
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration \"%s\"", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// stats reports the hit rates of the runs recorded in the stats log, by
+// command. The events of the snapshot are counted by day, so --since
+// includes the whole day at its start for events older than a day.
+func stats(args []string) {
+	dir := cacheDir()
+	now := time.Now()
+	if err := compactStats(dir, now); err != nil {
+		log.Fatal(err)
+	}
+	snap, events, err := readStats(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var since time.Time
+	if *statsSince != "" {
+		age, err := parseAge(*statsSince)
+		if err != nil {
+			log.Fatal(err)
+		}
+		since = now.Add(-age)
+	}
+
+	counts := map[string]*statsCounts{}
+	add := func(command string, c statsCounts) {
+		if counts[command] == nil {
+			counts[command] = &statsCounts{}
+		}
+		counts[command].add(c)
+	}
+	for day, commands := range snap.Days {
+		if t, err := time.Parse("2006-01-02", day); err == nil && t.Before(since.UTC().Truncate(24*time.Hour)) {
+			continue
+		}
+		for command, c := range commands {
+			add(command, *c)
+		}
+	}
+	for _, e := range events {
+		if e.Time.After(since) {
+			add(e.Command, e.counts())
+		}
+	}
+
+	if *jsonOutput {
+		fmt.Println(prettyJSON(counts))
+		return
+	}
+	var commands []string
+	for command := range counts {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		c := counts[command]
+		conflicts := ""
+		if c.PolicySkips > 0 {
+			conflicts = fmt.Sprintf(", %d skipped by policy", c.PolicySkips)
+		}
+		if c.Conflicts > 0 {
+			conflicts += fmt.Sprintf(", %d fingerprint conflicts", c.Conflicts)
+		}
+		fmt.Printf("%-8s %d runs: %d packages, %d hits, %d misses, %d stale, %d expired%s (%.1f%% hit rate)\n",
+			command, c.Runs, c.Packages, c.Hits, c.Misses, c.Stale, c.Expired, conflicts, 100*c.hitRate())
+		if line := formatSaved(c.SavedSeconds); line != "" {
+			fmt.Printf("%-8s %s\n", "", line)
+		}
+		if line := c.remoteCounts.format(0); line != "" {
+			fmt.Printf("%-8s %s\n", "", line)
+		}
+	}
+	if line := formatPinStats(pinStats(dir)); line != "" {
+		fmt.Println(line)
+	}
+	if line := formatEnvStats(envStats(dir)); line != "" {
+		fmt.Println(line)
+	}
+}
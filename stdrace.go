@@ -0,0 +1,182 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var stdRace = flag.Bool("std-race", false,
+	"warm the race-instrumented standard library of the toolchain instead of the named packages")
+
+var stdRaceDirs struct {
+	sync.Mutex
+	m map[string]string
+}
+
+// stdRacePkgdir returns the directory the race-instrumented standard
+// packages of the toolchain are installed to: root, the race package
+// directory of GOROOT (e.g. $GOROOT/pkg/linux_amd64_race), or, if that
+// cannot be written as is common for toolchains installed by root, the
+// directory of the same name in the pkg directory of the first GOPATH
+// entry. The go command only uses the latter with -pkgdir.
+func stdRacePkgdir(root string) string {
+	stdRaceDirs.Lock()
+	defer stdRaceDirs.Unlock()
+	if dir, ok := stdRaceDirs.m[root]; ok {
+		return dir
+	}
+	dir := root
+	if entries := gopathEntries(); len(entries) > 0 && !creatableDir(root) {
+		dir = filepath.Join(entries[0], "pkg", filepath.Base(root))
+	}
+	if stdRaceDirs.m == nil {
+		stdRaceDirs.m = map[string]string{}
+	}
+	stdRaceDirs.m[root] = dir
+	return dir
+}
+
+// stdRaceTarget returns the install target of the race-instrumented
+// standard package p, in the directory given by stdRacePkgdir.
+func stdRaceTarget(p *Package) string {
+	dir := stdRacePkgdir(p.PkgTargetRoot)
+	if dir == p.PkgTargetRoot {
+		return p.PkgObj
+	}
+	return filepath.Join(dir, filepath.FromSlash(p.baseImportPath)+".a")
+}
+
+// creatableDir reports whether dir exists and files can be created in
+// it, or else whether it can be created. Unlike writableDir it does not
+// create dir.
+func creatableDir(dir string) bool {
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return false
+			}
+			f, err := os.CreateTemp(dir, ".build-cache-preflight-")
+			if err != nil {
+				return false
+			}
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// stdPackages returns the import paths of the standard library
+// packages, as listed by "go list std", except the vendored ones which
+// are only loaded as the imports of other packages.
+func stdPackages() ([]string, error) {
+	out, err := runGo(goCmd(), "list", "std")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, path := range strings.Split(string(out), "\n") {
+		path = strings.TrimSpace(path)
+		if strings.ContainsAny(path, " \t") {
+			return nil, fmt.Errorf("go list std: unexpected output %s", outputExcerpt(out))
+		}
+		if path != "" && !strings.HasPrefix(path, "vendor/") {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// warmStdRace installs the race-instrumented standard library with "go
+// install -race std" if any of its packages are missing from the cache
+// and then saves them, so that the first -race build after a toolchain
+// upgrade does not rebuild them on every machine. The fingerprints of
+// the packages include the toolchain, so each toolchain has entries of
+// its own.
+func warmStdRace() {
+	dir := cacheDir()
+	_, version, _ := findGo()
+	log.Printf("warming the race-instrumented standard library of %s in %s", version, dir)
+
+	std, err := stdPackages()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var args []string
+	for _, path := range std {
+		args = append(args, path+":race")
+	}
+	pkgs := loadAll(args)
+
+	var missing []*Package
+	for _, pkg := range pkgs {
+		if !pkg.Goroot || !pkg.race || pkg.Target == "" {
+			continue
+		}
+		if lookupEntry(dir, pkg.Fingerprint()) != "" {
+			continue
+		}
+		log.Printf("%-40s  %s", pkg.Fingerprint(), pkg.ImportPath)
+		missing = append(missing, pkg)
+	}
+	log.Printf("%d standard packages missing from the cache", len(missing))
+	if *dryRun || len(missing) == 0 {
+		return
+	}
+
+	flags := []string{"-race"}
+	if _, pkgdir := buildSettings(); pkgdir == "" {
+		root := missing[0].PkgTargetRoot
+		if dir := stdRacePkgdir(root); dir != root {
+			log.Printf("notice: %s cannot be written, installing to %s; "+
+				"build with GOFLAGS=-pkgdir=%s to use it", root, dir, dir)
+			flags = append(flags, "-pkgdir", dir)
+		}
+	}
+	before := targetTimes(pkgs)
+	start := time.Now()
+	goInstall(flags, []string{"std"})
+	buildDurations = attributeBuildTimes(pkgs, before, start)
+	for _, pkg := range missing {
+		if !exists(pkg.Target) {
+			log.Printf("warning: go install did not write %s; since Go 1.20 the standard library "+
+				"is only installed with GODEBUG=installgoroot=all", pkg.Target)
+			break
+		}
+	}
+
+	// Reload the packages as the installed outputs are now up to date.
+	packageCache = map[string]*Package{}
+	s, err := save(args)
+	if err != nil {
+		fatal(err)
+	}
+	log.Printf("warmed %d entries", s.Misses)
+}
@@ -0,0 +1,155 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"time"
+)
+
+// The possible results of saving or restoring a package.
+const (
+	resultHit   = "hit"   // the cache already contained the package output
+	resultMiss  = "miss"  // the cache did not contain the package output
+	resultStale = "stale" // the package output was not up to date
+
+	// The cache contained the package output but it was older than
+	// --max-entry-age.
+	resultExpired = "expired"
+	// The cache did not contain the package output as save skipped it
+	// by policy, e.g. --min-artifact-size.
+	resultPolicy = "skipped-by-policy"
+)
+
+// A summary accumulates the per-package results of a save or restore.
+type summary struct {
+	Command  string `json:"command"`
+	Packages int    `json:"packages"`
+	Hits     int    `json:"hits"`
+	Misses   int    `json:"misses"`
+	Stale    int    `json:"stale"`
+	Expired  int    `json:"expired"`
+	// Neither hits nor misses, of a restore.
+	PolicySkips int `json:"skippedByPolicy,omitempty"`
+	// Saved outputs differing from the entry of the same fingerprint.
+	Conflicts int `json:"conflicts,omitempty"`
+	// Saves which waited for that of the same fingerprint by another
+	// package.
+	Coalesced int `json:"coalesced,omitempty"`
+	// Failures the command got past, such as packages which could not
+	// be saved or restored.
+	Errors int `json:"errors,omitempty"`
+	// Packages whose outputs were up to date, neither fingerprinted nor
+	// restored, of a restore with --only-missing-targets.
+	Fresh   int     `json:"skippedAsFresh,omitempty"`
+	HitRate float64 `json:"hitRate"`
+	Seconds float64 `json:"seconds"`
+	// The recorded build time of the outputs restored, of a restore.
+	SavedSeconds float64 `json:"savedSeconds,omitempty"`
+	remoteCounts
+	// The entries handed off to a background push, of a save with
+	// --remote-push=async.
+	PushQueued int `json:"pushQueued,omitempty"`
+
+	Skipped map[string]int `json:"skipped,omitempty"` // of a save, by reason
+	Roots   []*rootStats   `json:"roots,omitempty"`
+	// Hits by namespace, "" being the default one, of a restore.
+	NamespaceHits map[string]int   `json:"namespaceHits,omitempty"`
+	Limits        *resourceLimits  `json:"limits"`
+	Preflight     *preflightResult `json:"preflight,omitempty"` // of a restore
+	// The description of the annotation of the annotated packages, by
+	// import path.
+	Annotated map[string]string `json:"annotated,omitempty"`
+
+	start    time.Time
+	hooks    *hooks
+	reported []*reportPackage // for --report
+	errs     commandErrors    // the failures the command got past
+}
+
+func newSummary(command string) *summary {
+	s := &summary{
+		Command: command,
+		Limits:  limits,
+		start:   time.Now(),
+	}
+	s.hooks = newHooks(s)
+	return s
+}
+
+// record notes the result for the package with the specified import
+// path and fingerprint.
+func (s *summary) record(importPath, fp, result string) {
+	s.Packages++
+	switch result {
+	case resultHit:
+		s.Hits++
+	case resultMiss:
+		s.Misses++
+	case resultStale:
+		s.Stale++
+	case resultExpired:
+		s.Expired++
+	case resultPolicy:
+		s.PolicySkips++
+	}
+	if n := s.Hits + s.Misses + s.Expired; n > 0 {
+		s.HitRate = float64(s.Hits) / float64(n)
+	}
+	s.hooks.record(importPath, fp, result)
+}
+
+// skip counts a package whose output was not saved for reason; it is
+// recorded as stale separately.
+func (s *summary) skip(reason string) {
+	if s.Skipped == nil {
+		s.Skipped = map[string]int{}
+	}
+	s.Skipped[reason]++
+}
+
+// fail records a failure the command got past.
+func (s *summary) fail(err error) {
+	s.Errors++
+	s.errs = append(s.errs, err)
+}
+
+// err returns the failures recorded by fail, or nil if there were none.
+func (s *summary) err() error {
+	return s.errs.err()
+}
+
+// hitFrom counts a hit found in the namespace ns.
+func (s *summary) hitFrom(ns string) {
+	if s.NamespaceHits == nil {
+		s.NamespaceHits = map[string]int{}
+	}
+	s.NamespaceHits[ns]++
+}
+
+// finish logs the summary, records it in the stats of the cache and runs
+// the completion hooks. A hook failure with --hooks-strict is recorded
+// as a failure of the command; see fail.
+func (s *summary) finish() {
+	s.Seconds = time.Since(s.start).Seconds()
+	logSummary(s)
+	writeReport(s)
+	reportLargestFiles()
+	saveDenylist(cacheDir())
+	appendStats(cacheDir(), s)
+	if err := s.hooks.finish(); err != nil {
+		s.fail(err)
+	}
+}
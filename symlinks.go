@@ -0,0 +1,96 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A brokenSymlinkError reports a package source file which is a symlink
+// to a file that does not exist.
+type brokenSymlinkError struct {
+	Link string // as read from the symlink
+}
+
+func (e *brokenSymlinkError) Error() string {
+	return "is a broken symlink to " + e.Link
+}
+
+// brokenSymlink describes the first file in dir that is a broken
+// symlink, or returns "" if there is none. go/build fails to load a
+// package with one among its source files, as it reads them all, but
+// loads the rest of the package.
+func brokenSymlink(dir string) string {
+	infos, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.Type()&os.ModeSymlink == 0 || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			link, _ := os.Readlink(path)
+			return name + " " + (&brokenSymlinkError{Link: link}).Error()
+		}
+	}
+	return ""
+}
+
+// hashSymlink writes to h whether the package source file at path is a
+// symlink, and so whether its contents are those of another file, as
+// with source trees managed by Bazel or nix. Regular files write
+// nothing, leaving their fingerprints as they were. A symlink whose
+// target lies outside the root of the package's module or repository
+// also writes the path of its target, since pointing it at another copy
+// of the same contents, e.g. a new version in a content store, changes
+// what the build reads from outside the tree. It returns errVanished if
+// the file no longer exists and a brokenSymlinkError if its target does
+// not.
+func (p *Package) hashSymlink(h hash.Hash, file, path string) (bool, error) {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, errVanished
+	}
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		// Other errors are reported when the file is opened.
+		return false, nil
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		link, _ := os.Readlink(path)
+		return true, &brokenSymlinkError{Link: link}
+	}
+	root := repoRoot(p.Dir)
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	if rel, err := filepath.Rel(root, target); err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		fmt.Fprint(h, "symlink")
+		p.record("symlink "+file, "")
+		return true, nil
+	}
+	fmt.Fprintf(h, "symlink %s", target)
+	p.record("symlink "+file, target)
+	return true, nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSymlinkFingerprints checks the fingerprints of a package with a
+// source file symlinked within its repository and outside it: editing
+// the target changes them, touching it does not, and pointing the link
+// at a copy of the same contents changes them only outside the
+// repository.
+func TestSymlinkFingerprints(t *testing.T) {
+	const extra = "package lib\n\nconst Extra = 1\n"
+	for _, test := range []struct {
+		name    string
+		outside bool
+		change  func(t *testing.T, f *fixture, link, target string)
+		changed bool
+	}{
+		{name: "in tree, target edited", change: editTarget, changed: true},
+		{name: "in tree, target touched", change: touchTarget},
+		{name: "in tree, retargeted to a copy", change: retarget},
+		{name: "in tree, replaced by a copy", change: replaceLink, changed: true},
+		{name: "out of tree, target edited", outside: true, change: editTarget, changed: true},
+		{name: "out of tree, target touched", outside: true, change: touchTarget},
+		{name: "out of tree, retargeted to a copy", outside: true, change: retarget, changed: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			// The repository root of the fixture packages.
+			if err := os.MkdirAll(filepath.Join(f.dir("example.com/app"), ".git"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Join(f.dir("example.com/app"), "testdata")
+			if test.outside {
+				dir = filepath.Join(f.root, "outside")
+			}
+			f.writeFiles(dir, map[string]string{"extra.go": extra})
+			link, target := filepath.Join(f.dir("example.com/app/lib"), "extra.go"), filepath.Join(dir, "extra.go")
+			symlink(t, target, link)
+
+			before := f.fingerprints("./...")
+			for _, importPath := range []string{"example.com/app/lib", "example.com/app/cmd/tool"} {
+				if before[importPath] == "" {
+					t.Fatalf("%s: not fingerprinted: %v", importPath, before)
+				}
+			}
+			test.change(t, f, link, target)
+			after := f.fingerprints("./...")
+			for _, importPath := range []string{"example.com/app/lib", "example.com/app/cmd/tool"} {
+				if changed := after[importPath] != before[importPath]; changed != test.changed {
+					t.Errorf("%s: fingerprint changed = %v, want %v", importPath, changed, test.changed)
+				}
+			}
+			for _, importPath := range []string{"example.com/app/util", "other.org/dep"} {
+				if after[importPath] != before[importPath] {
+					t.Errorf("%s: fingerprint changed", importPath)
+				}
+			}
+		})
+	}
+}
+
+// editTarget rewrites the target of the symlink with other contents.
+func editTarget(t *testing.T, f *fixture, _, target string) {
+	f.writeFiles(filepath.Dir(target), map[string]string{"extra.go": "package lib\n\nconst Extra = 2\n"})
+}
+
+// touchTarget updates the modification time of the target.
+func touchTarget(t *testing.T, _ *fixture, _, target string) {
+	now := time.Now()
+	if err := os.Chtimes(target, now, now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// retarget points the symlink at a copy of its target.
+func retarget(t *testing.T, f *fixture, link, target string) {
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.writeFiles(filepath.Dir(target), map[string]string{"copy.go": string(data)})
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, filepath.Join(filepath.Dir(target), "copy.go"), link)
+}
+
+// replaceLink replaces the symlink by a regular file holding the
+// contents of its target.
+func replaceLink(t *testing.T, f *fixture, link, target string) {
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	f.writeFiles(filepath.Dir(link), map[string]string{"extra.go": string(data)})
+}
+
+// TestBrokenSymlinks checks that a package with a broken symlink among
+// its source files, whether broken before or after the packages are
+// loaded, is uncacheable with the link as the reason rather than
+// failing the run, without being recorded in the denylist, and that the
+// other packages are still fingerprinted.
+func TestBrokenSymlinks(t *testing.T) {
+	for _, afterLoading := range []bool{false, true} {
+		name := "broken before loading"
+		if afterLoading {
+			name = "broken after loading"
+		}
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t)
+			target := filepath.Join(f.root, "outside", "extra.go")
+			f.writeFiles(filepath.Dir(target), map[string]string{"extra.go": "package lib\n"})
+			symlink(t, target, filepath.Join(f.dir("example.com/app/lib"), "extra.go"))
+			if !afterLoading {
+				if err := os.Remove(target); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pkgs := map[string]*Package{}
+			for _, pkg := range f.load("./...") {
+				pkgs[pkg.ImportPath] = pkg
+			}
+			if afterLoading {
+				if err := os.Remove(target); err != nil {
+					t.Fatal(err)
+				}
+			}
+			lib := pkgs["example.com/app/lib"]
+			if lib == nil {
+				t.Fatal("example.com/app/lib not loaded")
+			}
+			if fp := lib.Fingerprint(); fp != "" {
+				t.Errorf("example.com/app/lib: fingerprinted as %s", fp)
+			}
+			if want := "extra.go is a broken symlink to " + target; !strings.Contains(lib.uncacheable, want) {
+				t.Errorf("example.com/app/lib: uncacheable = %q, want %q", lib.uncacheable, want)
+			}
+			for _, importPath := range []string{"example.com/app/util", "other.org/dep"} {
+				if pkgs[importPath].Fingerprint() == "" {
+					t.Errorf("%s: not fingerprinted: %s", importPath, pkgs[importPath].uncacheable)
+				}
+			}
+			if _, ok := denylist.changes["example.com/app/lib"]; ok {
+				t.Error("example.com/app/lib: recorded in the denylist")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkTarget verifies that the output at target can be replaced
+// safely before a restore writes it. Directories and other files which
+// are not regular files or symlinks are refused; a symlink is reported
+// so that the link itself, never its referent, is replaced. The parent
+// directories of target are resolved and must stay under root, if
+// given, so that a symlinked parent cannot redirect the write.
+func checkTarget(target, root string) (symlink bool, err error) {
+	fi, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return false, err
+	case fi.IsDir():
+		return false, fmt.Errorf("%s is a directory", target)
+	case fi.Mode()&os.ModeSymlink != 0:
+		symlink = true
+	case !fi.Mode().IsRegular():
+		return false, fmt.Errorf("%s is not a regular file (%s)", target, fi.Mode().Type())
+	}
+	if root == "" {
+		return symlink, nil
+	}
+	resolvedRoot, err := resolveExisting(root)
+	if err != nil {
+		return false, err
+	}
+	dir, err := resolveExisting(filepath.Dir(target))
+	if err != nil {
+		return false, err
+	}
+	if !underDir(dir, resolvedRoot) {
+		return false, fmt.Errorf("%s resolves to %s, outside %s", filepath.Dir(target), dir, root)
+	}
+	return symlink, nil
+}
+
+// resolveExisting returns dir with the symlinks of its longest existing
+// prefix resolved.
+func resolveExisting(dir string) (string, error) {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(dir)}, rest...)
+		dir = parent
+	}
+}
+
+// underDir reports whether path is dir or within it.
+func underDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// targetRoot returns the root the Target of p is expected under: the
+// root it is rebased onto, or else its install root.
+func (p *Package) targetRoot() string {
+	if dir := p.altRootFor(); dir != "" {
+		return dir
+	}
+	return p.installRoot()
+}
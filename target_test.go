@@ -0,0 +1,222 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckTarget(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		setup   func(t *testing.T, root, outside string) // creating root/pkg/lib.a, the target
+		symlink bool
+		err     string // in the error, "" for none
+	}{
+		{name: "missing", setup: func(*testing.T, string, string) {}},
+		{
+			name:  "regular file",
+			setup: func(t *testing.T, root, _ string) { writeTestFile(t, filepath.Join(root, "pkg", "lib.a")) },
+		},
+		{
+			name: "directory",
+			setup: func(t *testing.T, root, _ string) {
+				if err := os.MkdirAll(filepath.Join(root, "pkg", "lib.a"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "is a directory",
+		},
+		{
+			name: "symlink",
+			setup: func(t *testing.T, root, outside string) {
+				writeTestFile(t, filepath.Join(outside, "lib.a"))
+				symlink(t, filepath.Join(outside, "lib.a"), filepath.Join(root, "pkg", "lib.a"))
+			},
+			symlink: true,
+		},
+		{
+			name: "broken symlink",
+			setup: func(t *testing.T, root, outside string) {
+				symlink(t, filepath.Join(outside, "missing"), filepath.Join(root, "pkg", "lib.a"))
+			},
+			symlink: true,
+		},
+		{
+			name: "symlink to a directory",
+			setup: func(t *testing.T, root, outside string) {
+				symlink(t, outside, filepath.Join(root, "pkg", "lib.a"))
+			},
+			symlink: true,
+		},
+		{
+			name: "parent symlinked within the root",
+			setup: func(t *testing.T, root, _ string) {
+				if err := os.MkdirAll(filepath.Join(root, "real"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				symlink(t, filepath.Join(root, "real"), filepath.Join(root, "pkg"))
+			},
+		},
+		{
+			name: "parent symlinked outside the root",
+			setup: func(t *testing.T, root, outside string) {
+				symlink(t, outside, filepath.Join(root, "pkg"))
+			},
+			err: "outside",
+		},
+		{
+			name: "root symlinked",
+			setup: func(t *testing.T, root, outside string) {
+				if err := os.RemoveAll(root); err != nil {
+					t.Fatal(err)
+				}
+				symlink(t, outside, root)
+				writeTestFile(t, filepath.Join(root, "pkg", "lib.a"))
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			root, outside := filepath.Join(dir, "root"), filepath.Join(dir, "outside")
+			for _, d := range []string{root, outside} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			test.setup(t, root, outside)
+			target := filepath.Join(root, "pkg", "lib.a")
+			symlink, err := checkTarget(target, root)
+			if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("checkTarget: %v, want error %q", err, test.err)
+			}
+			if err == nil && symlink != test.symlink {
+				t.Errorf("checkTarget: symlink %v, want %v", symlink, test.symlink)
+			}
+		})
+	}
+}
+
+// TestRestoreUnexpectedTargets checks that restore replaces a symlinked
+// Target rather than writing through it, and leaves Targets which are
+// directories or under a parent symlinked out of GOPATH alone, restoring
+// the other packages.
+func TestRestoreUnexpectedTargets(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		setup   func(t *testing.T, target, outside string) // of example.com/app/lib
+		refused []string
+	}{
+		{
+			name: "directory",
+			setup: func(t *testing.T, target, _ string) {
+				if err := os.MkdirAll(target, 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			refused: []string{"example.com/app/lib"},
+		},
+		{
+			name: "symlink",
+			setup: func(t *testing.T, target, outside string) {
+				writeTestFile(t, filepath.Join(outside, "lib.a"))
+				symlink(t, filepath.Join(outside, "lib.a"), target)
+			},
+		},
+		{
+			name: "symlinked parent",
+			setup: func(t *testing.T, target, outside string) {
+				if err := os.Remove(filepath.Dir(target)); err != nil {
+					t.Fatal(err)
+				}
+				symlink(t, outside, filepath.Dir(target))
+			},
+			// example.com/app/util shares the parent.
+			refused: []string{"example.com/app/lib", "example.com/app/util"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.install("./...")
+			f.mustRun("save", "./...")
+			f.removeOutputs()
+			var lib *Package
+			pkgs := f.load("./...")
+			for _, pkg := range pkgs {
+				if pkg.ImportPath == "example.com/app/lib" {
+					lib = pkg
+				}
+			}
+			outside := filepath.Join(f.root, "outside")
+			if err := os.MkdirAll(outside, 0755); err != nil {
+				t.Fatal(err)
+			}
+			test.setup(t, lib.Target, outside)
+
+			out := f.mustRun("restore", "./...")
+			for _, pkg := range pkgs {
+				if !pkg.cached() {
+					continue
+				}
+				refused := contains(test.refused, pkg.ImportPath)
+				if warned := strings.Contains(out, "WARNING: "+pkg.ImportPath+": refusing to replace target"); warned != refused {
+					t.Errorf("%s: refused %v, want %v:\n%s", pkg.ImportPath, warned, refused, out)
+				}
+				fi, err := os.Lstat(pkg.Target)
+				if restored := err == nil && fi.Mode().IsRegular(); restored == refused {
+					t.Errorf("%s: restored %v, want %v:\n%s", pkg.ImportPath, restored, !refused, out)
+				}
+			}
+			// Nothing was written outside GOPATH.
+			if data, err := os.ReadFile(filepath.Join(outside, "lib.a")); err == nil && string(data) != "test" {
+				t.Errorf("the symlinked file was written through: %q", data)
+			}
+			if infos, _ := os.ReadDir(outside); test.name == "symlinked parent" && len(infos) != 0 {
+				t.Errorf("%d files written through the symlinked parent", len(infos))
+			}
+		})
+	}
+}
+
+// writeTestFile writes "test" to path, creating its directory.
+func writeTestFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// symlink creates the symlink newname to oldname, creating its directory,
+// or skips the test where symlinks cannot be created.
+func symlink(t *testing.T, oldname, newname string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(newname), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(oldname, newname); err != nil {
+		if runtime.GOOS == "windows" {
+			t.Skip("cannot create symlinks:", err)
+		}
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+var targetList = flag.String("targets", "",
+	"comma-separated GOOS/GOARCH platforms to save, restore or print the key of in turn, e.g. linux/amd64,linux/arm64")
+
+// A platform is a GOOS/GOARCH pair given with -targets.
+type platform struct {
+	GOOS, GOARCH string
+}
+
+func (p platform) String() string {
+	return p.GOOS + "/" + p.GOARCH
+}
+
+// parseTargets returns the platforms listed by -targets.
+func parseTargets(s string) ([]platform, error) {
+	var platforms []platform
+	seen := map[platform]bool{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		i := strings.Index(t, "/")
+		if i <= 0 || i == len(t)-1 || strings.Count(t, "/") != 1 {
+			return nil, fmt.Errorf("-targets: %q is not GOOS/GOARCH", t)
+		}
+		p := platform{GOOS: t[:i], GOARCH: t[i+1:]}
+		if !seen[p] {
+			seen[p] = true
+			platforms = append(platforms, p)
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("-targets: no platforms given")
+	}
+	return platforms, nil
+}
+
+// forEachTarget runs fn, a save or restore, for each of the platforms
+// given by -targets in turn, as if -goos and -goarch named it, and then
+// logs the summary of each, if fn returns one. The packages are loaded again for each
+// platform since their files and imports depend on it, but the inputs
+// independent of the platform (the git index and the blob IDs computed
+// for files outside it, the module and extra inputs) are computed once.
+// A platform failing does not stop the others; the failures are
+// returned together.
+func forEachTarget(fn func() (*summary, error)) error {
+	platforms, err := parseTargets(*targetList)
+	if err != nil {
+		return err
+	}
+	if *targetGOOS != "" || *targetGOARCH != "" {
+		return errors.New("-targets cannot be combined with -goos or -goarch")
+	}
+	summaries := make([]*summary, len(platforms))
+	failed := make([]bool, len(platforms))
+	summarized := false
+	var errs commandErrors
+	for i, p := range platforms {
+		log.Print(colorize(ansiBold, "target "+p.String()))
+		*targetGOOS, *targetGOARCH = p.GOOS, p.GOARCH
+		packageCache = map[string]*Package{}
+		if summaries[i], err = fn(); err != nil {
+			failed[i] = true
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+		summarized = summarized || summaries[i] != nil
+	}
+	*targetGOOS, *targetGOARCH = "", ""
+	if !summarized {
+		return errs.err()
+	}
+
+	log.Print(colorize(ansiBold, fmt.Sprintf("%d targets:", len(platforms))))
+	for i, p := range platforms {
+		s := summaries[i]
+		if failed[i] {
+			log.Printf("  %-16s failed", p)
+			continue
+		}
+		if s == nil {
+			continue
+		}
+		log.Printf("  %-16s %d packages: %d hits, %d misses, %d stale (%.1f%% hit rate)",
+			p, s.Packages, s.Hits, s.Misses, s.Stale, 100*s.HitRate)
+	}
+	return errs.err()
+}
@@ -0,0 +1,73 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTargetKeys(t *testing.T) {
+	f := newFixture(t)
+	key := func(args ...string) []string {
+		t.Helper()
+		out, err := f.command(append(args, "key")...).Output()
+		if err != nil {
+			t.Fatalf("build-cache %v key: %v", args, err)
+		}
+		return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	}
+	platforms, err := parseTargets("linux/amd64,linux/arm64,darwin/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := map[string]string{}
+	for _, p := range platforms {
+		single[p.String()] = key("-goos", p.GOOS, "-goarch", p.GOARCH)[0]
+	}
+
+	for _, test := range []struct {
+		targets string
+		want    []string // platforms, in order
+	}{
+		{"linux/amd64", []string{"linux/amd64"}},
+		{"linux/amd64,linux/arm64,darwin/arm64", []string{"linux/amd64", "linux/arm64", "darwin/arm64"}},
+		{"darwin/arm64, linux/amd64,darwin/arm64", []string{"darwin/arm64", "linux/amd64"}},
+	} {
+		lines := key("-targets", test.targets)
+		if len(lines) != len(test.want) {
+			t.Errorf("-targets %s: printed %q, want %d keys", test.targets, lines, len(test.want))
+			continue
+		}
+		seen := map[string]bool{}
+		for i, line := range lines {
+			// Each key is that printed for its platform alone.
+			want := single[test.want[i]] + "  " + test.want[i]
+			if line != want {
+				t.Errorf("-targets %s: line %d is %q, want %q", test.targets, i+1, line, want)
+			}
+			k := strings.Fields(line)[0]
+			if seen[k] {
+				t.Errorf("-targets %s: key %s printed for more than one platform", test.targets, k)
+			}
+			seen[k] = true
+		}
+	}
+
+	if out, err := f.command("-targets", "linux/amd64", "-goos", "linux", "key").CombinedOutput(); err == nil {
+		t.Errorf("-targets with -goos succeeded:\n%s", out)
+	}
+}
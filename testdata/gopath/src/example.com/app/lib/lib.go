@@ -0,0 +1,10 @@
+package lib
+
+import (
+	"fmt"
+
+	"example.com/app/util"
+	_ "other.org/dep"
+)
+
+func Greet() string { return fmt.Sprint(util.Hello()) }
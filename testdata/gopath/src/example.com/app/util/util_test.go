@@ -0,0 +1,9 @@
+package util
+
+import "testing"
+
+func TestHello(t *testing.T) {
+	if Hello() != "hi" {
+		t.Fatal("bad")
+	}
+}
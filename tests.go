@@ -0,0 +1,208 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+var (
+	includeTests = flag.Bool("include-tests", false,
+		"load test imports and cache the test binaries of the root packages")
+	artifactsFrom = flag.String("artifacts-from", "",
+		"directory holding the test binaries built with \"go test -c -o <dir>/\"")
+)
+
+// testDir returns the directory within the cache holding test binaries,
+// which are kept apart from the package outputs.
+func testDir(dir string) string {
+	return filepath.Join(dir, "test")
+}
+
+// testBinary returns the path of the test binary for pkg, named the way
+// "go test -c -o <dir>/" names it.
+func testBinary(pkg *Package) string {
+	return filepath.Join(*artifactsFrom, path.Base(pkg.baseImportPath)+".test")
+}
+
+func testName(pkg *Package) string {
+	return pkg.ImportPath + " [test]"
+}
+
+// checkTestFlags verifies the flags needed to cache test binaries were
+// specified.
+func checkTestFlags() error {
+	if *includeTests && *artifactsFrom == "" {
+		return errors.New("-include-tests requires -artifacts-from")
+	}
+	return nil
+}
+
+// testBinaryStale reports whether the test binary for pkg is missing or
+// older than any of the package or test sources.
+func testBinaryStale(pkg *Package, bin string) bool {
+	fi, err := os.Stat(bin)
+	if err != nil {
+		return true
+	}
+	built := fi.ModTime()
+	srcs := stringList(pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles,
+		pkg.MFiles, pkg.HFiles, pkg.SFiles, pkg.SysoFiles, pkg.SwigFiles,
+		pkg.SwigCXXFiles, pkg.TestGoFiles, pkg.XTestGoFiles)
+	for _, src := range srcs {
+		fi, err := os.Stat(filepath.Join(pkg.Dir, src))
+		if err != nil || fi.ModTime().After(built) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveTest saves the test binary of the root package pkg to the cache,
+// recording a failure in r.
+func saveTest(pkg *Package, dir string, r *pkgReport) {
+	if err := saveTestBinary(pkg, dir, r); err != nil {
+		r.fail(fmt.Errorf("%s: %w", testName(pkg), err))
+	}
+}
+
+// saveTestBinary does the work of saveTest.
+func saveTestBinary(pkg *Package, dir string, r *pkgReport) error {
+	bin := testBinary(pkg)
+	name := testName(pkg)
+	if len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) == 0 {
+		return nil
+	}
+	if skip := skipSaveTest(pkg, bin); skip != nil {
+		r.skip(name, bin, skip)
+		return nil
+	}
+
+	fp := pkg.TestFingerprint()
+	tag := "*"
+	result := resultMiss
+	dst := filepath.Join(testDir(dir), entryFileName(fp, pkg.ImportPath))
+	stored := false
+	if src := lookupEntry(testDir(dir), fp); src != "" {
+		tag = " "
+		result = resultHit
+		detail, err := entryConflict(src, bin)
+		if err != nil {
+			return err
+		}
+		if detail != "" {
+			tag = "!"
+			if stored, err = saveConflicting(pkg, dir, bin, src, dst, detail, r); err != nil {
+				return err
+			}
+		}
+	} else if err := os.MkdirAll(testDir(dir), 0755); err != nil {
+		return err
+	} else if err := storeEntry(dir, bin, dst); err != nil {
+		return err
+	} else {
+		stored = true
+	}
+	if stored {
+		m, err := newEntryMeta(pkg, dst)
+		if err != nil {
+			return err
+		}
+		m.Test = true
+		m.Manifest = nil // the package's, not the test binary's
+		m.TargetModTime = time.Time{}
+		if err := writeMeta(dst, m); err != nil {
+			return err
+		}
+		addEntry(testDir(dir), dst)
+	}
+	r.logResult(result, fp, tag, name, bin)
+	r.record(name, fp, result)
+	if stored {
+		r.report(name, fp, result, actionSaved, bin, time.Time{})
+	} else {
+		r.report(name, fp, result, actionPresent, bin, time.Time{})
+	}
+	return nil
+}
+
+// restoreTest restores the test binary of the root package pkg from the
+// cache.
+func restoreTest(pkg *Package, dir string, now time.Time, causes *missIndex, r *pkgReport) {
+	if len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) == 0 {
+		return
+	}
+	bin := testBinary(pkg)
+	name := testName(pkg)
+	fp := pkg.TestFingerprint()
+	src, ns := lookupNamespaced(dir, fp, testDir)
+	if fp == "" {
+		r.logResult(resultStale, "", "", name, "uncacheable")
+		r.record(name, fp, resultStale)
+		r.report(name, fp, resultStale, actionSkipped, bin, time.Time{})
+		return
+	}
+	if src == "" {
+		c := causes.classify(pkg, fp, true)
+		r.logResult(resultMiss, "", "", name, fp+":"+bin+" "+c.String())
+		r.record(name, fp, resultMiss)
+		r.reportMiss(name, fp, resultMiss, actionMissed, bin, c)
+		return
+	}
+	if expiredEntry(src) {
+		r.logResult(resultExpired, "", "", name, fp+":"+bin+", expired")
+		r.record(name, fp, resultExpired)
+		r.reportMiss(name, fp, resultExpired, actionMissed, bin, missCause{Cause: causeExpired})
+		return
+	}
+	if reason := entryOwnerMismatch(src, pkg); reason != "" {
+		log.Printf("WARNING: %s: refusing to restore %s: %s", name, src, reason)
+		c := missCause{Cause: causeIntegrity}
+		r.logResult(resultMiss, "", "", name, fp+":"+bin+", "+reason+" "+c.String())
+		r.record(name, fp, resultMiss)
+		r.reportMiss(name, fp, resultMiss, actionMissed, bin, c)
+		return
+	}
+	if _, err := checkTarget(bin, *artifactsFrom); err != nil {
+		log.Printf("WARNING: %s: refusing to replace test binary: %s", name, err)
+		r.logResult(resultStale, "", "", name, "unsafe target: "+err.Error())
+		r.record(name, fp, resultStale)
+		r.report(name, fp, resultStale, actionSkipped, bin, time.Time{})
+		return
+	}
+	r.logResult(resultHit, fp, "", name, bin)
+	r.hitFrom(ns)
+	_ = os.Remove(bin)
+	_ = os.MkdirAll(filepath.Dir(bin), 0755)
+	if err := linkOrCopy(src, bin); err != nil {
+		r.fail(fmt.Errorf("%s: %w", name, err))
+		return
+	}
+	if err := os.Chtimes(bin, now, now); err != nil {
+		r.fail(fmt.Errorf("%s: %w", name, err))
+		return
+	}
+	r.record(name, fp, resultHit)
+	r.report(name, fp, resultHit, actionRestored, bin, now)
+	r.buildSaved(entryBuildTime(src))
+}
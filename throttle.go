@@ -0,0 +1,257 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var remoteMaxBandwidth bandwidth
+
+func init() {
+	flag.Var(&remoteMaxBandwidth, "remote-max-bandwidth",
+		"limit the transfers to and from --remote, all together, to this many bytes per second, e.g. 50MB/s")
+}
+
+const (
+	// maxThrottleRetries is the number of times a request the remote
+	// tier throttles is retried.
+	maxThrottleRetries = 5
+	// defaultRetryAfter is the wait before retrying a throttled request
+	// without a Retry-After header, and maxRetryAfter the longest.
+	defaultRetryAfter = time.Second
+	maxRetryAfter     = time.Minute
+)
+
+// A bandwidth is a byteSize per second, given with or without "/s".
+type bandwidth byteSize
+
+func (b *bandwidth) String() string {
+	return (*byteSize)(b).String()
+}
+
+func (b *bandwidth) Set(s string) error {
+	return (*byteSize)(b).Set(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+}
+
+// A tokenBucket limits transfers to rate bytes per second, allowing
+// bursts of a second's worth. Transfers going over it run into debt,
+// which those after them wait for, so that concurrent transfers share
+// the rate.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait takes n bytes from the bucket, waiting until they are available.
+func (b *tokenBucket) wait(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	time.Sleep(d)
+}
+
+// A throttledError is the failure of a request the remote tier
+// throttled, answering it with 429 Too Many Requests, or 503 Service
+// Unavailable with a Retry-After header.
+type throttledError struct {
+	err   error
+	after time.Duration // to wait before retrying
+}
+
+func (e *throttledError) Error() string { return e.err.Error() }
+func (e *throttledError) Unwrap() error { return e.err }
+
+// throttled returns the throttledError of the request for the object
+// name answered with resp, or nil if it was not throttled.
+func throttled(method, name string, resp *http.Response) error {
+	h := resp.Header.Get("Retry-After")
+	if resp.StatusCode != http.StatusTooManyRequests && (resp.StatusCode != http.StatusServiceUnavailable || h == "") {
+		return nil
+	}
+	after := defaultRetryAfter
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		after = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(h); err == nil {
+		after = time.Until(t)
+		if after < 0 {
+			after = 0
+		}
+	}
+	if after > maxRetryAfter {
+		after = maxRetryAfter
+	}
+	return &throttledError{err: fmt.Errorf("%s %s: %s", method, name, resp.Status), after: after}
+}
+
+// A transferLimiter paces the transfers to and from the remote tier: it
+// runs them within --remote-max-bandwidth and at most limit at a time,
+// halving limit, down to one, each time the remote tier throttles one.
+// It measures the bytes transferred and the time spent transferring.
+type transferLimiter struct {
+	bucket *tokenBucket // nil without --remote-max-bandwidth
+
+	mu        sync.Mutex // protects the following
+	cond      *sync.Cond
+	limit     int
+	active    int
+	throttled int
+	bytes     int64
+	busy      time.Duration // with at least one transfer active
+	since     time.Time     // the start of the current busy period
+}
+
+func newTransferLimiter() *transferLimiter {
+	l := &transferLimiter{}
+	l.cond = sync.NewCond(&l.mu)
+	if remoteMaxBandwidth > 0 {
+		l.bucket = newTokenBucket(int64(remoteMaxBandwidth))
+	}
+	return l
+}
+
+func (l *transferLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit == 0 {
+		l.limit = limits.Workers
+	}
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	if l.active == 0 {
+		l.since = time.Now()
+	}
+	l.active++
+}
+
+func (l *transferLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if l.active == 0 {
+		l.busy += time.Since(l.since)
+	}
+	l.cond.Broadcast()
+}
+
+// throttle notes a transfer the remote tier throttled, reducing the
+// transfers at a time for the rest of the run, and returns their number.
+func (l *transferLimiter) throttle() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.throttled++
+	if l.limit > 1 {
+		l.limit /= 2
+	}
+	return l.limit
+}
+
+// transferred accounts for n bytes transferred, waiting for the
+// bandwidth to allow them.
+func (l *transferLimiter) transferred(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.bytes += int64(n)
+	l.mu.Unlock()
+	l.bucket.wait(n)
+}
+
+// do runs the transfer op, retrying it after the wait the remote tier
+// asks for when it throttles it, up to maxThrottleRetries times, and as
+// long as retry allows.
+func (l *transferLimiter) do(op func() error, retry func() bool) error {
+	for attempt := 0; ; attempt++ {
+		l.acquire()
+		err := op()
+		l.release()
+		var te *throttledError
+		if !errors.As(err, &te) {
+			return err
+		}
+		limit := l.throttle()
+		if attempt == maxThrottleRetries || !retry() {
+			return err
+		}
+		vlogf("%s, retrying in %s with %d transfers at a time", err, te.after, limit)
+		time.Sleep(te.after)
+	}
+}
+
+// counts returns the throttled transfers, the bytes transferred and the
+// seconds spent transferring them so far.
+func (l *transferLimiter) counts() (int, int64, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	busy := l.busy
+	if l.active > 0 {
+		busy += time.Since(l.since)
+	}
+	return l.throttled, l.bytes, busy.Seconds()
+}
+
+// A limitedReader reads from r as the transferLimiter l allows.
+type limitedReader struct {
+	r io.Reader
+	l *transferLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.l.transferred(n)
+	return n, err
+}
+
+// A limitedWriter writes to w as the transferLimiter l allows.
+type limitedWriter struct {
+	w io.Writer
+	l *transferLimiter
+	n int64 // written
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.l.transferred(n)
+	return n, err
+}
@@ -0,0 +1,103 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBandwidthFlag(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  bandwidth
+	}{
+		{"50MB/s", 50 << 20},
+		{"50M", 50 << 20},
+		{"1024", 1024},
+		{" 2KB/s ", 2 << 10},
+	} {
+		var b bandwidth
+		if err := b.Set(test.value); err != nil {
+			t.Errorf("%q: %s", test.value, err)
+		} else if b != test.want {
+			t.Errorf("%q: %d, want %d", test.value, b, test.want)
+		}
+	}
+	var b bandwidth
+	if err := b.Set("fast"); err == nil {
+		t.Errorf("\"fast\" accepted")
+	}
+}
+
+// TestTokenBucket checks that the transfers going over the rate of a
+// tokenBucket wait for it once its burst is used up.
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10000)
+	start := time.Now()
+	b.wait(10000)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("the burst waited %s", d)
+	}
+	b.wait(5000)
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("going over the rate waited %s, want 500ms", d)
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	for _, test := range []struct {
+		status     int
+		retryAfter string
+		throttled  bool
+		after      time.Duration
+	}{
+		{status: http.StatusTooManyRequests, throttled: true, after: defaultRetryAfter},
+		{status: http.StatusTooManyRequests, retryAfter: "3", throttled: true, after: 3 * time.Second},
+		{status: http.StatusTooManyRequests, retryAfter: "3600", throttled: true, after: maxRetryAfter},
+		{status: http.StatusServiceUnavailable, retryAfter: "2", throttled: true, after: 2 * time.Second},
+		{status: http.StatusServiceUnavailable},
+		{status: http.StatusForbidden, retryAfter: "2"},
+	} {
+		resp := &http.Response{StatusCode: test.status, Status: http.StatusText(test.status), Header: http.Header{}}
+		if test.retryAfter != "" {
+			resp.Header.Set("Retry-After", test.retryAfter)
+		}
+		err := statusError(http.MethodPut, "x", resp)
+		te, ok := err.(*throttledError)
+		if ok != test.throttled {
+			t.Errorf("%d %q: throttled %t, want %t", test.status, test.retryAfter, ok, test.throttled)
+		} else if ok && te.after != test.after {
+			t.Errorf("%d %q: retry after %s, want %s", test.status, test.retryAfter, te.after, test.after)
+		}
+	}
+}
+
+// TestTransferLimiter checks that throttling halves the transfers at a
+// time down to one.
+func TestTransferLimiter(t *testing.T) {
+	l := newTransferLimiter()
+	l.limit = 8
+	for _, want := range []int{4, 2, 1, 1} {
+		if got := l.throttle(); got != want {
+			t.Errorf("limit %d after throttling, want %d", got, want)
+		}
+	}
+	if throttled, _, _ := l.counts(); throttled != 4 {
+		t.Errorf("%d throttled, want 4", throttled)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var tmpDir = flag.String("tmp-dir", "",
+	"directory holding the per-run scratch directory of temporary files (default .tmp in the cache)")
+
+// scratchMaxAge is the age beyond which save removes the scratch
+// directories left behind by runs which did not exit cleanly.
+const scratchMaxAge = 24 * time.Hour
+
+// scratchRoot returns the directory holding the scratch directories of
+// the runs.
+func scratchRoot() string {
+	if *tmpDir != "" {
+		return *tmpDir
+	}
+	return filepath.Join(cacheRoot(), ".tmp")
+}
+
+var scratchMu sync.Mutex
+var scratch string
+var scratchFailed bool // or removed
+
+// scratchDir returns the scratch directory of this run, <pid>-<random>
+// in scratchRoot, creating it on first use, or "" if it cannot be
+// created.
+func scratchDir() string {
+	scratchMu.Lock()
+	defer scratchMu.Unlock()
+	if scratch != "" || scratchFailed {
+		return scratch
+	}
+	root := scratchRoot()
+	d := ""
+	err := os.MkdirAll(root, 0755)
+	if err == nil {
+		d, err = os.MkdirTemp(root, strconv.Itoa(os.Getpid())+"-")
+	}
+	if err != nil {
+		vlogf("scratch directory: %s, using temporary files next to their destinations", err)
+		scratchFailed = true
+		return ""
+	}
+	scratch = d
+	return scratch
+}
+
+// removeScratch removes the scratch directory of this run, if it was
+// created.
+func removeScratch() {
+	scratchMu.Lock()
+	defer scratchMu.Unlock()
+	if scratch == "" {
+		return
+	}
+	if err := os.RemoveAll(scratch); err != nil {
+		log.Printf("warning: unable to remove %s: %s", scratch, err)
+	}
+	scratch = ""
+	scratchFailed = true
+}
+
+// removeScratchOnInterrupt removes the scratch directory of this run
+// when it is interrupted or terminated.
+func removeScratchOnInterrupt() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		removeScratch()
+		log.Printf("%s", sig)
+		os.Exit(1)
+	}()
+}
+
+var tempDirsMu sync.Mutex
+var tempDirs = map[string]string{}
+
+// tempDirFor returns the directory of the temporary files to be renamed
+// into dir: the scratch directory if it is on the same filesystem as
+// dir, or else dir itself, as renames cannot cross filesystems. That is
+// also the case on the platforms where the filesystem of a directory is
+// not known.
+func tempDirFor(dir string) string {
+	s := scratchDir()
+	if s == "" {
+		return dir
+	}
+	tempDirsMu.Lock()
+	defer tempDirsMu.Unlock()
+	if d, ok := tempDirs[dir]; ok {
+		return d
+	}
+	d := dir
+	if fs, _, err := diskSpace(s); err == nil {
+		if dirFS, _, err := diskSpace(dir); err == nil && dirFS == fs {
+			d = s
+		}
+	}
+	tempDirs[dir] = d
+	return d
+}
+
+// createTemp creates a temporary file to be renamed into dir.
+func createTemp(dir string) (*os.File, error) {
+	return os.CreateTemp(tempDirFor(dir), ".tmp-")
+}
+
+// isScratchName reports whether name is that of a scratch directory.
+func isScratchName(name string) bool {
+	i := strings.IndexByte(name, '-')
+	if i <= 0 || i == len(name)-1 {
+		return false
+	}
+	return strings.Trim(name[:i], "0123456789") == "" && strings.Trim(name[i+1:], "0123456789") == ""
+}
+
+// removeStaleScratch removes the scratch directories last modified
+// longer than scratchMaxAge ago, left behind by runs which crashed or
+// were killed.
+func removeStaleScratch() {
+	root := scratchRoot()
+	infos, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	removed := 0
+	for _, info := range infos {
+		if !info.IsDir() || !isScratchName(info.Name()) {
+			continue
+		}
+		fi, err := info.Info()
+		if err != nil || now.Sub(fi.ModTime()) <= scratchMaxAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, info.Name())); err != nil {
+			log.Printf("warning: unable to remove stale scratch directory: %s", err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		vlogf("removed %d stale scratch directories from %s", removed, root)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"encoding"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// The reasons hashFile could not hash a source file.
+var (
+	errVanished = errors.New("vanished while fingerprinting")
+	errTorn     = errors.New("kept changing while fingerprinting")
+)
+
+// tornReadAttempts is the number of times a source file is hashed
+// before giving up on it settling.
+const tornReadAttempts = 3
+
+// hashFile writes the name and contents of the package source file to
+// h; see hashFileOnce. A file written to while it is hashed, e.g. by
+// gofmt or a code generator run from a file watcher, would yield a
+// fingerprint of contents nobody compiles, so the file is stat'ed
+// before and after hashing it and hashed again, with h rolled back, if
+// its size or modification time changed. It returns errTorn if the
+// file does not settle.
+func (p *Package) hashFile(h hash.Hash, file string) error {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for attempt := 1; ; attempt++ {
+		err := p.hashFileOnce(h, file)
+		if err != errTorn || attempt == tornReadAttempts {
+			return err
+		}
+		vvlogf("%s: %s changed while fingerprinting, hashing it again (attempt %d of %d)",
+			p.ImportPath, file, attempt+1, tornReadAttempts)
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			log.Fatal(err)
+		}
+		time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+	}
+}
+
+// modifiedSince reports whether the file at path no longer has the size
+// and modification time of fi.
+func modifiedSince(path string, fi os.FileInfo) bool {
+	now, err := os.Stat(path)
+	return err != nil || now.Size() != fi.Size() || !now.ModTime().Equal(fi.ModTime())
+}
+
+// A countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,176 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	retryUncacheable = flag.Bool("retry-uncacheable", false,
+		"fingerprint packages previously found uncacheable")
+	uncacheableTTL = flag.Duration("uncacheable-ttl", 7*24*time.Hour,
+		"how long packages found uncacheable are skipped")
+)
+
+// An uncacheableEntry records why a package was found uncacheable.
+type uncacheableEntry struct {
+	Reason string    `json:"reason"`
+	Inputs string    `json:"inputs"` // see Package.inputsFingerprint
+	Marked time.Time `json:"marked"`
+}
+
+// The denylist holds the packages found uncacheable, by import path. It
+// is read from the cache dir when first consulted and the changes made
+// by this run are merged back by saveDenylist.
+var denylist struct {
+	sync.Mutex
+	loaded  bool
+	entries map[string]*uncacheableEntry
+	changes map[string]*uncacheableEntry // nil for removed entries
+	skipped int
+}
+
+// denylistPath returns the path of the denylist in the cache dir.
+func denylistPath(dir string) string {
+	return filepath.Join(dir, "uncacheable.json")
+}
+
+func readDenylist(dir string) map[string]*uncacheableEntry {
+	var entries map[string]*uncacheableEntry
+	if !readAux(denylistPath(dir), "uncacheable", &entries) || entries == nil {
+		entries = map[string]*uncacheableEntry{}
+	}
+	return entries
+}
+
+// loadDenylist reads the denylist of the cache dir if it has not been
+// read yet. It must be called with the denylist locked.
+func loadDenylist() {
+	if denylist.loaded {
+		return
+	}
+	denylist.loaded = true
+	denylist.changes = map[string]*uncacheableEntry{}
+	denylist.entries = readDenylist(cacheDir())
+}
+
+// inputsFingerprint returns the fingerprint of the list of inputs of p:
+// the toolchain, its import path and the names, but not the contents,
+// of its source files. It is cheap to compute.
+func (p *Package) inputsFingerprint() string {
+	h := sha1.New()
+	for _, s := range stringList(toolchain(p.buildContext), p.ImportPath, p.sourceFiles()) {
+		fmt.Fprintf(h, "%s\n", s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// previouslyUncacheable returns the reason p was found uncacheable by a
+// previous run, or "" if it was not, its inputs have changed since, the
+// record has expired or --retry-uncacheable was specified.
+func previouslyUncacheable(p *Package) string {
+	if *retryUncacheable {
+		return ""
+	}
+	denylist.Lock()
+	defer denylist.Unlock()
+	loadDenylist()
+	e := denylist.entries[p.ImportPath]
+	if e == nil || time.Since(e.Marked) > *uncacheableTTL || e.Inputs != p.inputsFingerprint() {
+		return ""
+	}
+	denylist.skipped++
+	return e.Reason
+}
+
+// uncacheableErr returns the failure to fingerprint p, or nil if it was
+// fingerprinted.
+func (p *Package) uncacheableErr() error {
+	if p.uncacheable == "" {
+		return nil
+	}
+	return &uncacheableError{ImportPath: p.ImportPath, Reason: p.uncacheable}
+}
+
+// markUncacheable records that p was found uncacheable for reason.
+func markUncacheable(p *Package, reason string) {
+	e := &uncacheableEntry{
+		Reason: reason,
+		Inputs: p.inputsFingerprint(),
+		Marked: time.Now().UTC(),
+	}
+	denylist.Lock()
+	defer denylist.Unlock()
+	loadDenylist()
+	denylist.entries[p.ImportPath] = e
+	denylist.changes[p.ImportPath] = e
+}
+
+// clearUncacheable removes the record of p having been found
+// uncacheable, once it has been fingerprinted successfully.
+func clearUncacheable(p *Package) {
+	denylist.Lock()
+	defer denylist.Unlock()
+	loadDenylist()
+	if _, ok := denylist.entries[p.ImportPath]; ok {
+		delete(denylist.entries, p.ImportPath)
+		denylist.changes[p.ImportPath] = nil
+	}
+}
+
+// saveDenylist merges the changes to the denylist into the one of the
+// cache dir, dropping expired records, and reports the packages skipped
+// as previously uncacheable.
+func saveDenylist(dir string) {
+	denylist.Lock()
+	defer denylist.Unlock()
+	if denylist.skipped > 0 {
+		log.Printf("%d packages skipped as previously uncacheable, run with --retry-uncacheable to retest",
+			denylist.skipped)
+		denylist.skipped = 0
+	}
+	if len(denylist.changes) == 0 || !exists(dir) {
+		return
+	}
+	err := withCacheLock(dir, func() error {
+		entries := readDenylist(dir)
+		for importPath, e := range denylist.changes {
+			if e == nil {
+				delete(entries, importPath)
+			} else {
+				entries[importPath] = e
+			}
+		}
+		for importPath, e := range entries {
+			if time.Since(e.Marked) > *uncacheableTTL {
+				delete(entries, importPath)
+			}
+		}
+		return writeAux(denylistPath(dir), "uncacheable", entries)
+	})
+	if err != nil {
+		log.Printf("warning: unable to save the uncacheable packages: %s", err)
+	}
+	denylist.changes = map[string]*uncacheableEntry{}
+}
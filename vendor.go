@@ -0,0 +1,199 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var noVendorFastpath = flag.Bool("no-vendor-fastpath", false,
+	"hash the files of vendored packages individually rather than by a digest of the vendor tree")
+
+// Vendored packages of the main module are fingerprinted by a digest of
+// the whole vendor tree instead of their own files, since hashing the
+// vendored files is most of the work of fingerprinting a vendor mode
+// module and they rarely change. The digest of the contents is only
+// recomputed when the manifest of the tree, its modules.txt and the
+// names, sizes and modification times of its files, changes; the
+// manifest and digest are kept in the cache directory.
+
+// A vendorDigest is the digest of the contents of a vendor tree along
+// with the manifest it was computed for.
+type vendorDigest struct {
+	Manifest string `json:"manifest"`
+	Digest   string `json:"digest"`
+}
+
+// vendorDigestsPath returns the path of the vendor digests in the cache
+// dir, by vendor directory.
+func vendorDigestsPath(dir string) string {
+	return filepath.Join(dir, "vendor-digests.json")
+}
+
+var vendorDigests struct {
+	sync.Mutex
+	m map[string]string // by vendor directory; "" if it cannot be used
+}
+
+// vendorDir returns the vendor directory of the main module containing
+// p if p is vendored there and the module builds in vendor mode, or ""
+// otherwise.
+func (p *Package) vendorDir() string {
+	if *noVendorFastpath || p.Goroot {
+		return ""
+	}
+	for _, root := range mainModuleDirs() {
+		vendor := filepath.Join(root, "vendor")
+		if underDir(p.Dir, vendor) && p.Dir != vendor && moduleResolutionMode(root) == "vendor" {
+			return vendor
+		}
+	}
+	return ""
+}
+
+// vendorDigest returns the vendor directory p is vendored in and the
+// digest of its tree, or "" if p is not vendored or the digest cannot
+// be used.
+func (p *Package) vendorDigest() (vendor, digest string) {
+	vendor = p.vendorDir()
+	if vendor == "" {
+		return "", ""
+	}
+	return vendor, vendorTreeDigest(vendor)
+}
+
+// vendorTreeDigest returns the digest of the contents of the vendor
+// tree at vendor, or "" if it cannot be computed. It is computed once
+// per run, and reused from the cache dir while the manifest of the tree
+// is unchanged.
+func vendorTreeDigest(vendor string) string {
+	vendorDigests.Lock()
+	defer vendorDigests.Unlock()
+	if d, ok := vendorDigests.m[vendor]; ok {
+		return d
+	}
+	if vendorDigests.m == nil {
+		vendorDigests.m = map[string]string{}
+	}
+	d, err := computeVendorDigest(vendor)
+	if err != nil {
+		log.Printf("WARNING: %s: %s; hashing vendored files individually", vendor, err)
+	}
+	vendorDigests.m[vendor] = d
+	return d
+}
+
+func computeVendorDigest(vendor string) (string, error) {
+	manifest, err := vendorManifest(vendor)
+	if err != nil {
+		return "", err
+	}
+	dir := cacheDir()
+	var saved map[string]*vendorDigest
+	if !readAux(vendorDigestsPath(dir), "vendor-digests", &saved) || saved == nil {
+		saved = map[string]*vendorDigest{}
+	}
+	if d := saved[vendor]; d != nil && d.Manifest == manifest {
+		vlogf("vendor: %s unchanged", vendor)
+		return d.Digest, nil
+	}
+
+	vlogf("vendor: hashing %s", vendor)
+	digest, err := vendorContents(vendor)
+	if err != nil {
+		return "", err
+	}
+	if exists(dir) {
+		saved[vendor] = &vendorDigest{Manifest: manifest, Digest: digest}
+		if err := writeAux(vendorDigestsPath(dir), "vendor-digests", saved); err != nil {
+			log.Printf("WARNING: %s", err)
+		}
+	}
+	return digest, nil
+}
+
+// walkVendor calls fn for each regular file of the vendor tree, in
+// lexical order, with its path relative to vendor.
+func walkVendor(vendor string, fn func(rel, path string, fi fs.FileInfo) error) error {
+	return filepath.WalkDir(vendor, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(vendor, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), path, fi)
+	})
+}
+
+// vendorManifest returns the hex digest of the contents of modules.txt
+// and of the names, sizes and modification times of the files of the
+// vendor tree.
+func vendorManifest(vendor string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "modules.txt %s\n", hashFileContents(filepath.Join(vendor, "modules.txt")))
+	err := walkVendor(vendor, func(rel, _ string, fi fs.FileInfo) error {
+		fmt.Fprintf(h, "%q %d %d %o\n", rel, fi.Size(), fi.ModTime().UnixNano(), fi.Mode().Perm())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// vendorContents returns the hex digest of the names and contents of
+// the files of the vendor tree.
+func vendorContents(vendor string) (string, error) {
+	h := sha256.New()
+	err := walkVendor(vendor, func(rel, path string, fi fs.FileInfo) error {
+		fmt.Fprintf(h, "%q %d\n", rel, fi.Size())
+		fds.acquire(1)
+		defer fds.release(1)
+		var f *os.File
+		err := fds.retry(func() (err error) {
+			f, err = os.Open(path)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestVendorFingerprints checks that editing the vendored files of a
+// vendor mode module changes the fingerprints of the vendored packages
+// and of those depending on them, whether or not the vendored packages
+// are fingerprinted by a digest of the vendor tree, and that touching a
+// file does not. The digest covers files no package builds too.
+func TestVendorFingerprints(t *testing.T) {
+	const dep = "vendor/other.org/dep/dep.go"
+	for _, test := range []struct {
+		name     string
+		file     string // relative to the module root
+		data     string // "" to keep the contents
+		changed  bool
+		fastpath bool // changed only with the fast path
+	}{
+		{name: "vendored package", file: dep, data: "package dep\n\nimport \"other.org/dep/internal/x\"\n\nconst X = x.X + 1\n", changed: true},
+		{name: "same size", file: dep, data: "package dep\n\nimport \"other.org/dep/internal/x\"\n\nconst Y = x.X\n", changed: true},
+		{name: "vendored dependency", file: "vendor/other.org/dep/internal/x/x.go", data: "package x\n\nconst X = 2\n", changed: true},
+		{name: "other vendored file", file: "vendor/other.org/dep/LICENSE", data: "license\n", fastpath: true},
+		{name: "touched", file: dep},
+	} {
+		for _, fastpath := range []bool{true, false} {
+			name := test.name
+			if !fastpath {
+				name += ", without the fast path"
+			}
+			t.Run(name, func(t *testing.T) {
+				f := newFixture(t)
+				f.moduleMode()
+				t.Setenv("GOFLAGS", "-mod=vendor")
+				if !fastpath {
+					f.setFlag("no-vendor-fastpath", "true")
+				}
+				if err := os.MkdirAll(f.cache, 0755); err != nil {
+					t.Fatal(err)
+				}
+				root := filepath.Join(f.root, "mod")
+				f.writeFiles(root, map[string]string{
+					"go.mod":                               "module example.com/mod\n\ngo 1.16\n\nrequire other.org/dep v1.0.0\n",
+					"lib/lib.go":                           "package lib\n\nimport \"other.org/dep\"\n\nvar X = dep.X\n",
+					"cmd/tool/main.go":                     "package main\n\nimport \"example.com/mod/lib\"\n\nfunc main() { println(lib.X) }\n",
+					"vendor/modules.txt":                   "# other.org/dep v1.0.0\n## explicit\nother.org/dep\nother.org/dep/internal/x\n",
+					dep:                                    "package dep\n\nimport \"other.org/dep/internal/x\"\n\nconst X = x.X\n",
+					"vendor/other.org/dep/internal/x/x.go": "package x\n\nconst X = 1\n",
+				})
+				cwd = root
+
+				before := f.fingerprints("./...")
+				for _, importPath := range []string{"other.org/dep", "example.com/mod/lib", "example.com/mod/cmd/tool"} {
+					if before[importPath] == "" {
+						t.Fatalf("%s: not fingerprinted: %v", importPath, before)
+					}
+				}
+				if saved := exists(vendorDigestsPath(f.cache)); saved != fastpath {
+					t.Fatalf("vendor digest saved %v, want %v", saved, fastpath)
+				}
+				// Fingerprinting again reuses the digest saved in the
+				// cache directory.
+				if again := f.fingerprints("./..."); !reflect.DeepEqual(again, before) {
+					t.Fatalf("fingerprints changed without edits: %v, then %v", before, again)
+				}
+
+				// Edit the file as an editor would, changing its
+				// modification time.
+				path := filepath.Join(root, filepath.FromSlash(test.file))
+				data := []byte(test.data)
+				if test.data == "" {
+					var err error
+					if data, err = os.ReadFile(path); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+				now := time.Now()
+				if err := os.Chtimes(path, now, now); err != nil {
+					t.Fatal(err)
+				}
+
+				after := f.fingerprints("./...")
+				want := test.changed || test.fastpath && fastpath
+				for _, importPath := range []string{"other.org/dep", "example.com/mod/lib", "example.com/mod/cmd/tool"} {
+					if changed := after[importPath] != before[importPath]; changed != want {
+						t.Errorf("%s: fingerprint changed %v, want %v", importPath, changed, want)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var rebuildIndex = flag.Bool("rebuild-index", false,
+	"make verify rebuild the index of the entries by import path")
+
+// checkEntry verifies the cache entry against its metadata, returning a
+// description of the problem or "" if the entry is intact. Entries
+// without metadata cannot be checked.
+func checkEntry(e *cacheEntry) string {
+	if e.MetaErr != nil {
+		return fmt.Sprintf("unreadable metadata: %s", e.MetaErr)
+	}
+	if e.Meta == nil {
+		return ""
+	}
+	if e.Size != e.Meta.Size {
+		return fmt.Sprintf("size %d does not match recorded size %d", e.Size, e.Meta.Size)
+	}
+	sum, _, err := fileChecksum(e.Path)
+	if err != nil {
+		return err.Error()
+	}
+	if sum != e.Meta.Checksum {
+		return "checksum does not match"
+	}
+	if problem := checkExtras(e.Path, e.Meta); problem != "" {
+		return problem
+	}
+	return toolchainMismatch(e.Path, e.Meta.GoVersion)
+}
+
+// verify checks every entry in the cache against its metadata and moves
+// the entries failing the check into quarantine.
+// entryOwnerMismatch returns why the entry at src, according to its
+// metadata, does not hold the output of pkg, or "" if it does or the
+// entry has no such metadata. A mismatch means a fingerprint collision
+// or a tampered entry.
+func entryOwnerMismatch(src string, pkg *Package) string {
+	m, err := readMeta(src)
+	if err != nil || m == nil || m.ImportPath == "" {
+		return ""
+	}
+	if !sameOwner(m.ImportPath, pkg.ImportPath) {
+		return fmt.Sprintf("entry holds %s", m.ImportPath)
+	}
+	if m.GOOS != pkg.buildContext.GOOS || m.GOARCH != pkg.buildContext.GOARCH {
+		return fmt.Sprintf("entry is for %s/%s", m.GOOS, m.GOARCH)
+	}
+	return ""
+}
+
+// reportDuplicates logs the packages with several entries of identical
+// contents. Different fingerprints should produce different outputs, so
+// these point at inputs folded into the fingerprint needlessly.
+func reportDuplicates(entries []*cacheEntry) {
+	fps := map[string][]string{} // by import path and checksum
+	var keys []string
+	for _, e := range entries {
+		if e.Meta == nil || e.Meta.ImportPath == "" {
+			continue
+		}
+		key := e.Meta.ImportPath + " " + e.Meta.Checksum
+		if len(fps[key]) == 0 {
+			keys = append(keys, key)
+		}
+		fps[key] = append(fps[key], e.Fingerprint())
+	}
+	for _, key := range keys {
+		if len(fps[key]) > 1 {
+			log.Printf("warning: %s has %d entries with identical contents: %s",
+				strings.Fields(key)[0], len(fps[key]), strings.Join(fps[key], ", "))
+		}
+	}
+}
+
+func verify(args []string) {
+	dir := cacheDir()
+	entries, err := listEntries(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failures := 0
+	for _, e := range entries {
+		reason := checkEntry(e)
+		if reason == "" {
+			continue
+		}
+		failures++
+		log.Printf("%-*s %s: %s", fingerprintWidth, e.Name, e.ImportPath(), reason)
+		if err := quarantine(dir, e.Path, reason); err != nil {
+			log.Fatal(err)
+		}
+	}
+	reportDuplicates(entries)
+	if *rebuildIndex {
+		if err := rebuildPackageIndex(dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("%d entries, %d quarantined", len(entries), failures)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+var (
+	jobs     = flag.Int("j", runtime.NumCPU(), "number of parallel jobs")
+	dryRun   = flag.Bool("dry-run", false, "report what would be done without doing it")
+	depsOnly = flag.Bool("deps-only", true,
+		"only warm packages outside the repositories of the root packages")
+)
+
+// warm installs the packages whose output is missing from the cache and
+// then saves them. By default only the dependencies living outside the
+// repositories of the root packages are warmed, since those change the
+// least.
+func warm(args []string) {
+	if *stdRace {
+		if len(args) > 0 {
+			log.Fatalf("warm -std-race takes no packages")
+		}
+		warmStdRace()
+		return
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	dir := cacheDir()
+	log.Printf("warming %s in %s", args, dir)
+
+	pkgs := loadAll(args)
+	trees := rootTrees(pkgs)
+
+	var missing, missingRace []string
+	for _, pkg := range pkgs {
+		if !pkg.cached() {
+			continue
+		}
+		if *depsOnly && pkg.inTree(trees) {
+			continue
+		}
+		if lookupEntry(dir, pkg.Fingerprint()) != "" {
+			continue
+		}
+		log.Printf("%-40s  %s", pkg.Fingerprint(), pkg.ImportPath)
+		if pkg.race {
+			missingRace = append(missingRace, pkg.baseImportPath)
+		} else {
+			missing = append(missing, pkg.baseImportPath)
+		}
+	}
+	log.Printf("%d packages missing from the cache", len(missing)+len(missingRace))
+	if *dryRun || len(missing)+len(missingRace) == 0 {
+		return
+	}
+
+	before := targetTimes(pkgs)
+	start := time.Now()
+	if len(missing) > 0 {
+		goInstall(nil, missing)
+	}
+	if len(missingRace) > 0 {
+		goInstall([]string{"-race"}, missingRace)
+	}
+	buildDurations = attributeBuildTimes(pkgs, before, start)
+
+	// Reload the packages as the installed outputs are now up to date.
+	packageCache = map[string]*Package{}
+	s, err := save(args)
+	if err != nil {
+		fatal(err)
+	}
+	log.Printf("warmed %d entries", s.Misses)
+}
+
+// goInstall runs "go install" for the specified packages.
+func goInstall(flags []string, pkgs []string) {
+	cmd := exec.Command(goCmd(), stringList("install", "-p", strconv.Itoa(*jobs), flags, pkgs)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("go install: %s", err)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// importChain returns the shortest chain of imports leading from root to
+// the package with the import path target, found by a breadth-first
+// search, or nil if target is not reachable from root. The imports of
+// the tests of root are followed if they were loaded.
+func importChain(root *Package, target string) []*Package {
+	parent := map[*Package]*Package{root: nil}
+	queue := []*Package{root}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if p.ImportPath == target || p.baseImportPath == target {
+			var chain []*Package
+			for ; p != nil; p = parent[p] {
+				chain = append([]*Package{p}, chain...)
+			}
+			return chain
+		}
+		next := p.imports
+		if p == root {
+			next = append(append([]*Package(nil), next...), p.testImports...)
+		}
+		for _, imp := range next {
+			if _, ok := parent[imp]; !ok {
+				parent[imp] = p
+				queue = append(queue, imp)
+			}
+		}
+	}
+	return nil
+}
+
+// cacheStatus returns the cache status of pkg in dir: "hit", "miss" or
+// "std" for standard packages, which are not cached.
+func cacheStatus(pkg *Package, dir string) string {
+	if !pkg.cached() {
+		return "std"
+	}
+	if lookupEntry(dir, pkg.Fingerprint()) != "" {
+		return resultHit
+	}
+	return resultMiss
+}
+
+func why(args []string) {
+	if len(args) == 0 {
+		log.Printf("usage: %s why <import-path> [packages]", os.Args[0])
+		os.Exit(1)
+	}
+	target, args := args[0], args[1:]
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	dir := cacheDir()
+	found := false
+	for _, root := range packagesForBuild(args) {
+		fmt.Printf("# %s\n", root.ImportPath)
+		chain := importChain(root, target)
+		if chain == nil {
+			fmt.Printf("(%s does not import %s)\n\n", root.ImportPath, target)
+			continue
+		}
+		found = true
+		for i, pkg := range chain {
+			status := cacheStatus(pkg, dir)
+			note := ""
+			if i == 1 && !contains(root.Imports, pkg.baseImportPath) {
+				note = " [test]"
+			}
+			fmt.Printf("%s %s%s\n", colorize(resultColors[status], fmt.Sprintf("%-4s", status)),
+				pkg.ImportPath, note)
+		}
+		fmt.Println()
+	}
+	if !found {
+		log.Printf("%s is not imported by %s", target, args)
+		os.Exit(1)
+	}
+}
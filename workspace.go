@@ -0,0 +1,308 @@
+// Copyright 2026 The build-cache Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package main
+
+import (
+	"bufio"
+	"go/build"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// moduleMode reports whether packages are resolved in module mode
+// rather than GOPATH mode, following the same rules as go/build.
+func moduleMode() bool {
+	switch os.Getenv("GO111MODULE") {
+	case "off":
+		return false
+	case "auto":
+		return findUp(cwd, "go.mod") != ""
+	}
+	return true
+}
+
+// gopathEntries returns the entries of GOPATH, in order.
+func gopathEntries() []string {
+	var entries []string
+	for _, entry := range filepath.SplitList(build.Default.GOPATH) {
+		if entry != "" && filepath.IsAbs(entry) {
+			entries = append(entries, filepath.Clean(entry))
+		}
+	}
+	return entries
+}
+
+// gopathEntry returns the GOPATH entry containing path, or "" if path is
+// not within any of them.
+func gopathEntry(path string) string {
+	for _, entry := range gopathEntries() {
+		rel, err := filepath.Rel(entry, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return entry
+		}
+	}
+	return ""
+}
+
+// goWorkFile returns the go.work file in effect, or "" if workspace
+// mode is not in use. Like the go command, it honors GOWORK and
+// otherwise looks for go.work in the current directory and its parents.
+func goWorkFile() string {
+	if !moduleMode() {
+		return ""
+	}
+	switch gowork := os.Getenv("GOWORK"); gowork {
+	case "off":
+		return ""
+	case "":
+		if d := findUp(cwd, "go.work"); d != "" {
+			return filepath.Join(d, "go.work")
+		}
+		return ""
+	default:
+		return gowork
+	}
+}
+
+// findUp returns the closest directory enclosing dir that contains name,
+// or "" if there is none.
+func findUp(dir, name string) string {
+	for d := dir; ; {
+		if exists(filepath.Join(d, name)) {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return ""
+		}
+		d = parent
+	}
+}
+
+var workspaceOnce sync.Once
+var workspace []string
+
+// workspaceDirs returns the directories of the modules listed in the
+// go.work file in effect.
+func workspaceDirs() []string {
+	workspaceOnce.Do(func() {
+		file := goWorkFile()
+		if file == "" {
+			return
+		}
+		dirs, err := parseGoWork(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		workspace = dirs
+	})
+	return workspace
+}
+
+// parseGoWork returns the absolute directories named by the use
+// directives in the go.work file.
+func parseGoWork(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dirs []string
+	add := func(s string) {
+		if u, err := strconv.Unquote(s); err == nil {
+			s = u
+		}
+		if !filepath.IsAbs(s) {
+			s = filepath.Join(filepath.Dir(file), s)
+		}
+		dirs = append(dirs, filepath.Clean(s))
+	}
+
+	inUse := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case inUse && fields[0] == ")":
+			inUse = false
+		case inUse:
+			add(fields[0])
+		case fields[0] == "use" && len(fields) >= 2 && fields[1] == "(":
+			inUse = true
+		case fields[0] == "use" && len(fields) >= 2:
+			add(fields[1])
+		}
+	}
+	return dirs, s.Err()
+}
+
+// moduleImportPath returns the import path of the package in dir
+// computed from the module path declared by the enclosing go.mod, or ""
+// if dir is not within a module.
+func moduleImportPath(dir string) string {
+	if !moduleMode() {
+		return ""
+	}
+	root := findUp(dir, "go.mod")
+	if root == "" {
+		return ""
+	}
+	mod := modulePath(filepath.Join(root, "go.mod"))
+	if mod == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return ""
+	}
+	return path.Join(mod, filepath.ToSlash(rel))
+}
+
+// packageModule returns the path of the module enclosing pkg, or "" if
+// it is not within a module.
+func packageModule(pkg *Package) string {
+	if pkg.Goroot {
+		return ""
+	}
+	root := findUp(pkg.Dir, "go.mod")
+	if root == "" {
+		return ""
+	}
+	return modulePath(filepath.Join(root, "go.mod"))
+}
+
+// modulePath returns the module path declared by the go.mod file.
+func modulePath(gomod string) string {
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			if u, err := strconv.Unquote(fields[1]); err == nil {
+				return u
+			}
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// matchPattern returns a function reporting whether a name matches the
+// pattern, where "..." matches any string, as in the go command.
+func matchPattern(pattern string) func(name string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	// Special case: foo/... matches foo too.
+	if strings.HasSuffix(re, `/.*`) {
+		re = re[:len(re)-len(`/.*`)] + `(/.*)?`
+	}
+	reg := regexp.MustCompile(`^` + re + `$`)
+	return reg.MatchString
+}
+
+// matchPackages expands an argument containing "..." into the packages
+// it matches. Local patterns (e.g. "./...") are matched against the
+// directories below the current directory, not descending into nested
+// modules other than the members of the workspace. Other patterns are
+// matched against the GOPATH entries.
+func matchPackages(arg string) []string {
+	base := packageBaseImportPath(arg)
+	options := arg[len(base):]
+	if !strings.Contains(base, "...") {
+		return []string{arg}
+	}
+	match := matchPattern(base)
+	prefix := base[:strings.Index(base, "...")]
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		prefix = prefix[:i]
+	} else {
+		prefix = ""
+	}
+
+	ctx := targetContext()
+	var matches []string
+	walk := func(top string, name func(dir string) string) {
+		_ = filepath.Walk(top, func(dir string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return nil
+			}
+			if dir != top {
+				elem := fi.Name()
+				if strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") ||
+					elem == "testdata" || elem == "vendor" {
+					return filepath.SkipDir
+				}
+				if exists(filepath.Join(dir, "go.mod")) && !contains(workspaceDirs(), dir) {
+					return filepath.SkipDir
+				}
+			}
+			n := name(dir)
+			if !match(n) {
+				return nil
+			}
+			if _, err := ctx.ImportDir(dir, 0); err != nil {
+				if _, noGo := err.(*build.NoGoError); noGo {
+					return nil
+				}
+			}
+			matches = append(matches, n+options)
+			return nil
+		})
+	}
+
+	if build.IsLocalImport(base) {
+		walk(filepath.Join(cwd, prefix), func(dir string) string {
+			rel, _ := filepath.Rel(cwd, dir)
+			rel = filepath.ToSlash(rel)
+			if !strings.HasPrefix(rel, ".") {
+				rel = "./" + rel
+			}
+			return rel
+		})
+	} else {
+		// The packages of the main modules, in module mode; those
+		// within GOPATH are skipped below as modules.
+		for _, root := range mainModuleDirs() {
+			walk(root, moduleImportPath)
+		}
+		for _, root := range filepath.SplitList(ctx.GOPATH) {
+			src := filepath.Join(root, "src")
+			walk(filepath.Join(src, filepath.FromSlash(prefix)), func(dir string) string {
+				rel, _ := filepath.Rel(src, dir)
+				return filepath.ToSlash(rel)
+			})
+		}
+	}
+
+	if len(matches) == 0 {
+		log.Printf("warning: \"%s\" matched no packages", arg)
+	}
+	return matches
+}